@@ -1,22 +1,167 @@
 package cmd
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/psanford/pptrace/inspect"
+	"github.com/psanford/pptrace/internal/config"
+	"github.com/psanford/pptrace/internal/dwarfutil"
+	"github.com/psanford/pptrace/internal/render"
+	"github.com/psanford/pptrace/internal/tracefsutil"
+	"github.com/psanford/pptrace/internal/vlog"
 	"github.com/psanford/pptrace/trace"
 	"github.com/psanford/pptrace/tracerstate"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "pptrace",
 	Short: "Peter's trace tool",
+
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := applyDefaults(cmd); err != nil {
+			return err
+		}
+
+		// only commands with a --json flag have structured output
+		if render.Output != render.Text && cmd.Flags().Lookup("json") == nil {
+			if cmd.Flags().Changed("format") {
+				return fmt.Errorf("%s has no %s output", cmd.CommandPath(), render.Output)
+			}
+			// a default format applies where it can
+			render.Output = render.Text
+		}
+
+		root := tracefsutil.UseRoot(expandHome(tracefsPath))
+		vlog.Debugf("using tracefs at %s", root)
+
+		for i, dir := range dwarfutil.ExtraDebugDirs {
+			dwarfutil.ExtraDebugDirs[i] = expandHome(dir)
+		}
+		return nil
+	},
+}
+
+var (
+	configPath  string
+	tracefsPath string
+)
+
+// applyDefaults sets the flags of cmd that weren't given on the
+// command line, first from PPTRACE_<FLAG> environment variables
+// and then from the config file.
+func applyDefaults(cmd *cobra.Command) error {
+	// setting values directly leaves flags unchanged, so they
+	// still count as defaults
+	var err error
+	fromEnv := make(map[string]bool)
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		env := "PPTRACE_" + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if v, ok := os.LookupEnv(env); ok {
+			fromEnv[f.Name] = true
+			if setErr := f.Value.Set(v); setErr != nil {
+				err = fmt.Errorf("%s: %s", env, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(expandHome(configPath))
+	if err != nil {
+		return err
+	}
+	config.Current = cfg
+
+	known := make(map[string]bool)
+	collectFlags(cmd.Root(), known)
+	for name := range cfg.Flags {
+		if !known[name] {
+			return fmt.Errorf("%s: unknown setting %q, expected a flag name or presets", cfg.Path, name)
+		}
+	}
+
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		vals, ok := cfg.Flags[f.Name]
+		if err != nil || f.Changed || fromEnv[f.Name] || !ok {
+			return
+		}
+		vlog.Debugf("--%s from %s: %s", f.Name, cfg.Path, strings.Join(vals, ", "))
+		for _, v := range vals {
+			if setErr := f.Value.Set(v); setErr != nil {
+				err = fmt.Errorf("%s: %s: %s", cfg.Path, f.Name, setErr)
+				return
+			}
+		}
+	})
+	return err
+}
+
+// collectFlags adds the names of the flags of cmd and its
+// subcommands to names, leaving out ones a config file can't set.
+func collectFlags(cmd *cobra.Command, names map[string]bool) {
+	add := func(f *pflag.Flag) {
+		if f.Name != "help" && f.Name != "config" {
+			names[f.Name] = true
+		}
+	}
+	cmd.LocalFlags().VisitAll(add)
+	cmd.PersistentFlags().VisitAll(add)
+	for _, sub := range cmd.Commands() {
+		collectFlags(sub, names)
+	}
+}
+
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+var completionCmd = &cobra.Command{
+	Use:       "completion bash|zsh|fish|powershell",
+	Short:     "Generate a shell completion script",
+	Long:      "Generate a shell completion script, e.g. source <(pptrace completion bash). Completion suggests function names from the binary being traced or inspected.",
+	Args:      cobra.ExactValidArgs(1),
+	ValidArgs: []string{"bash", "zsh", "fish", "powershell"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch args[0] {
+		case "bash":
+			return rootCmd.GenBashCompletion(os.Stdout)
+		case "zsh":
+			return rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			return rootCmd.GenFishCompletion(os.Stdout, true)
+		default:
+			return rootCmd.GenPowerShellCompletion(os.Stdout)
+		}
+	},
 }
 
 func Execute() error {
+	rootCmd.PersistentFlags().CountVarP(&vlog.Level, "verbose", "v", "Log debug messages, such as where debug info and probe offsets came from; -vv adds more detail. With trace, also shows the tracefs writes")
+	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "", "", "Read flag defaults and presets from this file instead of ~/.config/pptrace/config.yaml")
+	rootCmd.PersistentFlags().StringArrayVarP(&dwarfutil.ExtraDebugDirs, "debug-dir", "", nil, "Also search this directory for separate debug files, laid out like /usr/lib/debug (repeatable)")
+	rootCmd.PersistentFlags().Var(&render.Output, "format", "Output format of inspect and tracer_state commands: text, json or yaml (--json is short for --format json)")
+	rootCmd.PersistentFlags().StringVarP(&tracefsPath, "tracefs", "", "", "Where tracefs is mounted (default: /sys/kernel/tracing, else /sys/kernel/debug/tracing)")
 
 	rootCmd.AddCommand(inspect.Command())
 	rootCmd.AddCommand(tracerstate.Command())
 	rootCmd.AddCommand(trace.Command())
+	rootCmd.AddCommand(completionCmd)
 
 	return rootCmd.Execute()
 }
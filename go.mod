@@ -5,9 +5,8 @@ go 1.18
 require (
 	github.com/psanford/tracefs v0.0.0-20211230003654-d7ae54c4cdbb
 	github.com/spf13/cobra v1.1.3
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/arch v0.11.0
 )
 
-require (
-	github.com/inconshreveable/mousetrap v1.0.0 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
-)
+require github.com/inconshreveable/mousetrap v1.0.0 // indirect
@@ -0,0 +1,146 @@
+package inspect
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var loadBase uint64
+
+func addr2lineCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "addr2line <file> [<addr>...]",
+		Short: "Map addresses to source file and line",
+		Long:  "Map addresses to source file and line. If no addresses are given they are read from stdin, one per line.",
+		Run:   addr2lineAction,
+	}
+
+	cmd.Flags().Uint64VarP(&loadBase, "load-base", "", 0, "Runtime load address of the binary (for PIE addresses taken from a running process)")
+	cmd.Flags().BoolVarP(&noCRCCheck, "no-crc-check", "", false, "Don't verify debuglink CRC of separate debug files")
+	cmd.Flags().StringVarP(&debugFile, "debug-file", "", "", "Read DWARF from this file instead of searching for debug info")
+
+	return &cmd
+}
+
+func addr2lineAction(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		log.Fatalf("Usage: addr2line <file> [<addr>...]")
+	}
+
+	bin, dwarfInfo := openDwarf(args[0])
+	defer bin.Close()
+
+	var bias uint64
+	if loadBase != 0 {
+		vaddr, _ := bin.LoadAddress()
+		bias = loadBase - vaddr
+	}
+
+	lookup := func(s string) {
+		addr, err := parseAddr(s)
+		if err != nil {
+			log.Printf("invalid address %q: %s", s, err)
+			return
+		}
+
+		loc := Addr2Line(dwarfInfo, addr-bias)
+		fn := loc.Function
+		if fn == "" {
+			fn = "??"
+		}
+		file := loc.File
+		if file == "" {
+			file = "??"
+		}
+		fmt.Printf("%016x %s %s:%d\n", addr, fn, file, loc.Line)
+	}
+
+	if len(args) > 1 {
+		for _, a := range args[1:] {
+			lookup(a)
+		}
+		return
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		lookup(line)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("read stdin err: %s", err)
+	}
+}
+
+// parseAddr parses a hex address with or without a 0x prefix,
+// matching the convention of binutils addr2line.
+func parseAddr(s string) (uint64, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// SourceLocation is the source position of an address.
+type SourceLocation struct {
+	Addr     uint64 `json:"addr"`
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// Addr2Line returns the source file, line and enclosing function
+// of addr using the DWARF line tables. Fields that can't be
+// resolved are left empty.
+func Addr2Line(d *dwarf.Data, addr uint64) SourceLocation {
+	loc := SourceLocation{
+		Addr: addr,
+	}
+
+	r := d.Reader()
+	cu, err := r.SeekPC(addr)
+	if err != nil {
+		return loc
+	}
+
+	lr, err := d.LineReader(cu)
+	if err == nil && lr != nil {
+		var le dwarf.LineEntry
+		if lr.SeekPC(addr, &le) == nil && le.File != nil {
+			loc.File = le.File.Name
+			loc.Line = le.Line
+		}
+	}
+
+	// the reader is now positioned at the first child of the cu
+	for {
+		entry, err := r.Next()
+		if err != nil || entry == nil || entry.Tag == 0 {
+			break
+		}
+
+		if entry.Tag == dwarf.TagSubprogram {
+			ranges, err := d.Ranges(entry)
+			if err == nil {
+				for _, rng := range ranges {
+					if rng[0] <= addr && addr < rng[1] {
+						loc.Function, _ = entry.Val(dwarf.AttrName).(string)
+						return loc
+					}
+				}
+			}
+		}
+
+		r.SkipChildren()
+	}
+
+	return loc
+}
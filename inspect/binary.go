@@ -0,0 +1,484 @@
+package inspect
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/psanford/pptrace/internal/dwarfutil"
+)
+
+// Binary is an executable or library in one of the supported
+// object file formats (ELF, Mach-O or PE).
+type Binary interface {
+	// Format returns "elf", "macho" or "pe".
+	Format() string
+	// Type returns a human readable file type.
+	Type() string
+	// Symbols returns the symbol table entries.
+	Symbols() ([]Symbol, error)
+	// Sections returns the file's sections.
+	Sections() []Section
+	// DWARF returns the debug info embedded in the file.
+	DWARF() (*dwarf.Data, error)
+	// LoadAddress returns the lowest virtual address the file
+	// expects to be loaded at. ok is false if the file has no
+	// loadable segments.
+	LoadAddress() (addr uint64, ok bool)
+	// GoBuildInfo returns the embedded Go build info, or nil
+	// if this isn't a Go binary.
+	GoBuildInfo() *debug.BuildInfo
+	Close() error
+}
+
+// Symbol is a format independent symbol table entry.
+type Symbol struct {
+	Name      string `json:"name"`
+	Demangled string `json:"demangled,omitempty"`
+	Value     uint64 `json:"value"`
+	Size      uint64 `json:"size"`
+	Func      bool   `json:"func"`
+	// Section is the name of the section the symbol is in, as
+	// listed by Sections.
+	Section string `json:"section,omitempty"`
+}
+
+// Defined reports whether s is defined in its file, rather than
+// imported from another with no section and a zero value.
+func (s Symbol) Defined() bool {
+	return s.Section != "" && s.Section != "UND" && s.Value != 0
+}
+
+// Section is a format independent section header.
+type Section struct {
+	Name string `json:"name"`
+	Addr uint64 `json:"addr"`
+	Size uint64 `json:"size"`
+}
+
+// OpenBinary opens path, detecting the object format from
+// the file's magic number.
+func OpenBinary(path string) (Binary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(f, magic)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("read magic err: %w", err)
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte(elf.ELFMAG)):
+		f, err := elf.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &elfBinary{f: f, path: path}, nil
+	case bytes.Equal(magic, []byte{0xfe, 0xed, 0xfa, 0xce}),
+		bytes.Equal(magic, []byte{0xfe, 0xed, 0xfa, 0xcf}),
+		bytes.Equal(magic, []byte{0xce, 0xfa, 0xed, 0xfe}),
+		bytes.Equal(magic, []byte{0xcf, 0xfa, 0xed, 0xfe}):
+		f, err := macho.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &machoBinary{f: f, path: path}, nil
+	case bytes.Equal(magic[:2], []byte("MZ")):
+		f, err := pe.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &peBinary{f: f, path: path}, nil
+	}
+
+	return nil, fmt.Errorf("unrecognized file format")
+}
+
+type elfBinary struct {
+	f    *elf.File
+	path string
+}
+
+func (b *elfBinary) Format() string {
+	return "elf"
+}
+
+func (b *elfBinary) Type() string {
+	switch b.f.Type {
+	case elf.ET_REL:
+		return "Relocatable"
+	case elf.ET_EXEC:
+		return "Executable"
+	case elf.ET_DYN:
+		return "Shared object"
+	case elf.ET_CORE:
+		return "Core file"
+	case elf.ET_LOOS:
+		return "First operating system specific"
+	case elf.ET_HIOS:
+		return "Last operating system-specific"
+	case elf.ET_LOPROC:
+		return "First processor-specific"
+	case elf.ET_HIPROC:
+		return "Last processor-specific"
+	}
+	return "Unknown type"
+}
+
+func (b *elfBinary) Symbols() ([]Symbol, error) {
+	symbols, err := ELFSymbols(b.f)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]Symbol, 0, len(symbols))
+	for _, sym := range symbols {
+		out = append(out, Symbol{
+			Name:    sym.Name,
+			Value:   sym.Value,
+			Size:    sym.Size,
+			Func:    elf.ST_TYPE(sym.Info) == elf.STT_FUNC,
+			Section: symbolSection(b.f, sym.Section),
+		})
+	}
+	return out, nil
+}
+
+// ErrStripped is returned by ELFSymbols for a file with neither a
+// static nor a dynamic symbol table.
+var ErrStripped = errors.New("no symbol table, the file is stripped")
+
+// ELFSymbols returns the entries of f's static and dynamic symbol
+// tables. Symbols present in both tables are only returned once.
+func ELFSymbols(f *elf.File) ([]elf.Symbol, error) {
+	symbols, errSym := f.Symbols()
+	dsyms, errDyn := f.DynamicSymbols()
+
+	if errors.Is(errSym, elf.ErrNoSymbols) && errors.Is(errDyn, elf.ErrNoSymbols) {
+		return nil, ErrStripped
+	}
+	if errSym != nil && errDyn != nil {
+		return nil, fmt.Errorf("%s %s", errSym, errDyn)
+	}
+
+	type symKey struct {
+		name  string
+		value uint64
+	}
+	seen := make(map[symKey]bool, len(symbols))
+
+	out := make([]elf.Symbol, 0, len(symbols)+len(dsyms))
+	for _, sym := range append(symbols, dsyms...) {
+		key := symKey{sym.Name, sym.Value}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, sym)
+	}
+	return out, nil
+}
+
+// SymbolInfo is an ELF symbol with its type, binding, visibility
+// and section decoded.
+type SymbolInfo struct {
+	Name      string `json:"name"`
+	Demangled string `json:"demangled,omitempty"`
+	// Version is the symbol version of a dynamic symbol, e.g.
+	// GLIBC_2.2.5.
+	Version    string `json:"version,omitempty"`
+	Value      uint64 `json:"value"`
+	Size       uint64 `json:"size"`
+	Type       string `json:"type"`
+	Bind       string `json:"bind"`
+	Visibility string `json:"visibility"`
+	// Section is the name of the section the symbol is defined
+	// in, or UND, ABS or COMMON.
+	Section string `json:"section"`
+}
+
+// symbolTypes are the decoded symbol types, as in SymbolInfo.Type.
+var symbolTypes = map[string]bool{
+	"NOTYPE": true, "OBJECT": true, "FUNC": true, "SECTION": true,
+	"FILE": true, "COMMON": true, "TLS": true, "IFUNC": true,
+}
+
+// ELFSymbolInfo returns the entries of f's static and dynamic
+// symbol tables with their fields decoded.
+func ELFSymbolInfo(f *elf.File) ([]SymbolInfo, error) {
+	symbols, err := ELFSymbols(f)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]SymbolInfo, 0, len(symbols))
+	for _, sym := range symbols {
+		out = append(out, SymbolInfo{
+			Name:       sym.Name,
+			Version:    sym.Version,
+			Value:      sym.Value,
+			Size:       sym.Size,
+			Type:       decodeSymbolType(elf.ST_TYPE(sym.Info)),
+			Bind:       strings.TrimPrefix(elf.ST_BIND(sym.Info).String(), "STB_"),
+			Visibility: strings.TrimPrefix(elf.ST_VISIBILITY(sym.Other).String(), "STV_"),
+			Section:    symbolSection(f, sym.Section),
+		})
+	}
+	return out, nil
+}
+
+func decodeSymbolType(t elf.SymType) string {
+	// debug/elf predates STT_IFUNC, the GNU extension using the
+	// first OS specific value
+	if t == elf.STT_LOOS {
+		return "IFUNC"
+	}
+	return strings.TrimPrefix(t.String(), "STT_")
+}
+
+func symbolSection(f *elf.File, idx elf.SectionIndex) string {
+	switch idx {
+	case elf.SHN_UNDEF:
+		return "UND"
+	case elf.SHN_ABS:
+		return "ABS"
+	case elf.SHN_COMMON:
+		return "COMMON"
+	}
+	if int(idx) < len(f.Sections) {
+		return f.Sections[idx].Name
+	}
+	return fmt.Sprintf("0x%x", uint16(idx))
+}
+
+func (b *elfBinary) Sections() []Section {
+	out := make([]Section, 0, len(b.f.Sections))
+	for _, s := range b.f.Sections {
+		out = append(out, Section{
+			Name: s.Name,
+			Addr: s.Addr,
+			Size: s.Size,
+		})
+	}
+	return out
+}
+
+func (b *elfBinary) DWARF() (*dwarf.Data, error) {
+	return dwarfutil.ELFData(b.f, b.path)
+}
+
+func (b *elfBinary) LoadAddress() (uint64, bool) {
+	for _, prog := range b.f.Progs {
+		if prog.Type == elf.PT_LOAD {
+			return prog.Vaddr, true
+		}
+	}
+	return 0, false
+}
+
+func (b *elfBinary) GoBuildInfo() *debug.BuildInfo {
+	return readGoBuildInfo(b.path, &elfExe{b.f})
+}
+
+func (b *elfBinary) Close() error {
+	return b.f.Close()
+}
+
+type machoBinary struct {
+	f    *macho.File
+	path string
+}
+
+func (b *machoBinary) Format() string {
+	return "macho"
+}
+
+func (b *machoBinary) Type() string {
+	switch b.f.Type {
+	case macho.TypeObj:
+		return "Relocatable"
+	case macho.TypeExec:
+		return "Executable"
+	case macho.TypeDylib:
+		return "Shared object"
+	case macho.TypeBundle:
+		return "Bundle"
+	}
+	return "Unknown type"
+}
+
+func (b *machoBinary) Symbols() ([]Symbol, error) {
+	if b.f.Symtab == nil {
+		return nil, fmt.Errorf("no symbol table")
+	}
+
+	const (
+		nStab = 0xe0
+		nType = 0x0e
+		nSect = 0x0e
+	)
+
+	var syms []Symbol
+	for _, s := range b.f.Symtab.Syms {
+		// debugger entries such as N_BNSYM and N_ENSYM can have
+		// type bits that look like N_SECT
+		if s.Type&nStab != 0 {
+			continue
+		}
+		if s.Type&nType != nSect || s.Sect == 0 || int(s.Sect) > len(b.f.Sections) {
+			continue
+		}
+		sect := b.f.Sections[s.Sect-1]
+		syms = append(syms, Symbol{
+			Name:    s.Name,
+			Value:   s.Value,
+			Func:    sect.Seg == "__TEXT" && sect.Name == "__text",
+			Section: sect.Seg + "," + sect.Name,
+		})
+	}
+
+	fillSizes(syms)
+	return syms, nil
+}
+
+func (b *machoBinary) Sections() []Section {
+	out := make([]Section, 0, len(b.f.Sections))
+	for _, s := range b.f.Sections {
+		out = append(out, Section{
+			Name: s.Seg + "," + s.Name,
+			Addr: s.Addr,
+			Size: s.Size,
+		})
+	}
+	return out
+}
+
+func (b *machoBinary) DWARF() (*dwarf.Data, error) {
+	return b.f.DWARF()
+}
+
+func (b *machoBinary) LoadAddress() (uint64, bool) {
+	if seg := b.f.Segment("__TEXT"); seg != nil {
+		return seg.Addr, true
+	}
+	return 0, false
+}
+
+func (b *machoBinary) GoBuildInfo() *debug.BuildInfo {
+	return readGoBuildInfo(b.path, &machoExe{b.f})
+}
+
+func (b *machoBinary) Close() error {
+	return b.f.Close()
+}
+
+type peBinary struct {
+	f    *pe.File
+	path string
+}
+
+func (b *peBinary) Format() string {
+	return "pe"
+}
+
+func (b *peBinary) Type() string {
+	if b.f.Characteristics&pe.IMAGE_FILE_DLL != 0 {
+		return "Shared object"
+	}
+	if b.f.Characteristics&pe.IMAGE_FILE_EXECUTABLE_IMAGE != 0 {
+		return "Executable"
+	}
+	return "Relocatable"
+}
+
+func (b *peBinary) imageBase() uint64 {
+	return (&peExe{b.f}).imageBase()
+}
+
+func (b *peBinary) Symbols() ([]Symbol, error) {
+	if len(b.f.Symbols) == 0 {
+		return nil, fmt.Errorf("no symbol table")
+	}
+
+	base := b.imageBase()
+
+	var syms []Symbol
+	for _, s := range b.f.Symbols {
+		if s.SectionNumber <= 0 || int(s.SectionNumber) > len(b.f.Sections) {
+			continue
+		}
+		sect := b.f.Sections[s.SectionNumber-1]
+		syms = append(syms, Symbol{
+			Name:    s.Name,
+			Value:   base + uint64(sect.VirtualAddress) + uint64(s.Value),
+			Func:    sect.Characteristics&pe.IMAGE_SCN_CNT_CODE != 0,
+			Section: sect.Name,
+		})
+	}
+
+	fillSizes(syms)
+	return syms, nil
+}
+
+func (b *peBinary) Sections() []Section {
+	base := b.imageBase()
+	out := make([]Section, 0, len(b.f.Sections))
+	for _, s := range b.f.Sections {
+		out = append(out, Section{
+			Name: s.Name,
+			Addr: base + uint64(s.VirtualAddress),
+			Size: uint64(s.VirtualSize),
+		})
+	}
+	return out
+}
+
+func (b *peBinary) DWARF() (*dwarf.Data, error) {
+	return b.f.DWARF()
+}
+
+func (b *peBinary) LoadAddress() (uint64, bool) {
+	return b.imageBase(), true
+}
+
+func (b *peBinary) GoBuildInfo() *debug.BuildInfo {
+	return readGoBuildInfo(b.path, &peExe{b.f})
+}
+
+func (b *peBinary) Close() error {
+	return b.f.Close()
+}
+
+// fillSizes estimates symbol sizes for formats whose symbol
+// tables don't record them, using the distance to the next
+// symbol's address.
+func fillSizes(syms []Symbol) {
+	idx := make([]int, len(syms))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		return syms[idx[a]].Value < syms[idx[b]].Value
+	})
+
+	for i, cur := range idx {
+		for _, next := range idx[i+1:] {
+			if syms[next].Value > syms[cur].Value {
+				syms[cur].Size = syms[next].Value - syms[cur].Value
+				break
+			}
+		}
+	}
+}
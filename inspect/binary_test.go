@@ -0,0 +1,83 @@
+package inspect
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"errors"
+	"testing"
+)
+
+func TestMachoSymbolsSkipsStabs(t *testing.T) {
+	text := &macho.Section{SectionHeader: macho.SectionHeader{Name: "__text", Seg: "__TEXT"}}
+	f := &macho.File{
+		Sections: []*macho.Section{text},
+		Symtab: &macho.Symtab{Syms: []macho.Symbol{
+			{Name: "", Type: 0x2e, Sect: 1, Value: 0x1000}, // N_BNSYM
+			{Name: "_main", Type: 0x2e, Sect: 1, Value: 0x1000},
+			{Name: "_main", Type: 0x0f, Sect: 1, Value: 0x1000},
+			{Name: "", Type: 0x4e, Sect: 1, Value: 0x20}, // N_ENSYM
+			{Name: "_helper", Type: 0x0e, Sect: 1, Value: 0x1020},
+			{Name: "_undef", Type: 0x01, Sect: 0},
+		}},
+	}
+
+	syms, err := (&machoBinary{f: f}).Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range syms {
+		names = append(names, s.Name)
+		if !s.Func || s.Section != "__TEXT,__text" {
+			t.Errorf("%s: Func %v Section %q, want a function in __TEXT,__text", s.Name, s.Func, s.Section)
+		}
+	}
+	if len(names) != 2 || names[0] != "_main" || names[1] != "_helper" {
+		t.Errorf("got symbols %q, want [_main _helper]", names)
+	}
+}
+
+func TestELFSymbolsDedup(t *testing.T) {
+	// -rdynamic exports add, so it's in both symbol tables
+	f, _ := openELF(t, "c-rdynamic")
+	static, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynamic, err := f.DynamicSymbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(syms []elf.Symbol, name string) int {
+		n := 0
+		for _, s := range syms {
+			if s.Name == name {
+				n++
+			}
+		}
+		return n
+	}
+	if count(static, "add") != 1 || count(dynamic, "add") != 1 {
+		t.Fatalf("fixture has add %d times in .symtab and %d in .dynsym, want once in each", count(static, "add"), count(dynamic, "add"))
+	}
+
+	syms, err := ELFSymbols(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"add", "main", "scale"} {
+		if n := count(syms, name); n != 1 {
+			t.Errorf("got %s %d times, want once", name, n)
+		}
+	}
+	if matches, _ := findFunction(syms, "add"); len(matches) != 1 {
+		t.Errorf("add matched %d symbols, want 1", len(matches))
+	}
+}
+
+func TestELFSymbolsStripped(t *testing.T) {
+	f, _ := openELF(t, "go-stripped")
+	if _, err := ELFSymbols(f); !errors.Is(err, ErrStripped) {
+		t.Errorf("got err %v, want ErrStripped", err)
+	}
+}
@@ -0,0 +1,187 @@
+package inspect
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var bloatTop int
+
+func bloatCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "bloat <file>",
+		Short: "Show the packages contributing the most symbol size",
+		Run:   bloatAction,
+	}
+
+	cmd.Flags().IntVarP(&bloatTop, "top", "n", 20, "Number of packages to show (0 for all)")
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+	addSortFlags(&cmd, "name", "size")
+
+	return &cmd
+}
+
+func bloatAction(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		log.Fatalf("Usage: bloat <file>")
+	}
+
+	bin, err := OpenBinary(args[0])
+	if err != nil {
+		log.Fatalf("Open binary err: %s", err)
+	}
+
+	defer bin.Close()
+
+	report, err := Bloat(bin)
+	if err != nil {
+		log.Fatalf("Get symbols err: %s", err)
+	}
+
+	if bloatTop > 0 && len(report) > bloatTop {
+		report = report[:bloatTop]
+	}
+
+	// --top picks the largest packages, then --sort orders them
+	sortList(report, sortKeys{
+		name: func(i int) string { return report[i].Package },
+		size: func(i int) uint64 { return report[i].Size },
+	})
+
+	if structuredOutput() {
+		printStructured(report)
+		return
+	}
+
+	var total uint64
+	for _, p := range report {
+		total += p.Size
+	}
+
+	for _, p := range report {
+		pct := float64(0)
+		if total > 0 {
+			pct = 100 * float64(p.Size) / float64(total)
+		}
+		fmt.Printf("%10d %5.1f%% %6d  %s\n", p.Size, pct, p.Symbols, p.Package)
+	}
+}
+
+// PackageSize is the total size of the symbols under a package
+// or namespace prefix.
+type PackageSize struct {
+	Package string `json:"package"`
+	Size    uint64 `json:"size"`
+	Symbols int    `json:"symbols"`
+}
+
+// Bloat totals the sizes of b's function and data symbols by
+// package, largest first.
+func Bloat(b Binary) ([]PackageSize, error) {
+	symbols, err := b.Symbols()
+	if err != nil {
+		return nil, err
+	}
+
+	// the elf symbol tables can list the same symbol twice
+	type symKey struct {
+		name  string
+		value uint64
+	}
+	seen := make(map[symKey]bool)
+
+	byPkg := make(map[string]*PackageSize)
+	for _, sym := range symbols {
+		if sym.Size == 0 || sym.Value == 0 {
+			continue
+		}
+		key := symKey{sym.Name, sym.Value}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		pkg := symbolPackage(sym.Name)
+		p := byPkg[pkg]
+		if p == nil {
+			p = &PackageSize{Package: pkg}
+			byPkg[pkg] = p
+		}
+		p.Size += sym.Size
+		p.Symbols++
+	}
+
+	out := make([]PackageSize, 0, len(byPkg))
+	for _, p := range byPkg {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Size != out[j].Size {
+			return out[i].Size > out[j].Size
+		}
+		return out[i].Package < out[j].Package
+	})
+
+	return out, nil
+}
+
+// symbolPackage returns the package part of a symbol name. For Go
+// symbols that's everything before the first '.' following the
+// last '/', so methods and closures are counted with their
+// package. C++ and Rust names are grouped by their enclosing
+// namespace. Names with no package are grouped together.
+func symbolPackage(name string) string {
+	if d := demangledName(name); d != "" {
+		return namespace(d)
+	}
+
+	// compiler generated type descriptors, itabs, etc.
+	for _, prefix := range []string{"type:", "go:", "go."} {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimRight(prefix, ":.")
+		}
+	}
+
+	// skip past the import path, which may itself contain dots
+	start := strings.LastIndex(name, "/") + 1
+	if i := strings.Index(name[start:], "."); i > 0 {
+		return name[:start+i]
+	}
+
+	return "(none)"
+}
+
+// namespace returns the scope of a demangled name, ignoring any
+// "::" inside template args or the parameter list.
+func namespace(name string) string {
+	var depth, last int
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case '<':
+			depth++
+		case '>':
+			depth--
+		case '(':
+			if depth == 0 && i > 0 {
+				i = len(name)
+				continue
+			}
+			depth++
+		case ')':
+			depth--
+		case ':':
+			if depth == 0 && strings.HasPrefix(name[i:], "::") {
+				last = i
+				i++
+			}
+		}
+	}
+	if last == 0 {
+		return "(none)"
+	}
+	return name[:last]
+}
@@ -0,0 +1,114 @@
+package inspect
+
+import (
+	"debug/dwarf"
+
+	"github.com/psanford/pptrace/internal/dwarfutil"
+)
+
+// FuncArgsAt returns the signature of each subprogram in d whose
+// address ranges contain addr. Usually there's one; nested
+// functions also match their enclosing function.
+func FuncArgsAt(d *dwarf.Data, addr uint64) ([]FunctionArgs, error) {
+	files := newDeclFiles(d)
+	all := func(string) bool { return true }
+
+	funcs := make([]FunctionArgs, 0)
+	err := dwarfutil.Units(d, func(root, cu *dwarfutil.Node) error {
+		cu.Walk(func(node *dwarfutil.Node) {
+			if node.Entry.Tag != dwarf.TagSubprogram || !containsAddr(d, &node.Entry, addr) {
+				return
+			}
+			if f, ok := funcArgs(root, node, all); ok {
+				f.File, f.Line = files.location(&cu.Entry, node.Entry, subprogramDecl(root, node.Entry))
+				funcs = append(funcs, f)
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return funcs, nil
+}
+
+// containsAddr reports whether addr is in one of e's address
+// ranges, which may be discontiguous.
+func containsAddr(d *dwarf.Data, e *dwarf.Entry, addr uint64) bool {
+	ranges, err := d.Ranges(e)
+	if err != nil {
+		return false
+	}
+	for _, r := range ranges {
+		if addr >= r[0] && addr < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// AddrVariable is a global variable containing an address.
+type AddrVariable struct {
+	Variable
+	Size int64 `json:"size"`
+	// Offset is where the address is within the variable.
+	Offset int64 `json:"offset"`
+	// Layout is the variable's type if it's a struct, union or
+	// class.
+	Layout *Type `json:"layout,omitempty"`
+}
+
+// VariableAt returns the global variables in d whose storage
+// contains addr. Composite types are laid out as filter's Depth
+// and FollowPointers ask; its other fields are ignored.
+func VariableAt(d *dwarf.Data, addr uint64, filter TypeFilter) ([]AddrVariable, error) {
+	vars := make([]AddrVariable, 0)
+	err := globalVariables(d, func(root *dwarfutil.Node, v Variable, decl dwarf.Entry) {
+		if v.Address == nil || addr < *v.Address {
+			return
+		}
+		typeNode := typeRef(root, decl)
+		size := typeSize(root, typeNode)
+		offset := int64(addr - *v.Address)
+		// a variable of unknown size only contains its own address
+		if offset >= size && offset != 0 {
+			return
+		}
+
+		av := AddrVariable{
+			Variable: v,
+			Size:     size,
+			Offset:   offset,
+		}
+		if n := resolveQualifiers(root, typeNode); n != nil && isComposite(n.Entry.Tag) && n.Entry.Tag != dwarf.TagEnumerationType {
+			av.Layout = &Type{
+				Name:    v.Type,
+				Kind:    typeKinds[n.Entry.Tag],
+				Size:    size,
+				Align:   typeAlign(root, n),
+				Members: expandMembers(root, n, filter),
+			}
+		}
+		vars = append(vars, av)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+// resolveQualifiers follows typedefs and const and volatile
+// qualifiers to the underlying type node.
+func resolveQualifiers(root *dwarfutil.Node, n *dwarfutil.Node) *dwarfutil.Node {
+	for n != nil {
+		switch n.Entry.Tag {
+		case dwarf.TagTypedef, dwarf.TagConstType, dwarf.TagVolatileType:
+			n = typeRef(root, n.Entry)
+		default:
+			return n
+		}
+	}
+	return nil
+}
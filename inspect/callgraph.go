@@ -0,0 +1,293 @@
+package inspect
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+var callDepth int
+
+func callgraphCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:               "callgraph <file> [root]",
+		Short:             "Emit a Graphviz call graph of direct calls",
+		Long:              "Emit a Graphviz DOT call graph built from the direct call instructions of each function, e.g. pptrace inspect callgraph ./prog main | dot -Tsvg > calls.svg. Indirect calls can't be resolved statically and are only counted.",
+		Run:               callgraphAction,
+		ValidArgsFunction: completeFileThen(CompleteFunctions),
+	}
+
+	cmd.Flags().IntVarP(&callDepth, "depth", "", 0, "Only follow calls this many levels from the root (0 for no limit)")
+
+	return &cmd
+}
+
+func callgraphAction(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		log.Fatalf("Usage: callgraph <file> [root]")
+	}
+
+	exe, err := elf.Open(args[0])
+	if err != nil {
+		log.Fatalf("Open elf err: %s", err)
+	}
+
+	defer exe.Close()
+
+	var root string
+	if len(args) > 1 {
+		root = args[1]
+	}
+
+	g, err := CallGraph(exe, root, callDepth)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	WriteDOT(w, g)
+	w.Flush()
+}
+
+// CallNode is a function in a call graph and the functions it
+// calls directly.
+type CallNode struct {
+	Name  string   `json:"name"`
+	Addr  uint64   `json:"addr"`
+	Calls []string `json:"calls"`
+	// Indirect is the number of calls through a register or
+	// memory operand, whose targets aren't known.
+	Indirect int `json:"indirect"`
+}
+
+// CallGraph disassembles the functions of exe and returns the
+// direct calls between them. If root is set only functions
+// reachable from it are included, up to depth calls away when
+// depth is positive. Calls to addresses that aren't the start of a
+// known function are named by address, or as sym@plt for calls
+// through the PLT.
+func CallGraph(exe *elf.File, root string, depth int) ([]CallNode, error) {
+	funcs, err := Functions(&elfBinary{f: exe}, "", false)
+	if err != nil {
+		return nil, fmt.Errorf("get symbols err: %w", err)
+	}
+
+	byAddr := make(map[uint64]Function)
+	byName := make(map[string]Function)
+	for _, f := range funcs {
+		if f.Value == 0 || f.Size == 0 {
+			continue
+		}
+		if _, ok := byAddr[f.Value]; !ok {
+			byAddr[f.Value] = f
+		}
+		if _, ok := byName[f.Name]; !ok {
+			byName[f.Name] = f
+		}
+	}
+
+	plt := pltTargets(exe)
+	targetName := func(addr uint64) string {
+		if f, ok := byAddr[addr]; ok {
+			return f.Name
+		}
+		if name, ok := plt[addr]; ok {
+			return name + "@plt"
+		}
+		return fmt.Sprintf("0x%x", addr)
+	}
+
+	visit := func(f Function) (CallNode, error) {
+		n := CallNode{
+			Name:  f.Name,
+			Addr:  f.Value,
+			Calls: make([]string, 0),
+		}
+		code, err := readAddr(exe, f.Value, f.Size)
+		if err != nil {
+			return n, fmt.Errorf("read function %s err: %w", f.Name, err)
+		}
+		insts, err := decode(exe.Machine, code, f.Value, 0)
+		if err != nil {
+			return n, err
+		}
+		seen := make(map[string]bool)
+		for _, inst := range insts {
+			if !inst.Call {
+				continue
+			}
+			if inst.Target == 0 {
+				n.Indirect++
+				continue
+			}
+			callee := targetName(inst.Target)
+			if !seen[callee] {
+				seen[callee] = true
+				n.Calls = append(n.Calls, callee)
+			}
+		}
+		return n, nil
+	}
+
+	var nodes []CallNode
+
+	if root == "" {
+		addrs := make([]uint64, 0, len(byAddr))
+		for addr := range byAddr {
+			addrs = append(addrs, addr)
+		}
+		sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+		for _, addr := range addrs {
+			n, err := visit(byAddr[addr])
+			if err != nil {
+				continue
+			}
+			nodes = append(nodes, n)
+		}
+		return nodes, nil
+	}
+
+	start, ok := byName[root]
+	if !ok {
+		return nil, fmt.Errorf("function %s not found", root)
+	}
+
+	// breadth first so --depth counts the shortest call chain
+	type queued struct {
+		f     Function
+		level int
+	}
+	queue := []queued{{start, 0}}
+	done := map[string]bool{start.Name: true}
+	for len(queue) > 0 {
+		q := queue[0]
+		queue = queue[1:]
+
+		n, err := visit(q.f)
+		if err != nil {
+			return nil, err
+		}
+		if depth > 0 && q.level == depth {
+			// leaf at the depth limit; show it but not its calls
+			n.Calls = n.Calls[:0]
+			n.Indirect = 0
+		}
+		nodes = append(nodes, n)
+
+		for _, callee := range n.Calls {
+			f, ok := byName[callee]
+			if !ok || done[callee] {
+				continue
+			}
+			done[callee] = true
+			queue = append(queue, queued{f, q.level + 1})
+		}
+	}
+
+	return nodes, nil
+}
+
+// pltTargets maps the addresses of x86-64 PLT stubs to the names
+// of the symbols they jump to, by decoding each stub's indirect
+// jump and matching its GOT slot against the PLT relocations.
+func pltTargets(exe *elf.File) map[uint64]string {
+	out := make(map[uint64]string)
+	if exe.Machine != elf.EM_X86_64 || exe.Class != elf.ELFCLASS64 {
+		return out
+	}
+
+	relSec := exe.Section(".rela.plt")
+	if relSec == nil {
+		return out
+	}
+	relData, err := relSec.Data()
+	if err != nil {
+		return out
+	}
+	dsyms, err := exe.DynamicSymbols()
+	if err != nil {
+		return out
+	}
+
+	// GOT slot -> symbol name
+	slots := make(map[uint64]string)
+	for len(relData) >= 24 {
+		off := exe.ByteOrder.Uint64(relData)
+		info := exe.ByteOrder.Uint64(relData[8:])
+		relData = relData[24:]
+		// symbol indexes count the null symbol DynamicSymbols omits
+		idx := elf.R_SYM64(info)
+		if idx == 0 || int(idx) > len(dsyms) {
+			continue
+		}
+		slots[off] = dsyms[idx-1].Name
+	}
+
+	for _, name := range []string{".plt", ".plt.sec"} {
+		s := exe.Section(name)
+		if s == nil {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			continue
+		}
+		// stubs are 16 bytes; the lazy binding header in .plt
+		// doesn't jump through a relocated slot so it's skipped
+		// naturally
+		for i := 0; i+16 <= len(data); i += 16 {
+			stub := s.Addr + uint64(i)
+			if slot, ok := stubSlot(data[i:i+16], stub); ok {
+				if sym, ok := slots[slot]; ok {
+					out[stub] = sym
+				}
+			}
+		}
+	}
+
+	return out
+}
+
+// stubSlot returns the GOT address a PLT stub at pc jumps through,
+// skipping an endbr64 and bnd prefix if present.
+func stubSlot(code []byte, pc uint64) (uint64, bool) {
+	for len(code) > 0 {
+		inst, err := x86asm.Decode(code, 64)
+		if err != nil {
+			return 0, false
+		}
+		next := pc + uint64(inst.Len)
+		if inst.Op == x86asm.JMP {
+			mem, ok := inst.Args[0].(x86asm.Mem)
+			if !ok || mem.Base != x86asm.RIP {
+				return 0, false
+			}
+			return next + uint64(mem.Disp), true
+		}
+		code = code[inst.Len:]
+		pc = next
+	}
+	return 0, false
+}
+
+// WriteDOT writes nodes to w as a Graphviz digraph.
+func WriteDOT(w io.Writer, nodes []CallNode) {
+	fmt.Fprintln(w, "digraph callgraph {")
+	fmt.Fprintln(w, "\tnode [shape=box];")
+	for _, n := range nodes {
+		if n.Indirect > 0 {
+			fmt.Fprintf(w, "\t%q [label=%q];\n", n.Name, fmt.Sprintf("%s\n%d indirect", n.Name, n.Indirect))
+		}
+		for _, callee := range n.Calls {
+			fmt.Fprintf(w, "\t%q -> %q;\n", n.Name, callee)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
@@ -0,0 +1,97 @@
+package inspect
+
+import (
+	"debug/dwarf"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// maxCompletions caps the number of suggestions so completing in a
+// large binary stays fast and doesn't flood the terminal.
+const maxCompletions = 200
+
+// CompleteFunctions returns the sorted names of the function
+// symbols in the binary at path that start with prefix.
+func CompleteFunctions(path, prefix string) []string {
+	bin, err := OpenBinary(path)
+	if err != nil {
+		return nil
+	}
+	defer bin.Close()
+
+	symbols, err := bin.Symbols()
+	if err != nil {
+		return nil
+	}
+
+	names := make(map[string]bool)
+	for _, sym := range symbols {
+		// imported functions can't be traced in this binary
+		if sym.Func && sym.Defined() && sym.Name != "" && strings.HasPrefix(sym.Name, prefix) {
+			names[sym.Name] = true
+		}
+	}
+	return capCompletions(names)
+}
+
+// CompleteTypes returns the sorted names of the types defined in
+// the debug info of the binary at path that start with prefix.
+func CompleteTypes(path, prefix string) []string {
+	bin, err := OpenBinary(path)
+	if err != nil {
+		return nil
+	}
+	defer bin.Close()
+
+	d, err := bin.DWARF()
+	if err != nil {
+		return nil
+	}
+
+	// a flat scan of the entries; building the tree that Types
+	// uses is too slow to run on every keypress
+	names := make(map[string]bool)
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil || e == nil {
+			break
+		}
+		if _, ok := typeKinds[e.Tag]; !ok {
+			continue
+		}
+		name, _ := e.Val(dwarf.AttrName).(string)
+		if name != "" && strings.HasPrefix(name, prefix) {
+			names[name] = true
+		}
+	}
+	return capCompletions(names)
+}
+
+func capCompletions(names map[string]bool) []string {
+	out := make([]string, 0, len(names))
+	for name := range names {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	if len(out) > maxCompletions {
+		out = out[:maxCompletions]
+	}
+	return out
+}
+
+// completeFileThen returns a ValidArgsFunction for commands taking
+// a file followed by one argument completed by names.
+func completeFileThen(names func(path, prefix string) []string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		switch len(args) {
+		case 0:
+			return nil, cobra.ShellCompDirectiveDefault
+		case 1:
+			return names(args[0], toComplete), cobra.ShellCompDirectiveNoFileComp
+		}
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
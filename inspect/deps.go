@@ -0,0 +1,121 @@
+package inspect
+
+import (
+	"debug/elf"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func depsCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "deps <file>",
+		Short: "List shared library dependencies and library search paths",
+		Run:   depsAction,
+	}
+
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+
+	return &cmd
+}
+
+func depsAction(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		log.Fatalf("Usage: deps <file>")
+	}
+
+	exe, err := elf.Open(args[0])
+	if err != nil {
+		log.Fatalf("Open elf err: %s", err)
+	}
+
+	defer exe.Close()
+
+	deps, err := Dependencies(exe)
+	if err != nil {
+		log.Fatalf("Read dynamic section err: %s", err)
+	}
+
+	if structuredOutput() {
+		printStructured(deps)
+		return
+	}
+
+	if deps.Static {
+		fmt.Println("statically linked")
+		return
+	}
+
+	if deps.Interpreter != "" {
+		fmt.Printf("Interpreter: %s\n", deps.Interpreter)
+	}
+	for _, lib := range deps.Needed {
+		fmt.Printf("NEEDED %s\n", lib)
+	}
+	for _, p := range deps.RPath {
+		fmt.Printf("RPATH %s\n", p)
+	}
+	for _, p := range deps.RunPath {
+		fmt.Printf("RUNPATH %s\n", p)
+	}
+}
+
+// Deps is the dynamic linking information of an elf file.
+type Deps struct {
+	Static      bool     `json:"static"`
+	Interpreter string   `json:"interpreter,omitempty"`
+	Needed      []string `json:"needed"`
+	RPath       []string `json:"rpath,omitempty"`
+	RunPath     []string `json:"runpath,omitempty"`
+}
+
+// Dependencies reads the DT_NEEDED, DT_RPATH and DT_RUNPATH
+// entries from exe's dynamic section.
+func Dependencies(exe *elf.File) (*Deps, error) {
+	deps := &Deps{
+		Needed: make([]string, 0),
+	}
+
+	if exe.Section(".dynamic") == nil {
+		deps.Static = true
+		return deps, nil
+	}
+
+	for _, prog := range exe.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		interp := make([]byte, prog.Filesz)
+		if _, err := prog.ReadAt(interp, 0); err != nil {
+			return nil, fmt.Errorf("read interpreter err: %w", err)
+		}
+		deps.Interpreter = strings.TrimRight(string(interp), "\x00")
+	}
+
+	libs, err := exe.ImportedLibraries()
+	if err != nil {
+		return nil, err
+	}
+	deps.Needed = append(deps.Needed, libs...)
+
+	for _, d := range []struct {
+		tag  elf.DynTag
+		dest *[]string
+	}{
+		{elf.DT_RPATH, &deps.RPath},
+		{elf.DT_RUNPATH, &deps.RunPath},
+	} {
+		paths, err := exe.DynString(d.tag)
+		if err != nil {
+			return nil, err
+		}
+		// each entry is a colon separated search path
+		for _, p := range paths {
+			*d.dest = append(*d.dest, strings.Split(p, ":")...)
+		}
+	}
+
+	return deps, nil
+}
@@ -0,0 +1,461 @@
+package inspect
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+)
+
+var diffExitCode bool
+
+func diffCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "diff <old-file> <new-file>",
+		Short: "Compare the functions, package sizes and struct layouts of two builds",
+		Long: "Compare the functions, package sizes and struct layouts of two builds. " +
+			"Struct layouts are compared from debug info, and skipped if either file has none.",
+		Run: diffAction,
+	}
+
+	cmd.Flags().BoolVarP(&noCRCCheck, "no-crc-check", "", false, "Don't verify debuglink CRC of separate debug files")
+	cmd.Flags().BoolVarP(&diffExitCode, "exit-code", "", false, "Exit with status 1 if a function or type was removed or a type's layout changed")
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+
+	return &cmd
+}
+
+func diffAction(cmd *cobra.Command, args []string) {
+	if len(args) != 2 {
+		log.Fatalf("Usage: diff <old-file> <new-file>")
+	}
+
+	var (
+		bins   [2]Binary
+		dwarfs [2]*dwarf.Data
+	)
+	for i, path := range args {
+		bin, err := OpenBinary(path)
+		if err != nil {
+			log.Fatalf("Open binary err: %s", err)
+		}
+		defer bin.Close()
+		bins[i] = bin
+
+		dbin, d, err := loadDwarf(path)
+		if err != nil {
+			log.Printf("not comparing types: %s", err)
+			continue
+		}
+		defer dbin.Close()
+		dwarfs[i] = d
+	}
+
+	diff, err := DiffBinaries(bins[0], bins[1], dwarfs[0], dwarfs[1])
+	if err != nil {
+		log.Fatalf("Diff err: %s", err)
+	}
+
+	if structuredOutput() {
+		printStructured(diff)
+	} else {
+		printDiff(diff)
+	}
+
+	if diffExitCode && diff.Breaking() {
+		os.Exit(1)
+	}
+}
+
+func printDiff(diff *BinaryDiff) {
+	f := diff.Functions
+	var total int64
+	for _, r := range f.Resized {
+		total += r.Delta
+	}
+	for _, a := range f.Added {
+		total += int64(a.Size)
+	}
+	for _, r := range f.Removed {
+		total -= int64(r.Size)
+	}
+	fmt.Printf("functions: %d added, %d removed, %d resized (%+d bytes)\n", len(f.Added), len(f.Removed), len(f.Resized), total)
+	for _, a := range f.Added {
+		fmt.Printf("  + %s (%d bytes)\n", a.Name, a.Size)
+	}
+	for _, r := range f.Removed {
+		fmt.Printf("  - %s (%d bytes)\n", r.Name, r.Size)
+	}
+	for _, r := range f.Resized {
+		fmt.Printf("  ~ %s %d -> %d (%+d)\n", r.Name, r.Old, r.New, r.Delta)
+	}
+
+	if len(diff.Packages) > 0 {
+		fmt.Printf("packages: %d changed size\n", len(diff.Packages))
+		for _, p := range diff.Packages {
+			fmt.Printf("  ~ %s %d -> %d (%+d)\n", p.Name, p.Old, p.New, p.Delta)
+		}
+	}
+
+	if !diff.TypesCompared {
+		return
+	}
+	var added, removed, changed int
+	for _, t := range diff.Types {
+		switch t.Change {
+		case "added":
+			added++
+		case "removed":
+			removed++
+		default:
+			changed++
+		}
+	}
+	fmt.Printf("types: %d added, %d removed, %d changed layout\n", added, removed, changed)
+	for _, t := range diff.Types {
+		switch t.Change {
+		case "added":
+			fmt.Printf("  + %s %s (size %d)\n", t.Kind, t.Name, t.NewSize)
+		case "removed":
+			fmt.Printf("  - %s %s (size %d)\n", t.Kind, t.Name, t.OldSize)
+		default:
+			if t.OldSize != t.NewSize {
+				fmt.Printf("  ~ %s %s size %d -> %d\n", t.Kind, t.Name, t.OldSize, t.NewSize)
+			} else {
+				fmt.Printf("  ~ %s %s\n", t.Kind, t.Name)
+			}
+			for _, m := range t.Members {
+				printMemberChange(m)
+			}
+		}
+	}
+}
+
+func printMemberChange(m MemberChange) {
+	switch m.Change {
+	case "added":
+		fmt.Printf("      + %s %s at %d\n", m.Name, m.New.Type, m.New.Offset)
+	case "removed":
+		fmt.Printf("      - %s %s at %d\n", m.Name, m.Old.Type, m.Old.Offset)
+	default:
+		var what string
+		if m.Old.Offset != m.New.Offset {
+			what += fmt.Sprintf(" offset %d -> %d", m.Old.Offset, m.New.Offset)
+		}
+		if m.Old.Type != m.New.Type {
+			what += fmt.Sprintf(" type %s -> %s", m.Old.Type, m.New.Type)
+		}
+		if m.Old.Size != m.New.Size {
+			what += fmt.Sprintf(" size %d -> %d", m.Old.Size, m.New.Size)
+		}
+		fmt.Printf("      ~ %s%s\n", m.Name, what)
+	}
+}
+
+// BinaryDiff is the difference between an old and a new build of
+// a binary.
+type BinaryDiff struct {
+	Functions FunctionsDiff `json:"functions"`
+	// Packages are the packages whose total symbol size changed,
+	// largest change first.
+	Packages []SizeChange `json:"packages"`
+	// TypesCompared is false if either build has no debug info,
+	// leaving Types empty.
+	TypesCompared bool         `json:"types_compared"`
+	Types         []TypeChange `json:"types"`
+}
+
+// FunctionsDiff lists the functions added, removed and resized
+// between two builds, by name.
+type FunctionsDiff struct {
+	Added   []Function   `json:"added"`
+	Removed []Function   `json:"removed"`
+	Resized []SizeChange `json:"resized"`
+}
+
+// SizeChange is a change in the size of a function or package.
+type SizeChange struct {
+	Name  string `json:"name"`
+	Old   uint64 `json:"old"`
+	New   uint64 `json:"new"`
+	Delta int64  `json:"delta"`
+}
+
+// TypeChange is a struct, union or class that was added, removed
+// or whose layout changed.
+type TypeChange struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	// Change is added, removed or changed.
+	Change  string         `json:"change"`
+	OldSize int64          `json:"old_size"`
+	NewSize int64          `json:"new_size"`
+	Members []MemberChange `json:"members,omitempty"`
+}
+
+// MemberChange is a member of a type that was added, removed or
+// changed offset, type or size.
+type MemberChange struct {
+	Name string `json:"name"`
+	// Change is added, removed or changed.
+	Change string  `json:"change"`
+	Old    *Member `json:"old,omitempty"`
+	New    *Member `json:"new,omitempty"`
+}
+
+// Breaking reports whether d has a change that can break code
+// built against the old binary: a removed function or type, or a
+// changed type layout.
+func (d *BinaryDiff) Breaking() bool {
+	if len(d.Functions.Removed) > 0 {
+		return true
+	}
+	for _, t := range d.Types {
+		if t.Change != "added" {
+			return true
+		}
+	}
+	return false
+}
+
+// DiffBinaries compares the defined functions and package sizes of
+// two builds, and their struct, union and class layouts if both
+// oldDwarf and newDwarf are set.
+func DiffBinaries(oldBin, newBin Binary, oldDwarf, newDwarf *dwarf.Data) (*BinaryDiff, error) {
+	var diff BinaryDiff
+
+	oldFuncs, err := definedFunctions(oldBin)
+	if err != nil {
+		return nil, err
+	}
+	newFuncs, err := definedFunctions(newBin)
+	if err != nil {
+		return nil, err
+	}
+	diff.Functions = diffFunctions(oldFuncs, newFuncs)
+
+	oldPkgs, err := Bloat(oldBin)
+	if err != nil {
+		return nil, err
+	}
+	newPkgs, err := Bloat(newBin)
+	if err != nil {
+		return nil, err
+	}
+	diff.Packages = diffPackages(oldPkgs, newPkgs)
+
+	diff.Types = make([]TypeChange, 0)
+	if oldDwarf != nil && newDwarf != nil {
+		oldTypes, err := layoutTypes(oldDwarf)
+		if err != nil {
+			return nil, err
+		}
+		newTypes, err := layoutTypes(newDwarf)
+		if err != nil {
+			return nil, err
+		}
+		diff.TypesCompared = true
+		diff.Types = diffTypes(oldTypes, newTypes)
+	}
+
+	return &diff, nil
+}
+
+// definedFunctions returns b's defined functions by name. Of
+// several with the same name, such as static functions in
+// different files, the first is used.
+func definedFunctions(b Binary) (map[string]Function, error) {
+	funcs, err := Functions(b, "", false)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]Function)
+	for _, f := range funcs {
+		if f.Name == "" || !f.Defined() {
+			continue
+		}
+		if _, ok := out[f.Name]; !ok {
+			out[f.Name] = f
+		}
+	}
+	return out, nil
+}
+
+func diffFunctions(oldFuncs, newFuncs map[string]Function) FunctionsDiff {
+	d := FunctionsDiff{
+		Added:   make([]Function, 0),
+		Removed: make([]Function, 0),
+		Resized: make([]SizeChange, 0),
+	}
+	for name, nf := range newFuncs {
+		of, ok := oldFuncs[name]
+		if !ok {
+			d.Added = append(d.Added, nf)
+			continue
+		}
+		if of.Size != nf.Size {
+			d.Resized = append(d.Resized, sizeChange(name, of.Size, nf.Size))
+		}
+	}
+	for name, of := range oldFuncs {
+		if _, ok := newFuncs[name]; !ok {
+			d.Removed = append(d.Removed, of)
+		}
+	}
+
+	sort.Slice(d.Added, func(i, j int) bool { return d.Added[i].Name < d.Added[j].Name })
+	sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i].Name < d.Removed[j].Name })
+	sortSizeChanges(d.Resized)
+	return d
+}
+
+func diffPackages(oldPkgs, newPkgs []PackageSize) []SizeChange {
+	sizes := make(map[string][2]uint64)
+	for _, p := range oldPkgs {
+		s := sizes[p.Package]
+		s[0] = p.Size
+		sizes[p.Package] = s
+	}
+	for _, p := range newPkgs {
+		s := sizes[p.Package]
+		s[1] = p.Size
+		sizes[p.Package] = s
+	}
+
+	out := make([]SizeChange, 0)
+	for name, s := range sizes {
+		if s[0] != s[1] {
+			out = append(out, sizeChange(name, s[0], s[1]))
+		}
+	}
+	sortSizeChanges(out)
+	return out
+}
+
+func sizeChange(name string, old, new uint64) SizeChange {
+	return SizeChange{
+		Name:  name,
+		Old:   old,
+		New:   new,
+		Delta: int64(new) - int64(old),
+	}
+}
+
+// sortSizeChanges orders changes largest first, growth or
+// shrinkage.
+func sortSizeChanges(changes []SizeChange) {
+	abs := func(n int64) int64 {
+		if n < 0 {
+			return -n
+		}
+		return n
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if a, b := abs(changes[i].Delta), abs(changes[j].Delta); a != b {
+			return a > b
+		}
+		return changes[i].Name < changes[j].Name
+	})
+}
+
+// layoutTypes returns the structs, unions and classes in d by
+// name, using the first definition of each.
+func layoutTypes(d *dwarf.Data) (map[string]Type, error) {
+	types, err := Types(d, TypeFilter{
+		Match:         func(string) bool { return true },
+		CompositeOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]Type)
+	for _, t := range types {
+		if t.Kind != "struct" && t.Kind != "union" && t.Kind != "class" {
+			continue
+		}
+		if _, ok := out[t.Name]; !ok {
+			out[t.Name] = t
+		}
+	}
+	return out, nil
+}
+
+func diffTypes(oldTypes, newTypes map[string]Type) []TypeChange {
+	out := make([]TypeChange, 0)
+	for name, nt := range newTypes {
+		ot, ok := oldTypes[name]
+		if !ok {
+			out = append(out, TypeChange{Name: name, Kind: nt.Kind, Change: "added", NewSize: nt.Size})
+			continue
+		}
+		members := diffMembers(ot.Members, nt.Members)
+		if len(members) > 0 || ot.Size != nt.Size {
+			out = append(out, TypeChange{
+				Name:    name,
+				Kind:    nt.Kind,
+				Change:  "changed",
+				OldSize: ot.Size,
+				NewSize: nt.Size,
+				Members: members,
+			})
+		}
+	}
+	for name, ot := range oldTypes {
+		if _, ok := newTypes[name]; !ok {
+			out = append(out, TypeChange{Name: name, Kind: ot.Kind, Change: "removed", OldSize: ot.Size})
+		}
+	}
+
+	// layout changes first, as they matter most
+	rank := map[string]int{"changed": 0, "removed": 1, "added": 2}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Change != out[j].Change {
+			return rank[out[i].Change] < rank[out[j].Change]
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// diffMembers compares members by name, or by position for
+// unnamed ones.
+func diffMembers(oldMembers, newMembers []Member) []MemberChange {
+	key := func(i int, m Member) string {
+		if m.Name == "" {
+			return fmt.Sprintf("<unnamed %d>", i)
+		}
+		return m.Name
+	}
+	oldByKey := make(map[string]Member)
+	for i, m := range oldMembers {
+		oldByKey[key(i, m)] = m
+	}
+
+	out := make([]MemberChange, 0)
+	seen := make(map[string]bool)
+	for i, nm := range newMembers {
+		k := key(i, nm)
+		seen[k] = true
+		nm := nm
+		om, ok := oldByKey[k]
+		if !ok {
+			out = append(out, MemberChange{Name: k, Change: "added", New: &nm})
+			continue
+		}
+		if om.Offset != nm.Offset || om.Type != nm.Type || om.Size != nm.Size {
+			om := om
+			out = append(out, MemberChange{Name: k, Change: "changed", Old: &om, New: &nm})
+		}
+	}
+	for i, om := range oldMembers {
+		k := key(i, om)
+		if !seen[k] {
+			om := om
+			out = append(out, MemberChange{Name: k, Change: "removed", Old: &om})
+		}
+	}
+	return out
+}
@@ -0,0 +1,211 @@
+package inspect
+
+import (
+	"debug/elf"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/arch/arm64/arm64asm"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+var instCount int
+
+func disasmCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:               "disasm <file> <function>",
+		Short:             "Disassemble a function",
+		Run:               disasmAction,
+		ValidArgsFunction: completeFileThen(CompleteFunctions),
+	}
+
+	cmd.Flags().IntVarP(&instCount, "count", "n", 0, "Max number of instructions to show (0 for all)")
+
+	return &cmd
+}
+
+func disasmAction(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		log.Fatalf("Usage: disasm <file> <function>")
+	}
+
+	exe, err := elf.Open(args[0])
+	if err != nil {
+		log.Fatalf("Open elf err: %s", err)
+	}
+
+	defer exe.Close()
+
+	insts, err := Disassemble(exe, args[1], instCount)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, inst := range insts {
+		fmt.Printf("%016x  %-30s  %s\n", inst.Addr, fmt.Sprintf("% x", inst.Bytes), inst.Text)
+	}
+}
+
+// Instruction is a single decoded machine instruction.
+type Instruction struct {
+	Addr  uint64 `json:"addr"`
+	Bytes []byte `json:"bytes"`
+	Text  string `json:"text"`
+	// Call is set for call instructions. Target is the address
+	// called, or 0 for indirect calls.
+	Call   bool   `json:"call,omitempty"`
+	Target uint64 `json:"target,omitempty"`
+	// Return is set for return instructions.
+	Return bool `json:"return,omitempty"`
+}
+
+// Disassemble decodes up to count instructions (all if count is 0)
+// of the named function. The function is located via the symbol
+// table and the architecture is taken from the elf machine type.
+func Disassemble(exe *elf.File, function string, count int) ([]Instruction, error) {
+	funcs, err := Functions(&elfBinary{f: exe}, function, false)
+	if err != nil {
+		return nil, fmt.Errorf("get symbols err: %w", err)
+	}
+
+	var (
+		fn    Function
+		found bool
+	)
+	for _, f := range funcs {
+		if f.Name == function && f.Value != 0 {
+			fn = f
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("function %s not found", function)
+	}
+
+	code, err := readAddr(exe, fn.Value, fn.Size)
+	if err != nil {
+		return nil, fmt.Errorf("read function %s err: %w", function, err)
+	}
+
+	return decode(exe.Machine, code, fn.Value, count)
+}
+
+// ReturnOffsets returns the offsets from the start of the named
+// function in the ELF file at path of each of its return
+// instructions.
+func ReturnOffsets(path, function string) ([]uint64, error) {
+	exe, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Open elf %s err: %s", path, err)
+	}
+	defer exe.Close()
+
+	insts, err := Disassemble(exe, function, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var offsets []uint64
+	for _, inst := range insts {
+		if inst.Return {
+			offsets = append(offsets, inst.Addr-insts[0].Addr)
+		}
+	}
+	return offsets, nil
+}
+
+// readAddr reads size bytes at virtual address addr from the
+// section that contains it.
+func readAddr(exe *elf.File, addr, size uint64) ([]byte, error) {
+	for _, s := range exe.Sections {
+		if s.Type == elf.SHT_NOBITS || s.Addr == 0 {
+			continue
+		}
+		if s.Addr <= addr && addr+size <= s.Addr+s.Size {
+			data := make([]byte, size)
+			_, err := s.ReadAt(data, int64(addr-s.Addr))
+			if err != nil {
+				return nil, err
+			}
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("address 0x%x not in any section", addr)
+}
+
+func decode(machine elf.Machine, code []byte, pc uint64, count int) ([]Instruction, error) {
+	var insts []Instruction
+
+	for len(code) > 0 && (count == 0 || len(insts) < count) {
+		var (
+			size   int
+			text   string
+			call   bool
+			target uint64
+			ret    bool
+		)
+
+		switch machine {
+		case elf.EM_X86_64, elf.EM_386:
+			mode := 64
+			if machine == elf.EM_386 {
+				mode = 32
+			}
+			inst, err := x86asm.Decode(code, mode)
+			if err != nil {
+				size = 1
+				text = "?"
+			} else {
+				size = inst.Len
+				text = x86asm.GNUSyntax(inst, pc, nil)
+				ret = inst.Op == x86asm.RET
+				if inst.Op == x86asm.CALL {
+					call = true
+					if rel, ok := inst.Args[0].(x86asm.Rel); ok {
+						target = pc + uint64(size) + uint64(int64(rel))
+					}
+				}
+			}
+		case elf.EM_AARCH64:
+			size = 4
+			if len(code) < size {
+				size = len(code)
+			}
+			inst, err := arm64asm.Decode(code)
+			if err != nil {
+				text = "?"
+			} else {
+				text = arm64asm.GNUSyntax(inst)
+				switch inst.Op {
+				case arm64asm.BL:
+					call = true
+					if rel, ok := inst.Args[0].(arm64asm.PCRel); ok {
+						target = pc + uint64(int64(rel))
+					}
+				case arm64asm.BLR:
+					call = true
+				case arm64asm.RET:
+					ret = true
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported machine type %s", machine)
+		}
+
+		insts = append(insts, Instruction{
+			Addr:   pc,
+			Bytes:  code[:size],
+			Text:   text,
+			Call:   call,
+			Target: target,
+			Return: ret,
+		})
+
+		code = code[size:]
+		pc += uint64(size)
+	}
+
+	return insts, nil
+}
@@ -0,0 +1,126 @@
+package inspect
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	dumpOffset uint64
+	dumpLength uint64
+	dumpRaw    bool
+)
+
+func dumpCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "dump <file> <section>",
+		Short: "Hex dump a section",
+		Run:   dumpAction,
+	}
+
+	cmd.Flags().Uint64VarP(&dumpOffset, "offset", "s", 0, "Start at this offset into the section")
+	cmd.Flags().Uint64VarP(&dumpLength, "length", "l", 0, "Number of bytes to dump (0 for the rest of the section)")
+	cmd.Flags().BoolVarP(&dumpRaw, "raw", "", false, "Write the raw bytes instead of a hex dump")
+
+	return &cmd
+}
+
+func dumpAction(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		log.Fatalf("Usage: dump <file> <section>")
+	}
+
+	exe, err := elf.Open(args[0])
+	if err != nil {
+		log.Fatalf("Open elf err: %s", err)
+	}
+
+	defer exe.Close()
+
+	data, err := SectionWindow(exe, args[1], dumpOffset, dumpLength)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if dumpRaw {
+		os.Stdout.Write(data)
+		return
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	HexDump(w, data, dumpOffset)
+	w.Flush()
+}
+
+// SectionWindow returns length bytes of the named section starting
+// at offset. A length of 0 reads to the end of the section.
+func SectionWindow(exe *elf.File, name string, offset, length uint64) ([]byte, error) {
+	s := exe.Section(name)
+	if s == nil {
+		names := make([]string, 0, len(exe.Sections))
+		for _, s := range exe.Sections {
+			if s.Name != "" {
+				names = append(names, s.Name)
+			}
+		}
+		return nil, fmt.Errorf("section %s not found, available sections: %s", name, strings.Join(names, " "))
+	}
+
+	if s.Type == elf.SHT_NOBITS {
+		return nil, fmt.Errorf("section %s has no data in the file (SHT_NOBITS)", name)
+	}
+
+	data, err := s.Data()
+	if err != nil {
+		return nil, fmt.Errorf("read section %s err: %w", name, err)
+	}
+
+	if offset > uint64(len(data)) {
+		return nil, fmt.Errorf("offset 0x%x is past the end of %s (size 0x%x)", offset, name, len(data))
+	}
+	data = data[offset:]
+	if length > 0 && length < uint64(len(data)) {
+		data = data[:length]
+	}
+
+	return data, nil
+}
+
+// HexDump writes data to w in the style of xxd: 16 bytes per line
+// in groups of two, followed by the printable ASCII. Line offsets
+// start at start.
+func HexDump(w io.Writer, data []byte, start uint64) {
+	for i := 0; i < len(data); i += 16 {
+		line := data[i:]
+		if len(line) > 16 {
+			line = line[:16]
+		}
+
+		var hex, ascii strings.Builder
+		for j := 0; j < 16; j++ {
+			if j > 0 && j%2 == 0 {
+				hex.WriteByte(' ')
+			}
+			if j >= len(line) {
+				hex.WriteString("  ")
+				continue
+			}
+			fmt.Fprintf(&hex, "%02x", line[j])
+
+			c := line[j]
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			ascii.WriteByte(c)
+		}
+
+		fmt.Fprintf(w, "%08x: %s  %s\n", start+uint64(i), hex.String(), ascii.String())
+	}
+}
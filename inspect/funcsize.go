@@ -0,0 +1,177 @@
+package inspect
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"log"
+
+	"github.com/psanford/pptrace/internal/dwarfutil"
+	"github.com/spf13/cobra"
+)
+
+func funcsizeCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "funcsize <file> <function>",
+		Short: "Cross-check a function's symbol size against its DWARF pc range",
+		Long: "Compare the size of a function's symbol with the low_pc/high_pc range of its DWARF subprogram. " +
+			"A mismatch bigger than alignment padding usually means a high_pc encoded as an offset was read " +
+			"as an address, or the symbol's size was stripped.",
+		Run: funcsizeAction,
+
+		ValidArgsFunction: completeFileThen(CompleteFunctions),
+	}
+
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+
+	return &cmd
+}
+
+func funcsizeAction(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		log.Fatalf("Usage: funcsize <file> <function>")
+	}
+
+	bin, err := OpenBinary(args[0])
+	if err != nil {
+		log.Fatalf("Open binary err: %s", err)
+	}
+	defer bin.Close()
+
+	dbin, d := openDwarf(args[0])
+	defer dbin.Close()
+
+	sizes, err := FuncSize(bin, d, args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if structuredOutput() {
+		printStructured(sizes)
+		return
+	}
+
+	for i, s := range sizes {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Function: %s\n", s.Function)
+		fmt.Printf("Symbol: 0x%x size %d\n", s.Value, s.SymbolSize)
+		if !s.HasDWARF {
+			fmt.Println("DWARF: no subprogram with a pc range starts at this address")
+			continue
+		}
+		form := "address"
+		if s.HighPCOffset {
+			form = "offset"
+		}
+		fmt.Printf("DWARF: low_pc 0x%x high_pc 0x%x (%s form) size %d\n", s.LowPC, s.HighPC, form, s.DWARFSize)
+		if s.Warning != "" {
+			fmt.Printf("warning: %s\n", s.Warning)
+		}
+	}
+}
+
+// maxAlignPad is the most padding a compiler inserts between
+// functions to align the next one. Symbol sizes usually exclude it
+// while DWARF ranges may not, or the other way around.
+const maxAlignPad = 15
+
+// FunctionSize compares a function's symbol size with the pc range
+// of its DWARF subprogram.
+type FunctionSize struct {
+	Function   string `json:"function"`
+	Value      uint64 `json:"value"`
+	SymbolSize uint64 `json:"symbol_size"`
+	// HasDWARF is false if no subprogram starts at Value, in which
+	// case the DWARF fields are unset.
+	HasDWARF  bool   `json:"has_dwarf"`
+	LowPC     uint64 `json:"low_pc,omitempty"`
+	HighPC    uint64 `json:"high_pc,omitempty"`
+	DWARFSize uint64 `json:"dwarf_size,omitempty"`
+	// HighPCOffset is set if DW_AT_high_pc is encoded as an offset
+	// from low_pc rather than an address, as DWARF 4 allows.
+	HighPCOffset bool `json:"high_pc_offset,omitempty"`
+	// Warning describes a disagreement between the two sizes.
+	Warning string `json:"warning,omitempty"`
+}
+
+// FuncSize looks up the function symbols of b named function and
+// the subprogram in d starting at each one's address, and reports
+// their sizes.
+func FuncSize(b Binary, d *dwarf.Data, function string) ([]FunctionSize, error) {
+	funcs, err := Functions(b, function, false)
+	if err != nil {
+		return nil, fmt.Errorf("get symbols err: %w", err)
+	}
+
+	var out []FunctionSize
+	for _, f := range funcs {
+		if f.Name != function || f.Value == 0 {
+			continue
+		}
+		out = append(out, FunctionSize{
+			Function:   f.Name,
+			Value:      f.Value,
+			SymbolSize: f.Size,
+		})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("function %s not found", function)
+	}
+
+	want := make(map[uint64]int)
+	for i, s := range out {
+		want[s.Value] = i
+	}
+
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, fmt.Errorf("read dwarf err: %w", err)
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagSubprogram {
+			continue
+		}
+		low, high, ok := dwarfutil.PCRange(e)
+		if !ok {
+			continue
+		}
+		i, found := want[low]
+		if !found {
+			continue
+		}
+		delete(want, low)
+
+		s := &out[i]
+		s.HasDWARF = true
+		s.LowPC = low
+		s.HighPC = high
+		s.DWARFSize = high - low
+		if f := e.AttrField(dwarf.AttrHighpc); f != nil && f.Class == dwarf.ClassConstant {
+			s.HighPCOffset = true
+		}
+		s.Warning = sizeWarning(s.SymbolSize, s.DWARFSize)
+	}
+
+	return out, nil
+}
+
+// sizeWarning explains a difference between a symbol size and a
+// DWARF size that is too big to be alignment padding.
+func sizeWarning(symSize, dwarfSize uint64) string {
+	switch {
+	case symSize == 0 && dwarfSize != 0:
+		return "the symbol has no size; it may have been stripped or the function is hand-written assembly"
+	case dwarfSize == 0 && symSize != 0:
+		return "the DWARF subprogram has an empty pc range"
+	case dwarfSize > symSize+maxAlignPad:
+		return fmt.Sprintf("the DWARF range is %d bytes larger than the symbol; high_pc may be misread as an address or offset", dwarfSize-symSize)
+	case symSize > dwarfSize+maxAlignPad:
+		return fmt.Sprintf("the symbol is %d bytes larger than the DWARF range; the function may have out of line parts described elsewhere", symSize-dwarfSize)
+	}
+	return ""
+}
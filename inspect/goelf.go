@@ -6,26 +6,66 @@ package inspect
 
 import (
 	"bytes"
+	"debug/buildinfo"
 	"debug/elf"
+	"debug/macho"
+	"debug/pe"
 	"encoding/binary"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"runtime/debug"
+
+	"github.com/psanford/pptrace/internal/vlog"
 )
 
-func readGoVersionMod(exe *elf.File) (string, string) {
-	infoSection := exe.Section(".go.buildinfo")
-	if infoSection == nil {
-		return "", ""
+var buildInfoMagic = []byte("\xff Go buildinf:")
+
+// exe is the object format specific access needed to
+// read Go build info.
+type exe interface {
+	// ReadData reads and returns up to size bytes starting
+	// at virtual address addr.
+	ReadData(addr, size uint64) ([]byte, error)
+
+	// BuildInfo returns the data starting at the build info
+	// header, or nil if there isn't one.
+	BuildInfo() []byte
+}
+
+// readGoBuildInfo returns the Go build info for the binary at path.
+// debug/buildinfo is tried first; for very old binaries it doesn't
+// understand we fall back to parsing the legacy format directly.
+func readGoBuildInfo(path string, x exe) *debug.BuildInfo {
+	if path != "" {
+		bi, err := buildinfo.ReadFile(path)
+		if err == nil {
+			return bi
+		}
 	}
-	goinfo, err := ioutil.ReadAll(infoSection.Open())
+
+	vers, mod := readGoVersionMod(x)
+	if vers == "" {
+		return nil
+	}
+
+	bi, err := debug.ParseBuildInfo(mod)
 	if err != nil {
-		log.Fatalf("read go.buildinfo err: %s", err)
+		bi = &debug.BuildInfo{}
 	}
-	buildInfoMagic := []byte("\xff Go buildinf:")
+	bi.GoVersion = vers
 
-	if !bytes.HasPrefix(goinfo, buildInfoMagic) {
-		log.Printf("unexpected data in go.buildinfo")
+	return bi
+}
+
+// readGoVersionMod is the legacy build info parser, used when
+// debug/buildinfo fails.
+func readGoVersionMod(x exe) (string, string) {
+	goinfo := x.BuildInfo()
+	if goinfo == nil {
+		return "", ""
+	}
+
+	if len(goinfo) < 32 || !bytes.HasPrefix(goinfo, buildInfoMagic) {
+		vlog.Debugf("unexpected data in go.buildinfo")
 		return "", ""
 	}
 	ptrSize := int(goinfo[14])
@@ -44,11 +84,11 @@ func readGoVersionMod(exe *elf.File) (string, string) {
 		readPtr = bo.Uint64
 	}
 
-	vers := readString(exe, ptrSize, readPtr, readPtr(goinfo[16:]))
+	vers := readString(x, ptrSize, readPtr, readPtr(goinfo[16:]))
 	if vers == "" {
 		return "", ""
 	}
-	mod := readString(exe, ptrSize, readPtr, readPtr(goinfo[16+ptrSize:]))
+	mod := readString(x, ptrSize, readPtr, readPtr(goinfo[16+ptrSize:]))
 	if len(mod) >= 33 && mod[len(mod)-17] == '\n' {
 		// Strip module framing.
 		mod = mod[16 : len(mod)-16]
@@ -59,22 +99,50 @@ func readGoVersionMod(exe *elf.File) (string, string) {
 	return vers, mod
 }
 
-func readString(f *elf.File, ptrSize int, readPtr func([]byte) uint64, addr uint64) string {
-	hdr, err := readData(f, addr, uint64(2*ptrSize))
+func readString(x exe, ptrSize int, readPtr func([]byte) uint64, addr uint64) string {
+	hdr, err := x.ReadData(addr, uint64(2*ptrSize))
 	if err != nil || len(hdr) < 2*ptrSize {
 		return ""
 	}
 	dataAddr := readPtr(hdr)
 	dataLen := readPtr(hdr[ptrSize:])
-	data, err := readData(f, dataAddr, dataLen)
+	data, err := x.ReadData(dataAddr, dataLen)
 	if err != nil || uint64(len(data)) < dataLen {
 		return ""
 	}
 	return string(data)
 }
 
-func readData(f *elf.File, addr, size uint64) ([]byte, error) {
-	for _, prog := range f.Progs {
+// findBuildInfo searches data for the build info magic, which
+// is always 16 byte aligned.
+func findBuildInfo(data []byte) []byte {
+	for i := 0; i+len(buildInfoMagic) <= len(data); i += 16 {
+		if bytes.HasPrefix(data[i:], buildInfoMagic) {
+			return data[i:]
+		}
+	}
+	return nil
+}
+
+type elfExe struct {
+	f *elf.File
+}
+
+func (x *elfExe) BuildInfo() []byte {
+	infoSection := x.f.Section(".go.buildinfo")
+	if infoSection == nil {
+		return nil
+	}
+	goinfo, err := infoSection.Data()
+	if err != nil {
+		vlog.Debugf("read go.buildinfo err: %s", err)
+		return nil
+	}
+	return goinfo
+}
+
+func (x *elfExe) ReadData(addr, size uint64) ([]byte, error) {
+	for _, prog := range x.f.Progs {
 		if prog.Vaddr <= addr && addr <= prog.Vaddr+prog.Filesz-1 {
 			n := prog.Vaddr + prog.Filesz - addr
 			if n > size {
@@ -90,3 +158,94 @@ func readData(f *elf.File, addr, size uint64) ([]byte, error) {
 	}
 	return nil, fmt.Errorf("address not mapped")
 }
+
+type machoExe struct {
+	f *macho.File
+}
+
+func (x *machoExe) BuildInfo() []byte {
+	if s := x.f.Section("__go_buildinfo"); s != nil {
+		data, err := s.Data()
+		if err == nil {
+			return findBuildInfo(data)
+		}
+	}
+	// older toolchains put build info at the start of __data
+	if s := x.f.Section("__data"); s != nil {
+		data, err := s.Data()
+		if err == nil {
+			return findBuildInfo(data)
+		}
+	}
+	return nil
+}
+
+func (x *machoExe) ReadData(addr, size uint64) ([]byte, error) {
+	for _, load := range x.f.Loads {
+		seg, ok := load.(*macho.Segment)
+		if !ok {
+			continue
+		}
+		if seg.Addr <= addr && addr <= seg.Addr+seg.Filesz-1 {
+			if seg.Name == "__PAGEZERO" {
+				continue
+			}
+			n := seg.Addr + seg.Filesz - addr
+			if n > size {
+				n = size
+			}
+			data := make([]byte, n)
+			_, err := seg.ReadAt(data, int64(addr-seg.Addr))
+			if err != nil {
+				return nil, err
+			}
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("address not mapped")
+}
+
+type peExe struct {
+	f *pe.File
+}
+
+func (x *peExe) imageBase() uint64 {
+	switch oh := x.f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		return uint64(oh.ImageBase)
+	case *pe.OptionalHeader64:
+		return oh.ImageBase
+	}
+	return 0
+}
+
+func (x *peExe) BuildInfo() []byte {
+	s := x.f.Section(".data")
+	if s == nil {
+		return nil
+	}
+	data, err := s.Data()
+	if err != nil {
+		return nil
+	}
+	return findBuildInfo(data)
+}
+
+func (x *peExe) ReadData(addr, size uint64) ([]byte, error) {
+	addr -= x.imageBase()
+	for _, sect := range x.f.Sections {
+		if uint64(sect.VirtualAddress) <= addr && addr <= uint64(sect.VirtualAddress+sect.Size-1) {
+			n := uint64(sect.VirtualAddress+sect.Size) - addr
+			if n > size {
+				n = size
+			}
+			data := make([]byte, n)
+			_, err := sect.ReadAt(data, int64(addr-uint64(sect.VirtualAddress)))
+			if err != nil {
+				return nil, err
+			}
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("address not mapped")
+}
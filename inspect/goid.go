@@ -0,0 +1,116 @@
+package inspect
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+
+	"github.com/psanford/pptrace/internal/dwarfutil"
+)
+
+// goGRegister is the register Go code keeps the current goroutine's
+// g in on each architecture. On x86-64 that's only so with the
+// register ABI; before it g was in thread local storage, which
+// fetch args can't read.
+var goGRegister = map[elf.Machine]string{
+	elf.EM_X86_64:  "r14",
+	elf.EM_AARCH64: "x28",
+}
+
+// GoIDFetch returns a fetch arg reading the id of the goroutine
+// running a function of the Go binary at path, as
+// +<offset of goid>(%<g register>). The offset of the goid field
+// differs between Go versions, so it's read from the runtime.g
+// type in the binary's DWARF.
+//
+// The register only holds g in Go code; in assembly functions and
+// cgo calls it may hold anything.
+func GoIDFetch(path string) (string, error) {
+	exe, err := elf.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Open elf %s err: %s", path, err)
+	}
+	defer exe.Close()
+
+	if readGoBuildInfo(path, &elfExe{exe}) == nil {
+		return "", fmt.Errorf("%s is not a Go binary", path)
+	}
+	reg, ok := goGRegister[exe.Machine]
+	if !ok {
+		return "", fmt.Errorf("%s: goroutine ids aren't supported on %s", path, exe.Machine)
+	}
+	abi, err := findABI(path, exe)
+	if err != nil {
+		return "", err
+	}
+	if exe.Machine == elf.EM_X86_64 && abi == &goABI0 {
+		return "", fmt.Errorf("%s: goroutine ids need Go 1.17 or later on x86-64, where g is kept in a register", path)
+	}
+
+	dwarfPath, err := dwarfutil.FindDwarf(path)
+	if err != nil {
+		return "", err
+	}
+	d, err := dwarfutil.FileData(dwarfPath, func() (*dwarf.Data, error) {
+		df, err := elf.Open(dwarfPath)
+		if err != nil {
+			return nil, err
+		}
+		defer df.Close()
+		return dwarfutil.ELFData(df, dwarfPath)
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: read dwarf err: %s", dwarfPath, err)
+	}
+
+	off, err := memberOffset(d, "runtime.g", "goid")
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", path, err)
+	}
+	return fmt.Sprintf("+%d(%%%s)", off, reg), nil
+}
+
+// memberOffset returns the offset of member in the struct type
+// named typeName.
+func memberOffset(d *dwarf.Data, typeName, member string) (int64, error) {
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return 0, err
+		}
+		if e == nil {
+			return 0, fmt.Errorf("no type %s in debug info", typeName)
+		}
+		if e.Tag != dwarf.TagStructType || e.Val(dwarf.AttrName) != typeName {
+			if e.Tag != dwarf.TagCompileUnit && e.Children {
+				r.SkipChildren()
+			}
+			continue
+		}
+		if !e.Children {
+			continue
+		}
+
+		for {
+			m, err := r.Next()
+			if err != nil {
+				return 0, err
+			}
+			if m == nil || m.Tag == 0 {
+				return 0, fmt.Errorf("type %s has no member %s", typeName, member)
+			}
+			if m.Tag != dwarf.TagMember || m.Val(dwarf.AttrName) != member {
+				if m.Children {
+					r.SkipChildren()
+				}
+				continue
+			}
+			off, ok := m.Val(dwarf.AttrDataMemberLoc).(int64)
+			if !ok {
+				return 0, fmt.Errorf("%s.%s has no constant offset", typeName, member)
+			}
+			return off, nil
+		}
+	}
+}
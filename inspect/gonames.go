@@ -0,0 +1,96 @@
+package inspect
+
+import (
+	"debug/elf"
+	"regexp"
+	"strings"
+)
+
+// ptrReceiverRe matches the parenthesized pointer receiver of a Go
+// method symbol, e.g. "(*T)" in "pkg.(*T).Method".
+var ptrReceiverRe = regexp.MustCompile(`\(\*([^()]*)\)`)
+
+// goNameForms returns progressively looser forms of a Go symbol
+// name for matching. typed drops pointer receiver markers and the
+// go.shape. prefix of generic shape arguments, so "pkg.List[int].Push"
+// matches "pkg.(*List[go.shape.int]).Push". bare additionally
+// drops type arguments, so "pkg.List.Push" matches every
+// instantiation.
+func goNameForms(name string) (typed, bare string) {
+	typed = ptrReceiverRe.ReplaceAllString(name, "$1")
+	typed = strings.ReplaceAll(typed, "go.shape.", "")
+
+	var b strings.Builder
+	depth := 0
+	for _, r := range typed {
+		switch {
+		case r == '[':
+			depth++
+		case r == ']' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return typed, b.String()
+}
+
+// findFunction returns the defined function symbols matching
+// name. An exact match is preferred; failing that the Go forms
+// from goNameForms are tried in turn, so methods and generic
+// functions can be named the way they're written in source, and
+// may match several functions. A name with type arguments only
+// matches those instantiations. imported reports whether name is
+// only an undefined reference.
+func findFunction(symbols []elf.Symbol, name string) (matches []elf.Symbol, imported bool) {
+	var defined []elf.Symbol
+	for _, s := range symbols {
+		if elf.ST_TYPE(s.Info) != elf.STT_FUNC {
+			continue
+		}
+		// imported functions are listed with no section and a
+		// zero value; a probe there would be at offset 0
+		if s.Section == elf.SHN_UNDEF || s.Value == 0 {
+			if s.Name == name {
+				imported = true
+			}
+			continue
+		}
+		defined = append(defined, s)
+	}
+
+	for _, s := range defined {
+		if s.Name == name {
+			return []elf.Symbol{s}, false
+		}
+	}
+
+	wantTyped, wantBare := goNameForms(name)
+	forms := []func(string) bool{
+		func(n string) bool {
+			typed, _ := goNameForms(n)
+			return typed == wantTyped
+		},
+	}
+	if wantBare == wantTyped {
+		forms = append(forms, func(n string) bool {
+			_, bare := goNameForms(n)
+			return bare == wantBare
+		})
+	}
+	for _, match := range forms {
+		// aliases of one function aren't ambiguous
+		seen := make(map[uint64]bool)
+		for _, s := range defined {
+			if match(s.Name) && !seen[s.Value] {
+				seen[s.Value] = true
+				matches = append(matches, s)
+			}
+		}
+		if len(matches) > 0 {
+			return matches, false
+		}
+	}
+
+	return nil, imported
+}
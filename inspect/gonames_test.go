@@ -0,0 +1,208 @@
+package inspect
+
+import (
+	"debug/elf"
+	"testing"
+)
+
+// funcSym returns a defined function symbol.
+func funcSym(name string, value uint64) elf.Symbol {
+	return elf.Symbol{
+		Name:    name,
+		Info:    elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
+		Section: 14,
+		Value:   value,
+	}
+}
+
+// importedSym returns an undefined function symbol, as imported
+// functions are listed in .dynsym.
+func importedSym(name string) elf.Symbol {
+	return elf.Symbol{
+		Name:    name,
+		Info:    elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
+		Section: elf.SHN_UNDEF,
+	}
+}
+
+func TestFindFunctionImported(t *testing.T) {
+	tests := []struct {
+		name     string
+		symbols  []elf.Symbol
+		want     []uint64
+		imported bool
+	}{
+		{
+			name:     "imported only",
+			symbols:  []elf.Symbol{importedSym("malloc"), funcSym("main", 0x1000)},
+			imported: true,
+		},
+		{
+			name:    "imported listed first",
+			symbols: []elf.Symbol{importedSym("malloc"), funcSym("malloc", 0x2000)},
+			want:    []uint64{0x2000},
+		},
+		{
+			name:    "imported listed last",
+			symbols: []elf.Symbol{funcSym("malloc", 0x2000), importedSym("malloc")},
+			want:    []uint64{0x2000},
+		},
+		{
+			name: "zero value",
+			symbols: []elf.Symbol{
+				{Name: "malloc", Info: elf.ST_INFO(elf.STB_WEAK, elf.STT_FUNC), Section: 14},
+			},
+			imported: true,
+		},
+		{
+			name:    "not a function",
+			symbols: []elf.Symbol{{Name: "malloc", Info: elf.ST_INFO(elf.STB_GLOBAL, elf.STT_OBJECT), Section: 20, Value: 0x3000}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, imported := findFunction(tt.symbols, "malloc")
+			var got []uint64
+			for _, m := range matches {
+				got = append(got, m.Value)
+			}
+			if !equalValues(got, tt.want) || imported != tt.imported {
+				t.Errorf("got %x, imported %v; want %x, imported %v", got, imported, tt.want, tt.imported)
+			}
+		})
+	}
+}
+
+func equalValues(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFindFunctionImportedFixture(t *testing.T) {
+	f, _ := openELF(t, "c-pie")
+	syms, err := ELFSymbols(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matches, imported := findFunction(syms, "printf"); len(matches) != 0 || !imported {
+		t.Errorf("printf: got %d matches, imported %v; want none, imported", len(matches), imported)
+	}
+	if matches, imported := findFunction(syms, "add"); len(matches) != 1 || matches[0].Value == 0 || imported {
+		t.Errorf("add: got %v, imported %v; want one defined symbol", matches, imported)
+	}
+}
+
+func TestGoNameForms(t *testing.T) {
+	tests := []struct {
+		name, typed, bare string
+	}{
+		{"main.main", "main.main", "main.main"},
+		{"pkg.(*T).Method", "pkg.T.Method", "pkg.T.Method"},
+		{"pkg.T.Method", "pkg.T.Method", "pkg.T.Method"},
+		{"pkg.Max[go.shape.int]", "pkg.Max[int]", "pkg.Max"},
+		{"pkg.Max[int]", "pkg.Max[int]", "pkg.Max"},
+		{"pkg.(*List[go.shape.string]).Push", "pkg.List[string].Push", "pkg.List.Push"},
+		{"pkg.List[go.shape.string].Len", "pkg.List[string].Len", "pkg.List.Len"},
+		{"pkg.Map[go.shape.string,go.shape.[]int].Get", "pkg.Map[string,[]int].Get", "pkg.Map.Get"},
+		{"pkg.(*T).Method.func1", "pkg.T.Method.func1", "pkg.T.Method.func1"},
+		{"github.com/a/b.(*T).M", "github.com/a/b.T.M", "github.com/a/b.T.M"},
+	}
+	for _, tt := range tests {
+		typed, bare := goNameForms(tt.name)
+		if typed != tt.typed || bare != tt.bare {
+			t.Errorf("goNameForms(%q) = %q, %q; want %q, %q", tt.name, typed, bare, tt.typed, tt.bare)
+		}
+	}
+}
+
+func TestFindFunctionGoNames(t *testing.T) {
+	symbols := []elf.Symbol{
+		funcSym("main.(*Point).Scale", 0x1000),
+		funcSym("main.Point.Sum", 0x1100),
+		funcSym("main.Max[go.shape.int]", 0x1200),
+		funcSym("main.Max[go.shape.float64]", 0x1300),
+		funcSym("main.(*List[go.shape.int]).Push", 0x1400),
+		funcSym("main.(*List[go.shape.string]).Push", 0x1500),
+		// an alias of the int instantiation
+		funcSym("main.List[go.shape.int].Push", 0x1400),
+		funcSym("main.Value.Get", 0x1600),
+		funcSym("main.(*Value).Get", 0x1700),
+	}
+
+	tests := []struct {
+		name string
+		want []uint64
+	}{
+		// pointer receivers, with or without the (*T) form
+		{"main.(*Point).Scale", []uint64{0x1000}},
+		{"main.Point.Scale", []uint64{0x1000}},
+		// value receivers
+		{"main.Point.Sum", []uint64{0x1100}},
+		{"main.(*Point).Sum", []uint64{0x1100}},
+		// an exact match is preferred to a looser one
+		{"main.Value.Get", []uint64{0x1600}},
+		{"main.(*Value).Get", []uint64{0x1700}},
+		// generic functions and methods, by instantiation or all
+		// of them
+		{"main.Max[int]", []uint64{0x1200}},
+		{"main.Max[go.shape.float64]", []uint64{0x1300}},
+		{"main.Max", []uint64{0x1200, 0x1300}},
+		{"main.List[string].Push", []uint64{0x1500}},
+		{"main.(*List[int]).Push", []uint64{0x1400}},
+		{"main.List.Push", []uint64{0x1400, 0x1500}},
+		{"main.Max[string]", nil},
+		{"main.Min", nil},
+	}
+
+	for _, tt := range tests {
+		matches, _ := findFunction(symbols, tt.name)
+		var got []uint64
+		for _, m := range matches {
+			got = append(got, m.Value)
+		}
+		if !equalValues(got, tt.want) {
+			t.Errorf("%s: got %x, want %x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFindFunctionGoFixture(t *testing.T) {
+	f, _ := openELF(t, "go-exe")
+	syms, err := ELFSymbols(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"main.Point.Scale", []string{"main.(*Point).Scale"}},
+		{"main.(*Point).Sum", []string{"main.Point.Sum"}},
+		{"main.Max[float64]", []string{"main.Max[go.shape.float64]"}},
+		{"main.Max", []string{"main.Max[go.shape.float64]", "main.Max[go.shape.int]"}},
+	}
+	for _, tt := range tests {
+		matches, _ := findFunction(syms, tt.name)
+		got := make(map[string]bool)
+		for _, m := range matches {
+			got[m.Name] = true
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %d matches, want %q", tt.name, len(matches), tt.want)
+			continue
+		}
+		for _, w := range tt.want {
+			if !got[w] {
+				t.Errorf("%s: %s not matched", tt.name, w)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package inspect
+
+import (
+	"debug/elf"
+	"fmt"
+)
+
+// Hardening reports which exploit mitigations an elf file was
+// built with, similar to checksec.
+type Hardening struct {
+	// NX is set if the GNU_STACK segment is not executable.
+	NX bool `json:"nx"`
+	// RELRO is "full", "partial" or "none".
+	RELRO string `json:"relro"`
+	PIE   bool   `json:"pie"`
+	// Canary is set if the file references __stack_chk_fail.
+	Canary bool `json:"canary"`
+}
+
+// ELFHardening inspects the program headers, dynamic section and
+// symbols of f for exploit mitigations.
+func ELFHardening(f *elf.File) (*Hardening, error) {
+	h := Hardening{
+		RELRO: "none",
+	}
+
+	// without a GNU_STACK header the kernel assumes an
+	// executable stack on most architectures, so NX stays false
+	var hasRelro, hasInterp bool
+	for _, prog := range f.Progs {
+		switch prog.Type {
+		case elf.PT_GNU_STACK:
+			h.NX = prog.Flags&elf.PF_X == 0
+		case elf.PT_GNU_RELRO:
+			hasRelro = true
+		case elf.PT_INTERP:
+			hasInterp = true
+		}
+	}
+	h.PIE = f.Type == elf.ET_DYN && hasInterp
+
+	dyn, err := dynamicEntries(f)
+	if err != nil {
+		return nil, err
+	}
+	if hasRelro {
+		h.RELRO = "partial"
+		if dyn.bindNow() {
+			h.RELRO = "full"
+		}
+	}
+
+	syms, _ := f.Symbols()
+	dsyms, _ := f.DynamicSymbols()
+	for _, sym := range append(syms, dsyms...) {
+		if sym.Name == "__stack_chk_fail" {
+			h.Canary = true
+			break
+		}
+	}
+
+	return &h, nil
+}
+
+type dynEntry struct {
+	tag elf.DynTag
+	val uint64
+}
+
+type dynEntries []dynEntry
+
+func (d dynEntries) bindNow() bool {
+	for _, e := range d {
+		switch {
+		case e.tag == elf.DT_BIND_NOW:
+			return true
+		case e.tag == elf.DT_FLAGS && elf.DynFlag(e.val)&elf.DF_BIND_NOW != 0:
+			return true
+		case e.tag == elf.DT_FLAGS_1 && elf.DynFlag1(e.val)&elf.DF_1_NOW != 0:
+			return true
+		}
+	}
+	return false
+}
+
+// dynamicEntries reads the tag/value pairs from the .dynamic
+// section. Files without one have no entries.
+func dynamicEntries(f *elf.File) (dynEntries, error) {
+	ds := f.Section(".dynamic")
+	if ds == nil || ds.Type == elf.SHT_NOBITS {
+		return nil, nil
+	}
+	data, err := ds.Data()
+	if err != nil {
+		return nil, fmt.Errorf("read .dynamic err: %w", err)
+	}
+
+	var entries dynEntries
+	bo := f.ByteOrder
+	for len(data) > 0 {
+		var e dynEntry
+		switch f.Class {
+		case elf.ELFCLASS32:
+			if len(data) < 8 {
+				return entries, nil
+			}
+			e = dynEntry{elf.DynTag(int32(bo.Uint32(data))), uint64(bo.Uint32(data[4:]))}
+			data = data[8:]
+		case elf.ELFCLASS64:
+			if len(data) < 16 {
+				return entries, nil
+			}
+			e = dynEntry{elf.DynTag(int64(bo.Uint64(data))), bo.Uint64(data[8:])}
+			data = data[16:]
+		default:
+			return nil, fmt.Errorf("unknown elf class %s", f.Class)
+		}
+		if e.tag == elf.DT_NULL {
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
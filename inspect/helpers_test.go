@@ -0,0 +1,124 @@
+package inspect
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"os"
+	"testing"
+
+	"github.com/psanford/pptrace/internal/dwarfutil"
+	"github.com/psanford/pptrace/internal/fixture"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	fixture.Cleanup()
+	os.Exit(code)
+}
+
+// openELF builds the named fixture and opens it, closing it when
+// the test ends.
+func openELF(tb testing.TB, name string) (*elf.File, string) {
+	tb.Helper()
+	path := fixture.Path(tb, name)
+	f, err := elf.Open(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { f.Close() })
+	return f, path
+}
+
+// openDWARF builds the named fixture and reads its debug info,
+// wherever it is.
+func openDWARF(tb testing.TB, name string) *dwarf.Data {
+	tb.Helper()
+	path := fixture.Path(tb, name)
+	dwarfPath, err := dwarfutil.FindDwarf(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	f, err := elf.Open(dwarfPath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	d, err := dwarfutil.ELFData(f, dwarfPath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return d
+}
+
+// symbolValue returns the value of the function symbol name in f.
+func symbolValue(tb testing.TB, f *elf.File, name string) uint64 {
+	tb.Helper()
+	syms, err := f.Symbols()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, s := range syms {
+		if s.Name == name && elf.ST_TYPE(s.Info) == elf.STT_FUNC {
+			return s.Value
+		}
+	}
+	tb.Fatalf("no function symbol %s", name)
+	return 0
+}
+
+// codeAt returns n bytes of the code at virtual address addr of f.
+func codeAt(tb testing.TB, f *elf.File, addr uint64, n int) []byte {
+	tb.Helper()
+	for _, s := range f.Sections {
+		if s.Flags&elf.SHF_EXECINSTR == 0 || addr < s.Addr || addr+uint64(n) > s.Addr+s.Size {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return data[addr-s.Addr : addr-s.Addr+uint64(n)]
+	}
+	tb.Fatalf("no code at 0x%x", addr)
+	return nil
+}
+
+// entry returns a tree node for a DWARF entry at offset off.
+func entry(off dwarf.Offset, tag dwarf.Tag, fields []dwarf.Field, children ...*dwarfutil.Node) *dwarfutil.Node {
+	return &dwarfutil.Node{
+		Entry:    dwarf.Entry{Offset: off, Tag: tag, Children: len(children) > 0, Field: fields},
+		Children: children,
+	}
+}
+
+// fields pairs each attribute with the value following it.
+func fields(attrVals ...interface{}) []dwarf.Field {
+	var out []dwarf.Field
+	for i := 0; i+1 < len(attrVals); i += 2 {
+		f := dwarf.Field{Attr: attrVals[i].(dwarf.Attr), Val: attrVals[i+1]}
+		switch attrVals[i+1].(type) {
+		case string:
+			f.Class = dwarf.ClassString
+		case dwarf.Offset:
+			f.Class = dwarf.ClassReference
+		case int64:
+			f.Class = dwarf.ClassConstant
+		case []byte:
+			f.Class = dwarf.ClassExprLoc
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// entryTree returns a compile unit root holding nodes, with every
+// node under it in its offset map.
+func entryTree(nodes ...*dwarfutil.Node) *dwarfutil.Node {
+	root := entry(0, dwarf.TagCompileUnit, nil, nodes...)
+	root.OffsetMap = make(map[dwarf.Offset]*dwarfutil.Node)
+	root.AddressSize = 8
+	root.Walk(func(n *dwarfutil.Node) {
+		root.OffsetMap[n.Entry.Offset] = n
+	})
+	return root
+}
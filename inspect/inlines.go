@@ -0,0 +1,177 @@
+package inspect
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"log"
+
+	"github.com/psanford/pptrace/internal/dwarfutil"
+	"github.com/spf13/cobra"
+)
+
+func inlinesCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:               "inlines <file> <function>",
+		Short:             "Show where a function was inlined",
+		Long:              "Show where a function was inlined. A uprobe on a function only fires for out of line calls, so calls that were inlined are never seen.",
+		Run:               inlinesAction,
+		ValidArgsFunction: completeFileThen(CompleteFunctions),
+	}
+
+	cmd.Flags().BoolVarP(&exactMatch, "exact", "e", false, "Match on exact name")
+	cmd.Flags().BoolVarP(&noCRCCheck, "no-crc-check", "", false, "Don't verify debuglink CRC of separate debug files")
+	cmd.Flags().StringVarP(&debugFile, "debug-file", "", "", "Read DWARF from this file instead of searching for debug info")
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+
+	return &cmd
+}
+
+func inlinesAction(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		log.Fatalf("Usage: inlines <file> <function>")
+	}
+
+	bin, dwarfInfo := openDwarf(args[0])
+	defer bin.Close()
+
+	inlines, err := Inlines(dwarfInfo, nameMatcher(args[1]))
+	if err != nil {
+		log.Fatalf("Read DWARF err: %s", err)
+	}
+
+	if structuredOutput() {
+		printStructured(inlines)
+		return
+	}
+
+	if len(inlines) == 0 {
+		fmt.Printf("no inlined instances of %s\n", args[1])
+		return
+	}
+
+	for _, in := range inlines {
+		caller := in.Caller
+		if caller == "" {
+			caller = "??"
+		}
+		fmt.Printf("%s in %s at %s\n", in.Function, caller, srcLocation(in.CallFile, in.CallLine))
+		for _, r := range in.Ranges {
+			fmt.Printf("\t%016x-%016x\n", r[0], r[1])
+		}
+	}
+}
+
+// Inline is a copy of a function inlined into another.
+type Inline struct {
+	Function string `json:"function"`
+	// Caller is the function the copy was inlined into. For nested
+	// inlining this is the innermost enclosing function.
+	Caller   string `json:"caller"`
+	CallFile string `json:"call_file"`
+	CallLine int    `json:"call_line"`
+	// Ranges are the [low, high) pc ranges of the inlined code.
+	Ranges [][2]uint64 `json:"ranges"`
+}
+
+// Inlines returns the inlined instances of every function in d
+// whose name satisfies match.
+func Inlines(d *dwarf.Data, match func(name string) bool) ([]Inline, error) {
+	files := newDeclFiles(d)
+
+	out := make([]Inline, 0)
+
+	var visit func(root, cu, n *dwarfutil.Node, caller string)
+	visit = func(root, cu, n *dwarfutil.Node, caller string) {
+		for _, child := range n.Children {
+			childCaller := caller
+			switch child.Entry.Tag {
+			case dwarf.TagSubprogram:
+				decl := subprogramDecl(root, child.Entry)
+				childCaller, _ = decl.Val(dwarf.AttrName).(string)
+			case dwarf.TagInlinedSubroutine:
+				// the abstract origin holds the name of the
+				// function that was inlined
+				decl := subprogramDecl(root, child.Entry)
+				name, _ := decl.Val(dwarf.AttrName).(string)
+				if name != "" && match(name) {
+					in := Inline{
+						Function: name,
+						Caller:   caller,
+						Ranges:   make([][2]uint64, 0),
+					}
+					if idx, ok := child.Entry.Val(dwarf.AttrCallFile).(int64); ok {
+						in.CallFile = files.file(&cu.Entry, idx)
+					}
+					if line, ok := child.Entry.Val(dwarf.AttrCallLine).(int64); ok {
+						in.CallLine = int(line)
+					}
+					if ranges, err := d.Ranges(&child.Entry); err == nil {
+						for _, r := range ranges {
+							in.Ranges = append(in.Ranges, [2]uint64{r[0], r[1]})
+						}
+					}
+					out = append(out, in)
+				}
+				childCaller = name
+			}
+			visit(root, cu, child, childCaller)
+		}
+	}
+
+	err := dwarfutil.Units(d, func(root, cu *dwarfutil.Node) error {
+		visit(root, cu, cu, "")
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// InlinedOnly returns the inlined copies of function in the ELF
+// file at path if its debug info has no out of line copy of it,
+// which a uprobe could be placed on. It returns nil if there is
+// one, or function isn't in the debug info at all.
+func InlinedOnly(path, function string) ([]Inline, error) {
+	dwarfPath, err := dwarfutil.FindDwarf(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := elf.Open(dwarfPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, err := dwarfutil.ELFData(f, dwarfPath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagSubprogram {
+			continue
+		}
+		if _, _, ok := dwarfutil.PCRange(e); !ok {
+			continue
+		}
+		if name, _ := originAttr(d, e, dwarf.AttrName).(string); name == function {
+			return nil, nil
+		}
+	}
+
+	inlines, err := Inlines(d, func(name string) bool { return name == function })
+	if err != nil || len(inlines) == 0 {
+		return nil, err
+	}
+	return inlines, nil
+}
@@ -3,20 +3,34 @@ package inspect
 import (
 	"debug/dwarf"
 	"debug/elf"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"runtime/debug"
 	"strings"
 
 	"github.com/psanford/pptrace/internal/dwarfutil"
+	"github.com/psanford/pptrace/internal/render"
 	"github.com/spf13/cobra"
 )
 
 var (
-	jsonOutput bool
-	allFlag    bool
-	exactMatch bool
+	jsonOutput  bool
+	allFlag     bool
+	exactMatch  bool
+	noCRCCheck  bool
+	debugFile   string
+	typeKind    string
+	typeDepth   int
+	recursive   bool
+	followPtrs  bool
+	showSrc     bool
+	showSig     bool
+	definedOnly bool
+	lookupAddr  string
+
+	demangleNames bool
 )
 
 func Command() *cobra.Command {
@@ -27,79 +41,220 @@ func Command() *cobra.Command {
 
 	cmd.AddCommand(infoCommand())
 	cmd.AddCommand(listSectionsCommand())
+	cmd.AddCommand(listSegmentsCommand())
 	cmd.AddCommand(listSymbolsCommand())
 	cmd.AddCommand(listFunctionsCommand())
 	cmd.AddCommand(typesCommand())
+	cmd.AddCommand(variablesCommand())
 	cmd.AddCommand(functionArgsCommand())
+	cmd.AddCommand(inlinesCommand())
+	cmd.AddCommand(addr2lineCommand())
+	cmd.AddCommand(unitsCommand())
+	cmd.AddCommand(probeOffsetCommand())
+	cmd.AddCommand(disasmCommand())
+	cmd.AddCommand(funcsizeCommand())
+	cmd.AddCommand(callgraphCommand())
+	cmd.AddCommand(bloatCommand())
+	cmd.AddCommand(depsCommand())
+	cmd.AddCommand(whichCommand())
+	cmd.AddCommand(dumpCommand())
+	cmd.AddCommand(tuiCommand())
+	cmd.AddCommand(diffCommand())
 
 	return &cmd
 }
 
 func infoCommand() *cobra.Command {
 	cmd := cobra.Command{
-		Use:   "info <file>",
-		Short: "General information about elf",
+		Use:   "info <file|pid>",
+		Short: "General information about a binary",
+		Long:  "General information about a binary. Given the pid of a running process, its executable is inspected and the load bias it was mapped with is shown. Besides Go build info, an ELF file's compiler versions (.comment), SONAME and distro package metadata (.note.package) are shown if present.",
 		Run:   infoAction,
 	}
 
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+
 	return &cmd
 }
 
+// BinaryInfo is the json form of the info command's output.
+type BinaryInfo struct {
+	Type         string           `json:"type"`
+	MemoryOffset *uint64          `json:"memory_offset,omitempty"`
+	PID          int              `json:"pid,omitempty"`
+	Path         string           `json:"path,omitempty"`
+	LoadBias     *uint64          `json:"load_bias,omitempty"`
+	Hardening    *Hardening       `json:"hardening,omitempty"`
+	Version      *VersionInfo     `json:"version,omitempty"`
+	BuildInfo    *debug.BuildInfo `json:"build_info,omitempty"`
+}
+
 func infoAction(cmd *cobra.Command, args []string) {
 	if len(args) < 1 {
-		log.Fatalf("Usage: info <file>")
+		log.Fatalf("Usage: info <file|pid>")
 	}
 
-	exe, err := elf.Open(args[0])
+	path := args[0]
+	pid, isPID := PIDArg(path)
+	if isPID {
+		var err error
+		path, err = ProcessExecutable(pid)
+		if err != nil {
+			log.Fatalf("Find executable of process %d err: %s", pid, err)
+		}
+	}
+
+	bin, err := OpenBinary(path)
 	if err != nil {
-		log.Fatalf("Open elf err: %s", err)
+		log.Fatalf("Open binary err: %s", err)
 	}
 
-	defer exe.Close()
+	defer bin.Close()
 
-	var prettyType string
-
-	switch exe.Type {
-	case elf.ET_REL:
-		prettyType = "Relocatable"
-	case elf.ET_EXEC:
-		prettyType = "Executable"
-	case elf.ET_DYN:
-		prettyType = "Shared object"
-	case elf.ET_CORE:
-		prettyType = "Core file"
-	case elf.ET_LOOS:
-		prettyType = "First operating system specific"
-	case elf.ET_HIOS:
-		prettyType = "Last operating system-specific"
-	case elf.ET_LOPROC:
-		prettyType = "First processor-specific"
-	case elf.ET_HIPROC:
-		prettyType = "Last processor-specific"
-	case elf.ET_NONE:
-		prettyType = "Unknown type"
-	default:
-		prettyType = "Unknown type"
+	info := BinaryInfo{
+		Type:      bin.Type(),
+		BuildInfo: bin.GoBuildInfo(),
+	}
+	if isPID {
+		info.PID = pid
+		info.Path = path
+	}
+	if vaddr, ok := bin.LoadAddress(); ok {
+		info.MemoryOffset = &vaddr
+	}
+	if eb, ok := bin.(*elfBinary); ok {
+		info.Hardening, err = ELFHardening(eb.f)
+		if err != nil {
+			log.Fatalf("Read hardening flags err: %s", err)
+		}
+		info.Version, err = ELFVersionInfo(eb.f)
+		if err != nil {
+			log.Fatalf("Read version info err: %s", err)
+		}
+		if isPID {
+			maps, err := ProcessFileMappings(pid, path)
+			if err != nil {
+				log.Fatalf("Read process maps err: %s", err)
+			}
+			bias, err := LoadBias(eb.f, maps)
+			if err != nil {
+				log.Fatalf("Compute load bias err: %s", err)
+			}
+			info.LoadBias = &bias
+		}
+	}
+
+	if structuredOutput() {
+		printStructured(info)
+		return
 	}
 
-	fmt.Printf("Type: %s\n", prettyType)
+	if info.PID != 0 {
+		fmt.Printf("Process: %d (%s)\n", info.PID, info.Path)
+	}
+
+	fmt.Printf("Type: %s\n", info.Type)
+
+	if info.MemoryOffset != nil {
+		fmt.Printf("Memory offset: 0x%016x\n", *info.MemoryOffset)
+	}
 
-	firstLoad := true
-	for _, prog := range exe.Progs {
-		if prog.Type == elf.PT_LOAD && firstLoad {
-			fmt.Printf("Memory offset: 0x%016x\n", prog.Vaddr)
-			firstLoad = false
+	if info.LoadBias != nil {
+		fmt.Printf("Load bias: 0x%016x\n", *info.LoadBias)
+	}
+
+	if h := info.Hardening; h != nil {
+		fmt.Printf("NX: %s\n", enabled(h.NX))
+		fmt.Printf("RELRO: %s\n", h.RELRO)
+		fmt.Printf("PIE: %s\n", enabled(h.PIE))
+		fmt.Printf("Stack canary: %s\n", enabled(h.Canary))
+	}
+
+	if v := info.Version; v != nil {
+		if v.SONAME != "" {
+			fmt.Printf("SONAME: %s\n", v.SONAME)
+		}
+		for _, c := range v.Comment {
+			fmt.Printf("Comment: %s\n", c)
+		}
+		if len(v.Package) > 0 {
+			fmt.Printf("Package: %s\n", v.Package)
 		}
 	}
 
-	ver, modinfo := readGoVersionMod(exe)
-	if ver != "" {
-		fmt.Printf("Go version: %s\n", ver)
+	bi := info.BuildInfo
+	if bi == nil {
+		return
 	}
+
+	fmt.Printf("Go version: %s\n", bi.GoVersion)
+
+	modinfo := goModText(bi)
 	if modinfo != "" {
 		modinfo = strings.ReplaceAll(modinfo, "\n", "\n\t")
 		fmt.Printf("Go modules:\n\t%s\n", modinfo)
 	}
+
+	settings := make(map[string]string)
+	for _, s := range bi.Settings {
+		settings[s.Key] = s.Value
+	}
+
+	for _, s := range []struct {
+		key   string
+		label string
+	}{
+		{"vcs", "VCS"},
+		{"vcs.revision", "VCS revision"},
+		{"vcs.time", "VCS time"},
+		{"vcs.modified", "VCS modified"},
+		{"GOOS", "GOOS"},
+		{"GOARCH", "GOARCH"},
+		{"CGO_ENABLED", "CGO_ENABLED"},
+		{"-ldflags", "ldflags"},
+		{"-tags", "Build tags"},
+	} {
+		if v, ok := settings[s.key]; ok {
+			fmt.Printf("%s: %s\n", s.label, v)
+		}
+	}
+}
+
+func enabled(b bool) string {
+	if b {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+// goModText formats the module portion of bi in the same
+// tab separated form the go command embeds in binaries.
+func goModText(bi *debug.BuildInfo) string {
+	var lines []string
+	if bi.Path != "" {
+		lines = append(lines, "path\t"+bi.Path)
+	}
+
+	var formatMod func(word string, m debug.Module)
+	formatMod = func(word string, m debug.Module) {
+		line := word + "\t" + m.Path + "\t" + m.Version
+		if m.Sum != "" {
+			line += "\t" + m.Sum
+		}
+		lines = append(lines, line)
+		if m.Replace != nil {
+			formatMod("=>", *m.Replace)
+		}
+	}
+
+	if bi.Main.Path != "" {
+		formatMod("mod", bi.Main)
+	}
+	for _, dep := range bi.Deps {
+		formatMod("dep", *dep)
+	}
+
+	return strings.Join(lines, "\n")
 }
 
 func listSectionsCommand() *cobra.Command {
@@ -109,7 +264,7 @@ func listSectionsCommand() *cobra.Command {
 		Run:   listSectionsAction,
 	}
 
-	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show raw json ouput")
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
 
 	return &cmd
 }
@@ -119,9 +274,6 @@ func listSectionsAction(cmd *cobra.Command, args []string) {
 		log.Fatalf("Usage: sections <file>")
 	}
 
-	jsonOut := json.NewEncoder(os.Stdout)
-	jsonOut.SetIndent("", "  ")
-
 	exe, err := elf.Open(args[0])
 	if err != nil {
 		log.Fatalf("Open elf err: %s", err)
@@ -129,22 +281,94 @@ func listSectionsAction(cmd *cobra.Command, args []string) {
 
 	defer exe.Close()
 
+	if structuredOutput() {
+		headers := make([]elf.SectionHeader, 0, len(exe.Sections))
+		for _, s := range exe.Sections {
+			headers = append(headers, s.SectionHeader)
+		}
+		printStructured(headers)
+		return
+	}
+
 	for _, s := range exe.Sections {
-		if jsonOutput {
-			jsonOut.Encode(s)
-		} else {
-			fmt.Printf("%s %s\n", s.Type, s.Name)
+		fmt.Printf("%s %s\n", s.Type, s.Name)
+	}
+}
+
+func listSegmentsCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "segments <file>",
+		Short: "List program headers",
+		Run:   listSegmentsAction,
+	}
+
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+
+	return &cmd
+}
+
+func listSegmentsAction(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		log.Fatalf("Usage: segments <file>")
+	}
+
+	exe, err := elf.Open(args[0])
+	if err != nil {
+		log.Fatalf("Open elf err: %s", err)
+	}
+
+	defer exe.Close()
+
+	if structuredOutput() {
+		headers := make([]elf.ProgHeader, 0, len(exe.Progs))
+		for _, p := range exe.Progs {
+			headers = append(headers, p.ProgHeader)
 		}
+		printStructured(headers)
+		return
+	}
+
+	fmt.Printf("%-16s %-5s %-18s %-18s %-18s %-18s %s\n", "Type", "Flags", "VirtAddr", "PhysAddr", "FileSiz", "MemSiz", "Align")
+	for _, p := range exe.Progs {
+		fmt.Printf("%-16s %-5s 0x%016x 0x%016x 0x%016x 0x%016x 0x%x\n",
+			strings.TrimPrefix(p.Type.String(), "PT_"), progFlags(p.Flags), p.Vaddr, p.Paddr, p.Filesz, p.Memsz, p.Align)
 	}
 }
 
+// progFlags formats segment permissions as RWX, e.g. "R X".
+func progFlags(f elf.ProgFlag) string {
+	flags := []byte("   ")
+	if f&elf.PF_R != 0 {
+		flags[0] = 'R'
+	}
+	if f&elf.PF_W != 0 {
+		flags[1] = 'W'
+	}
+	if f&elf.PF_X != 0 {
+		flags[2] = 'X'
+	}
+	return string(flags)
+}
+
+var (
+	symbolType    string
+	sectionFilter string
+)
+
 func listSymbolsCommand() *cobra.Command {
 	cmd := cobra.Command{
 		Use:   "symbols <file>",
 		Short: "List symbols",
+		Long:  "List symbols with their value, size, type, binding, visibility and section. ELF symbols from both the static and dynamic symbol tables are listed.",
 		Run:   listSymbolsAction,
 	}
 
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+	cmd.Flags().BoolVarP(&demangleNames, "demangle", "C", false, "Demangle C++ and Rust symbol names")
+	cmd.Flags().StringVarP(&sectionFilter, "section", "", "", "Only list symbols in this section, e.g. .text")
+	addSortFlags(&cmd, "name", "addr", "size")
+	cmd.Flags().StringVarP(&symbolType, "type", "", "", "Only list symbols of this type: func, object, tls, ifunc, common, section, file or notype")
+
 	return &cmd
 }
 
@@ -153,18 +377,152 @@ func listSymbolsAction(cmd *cobra.Command, args []string) {
 		log.Fatalf("Usage: symbols <file>")
 	}
 
-	exe, err := elf.Open(args[0])
+	bin, err := OpenBinary(args[0])
 	if err != nil {
-		log.Fatalf("Open elf err: %s", err)
+		log.Fatalf("Open binary err: %s", err)
 	}
 
-	defer exe.Close()
+	defer bin.Close()
 
-	symbols, err := exe.Symbols()
+	checkSection(bin, sectionFilter)
+
+	eb, ok := bin.(*elfBinary)
+	if !ok {
+		listPortableSymbols(bin)
+		return
+	}
+
+	symbols, err := ELFSymbolInfo(eb.f)
 	if err != nil {
 		log.Fatalf("Get symbols err: %s", err)
 	}
+
+	if sectionFilter != "" {
+		filtered := symbols[:0]
+		for _, sym := range symbols {
+			if sym.Section == sectionFilter {
+				filtered = append(filtered, sym)
+			}
+		}
+		symbols = filtered
+	}
+
+	if symbolType != "" {
+		want := strings.ToUpper(symbolType)
+		if !symbolTypes[want] {
+			log.Fatalf("Unknown symbol type %q; use func, object, tls, ifunc, common, section, file or notype", symbolType)
+		}
+		filtered := symbols[:0]
+		for _, sym := range symbols {
+			if sym.Type == want {
+				filtered = append(filtered, sym)
+			}
+		}
+		symbols = filtered
+	}
+
+	if demangleNames {
+		for i := range symbols {
+			symbols[i].Demangled = demangledName(symbols[i].Name)
+		}
+	}
+
+	sortList(symbols, sortKeys{
+		name: func(i int) string { return symbols[i].Name },
+		addr: func(i int) uint64 { return symbols[i].Value },
+		size: func(i int) uint64 { return symbols[i].Size },
+	})
+
+	if structuredOutput() {
+		printStructured(symbols)
+		return
+	}
+
+	fmt.Printf("%-16s %8s %-7s %-6s %-9s %-20s %s\n", "Value", "Size", "Type", "Bind", "Vis", "Section", "Name")
+	for _, sym := range symbols {
+		name := sym.Name
+		if sym.Demangled != "" {
+			name = sym.Demangled
+		}
+		if sym.Version != "" {
+			name += "@" + sym.Version
+		}
+		fmt.Printf("%016x %8d %-7s %-6s %-9s %-20s %s\n", sym.Value, sym.Size, sym.Type, sym.Bind, sym.Visibility, sym.Section, name)
+	}
+}
+
+// checkSection exits with an error if name is set but isn't a
+// section of bin.
+func checkSection(bin Binary, name string) {
+	if name == "" {
+		return
+	}
+	var names []string
+	for _, s := range bin.Sections() {
+		if s.Name == name {
+			return
+		}
+		if s.Name != "" {
+			names = append(names, s.Name)
+		}
+	}
+	log.Fatalf("No section %q; sections are %s", name, strings.Join(names, " "))
+}
+
+// listPortableSymbols lists the symbols of a Mach-O or PE binary,
+// whose symbol tables only distinguish functions from data.
+func listPortableSymbols(bin Binary) {
+	symbols, err := bin.Symbols()
+	if err != nil {
+		log.Fatalf("Get symbols err: %s", err)
+	}
+
+	switch strings.ToLower(symbolType) {
+	case "":
+	case "func", "object":
+		funcs := strings.ToLower(symbolType) == "func"
+		filtered := symbols[:0]
+		for _, sym := range symbols {
+			if sym.Func == funcs {
+				filtered = append(filtered, sym)
+			}
+		}
+		symbols = filtered
+	default:
+		log.Fatalf("Only --type func and object are supported for %s binaries", bin.Format())
+	}
+
+	if sectionFilter != "" {
+		filtered := symbols[:0]
+		for _, sym := range symbols {
+			if sym.Section == sectionFilter {
+				filtered = append(filtered, sym)
+			}
+		}
+		symbols = filtered
+	}
+
+	if demangleNames {
+		for i := range symbols {
+			symbols[i].Demangled = demangledName(symbols[i].Name)
+		}
+	}
+
+	sortList(symbols, sortKeys{
+		name: func(i int) string { return symbols[i].Name },
+		addr: func(i int) uint64 { return symbols[i].Value },
+		size: func(i int) uint64 { return symbols[i].Size },
+	})
+
+	if structuredOutput() {
+		printStructured(symbols)
+		return
+	}
+
 	for _, sym := range symbols {
+		if sym.Demangled != "" {
+			sym.Name, sym.Demangled = sym.Demangled, ""
+		}
 		fmt.Printf("%+v\n", sym)
 	}
 }
@@ -176,6 +534,14 @@ func listFunctionsCommand() *cobra.Command {
 		Run:   listFunctionsAction,
 	}
 
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+	cmd.Flags().BoolVarP(&demangleNames, "demangle", "C", false, "Demangle C++ and Rust symbol names; the filter matches either form")
+	cmd.Flags().BoolVarP(&showSrc, "src", "", false, "Show the source file and line each function is defined at (requires debug info)")
+	cmd.Flags().BoolVarP(&showSig, "signature", "", false, "Append each function's parameters and results from debug info, if there is any")
+	cmd.Flags().StringVarP(&sectionFilter, "section", "", "", "Only list functions in this section, e.g. .text")
+	cmd.Flags().BoolVarP(&definedOnly, "defined-only", "", false, "Leave out functions imported from shared libraries")
+	addSortFlags(&cmd, "name", "addr", "size")
+
 	return &cmd
 }
 
@@ -188,207 +554,375 @@ func listFunctionsAction(cmd *cobra.Command, args []string) {
 	if len(args) > 1 {
 		filterString = args[1]
 	}
-	exe, err := elf.Open(args[0])
+	bin, err := OpenBinary(args[0])
 	if err != nil {
-		log.Fatalf("Open elf err: %s", err)
+		log.Fatalf("Open binary err: %s", err)
 	}
 
-	defer exe.Close()
+	defer bin.Close()
 
-	symbols, errSym := exe.Symbols()
-	dsyms, errDyn := exe.DynamicSymbols()
+	checkSection(bin, sectionFilter)
 
-	if errSym != nil && errDyn != nil {
-		log.Fatalf("Get symbols err: %s %s", errSym, errDyn)
+	funcs, err := Functions(bin, filterString, demangleNames)
+	if errors.Is(err, ErrStripped) {
+		log.Fatalf("%s has no symbol table, it's stripped. "+
+			"List its functions from debug info with `pptrace inspect args --all %s`, adding --debug-file if that's in a separate file", args[0], args[0])
+	} else if err != nil {
+		log.Fatalf("Get symbols err: %s", err)
 	}
 
-	symbols = append(symbols, dsyms...)
+	if sectionFilter != "" || definedOnly {
+		filtered := funcs[:0]
+		for _, f := range funcs {
+			if sectionFilter != "" && f.Section != sectionFilter {
+				continue
+			}
+			if definedOnly && !f.Defined() {
+				continue
+			}
+			filtered = append(filtered, f)
+		}
+		funcs = filtered
+	}
 
-	for _, sym := range symbols {
-		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC {
-			continue
+	if showSrc || showSig {
+		addDwarfInfo(args[0], funcs)
+	}
+
+	sortList(funcs, sortKeys{
+		name: func(i int) string { return funcs[i].Name },
+		addr: func(i int) uint64 { return funcs[i].Value },
+		size: func(i int) uint64 { return funcs[i].Size },
+	})
+
+	if structuredOutput() {
+		printStructured(funcs)
+		return
+	}
+
+	for _, f := range funcs {
+		name := f.Name
+		if f.Demangled != "" {
+			name = f.Demangled
+		}
+		if f.Signature != nil {
+			name += f.Signature.String()
+		}
+		if showSrc {
+			fmt.Printf("%016x %016x %s %s\n", f.Value, f.Size, name, srcLocation(f.File, f.Line))
+		} else {
+			fmt.Printf("%016x %016x %s\n", f.Value, f.Size, name)
+		}
+	}
+}
+
+// addDwarfInfo fills in the source locations and signatures of
+// funcs from the debug info of path, matching them by address.
+// Without debug info, --src fails but --signature is left out.
+func addDwarfInfo(path string, funcs []Function) {
+	var (
+		dwarfBin  Binary
+		dwarfInfo *dwarf.Data
+	)
+	if showSrc {
+		dwarfBin, dwarfInfo = openDwarf(path)
+	} else {
+		var err error
+		dwarfBin, dwarfInfo, err = loadDwarf(path)
+		if err != nil {
+			log.Printf("Listing functions without signatures: %s", err)
+			return
 		}
+	}
+	defer dwarfBin.Close()
 
-		if len(filterString) == 0 || strings.Contains(sym.Name, filterString) {
-			fmt.Printf("%016x %016x %s\n", sym.Value, sym.Size, sym.Name)
+	defs := make(map[uint64]FunctionArgs)
+	all, err := FuncArgs(dwarfInfo, func(string) bool { return true })
+	if err != nil {
+		log.Fatalf("Read DWARF err: %s", err)
+	}
+	for _, def := range all {
+		defs[def.LowPC] = def
+	}
+	for i, f := range funcs {
+		def, ok := defs[f.Value]
+		if !ok {
+			continue
+		}
+		if showSrc {
+			funcs[i].File = def.File
+			funcs[i].Line = def.Line
+		}
+		if showSig {
+			funcs[i].Signature = &Signature{Params: def.Params, Returns: def.Returns}
 		}
 	}
 }
 
+// srcLocation formats a file and line as file:line, using ?? for
+// parts that aren't known.
+func srcLocation(file string, line int) string {
+	if file == "" {
+		file = "??"
+	}
+	if line == 0 {
+		return file + ":?"
+	}
+	return fmt.Sprintf("%s:%d", file, line)
+}
+
 func functionArgsCommand() *cobra.Command {
 	cmd := cobra.Command{
-		Use:   "args <file> [<function-name>|-all]",
-		Short: "Show available function args",
-		Run:   funcArgsAction,
+		Use:               "args <file> [<function-name>|-all|--addr <addr>]",
+		Short:             "Show available function args",
+		Run:               funcArgsAction,
+		ValidArgsFunction: completeFileThen(CompleteFunctions),
 	}
 
 	cmd.Flags().BoolVarP(&allFlag, "all", "", false, "Show all functions")
 	cmd.Flags().BoolVarP(&exactMatch, "exact", "e", false, "Match on exact name")
+	cmd.Flags().BoolVarP(&noCRCCheck, "no-crc-check", "", false, "Don't verify debuglink CRC of separate debug files")
+	cmd.Flags().StringVarP(&debugFile, "debug-file", "", "", "Read DWARF from this file instead of searching for debug info")
+	cmd.Flags().BoolVarP(&showSrc, "src", "", false, "Show the source file and line each function is defined at")
+	cmd.Flags().StringVarP(&lookupAddr, "addr", "", "", "Show the function containing this address instead of looking it up by name")
+	cmd.Flags().Uint64VarP(&loadBase, "load-base", "", 0, "Runtime load address of the binary, for --addr addresses taken from a running PIE process")
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
 
 	return &cmd
 }
 
 func funcArgsAction(cmd *cobra.Command, args []string) {
 	if len(args) < 1 {
-		log.Fatalf("Usage: args <file> [<function>|-all]")
+		log.Fatalf("Usage: args <file> [<function>|-all|--addr <addr>]")
 	}
 
-	if len(args) < 2 && !allFlag {
-		log.Fatalf("Usage: args <file> [<function>|-all]")
+	if len(args) < 2 && !allFlag && lookupAddr == "" {
+		log.Fatalf("Usage: args <file> [<function>|-all|--addr <addr>]")
 	}
 
 	var matchFuncName string
-	if !allFlag {
+	if !allFlag && lookupAddr == "" {
 		matchFuncName = args[1]
 	}
 
-	dwarfPath, err := dwarfutil.FindDwarf(args[0])
+	bin, dwarfInfo := openDwarf(args[0])
+	defer bin.Close()
+
+	var (
+		funcs []FunctionArgs
+		err   error
+	)
+	if lookupAddr != "" {
+		addr := fileAddr(bin, lookupAddr)
+		funcs, err = FuncArgsAt(dwarfInfo, addr)
+		if err == nil && len(funcs) == 0 {
+			log.Fatalf("No function in the debug info contains address 0x%x", addr)
+		}
+	} else {
+		funcs, err = FuncArgs(dwarfInfo, nameMatcher(matchFuncName))
+	}
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Read DWARF err: %s", err)
 	}
 
-	debugElf, err := elf.Open(dwarfPath)
+	if structuredOutput() {
+		printStructured(funcs)
+		return
+	}
+
+	for _, f := range funcs {
+		if showSrc {
+			fmt.Printf("%016x %016x %s %s\n", f.LowPC, f.Size, f.Function, srcLocation(f.File, f.Line))
+		} else {
+			fmt.Printf("%016x %016x %s\n", f.LowPC, f.Size, f.Function)
+		}
+		for _, p := range f.Params {
+			fmt.Printf("\t%s %s\n", p.Name, p.Type)
+		}
+		if len(f.Returns) > 0 {
+			fmt.Printf("\t-> %s\n", strings.Join(f.Returns, ", "))
+		}
+	}
+}
+
+// openDwarf locates the debug info for path (possibly in a
+// separate debug file) and returns the opened binary and its DWARF data.
+// The --debug-file flag overrides the search.
+func openDwarf(path string) (Binary, *dwarf.Data) {
+	if debugFile != "" {
+		bin, err := OpenBinary(debugFile)
+		if err != nil {
+			log.Fatalf("Open debug file err: %s", err)
+		}
+		dwarfInfo, err := dwarfutil.FileData(debugFile, bin.DWARF)
+		if err != nil {
+			log.Fatalf("Debug file %s has no DWARF: %s", debugFile, err)
+		}
+		return bin, dwarfInfo
+	}
+
+	bin, dwarfInfo, err := loadDwarf(path)
 	if err != nil {
-		log.Fatalf("Open debug ELF %s err: %s", dwarfPath, err)
+		log.Fatal(err)
 	}
-	defer debugElf.Close()
+	return bin, dwarfInfo
+}
 
-	dwarfInfo, err := debugElf.DWARF()
+// loadDwarf is openDwarf without --debug-file, returning errors
+// rather than exiting.
+func loadDwarf(path string) (Binary, *dwarf.Data, error) {
+	bin, err := OpenBinary(path)
 	if err != nil {
-		log.Fatalf("read dwarf err: %s", err)
-	}
-
-	r := dwarfInfo.Reader()
-	root := dwarfutil.Tree(r)
-
-	for _, pkgs := range root.Children {
-		for _, pkgNode := range pkgs.Children {
-			// function definition
-			if pkgNode.Entry.Tag == dwarf.TagSubprogram {
-				var (
-					funcName  string
-					startAddr uint64
-					endAddr   uint64
-				)
-				for _, field := range pkgNode.Entry.Field {
-					if field.Attr == dwarf.AttrName {
-						name := field.Val.(string)
-						if exactMatch {
-							if name == matchFuncName {
-								funcName = name
-							}
-						} else if strings.Contains(name, matchFuncName) {
-							funcName = name
-						}
-					}
-					if field.Attr == dwarf.AttrLowpc {
-						switch v := field.Val.(type) {
-						case uint64:
-							startAddr = v
-						case int64:
-							startAddr = uint64(v)
-						default:
-							panic(fmt.Sprintf("unexpected type for AttrLowpc %T", field.Val))
-						}
-					}
-					if field.Attr == dwarf.AttrHighpc {
-						switch v := field.Val.(type) {
-						case uint64:
-							endAddr = v
-						case int64:
-							endAddr = uint64(v)
-						default:
-							panic(fmt.Sprintf("unexpected type for AttrHighpc %T", field.Val))
-						}
-					}
-				}
-
-				if funcName == "" {
-					continue
-				}
-
-				size := endAddr - startAddr
-				fmt.Printf("%016x %016x %s\n", startAddr, size, funcName)
-
-				for _, funcChild := range pkgNode.Children {
-					// function argument
-					if funcChild.Entry.Tag == dwarf.TagFormalParameter {
-						var (
-							name     string
-							typeName string
-						)
-
-						for _, field := range funcChild.Entry.Field {
-							if field.Attr == dwarf.AttrName {
-								name = field.Val.(string)
-							}
-						}
-
-						typeName = findType(root, funcChild.Entry)
-
-						fmt.Printf("\t%s %s\n", name, typeName)
-					}
-				}
-			}
+		return nil, nil, fmt.Errorf("Open binary err: %s", err)
+	}
+
+	dwarfPath := path
+	if bin.Format() == "elf" {
+		bin.Close()
+
+		dwarfPath, err = dwarfutil.FindDwarfWithOptions(path, dwarfutil.FindOptions{
+			SkipCRCCheck:   noCRCCheck,
+			DebuginfodURLs: dwarfutil.DebuginfodURLsFromEnv(),
+		})
+		if errors.Is(err, dwarfutil.ErrNoDebugInfo) {
+			return nil, nil, fmt.Errorf("No debug info found for %s: checked the file, /usr/lib/debug and its .gnu_debuglink. "+
+				"Install its debug package or set DEBUGINFOD_URLS", path)
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		bin, err = OpenBinary(dwarfPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Open debug ELF %s err: %s", dwarfPath, err)
 		}
 	}
+
+	dwarfInfo, err := dwarfutil.FileData(dwarfPath, bin.DWARF)
+	if err != nil {
+		bin.Close()
+		return nil, nil, fmt.Errorf("read dwarf err: %s", err)
+	}
+
+	return bin, dwarfInfo, nil
 }
 
-func findType(root *dwarfutil.Node, entry dwarf.Entry) string {
-	var typeName string
-	for _, field := range entry.Field {
-		if field.Attr == dwarf.AttrType {
-			typeEntry := root.OffsetMap[field.Val.(dwarf.Offset)].Entry
-			var modifier string
-			isStruct := typeEntry.Tag == dwarf.TagStructType
-			if isStruct {
-				modifier = modifier + "struct "
-			}
+// fileAddr parses an --addr address and removes the load bias
+// given with --load-base, leaving the address in bin's file.
+func fileAddr(bin Binary, s string) uint64 {
+	addr, err := parseAddr(s)
+	if err != nil {
+		log.Fatalf("Invalid --addr %q: %s", s, err)
+	}
+	if loadBase != 0 {
+		vaddr, _ := bin.LoadAddress()
+		addr -= loadBase - vaddr
+	}
+	return addr
+}
 
-			isPointer := typeEntry.Tag == dwarf.TagPointerType
-			if isPointer {
-				modifier = modifier + "*"
-			}
+// nameMatcher returns a match function for pattern honoring
+// the --all and --exact flags.
+func nameMatcher(pattern string) func(string) bool {
+	return func(name string) bool {
+		if allFlag {
+			return true
+		}
+		if exactMatch {
+			return name == pattern
+		}
+		return strings.Contains(name, pattern)
+	}
+}
 
-			for i := range typeEntry.Field {
-				if typeEntry.Field[i].Attr == dwarf.AttrName {
-					typeName = typeEntry.Field[i].Val.(string)
-					if strings.HasPrefix(typeName, "*") {
-						// go pointer types have '*' in the name so don't
-						// append an additional one
-						modifier = strings.Replace(modifier, "*", "", 1)
-					}
-					return modifier + typeName
-				}
-			}
-			if typeName == "" {
-				return modifier + findType(root, typeEntry)
-			}
+// printMembers prints members and any expanded member types,
+// indenting each level of nesting.
+func printMembers(members []Member, indent string) {
+	for _, m := range members {
+		if m.Padding > 0 {
+			fmt.Printf("%s%3d %32s\t%s\t<-- %d bytes padding\n", indent, m.Offset, m.Name, m.Type, m.Padding)
+		} else {
+			fmt.Printf("%s%3d %32s\t%s\n", indent, m.Offset, m.Name, m.Type)
 		}
+		printMembers(m.Members, indent+"    ")
+	}
+}
+
+// structuredOutput reports whether a command should print its
+// result with printStructured rather than as text.
+func structuredOutput() bool {
+	return jsonOutput || render.Output != render.Text
+}
+
+// printStructured writes v to stdout in the format chosen with
+// --json or --format.
+func printStructured(v interface{}) {
+	format := render.Output
+	if jsonOutput {
+		format = render.JSON
+	}
+	if err := render.Render(os.Stdout, format, v); err != nil {
+		log.Fatalf("Write output err: %s", err)
 	}
-	return ""
 }
 
 func typesCommand() *cobra.Command {
 	cmd := cobra.Command{
-		Use:   "types <file> [<type-name>|-all]",
-		Short: "Show available types",
-		Run:   typesAction,
+		Use:               "types <file> [<type-name>|-all|--addr <addr>]",
+		Short:             "Show available types",
+		Run:               typesAction,
+		ValidArgsFunction: completeFileThen(CompleteTypes),
 	}
 
-	cmd.Flags().BoolVarP(&allFlag, "all", "", false, "Show all types")
+	cmd.Flags().BoolVarP(&allFlag, "all", "", false, "Show all composite types")
 	cmd.Flags().BoolVarP(&exactMatch, "exact", "e", false, "Match on exact name")
+	cmd.Flags().BoolVarP(&noCRCCheck, "no-crc-check", "", false, "Don't verify debuglink CRC of separate debug files")
+	cmd.Flags().StringVarP(&debugFile, "debug-file", "", "", "Read DWARF from this file instead of searching for debug info")
+	cmd.Flags().StringVarP(&typeKind, "kind", "", "", "Only show types of kind struct|union|class|enum|typedef")
+	cmd.Flags().BoolVarP(&recursive, "recursive", "r", false, "Expand composite member types inline")
+	cmd.Flags().IntVarP(&typeDepth, "depth", "", 0, "Expand composite member types inline up to this many levels")
+	cmd.Flags().BoolVarP(&followPtrs, "follow-pointers", "", false, "Also expand the types pointed to by pointer members")
+	cmd.Flags().StringVarP(&lookupAddr, "addr", "", "", "Show the global variable containing this address and its type")
+	cmd.Flags().Uint64VarP(&loadBase, "load-base", "", 0, "Runtime load address of the binary, for --addr addresses taken from a running PIE process")
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
 
 	return &cmd
 }
 
 func typesAction(cmd *cobra.Command, args []string) {
 	if len(args) < 1 {
-		log.Fatalf("Usage: types <file> [<type-name>|-all]")
+		log.Fatalf("Usage: types <file> [<type-name>|-all|--addr <addr>]")
+	}
+
+	if len(args) < 2 && !allFlag && lookupAddr == "" {
+		log.Fatalf("Usage: types <file> [<type-name>|-all|--addr <addr>]")
 	}
 
-	if len(args) < 2 && !allFlag {
-		log.Fatalf("Usage: types <file> [<type-name>|-all]")
+	if typeKind != "" {
+		var valid bool
+		for _, k := range typeKinds {
+			if k == typeKind {
+				valid = true
+			}
+		}
+		if !valid {
+			log.Fatalf("Invalid --kind %q, must be one of struct|union|class|enum|typedef", typeKind)
+		}
+	}
+
+	if typeDepth < 0 {
+		log.Fatalf("Invalid --depth %d, must not be negative", typeDepth)
+	}
+	depth := typeDepth
+	if recursive && depth == 0 {
+		depth = -1
+	}
+
+	bin, dwarfInfo := openDwarf(args[0])
+	defer bin.Close()
+
+	if lookupAddr != "" {
+		variableAtAction(bin, dwarfInfo, depth)
+		return
 	}
 
 	var matchTypeName string
@@ -396,89 +930,66 @@ func typesAction(cmd *cobra.Command, args []string) {
 		matchTypeName = args[1]
 	}
 
-	dwarfPath, err := dwarfutil.FindDwarf(args[0])
+	types, err := Types(dwarfInfo, TypeFilter{
+		Match:          nameMatcher(matchTypeName),
+		Kind:           typeKind,
+		CompositeOnly:  allFlag,
+		Depth:          depth,
+		FollowPointers: followPtrs,
+	})
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Read DWARF err: %s", err)
 	}
 
-	debugElf, err := elf.Open(dwarfPath)
-	if err != nil {
-		log.Fatalf("Open debug ELF %s err: %s", dwarfPath, err)
+	if structuredOutput() {
+		printStructured(types)
+		return
+	}
+
+	for _, t := range types {
+		printType(t)
 	}
-	defer debugElf.Close()
+}
 
-	dwarfInfo, err := debugElf.DWARF()
+func printType(t Type) {
+	switch {
+	case t.BaseType != "":
+		fmt.Printf("%s (%s)\n", t.Name, t.BaseType)
+	case t.Size > 0:
+		fmt.Printf("%s (size %d, align %d)\n", t.Name, t.Size, t.Align)
+	default:
+		fmt.Printf("%s\n", t.Name)
+	}
+	printMembers(t.Members, "")
+	for _, e := range t.Enumerators {
+		fmt.Printf("    %32s\t%d\n", e.Name, e.Value)
+	}
+}
+
+// variableAtAction shows the global variable containing the
+// --addr address and the layout of its type.
+func variableAtAction(bin Binary, dwarfInfo *dwarf.Data, depth int) {
+	addr := fileAddr(bin, lookupAddr)
+	vars, err := VariableAt(dwarfInfo, addr, TypeFilter{
+		Depth:          depth,
+		FollowPointers: followPtrs,
+	})
 	if err != nil {
-		log.Fatalf("read dwarf err: %s", err)
-	}
-
-	r := dwarfInfo.Reader()
-	root := dwarfutil.Tree(r)
-
-	for _, pkgs := range root.Children {
-		for _, pkgNode := range pkgs.Children {
-			// // function definition
-
-			if pkgNode.Entry.Tag == dwarf.TagTypedef {
-				var (
-					typeName string
-					typeInfo dwarf.Field
-				)
-				for _, field := range pkgNode.Entry.Field {
-					if field.Attr == dwarf.AttrName {
-						name := field.Val.(string)
-						if exactMatch {
-							if name == matchTypeName {
-								typeName = name
-							}
-						} else if strings.Contains(name, matchTypeName) {
-							typeName = name
-						}
-					}
-					if field.Attr == dwarf.AttrType {
-						typeInfo = field
-					}
-				}
-
-				if typeName == "" {
-					continue
-				}
-
-				fmt.Printf("%s\n", typeName)
-
-				typedef := root.OffsetMap[typeInfo.Val.(dwarf.Offset)]
-
-				for _, tChild := range typedef.Children {
-
-					if tChild.Entry.Tag == dwarf.TagMember {
-						var (
-							name        string
-							typeName    string
-							fieldOffset int64
-						)
-
-						for _, field := range tChild.Entry.Field {
-							if field.Attr == dwarf.AttrName {
-								name = field.Val.(string)
-							}
-
-							if field.Attr == dwarf.AttrType {
-								typeEntry := root.OffsetMap[field.Val.(dwarf.Offset)].Entry
-								for i := range typeEntry.Field {
-									if typeEntry.Field[i].Attr == dwarf.AttrName {
-										typeName = typeEntry.Field[i].Val.(string)
-									}
-								}
-							}
-							if field.Attr == dwarf.AttrDataMemberLoc {
-								fieldOffset = field.Val.(int64)
-							}
-						}
-
-						fmt.Printf("%3d %32s\t%s\n", fieldOffset, name, typeName)
-					}
-				}
-			}
+		log.Fatalf("Read DWARF err: %s", err)
+	}
+	if len(vars) == 0 {
+		log.Fatalf("No global variable in the debug info contains address 0x%x", addr)
+	}
+
+	if structuredOutput() {
+		printStructured(vars)
+		return
+	}
+
+	for _, v := range vars {
+		fmt.Printf("%016x %s %s +%d (size %d)\n", *v.Address, v.Name, v.Type, v.Offset, v.Size)
+		if v.Layout != nil {
+			printType(*v.Layout)
 		}
 	}
 }
@@ -0,0 +1,912 @@
+package inspect
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"strings"
+
+	"github.com/psanford/pptrace/internal/demangle"
+	"github.com/psanford/pptrace/internal/dwarfutil"
+)
+
+// Function is a function symbol from a symbol table.
+type Function struct {
+	Name      string `json:"name"`
+	Demangled string `json:"demangled,omitempty"`
+	Value     uint64 `json:"value"`
+	Size      uint64 `json:"size"`
+	Section   string `json:"section,omitempty"`
+	// File and Line are where the function is defined, if known
+	// from debug info.
+	File string `json:"file,omitempty"`
+	Line int    `json:"line,omitempty"`
+	// Signature is the function's parameters and results, if
+	// known from debug info.
+	Signature *Signature `json:"signature,omitempty"`
+}
+
+// Signature is a function's parameters and result types.
+type Signature struct {
+	Params  []Param  `json:"params"`
+	Returns []string `json:"returns,omitempty"`
+}
+
+// String formats s like a Go function type without the func
+// keyword, e.g. "(p *struct pt, k int) int".
+func (s Signature) String() string {
+	params := make([]string, len(s.Params))
+	for i, p := range s.Params {
+		params[i] = strings.TrimSpace(p.Name + " " + p.Type)
+	}
+	out := "(" + strings.Join(params, ", ") + ")"
+	switch len(s.Returns) {
+	case 0:
+	case 1:
+		out += " " + s.Returns[0]
+	default:
+		out += " (" + strings.Join(s.Returns, ", ") + ")"
+	}
+	return out
+}
+
+// Defined reports whether f is defined in its file rather than
+// imported.
+func (f Function) Defined() bool {
+	return Symbol{Section: f.Section, Value: f.Value}.Defined()
+}
+
+// Functions returns the function symbols from b's symbol
+// tables whose name contains filter. If demangleNames is set,
+// C++ and Rust names are demangled and filter may match either
+// the raw or the demangled name.
+func Functions(b Binary, filter string, demangleNames bool) ([]Function, error) {
+	symbols, err := b.Symbols()
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := make([]Function, 0)
+	for _, sym := range symbols {
+		if !sym.Func {
+			continue
+		}
+
+		var demangled string
+		if demangleNames {
+			demangled = demangledName(sym.Name)
+		}
+
+		if len(filter) == 0 || strings.Contains(sym.Name, filter) ||
+			(demangled != "" && strings.Contains(demangled, filter)) {
+			funcs = append(funcs, Function{
+				Name:      sym.Name,
+				Demangled: demangled,
+				Value:     sym.Value,
+				Size:      sym.Size,
+				Section:   sym.Section,
+			})
+		}
+	}
+
+	return funcs, nil
+}
+
+// PackageFunctions returns the names of the functions defined in
+// the Go binary at path that belong to package pkg, e.g. net/http,
+// including methods and closures but not those of its
+// subpackages. Names are in symbol table order, without
+// duplicates.
+func PackageFunctions(path, pkg string) ([]string, error) {
+	bin, err := OpenBinary(path)
+	if err != nil {
+		return nil, err
+	}
+	defer bin.Close()
+
+	prefix := pkg + "."
+	funcs, err := Functions(bin, prefix, false)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	seen := make(map[string]bool)
+	for _, f := range funcs {
+		if !strings.HasPrefix(f.Name, prefix) || !f.Defined() || seen[f.Name] {
+			continue
+		}
+		seen[f.Name] = true
+		names = append(names, f.Name)
+	}
+	return names, nil
+}
+
+// demangledName returns the demangled form of a C++ or Rust
+// symbol name, or "" if name isn't mangled.
+func demangledName(name string) string {
+	s, err := demangle.ToString(name)
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
+// Param is a formal parameter of a function.
+type Param struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// FunctionArgs describes a DWARF subprogram's signature.
+type FunctionArgs struct {
+	Function string   `json:"function"`
+	LowPC    uint64   `json:"lowpc"`
+	Size     uint64   `json:"size"`
+	Params   []Param  `json:"params"`
+	Returns  []string `json:"returns,omitempty"`
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+}
+
+// FuncArgs returns the signature of every subprogram in d whose
+// name satisfies match.
+func FuncArgs(d *dwarf.Data, match func(name string) bool) ([]FunctionArgs, error) {
+	files := newDeclFiles(d)
+
+	funcs := make([]FunctionArgs, 0)
+
+	// functions may be nested in namespaces, classes or other
+	// functions depending on the language
+	err := dwarfutil.Units(d, func(root, cu *dwarfutil.Node) error {
+		cu.Walk(func(node *dwarfutil.Node) {
+			if f, ok := funcArgs(root, node, match); ok {
+				f.File, f.Line = files.location(&cu.Entry, node.Entry, subprogramDecl(root, node.Entry))
+				funcs = append(funcs, f)
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return funcs, nil
+}
+
+// funcArgs returns the signature of node if it's a function
+// definition whose name satisfies match.
+func funcArgs(root *dwarfutil.Node, node *dwarfutil.Node, match func(name string) bool) (FunctionArgs, bool) {
+	if node.Entry.Tag != dwarf.TagSubprogram {
+		return FunctionArgs{}, false
+	}
+	if isDecl, _ := node.Entry.Val(dwarf.AttrDeclaration).(bool); isDecl {
+		return FunctionArgs{}, false
+	}
+
+	decl := subprogramDecl(root, node.Entry)
+	funcName, _ := decl.Val(dwarf.AttrName).(string)
+	if funcName == "" || !match(funcName) {
+		return FunctionArgs{}, false
+	}
+
+	startAddr, endAddr, _ := dwarfutil.PCRange(&node.Entry)
+	f := FunctionArgs{
+		Function: funcName,
+		LowPC:    startAddr,
+		Size:     endAddr - startAddr,
+		Params:   make([]Param, 0),
+	}
+
+	if decl.AttrField(dwarf.AttrType) != nil {
+		// C style return type on the subprogram itself
+		f.Returns = append(f.Returns, findType(root, decl))
+	}
+
+	for _, funcChild := range node.Children {
+		// function argument
+		if funcChild.Entry.Tag != dwarf.TagFormalParameter {
+			continue
+		}
+
+		name, _ := funcChild.Entry.Val(dwarf.AttrName).(string)
+		typeName := findType(root, funcChild.Entry)
+
+		// Go marks result parameters with DW_AT_variable_parameter
+		if isOutput, _ := funcChild.Entry.Val(dwarf.AttrVarParam).(bool); isOutput {
+			f.Returns = append(f.Returns, typeName)
+			continue
+		}
+
+		f.Params = append(f.Params, Param{
+			Name: name,
+			Type: typeName,
+		})
+	}
+
+	return f, true
+}
+
+// declFiles resolves DW_AT_decl_file indexes through the file
+// table of each compilation unit's line program, which is read at
+// most once per unit.
+type declFiles struct {
+	d     *dwarf.Data
+	files map[dwarf.Offset][]*dwarf.LineFile
+}
+
+func newDeclFiles(d *dwarf.Data) *declFiles {
+	return &declFiles{
+		d:     d,
+		files: make(map[dwarf.Offset][]*dwarf.LineFile),
+	}
+}
+
+// location returns the file and line entries declare, using the
+// first that has a DW_AT_decl_file. An out of line definition
+// usually carries its own location while its declaration points at
+// the class body.
+func (f *declFiles) location(cu *dwarf.Entry, entries ...dwarf.Entry) (string, int) {
+	for _, e := range entries {
+		idx, ok := e.Val(dwarf.AttrDeclFile).(int64)
+		if !ok {
+			continue
+		}
+		line, _ := e.Val(dwarf.AttrDeclLine).(int64)
+		return f.file(cu, idx), int(line)
+	}
+	return "", 0
+}
+
+// file returns the name of the idx'th entry in cu's file table,
+// or "" if there's no such entry.
+func (f *declFiles) file(cu *dwarf.Entry, idx int64) string {
+	table, ok := f.files[cu.Offset]
+	if !ok {
+		if lr, err := f.d.LineReader(cu); err == nil && lr != nil {
+			table = lr.Files()
+		}
+		f.files[cu.Offset] = table
+	}
+	if idx < 0 || int(idx) >= len(table) || table[idx] == nil {
+		return ""
+	}
+	return table[idx].Name
+}
+
+// subprogramDecl returns the entry holding the name and return
+// type of a function definition. Out of line definitions of C++
+// methods and concrete copies of inlined functions refer to
+// another entry for those.
+func subprogramDecl(root *dwarfutil.Node, e dwarf.Entry) dwarf.Entry {
+	for i := 0; i < 8; i++ {
+		if _, ok := e.Val(dwarf.AttrName).(string); ok {
+			return e
+		}
+		off, ok := e.Val(dwarf.AttrSpecification).(dwarf.Offset)
+		if !ok {
+			off, ok = e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+		}
+		decl := root.Lookup(off)
+		if !ok || decl == nil {
+			break
+		}
+		e = decl.Entry
+	}
+	return e
+}
+
+// Variable is a package level or global variable.
+type Variable struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Address is set for variables at a fixed address.
+	Address *uint64 `json:"address,omitempty"`
+}
+
+// Variables returns the variables declared at the top level of
+// each compilation unit in d whose name satisfies match.
+func Variables(d *dwarf.Data, match func(name string) bool) ([]Variable, error) {
+	vars := make([]Variable, 0)
+	err := globalVariables(d, func(root *dwarfutil.Node, v Variable, decl dwarf.Entry) {
+		if match(v.Name) {
+			vars = append(vars, v)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+// globalVariables calls fn with each named variable defined at the
+// top level of a compilation unit, the entry holding its name and
+// type, and the root to resolve offsets through.
+func globalVariables(d *dwarf.Data, fn func(root *dwarfutil.Node, v Variable, decl dwarf.Entry)) error {
+	order := d.Reader().ByteOrder()
+	return dwarfutil.Units(d, func(root, cu *dwarfutil.Node) error {
+		for _, node := range cu.Children {
+			if node.Entry.Tag != dwarf.TagVariable {
+				continue
+			}
+			if isDecl, _ := node.Entry.Val(dwarf.AttrDeclaration).(bool); isDecl {
+				continue
+			}
+
+			// C definitions of previously declared globals point
+			// back to the declaration for their name and type
+			decl := node.Entry
+			if off, ok := node.Entry.Val(dwarf.AttrSpecification).(dwarf.Offset); ok {
+				if spec := root.Lookup(off); spec != nil {
+					decl = spec.Entry
+				}
+			}
+
+			name, _ := decl.Val(dwarf.AttrName).(string)
+			if name == "" {
+				continue
+			}
+
+			v := Variable{
+				Name: name,
+				Type: findType(root, decl),
+			}
+			if loc, ok := node.Entry.Val(dwarf.AttrLocation).([]byte); ok {
+				if addr, ok := dwarfutil.StaticAddr(loc, order); ok {
+					v.Address = &addr
+				}
+			}
+
+			fn(root, v, decl)
+		}
+		return nil
+	})
+}
+
+// Member is a field of a struct, union or class. When expanded,
+// Members holds the layout of a composite member type, with
+// offsets relative to the member.
+type Member struct {
+	Offset int64  `json:"offset"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Size   int64  `json:"size"`
+	// Padding is the number of unused bytes between this member
+	// and the next, or the end of the struct for the last member.
+	Padding int64    `json:"padding,omitempty"`
+	Members []Member `json:"members,omitempty"`
+}
+
+// Enumerator is a named constant of an enum.
+type Enumerator struct {
+	Name  string `json:"name"`
+	Value int64  `json:"value"`
+}
+
+// Type is a named type and its members. Enums have enumerators
+// and the underlying integer type instead of members.
+type Type struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Size int64  `json:"size"`
+	// Align is the type's alignment, from DW_AT_alignment if
+	// present and otherwise implied by its members.
+	Align       int64        `json:"align"`
+	Members     []Member     `json:"members"`
+	BaseType    string       `json:"base_type,omitempty"`
+	Enumerators []Enumerator `json:"enumerators,omitempty"`
+}
+
+// TypeFilter selects which types Types returns.
+type TypeFilter struct {
+	// Match reports whether a type name should be included.
+	Match func(name string) bool
+	// Kind restricts results to struct, union, class, enum or
+	// typedef. Empty matches all kinds.
+	Kind string
+	// CompositeOnly skips typedefs that don't resolve to a
+	// struct, union, class or enum.
+	CompositeOnly bool
+	// Depth is how many levels of composite member types to expand
+	// inline. Negative expands without limit.
+	Depth int
+	// FollowPointers also expands the types pointed to by pointer
+	// members.
+	FollowPointers bool
+}
+
+var typeKinds = map[dwarf.Tag]string{
+	dwarf.TagTypedef:         "typedef",
+	dwarf.TagStructType:      "struct",
+	dwarf.TagUnionType:       "union",
+	dwarf.TagClassType:       "class",
+	dwarf.TagEnumerationType: "enum",
+}
+
+func isComposite(tag dwarf.Tag) bool {
+	return tag == dwarf.TagStructType || tag == dwarf.TagUnionType || tag == dwarf.TagClassType ||
+		tag == dwarf.TagEnumerationType
+}
+
+// Types returns the named types in d selected by filter.
+func Types(d *dwarf.Data, filter TypeFilter) ([]Type, error) {
+	type seenKey struct {
+		name   string
+		offset dwarf.Offset
+	}
+	seen := make(map[seenKey]bool)
+
+	types := make([]Type, 0)
+
+	err := dwarfutil.Units(d, func(root, cu *dwarfutil.Node) error {
+		cu.Walk(func(node *dwarfutil.Node) {
+			kind, ok := typeKinds[node.Entry.Tag]
+			if !ok {
+				return
+			}
+			if filter.Kind != "" && kind != filter.Kind {
+				return
+			}
+
+			typeName, _ := node.Entry.Val(dwarf.AttrName).(string)
+			if typeName == "" || !filter.Match(typeName) {
+				return
+			}
+
+			typeNode := resolveTypedef(root, node)
+			if typeNode == nil {
+				return
+			}
+
+			if filter.CompositeOnly && !isComposite(typeNode.Entry.Tag) {
+				return
+			}
+
+			// Go emits both a struct and a typedef of the same name
+			// for named types; only show them once.
+			key := seenKey{typeName, typeNode.Entry.Offset}
+			if seen[key] {
+				return
+			}
+			seen[key] = true
+
+			t := Type{
+				Name:    typeName,
+				Kind:    kind,
+				Size:    typeSize(root, typeNode),
+				Align:   typeAlign(root, typeNode),
+				Members: expandMembers(root, typeNode, filter),
+			}
+			if typeNode.Entry.Tag == dwarf.TagEnumerationType {
+				t.BaseType = findType(root, typeNode.Entry)
+				t.Enumerators = enumerators(typeNode)
+			}
+
+			types = append(types, t)
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return types, nil
+}
+
+// resolveTypedef follows a chain of typedefs to the underlying
+// type node. Non-typedef nodes are returned as is.
+func resolveTypedef(root *dwarfutil.Node, n *dwarfutil.Node) *dwarfutil.Node {
+	for n != nil && n.Entry.Tag == dwarf.TagTypedef {
+		off, ok := n.Entry.Val(dwarf.AttrType).(dwarf.Offset)
+		if !ok {
+			return nil
+		}
+		n = root.Lookup(off)
+	}
+	return n
+}
+
+// expandMembers returns the members of typeNode, expanding
+// composite member types as configured by filter.
+func expandMembers(root *dwarfutil.Node, typeNode *dwarfutil.Node, filter TypeFilter) []Member {
+	e := memberExpander{
+		root:           root,
+		followPointers: filter.FollowPointers,
+		visited:        map[dwarf.Offset]bool{typeNode.Entry.Offset: true},
+	}
+	return e.members(typeNode, filter.Depth)
+}
+
+type memberExpander struct {
+	root           *dwarfutil.Node
+	followPointers bool
+	// visited holds the types being expanded on the current path,
+	// so self-referential types aren't expanded forever
+	visited map[dwarf.Offset]bool
+}
+
+func (e *memberExpander) members(typeNode *dwarfutil.Node, depth int) []Member {
+	out := members(e.root, typeNode)
+	if depth == 0 {
+		return out
+	}
+
+	for i, child := range memberNodes(typeNode) {
+		n := e.compositeType(child.Entry)
+		if n == nil || e.visited[n.Entry.Offset] {
+			continue
+		}
+		e.visited[n.Entry.Offset] = true
+		out[i].Members = e.members(n, depth-1)
+		delete(e.visited, n.Entry.Offset)
+	}
+	return out
+}
+
+// compositeType returns the struct, union or class node a member
+// entry's type refers to, looking through typedefs, qualifiers and,
+// if enabled, pointers. It returns nil for any other type.
+func (e *memberExpander) compositeType(entry dwarf.Entry) *dwarfutil.Node {
+	followedPointer := false
+	for {
+		off, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+		if !ok {
+			return nil
+		}
+		n := e.root.Lookup(off)
+		if n == nil {
+			return nil
+		}
+		switch n.Entry.Tag {
+		case dwarf.TagStructType, dwarf.TagUnionType, dwarf.TagClassType:
+			return n
+		case dwarf.TagTypedef, dwarf.TagConstType, dwarf.TagVolatileType:
+		case dwarf.TagPointerType:
+			if !e.followPointers || followedPointer {
+				return nil
+			}
+			followedPointer = true
+		default:
+			return nil
+		}
+		entry = n.Entry
+	}
+}
+
+func memberNodes(typeNode *dwarfutil.Node) []*dwarfutil.Node {
+	var out []*dwarfutil.Node
+	for _, child := range typeNode.Children {
+		if child.Entry.Tag == dwarf.TagMember {
+			out = append(out, child)
+		}
+	}
+	return out
+}
+
+func members(root *dwarfutil.Node, typeNode *dwarfutil.Node) []Member {
+	out := make([]Member, 0)
+	// ends holds the byte just past each member, accounting for
+	// bit fields that only use part of their storage unit
+	var ends []int64
+	for _, tChild := range memberNodes(typeNode) {
+		var m Member
+		m.Name, _ = tChild.Entry.Val(dwarf.AttrName).(string)
+		m.Type = findType(root, tChild.Entry)
+		// constant offsets may be decoded with either signedness
+		// depending on the form, and older compilers emit a
+		// location expression instead
+		switch v := tChild.Entry.Val(dwarf.AttrDataMemberLoc).(type) {
+		case int64:
+			m.Offset = v
+		case uint64:
+			m.Offset = int64(v)
+		case []byte:
+			m.Offset, _ = dwarfutil.MemberOffset(v)
+		}
+		if off, ok := tChild.Entry.Val(dwarf.AttrType).(dwarf.Offset); ok {
+			m.Size = typeSize(root, root.Lookup(off))
+		}
+
+		end := m.Offset + m.Size
+		if bits, ok := tChild.Entry.Val(dwarf.AttrBitSize).(int64); ok {
+			startBit := m.Offset * 8
+			if bitOff, ok := tChild.Entry.Val(dwarf.AttrDataBitOffset).(int64); ok {
+				startBit = bitOff
+				m.Offset = bitOff / 8
+			}
+			end = (startBit + bits + 7) / 8
+		}
+
+		out = append(out, m)
+		ends = append(ends, end)
+	}
+
+	if typeNode.Entry.Tag == dwarf.TagUnionType || len(out) == 0 {
+		return out
+	}
+
+	var maxEnd int64
+	for i := range out {
+		if ends[i] > maxEnd {
+			maxEnd = ends[i]
+		}
+		next := typeSize(root, typeNode)
+		if i+1 < len(out) {
+			next = out[i+1].Offset
+		}
+		// bit fields sharing a storage unit overlap, so only
+		// count space past everything laid out so far
+		if next > maxEnd {
+			out[i].Padding = next - maxEnd
+		}
+	}
+	return out
+}
+
+// typeSize returns the size in bytes of the type n, following
+// typedefs and qualifiers and multiplying out array dimensions.
+// It returns 0 if the size is unknown.
+func typeSize(root *dwarfutil.Node, n *dwarfutil.Node) int64 {
+	for n != nil {
+		if size, ok := n.Entry.Val(dwarf.AttrByteSize).(int64); ok {
+			return size
+		}
+		switch n.Entry.Tag {
+		case dwarf.TagTypedef, dwarf.TagConstType, dwarf.TagVolatileType:
+		case dwarf.TagPointerType:
+			// Go omits the size of pointer types
+			return int64(root.AddressSize)
+		case dwarf.TagArrayType:
+			elem := typeSize(root, typeRef(root, n.Entry))
+			for _, child := range n.Children {
+				if child.Entry.Tag != dwarf.TagSubrangeType {
+					continue
+				}
+				if count, ok := child.Entry.Val(dwarf.AttrCount).(int64); ok {
+					elem *= count
+				} else if ub, ok := child.Entry.Val(dwarf.AttrUpperBound).(int64); ok {
+					elem *= ub + 1
+				} else {
+					return 0
+				}
+			}
+			return elem
+		default:
+			return 0
+		}
+		n = typeRef(root, n.Entry)
+	}
+	return 0
+}
+
+// typeAlign returns the alignment of the type n. Without an
+// explicit DW_AT_alignment, scalars are aligned to their size and
+// composites to their most aligned member.
+func typeAlign(root *dwarfutil.Node, n *dwarfutil.Node) int64 {
+	for n != nil {
+		if align, ok := n.Entry.Val(dwarf.AttrAlignment).(int64); ok {
+			return align
+		}
+		switch n.Entry.Tag {
+		case dwarf.TagTypedef, dwarf.TagConstType, dwarf.TagVolatileType, dwarf.TagArrayType:
+			n = typeRef(root, n.Entry)
+			continue
+		case dwarf.TagStructType, dwarf.TagUnionType, dwarf.TagClassType:
+			align := int64(1)
+			for _, child := range memberNodes(n) {
+				if a := typeAlign(root, typeRef(root, child.Entry)); a > align {
+					align = a
+				}
+			}
+			return align
+		}
+		size := typeSize(root, n)
+		if size <= 0 {
+			return 1
+		}
+		return size
+	}
+	return 1
+}
+
+// typeRef returns the node referenced by entry's DW_AT_type, or
+// nil if it has none or it doesn't resolve.
+func typeRef(root *dwarfutil.Node, entry dwarf.Entry) *dwarfutil.Node {
+	off, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+	if !ok {
+		return nil
+	}
+	return root.Lookup(off)
+}
+
+func enumerators(typeNode *dwarfutil.Node) []Enumerator {
+	out := make([]Enumerator, 0)
+	for _, child := range typeNode.Children {
+		if child.Entry.Tag != dwarf.TagEnumerator {
+			continue
+		}
+
+		e := Enumerator{}
+		e.Name, _ = child.Entry.Val(dwarf.AttrName).(string)
+		switch v := child.Entry.Val(dwarf.AttrConstValue).(type) {
+		case int64:
+			e.Value = v
+		case uint64:
+			e.Value = int64(v)
+		}
+
+		out = append(out, e)
+	}
+	return out
+}
+
+// findType returns the name of the type referenced by entry's
+// DW_AT_type attribute.
+func findType(root *dwarfutil.Node, entry dwarf.Entry) string {
+	off, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+	if !ok {
+		return ""
+	}
+	return typeName(root, off)
+}
+
+// unknownType is shown for type references that don't resolve to
+// an entry, as happens with partial debug info.
+const unknownType = "<unknown>"
+
+// typeName builds a readable name for the type at off, following
+// pointer, array and qualifier entries which don't carry a name
+// of their own. Anonymous structs, unions, enums, function types
+// and base types get a name describing them.
+func typeName(root *dwarfutil.Node, off dwarf.Offset) string {
+	node := root.Lookup(off)
+	if node == nil {
+		return unknownType
+	}
+	typeEntry := node.Entry
+
+	if name, ok := typeEntry.Val(dwarf.AttrName).(string); ok {
+		if typeEntry.Tag == dwarf.TagStructType {
+			return "struct " + name
+		}
+		return name
+	}
+
+	elem := func() string {
+		elemOff, ok := typeEntry.Val(dwarf.AttrType).(dwarf.Offset)
+		if !ok {
+			return "void"
+		}
+		return typeName(root, elemOff)
+	}
+
+	switch typeEntry.Tag {
+	case dwarf.TagPointerType:
+		return "*" + elem()
+	case dwarf.TagConstType:
+		return "const " + elem()
+	case dwarf.TagVolatileType:
+		return "volatile " + elem()
+	case dwarf.TagRestrictType:
+		return "restrict " + elem()
+	case dwarf.TagTypedef:
+		return elem()
+	case dwarf.TagSubroutineType:
+		var params []string
+		for _, child := range node.Children {
+			switch child.Entry.Tag {
+			case dwarf.TagFormalParameter:
+				params = append(params, findType(root, child.Entry))
+			case dwarf.TagUnspecifiedParameters:
+				params = append(params, "...")
+			}
+		}
+		name := "func(" + strings.Join(params, ", ") + ")"
+		if _, ok := typeEntry.Val(dwarf.AttrType).(dwarf.Offset); ok {
+			name += " " + elem()
+		}
+		return name
+	case dwarf.TagStructType:
+		return "struct" + anonMembers(root, node)
+	case dwarf.TagUnionType:
+		return "union" + anonMembers(root, node)
+	case dwarf.TagClassType:
+		return "class" + anonMembers(root, node)
+	case dwarf.TagEnumerationType:
+		var names []string
+		for _, e := range enumerators(node) {
+			names = append(names, e.Name)
+		}
+		return "enum{" + abbrevList(names, ", ") + "}"
+	case dwarf.TagBaseType:
+		return baseTypeName(typeEntry)
+	case dwarf.TagArrayType:
+		var dims string
+		for _, child := range node.Children {
+			if child.Entry.Tag != dwarf.TagSubrangeType {
+				continue
+			}
+			if count, ok := child.Entry.Val(dwarf.AttrCount).(int64); ok {
+				dims += fmt.Sprintf("[%d]", count)
+			} else if ub, ok := child.Entry.Val(dwarf.AttrUpperBound).(int64); ok {
+				dims += fmt.Sprintf("[%d]", ub+1)
+			} else {
+				dims += "[]"
+			}
+		}
+		if dims == "" {
+			dims = "[]"
+		}
+		return dims + elem()
+	}
+
+	return ""
+}
+
+// maxAnonMembers is how many members of an anonymous type are
+// shown in its name before the rest are elided.
+const maxAnonMembers = 4
+
+// anonMembers describes the members of an anonymous struct or
+// union as {name type; ...}.
+func anonMembers(root *dwarfutil.Node, node *dwarfutil.Node) string {
+	var members []string
+	for _, child := range node.Children {
+		if child.Entry.Tag != dwarf.TagMember {
+			continue
+		}
+		typ := findType(root, child.Entry)
+		if name, ok := child.Entry.Val(dwarf.AttrName).(string); ok {
+			typ = name + " " + typ
+		}
+		members = append(members, typ)
+	}
+	return "{" + abbrevList(members, "; ") + "}"
+}
+
+func abbrevList(items []string, sep string) string {
+	if len(items) > maxAnonMembers {
+		items = append(items[:maxAnonMembers:maxAnonMembers], "...")
+	}
+	return strings.Join(items, sep)
+}
+
+// baseTypeName names a base type without a name from its encoding
+// and size, e.g. int32 or float64.
+func baseTypeName(entry dwarf.Entry) string {
+	size, _ := entry.Val(dwarf.AttrByteSize).(int64)
+	enc, _ := entry.Val(dwarf.AttrEncoding).(int64)
+
+	var kind string
+	switch enc {
+	case encBoolean:
+		return "bool"
+	case encSigned, encSignedChar:
+		kind = "int"
+	case encUnsigned, encUnsignedChar:
+		kind = "uint"
+	case encFloat:
+		kind = "float"
+	case encComplexFloat:
+		kind = "complex"
+	case encAddress:
+		return "uintptr"
+	default:
+		kind = "base"
+	}
+	if size == 0 {
+		return kind
+	}
+	return fmt.Sprintf("%s%d", kind, size*8)
+}
+
+// DW_ATE base type encodings, which debug/dwarf doesn't define.
+const (
+	encAddress      = 0x01
+	encBoolean      = 0x02
+	encComplexFloat = 0x03
+	encFloat        = 0x04
+	encSigned       = 0x05
+	encSignedChar   = 0x06
+	encUnsigned     = 0x08
+	encUnsignedChar = 0x10
+)
@@ -0,0 +1,348 @@
+package inspect
+
+import (
+	"debug/dwarf"
+	"testing"
+
+	"github.com/psanford/pptrace/internal/fixture"
+)
+
+func TestFunctions(t *testing.T) {
+	tests := []struct {
+		fixture string
+		filter  string
+		// defined must be listed as defined functions, imported
+		// as imported ones
+		defined  []string
+		imported []string
+	}{
+		{fixture: "c-nopie", defined: []string{"main", "add", "scale", "nested", "label"}, imported: []string{"printf"}},
+		{fixture: "c-pie", defined: []string{"main", "add", "scale"}, imported: []string{"printf"}},
+		{fixture: "c-nodebug", filter: "sca", defined: []string{"scale"}},
+		{fixture: "go-exe", filter: "main.", defined: []string{"main.main", "main.mixed", "main.(*Point).Scale", "main.Point.Sum", "main.Max[go.shape.int]"}},
+		{fixture: "go-pie", filter: "main.", defined: []string{"main.main", "main.mixed"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			f, path := openELF(t, tt.fixture)
+			bin, err := OpenBinary(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer bin.Close()
+
+			funcs, err := Functions(bin, tt.filter, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			byName := make(map[string]Function)
+			for _, fn := range funcs {
+				byName[fn.Name] = fn
+			}
+
+			for _, name := range tt.defined {
+				fn, ok := byName[name]
+				if !ok {
+					t.Errorf("%s not listed", name)
+					continue
+				}
+				if !fn.Defined() {
+					t.Errorf("%s listed as imported", name)
+				}
+				if want := symbolValue(t, f, name); fn.Value != want {
+					t.Errorf("%s: got value 0x%x, want 0x%x", name, fn.Value, want)
+				}
+				if fn.Size == 0 {
+					t.Errorf("%s: got size 0", name)
+				}
+			}
+			for _, name := range tt.imported {
+				fn, ok := byName[name]
+				if !ok {
+					t.Errorf("%s not listed", name)
+				} else if fn.Defined() {
+					t.Errorf("%s listed as defined at 0x%x", name, fn.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestFunctionsFilter(t *testing.T) {
+	bin, err := OpenBinary(fixture.Path(t, "c-pie"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bin.Close()
+
+	funcs, err := Functions(bin, "ad", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range funcs {
+		if fn.Name != "add" {
+			t.Errorf("filter ad matched %s", fn.Name)
+		}
+	}
+	if len(funcs) != 1 {
+		t.Errorf("got %d functions matching ad, want 1", len(funcs))
+	}
+}
+
+func TestTypeName(t *testing.T) {
+	root := entryTree(
+		entry(0x10, dwarf.TagBaseType, fields(dwarf.AttrName, "int", dwarf.AttrByteSize, int64(4))),
+		entry(0x11, dwarf.TagBaseType, fields(dwarf.AttrName, "char", dwarf.AttrByteSize, int64(1))),
+		entry(0x12, dwarf.TagBaseType, fields(dwarf.AttrByteSize, int64(8), dwarf.AttrEncoding, int64(encUnsigned))),
+		entry(0x20, dwarf.TagPointerType, fields(dwarf.AttrType, dwarf.Offset(0x10))),
+		entry(0x21, dwarf.TagPointerType, nil),
+		entry(0x22, dwarf.TagPointerType, fields(dwarf.AttrType, dwarf.Offset(0x20))),
+		entry(0x30, dwarf.TagConstType, fields(dwarf.AttrType, dwarf.Offset(0x11))),
+		entry(0x31, dwarf.TagPointerType, fields(dwarf.AttrType, dwarf.Offset(0x30))),
+		entry(0x32, dwarf.TagVolatileType, fields(dwarf.AttrType, dwarf.Offset(0x10))),
+		entry(0x33, dwarf.TagRestrictType, fields(dwarf.AttrType, dwarf.Offset(0x21))),
+		entry(0x34, dwarf.TagConstType, fields(dwarf.AttrType, dwarf.Offset(0x32))),
+		entry(0x40, dwarf.TagArrayType, fields(dwarf.AttrType, dwarf.Offset(0x11)),
+			entry(0x41, dwarf.TagSubrangeType, fields(dwarf.AttrCount, int64(4)))),
+		entry(0x42, dwarf.TagArrayType, fields(dwarf.AttrType, dwarf.Offset(0x10)),
+			entry(0x43, dwarf.TagSubrangeType, fields(dwarf.AttrUpperBound, int64(1))),
+			entry(0x44, dwarf.TagSubrangeType, fields(dwarf.AttrUpperBound, int64(2)))),
+		entry(0x45, dwarf.TagArrayType, fields(dwarf.AttrType, dwarf.Offset(0x10)),
+			entry(0x46, dwarf.TagSubrangeType, nil)),
+		entry(0x47, dwarf.TagArrayType, fields(dwarf.AttrType, dwarf.Offset(0x20))),
+		entry(0x48, dwarf.TagPointerType, fields(dwarf.AttrType, dwarf.Offset(0x40))),
+		entry(0x50, dwarf.TagTypedef, fields(dwarf.AttrName, "myint", dwarf.AttrType, dwarf.Offset(0x10))),
+		entry(0x51, dwarf.TagStructType, fields(dwarf.AttrName, "point")),
+		entry(0x52, dwarf.TagPointerType, fields(dwarf.AttrType, dwarf.Offset(0x51))),
+		entry(0x53, dwarf.TagStructType, nil,
+			entry(0x54, dwarf.TagMember, fields(dwarf.AttrName, "x", dwarf.AttrType, dwarf.Offset(0x10))),
+			entry(0x55, dwarf.TagMember, fields(dwarf.AttrName, "p", dwarf.AttrType, dwarf.Offset(0x20)))),
+		entry(0x56, dwarf.TagUnionType, nil,
+			entry(0x57, dwarf.TagMember, fields(dwarf.AttrName, "c", dwarf.AttrType, dwarf.Offset(0x11)))),
+		entry(0x60, dwarf.TagSubroutineType, fields(dwarf.AttrType, dwarf.Offset(0x10)),
+			entry(0x61, dwarf.TagFormalParameter, fields(dwarf.AttrType, dwarf.Offset(0x31))),
+			entry(0x62, dwarf.TagUnspecifiedParameters, nil)),
+		entry(0x63, dwarf.TagPointerType, fields(dwarf.AttrType, dwarf.Offset(0x60))),
+		entry(0x64, dwarf.TagSubroutineType, nil),
+		entry(0x65, dwarf.TagPointerType, fields(dwarf.AttrType, dwarf.Offset(0x64))),
+		entry(0x66, dwarf.TagSubroutineType, fields(dwarf.AttrType, dwarf.Offset(0x53)),
+			entry(0x67, dwarf.TagFormalParameter, fields(dwarf.AttrType, dwarf.Offset(0x65))),
+			entry(0x68, dwarf.TagFormalParameter, fields(dwarf.AttrType, dwarf.Offset(0x12)))),
+		entry(0x70, dwarf.TagPointerType, fields(dwarf.AttrType, dwarf.Offset(0x999))),
+		entry(0x80, dwarf.TagStructType, nil),
+		entry(0x81, dwarf.TagStructType, nil,
+			entry(0x82, dwarf.TagMember, fields(dwarf.AttrName, "a", dwarf.AttrType, dwarf.Offset(0x10))),
+			entry(0x83, dwarf.TagMember, fields(dwarf.AttrType, dwarf.Offset(0x56))),
+			entry(0x84, dwarf.TagMember, fields(dwarf.AttrName, "b", dwarf.AttrType, dwarf.Offset(0x10))),
+			entry(0x85, dwarf.TagMember, fields(dwarf.AttrName, "c", dwarf.AttrType, dwarf.Offset(0x10))),
+			entry(0x86, dwarf.TagMember, fields(dwarf.AttrName, "d", dwarf.AttrType, dwarf.Offset(0x10)))),
+		entry(0x87, dwarf.TagPointerType, fields(dwarf.AttrType, dwarf.Offset(0x81))),
+		entry(0x90, dwarf.TagBaseType, fields(dwarf.AttrByteSize, int64(4), dwarf.AttrEncoding, int64(encFloat))),
+		entry(0x91, dwarf.TagBaseType, fields(dwarf.AttrByteSize, int64(1), dwarf.AttrEncoding, int64(encSignedChar))),
+		entry(0x92, dwarf.TagBaseType, fields(dwarf.AttrByteSize, int64(1), dwarf.AttrEncoding, int64(encBoolean))),
+		entry(0x93, dwarf.TagBaseType, fields(dwarf.AttrByteSize, int64(16), dwarf.AttrEncoding, int64(encComplexFloat))),
+		entry(0x94, dwarf.TagBaseType, fields(dwarf.AttrEncoding, int64(encSigned))),
+		entry(0x95, dwarf.TagBaseType, nil),
+	)
+
+	tests := []struct {
+		off  dwarf.Offset
+		want string
+	}{
+		{0x10, "int"},
+		{0x12, "uint64"},
+		{0x20, "*int"},
+		{0x21, "*void"},
+		{0x22, "**int"},
+		{0x30, "const char"},
+		{0x31, "*const char"},
+		{0x32, "volatile int"},
+		{0x33, "restrict *void"},
+		{0x34, "const volatile int"},
+		{0x40, "[4]char"},
+		{0x42, "[2][3]int"},
+		{0x45, "[]int"},
+		{0x47, "[]*int"},
+		{0x48, "*[4]char"},
+		{0x50, "myint"},
+		{0x51, "struct point"},
+		{0x52, "*struct point"},
+		{0x53, "struct{x int; p *int}"},
+		{0x56, "union{c char}"},
+		{0x63, "*func(*const char, ...) int"},
+		{0x65, "*func()"},
+		{0x66, "func(*func(), uint64) struct{x int; p *int}"},
+		{0x70, "*" + unknownType},
+		{0x80, "struct{}"},
+		{0x87, "*struct{a int; union{c char}; b int; c int; ...}"},
+		{0x90, "float32"},
+		{0x91, "int8"},
+		{0x92, "bool"},
+		{0x93, "complex128"},
+		{0x94, "int"},
+		{0x95, "base"},
+		{0x999, unknownType},
+	}
+	for _, tt := range tests {
+		if got := typeName(root, tt.off); got != tt.want {
+			t.Errorf("type at 0x%x: got %q, want %q", tt.off, got, tt.want)
+		}
+	}
+}
+
+func TestFuncArgsTypeNames(t *testing.T) {
+	for _, fixture := range []string{"c-dwarf4", "c-dwarf5"} {
+		d := openDWARF(t, fixture)
+		funcs, err := FuncArgs(d, func(name string) bool { return name == "label" || name == "add" })
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]string)
+		for _, f := range funcs {
+			for _, p := range f.Params {
+				got[f.Function+"."+p.Name] = p.Type
+			}
+		}
+		want := map[string]string{
+			"add.p":      "*struct point",
+			"add.k":      "int",
+			"label.name": "*const char",
+			"label.seen": "*volatile long int",
+		}
+		for k, w := range want {
+			if got[k] != w {
+				t.Errorf("%s: %s: got type %q, want %q", fixture, k, got[k], w)
+			}
+		}
+	}
+}
+
+func TestFuncArgsNested(t *testing.T) {
+	// step is a GCC nested function in a lexical block of nested
+	for _, fixture := range []string{"c-dwarf4", "c-dwarf5"} {
+		d := openDWARF(t, fixture)
+		funcs, err := FuncArgs(d, func(name string) bool { return name == "step" })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(funcs) != 1 {
+			t.Fatalf("%s: got %d functions named step, want 1", fixture, len(funcs))
+		}
+		f := funcs[0]
+		if f.LowPC == 0 || f.Size == 0 {
+			t.Errorf("%s: step has pc 0x%x and size %d", fixture, f.LowPC, f.Size)
+		}
+		if len(f.Params) != 1 || f.Params[0] != (Param{Name: "k", Type: "int"}) {
+			t.Errorf("%s: got params %+v, want k int", fixture, f.Params)
+		}
+		if len(f.Returns) != 1 || f.Returns[0] != "int" {
+			t.Errorf("%s: got returns %q, want int", fixture, f.Returns)
+		}
+	}
+}
+
+func TestMembersAttributeForms(t *testing.T) {
+	// member attributes in forms other than the usual ones must
+	// be read where they can be and ignored where they can't,
+	// never panicking
+	st := entry(0x20, dwarf.TagStructType, fields(dwarf.AttrName, "s", dwarf.AttrByteSize, int64(32)),
+		entry(0x21, dwarf.TagMember, fields(dwarf.AttrName, "a", dwarf.AttrType, dwarf.Offset(0x10), dwarf.AttrDataMemberLoc, int64(0))),
+		entry(0x22, dwarf.TagMember, fields(dwarf.AttrName, "b", dwarf.AttrType, dwarf.Offset(0x10), dwarf.AttrDataMemberLoc, uint64(4))),
+		// DW_OP_plus_uconst 8, as older compilers emit
+		entry(0x23, dwarf.TagMember, fields(dwarf.AttrName, "c", dwarf.AttrType, dwarf.Offset(0x11), dwarf.AttrDataMemberLoc, []byte{0x23, 0x08})),
+		entry(0x24, dwarf.TagMember, fields(dwarf.AttrName, int64(7), dwarf.AttrType, dwarf.Offset(0x11), dwarf.AttrDataMemberLoc, []byte{0x23, 0x10})),
+		entry(0x25, dwarf.TagMember, fields(dwarf.AttrName, "e", dwarf.AttrType, int64(0x11), dwarf.AttrDataMemberLoc, "24")),
+		// an expression that isn't a constant offset
+		entry(0x26, dwarf.TagMember, fields(dwarf.AttrName, "f", dwarf.AttrType, dwarf.Offset(0x10), dwarf.AttrDataMemberLoc, []byte{0x06})),
+	)
+	root := entryTree(
+		entry(0x10, dwarf.TagBaseType, fields(dwarf.AttrName, "int", dwarf.AttrByteSize, int64(4))),
+		entry(0x11, dwarf.TagBaseType, fields(dwarf.AttrName, "long", dwarf.AttrByteSize, int64(8))),
+		st,
+	)
+
+	want := []struct {
+		name   string
+		typ    string
+		offset int64
+	}{
+		{"a", "int", 0},
+		{"b", "int", 4},
+		{"c", "long", 8},
+		{"", "long", 16},
+		{"e", "", 0},
+		{"f", "int", 0},
+	}
+	got := members(root, st)
+	if len(got) != len(want) {
+		t.Fatalf("got %d members, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		m := got[i]
+		if m.Name != w.name || m.Type != w.typ || m.Offset != w.offset {
+			t.Errorf("member %d: got %q %q at %d, want %q %q at %d", i, m.Name, m.Type, m.Offset, w.name, w.typ, w.offset)
+		}
+	}
+}
+
+func TestTypesMemberLocationForms(t *testing.T) {
+	// c-dwarf2's member locations are DW_OP_plus_uconst
+	// expressions, c-dwarf5's constants; both must give the same
+	// layout
+	want := map[string]int64{
+		"ready": 0, "mode": 0, "id": 2, "val": 4, "val.i": 0, "val.f": 0,
+		"inner": 8, "inner.tag": 0, "inner.n": 8,
+	}
+	for _, fixture := range []string{"c-dwarf2", "c-dwarf5"} {
+		d := openDWARF(t, fixture)
+		if exprs := memberLocExprs(t, d); (fixture == "c-dwarf2") != (exprs > 0) {
+			t.Errorf("%s has %d expression member locations", fixture, exprs)
+		}
+
+		types, err := Types(d, TypeFilter{Match: func(name string) bool { return name == "flags" }, Depth: -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(types) != 1 {
+			t.Fatalf("%s: got %d types named flags, want 1", fixture, len(types))
+		}
+		got := make(map[string]int64)
+		var flatten func(prefix string, members []Member)
+		flatten = func(prefix string, members []Member) {
+			for _, m := range members {
+				got[prefix+m.Name] = m.Offset
+				flatten(prefix+m.Name+".", m.Members)
+			}
+		}
+		flatten("", types[0].Members)
+		for name, off := range want {
+			if o, ok := got[name]; !ok || o != off {
+				t.Errorf("%s: member %s at %d (found %v), want %d", fixture, name, o, ok, off)
+			}
+		}
+		if types[0].Size != 24 {
+			t.Errorf("%s: flags has size %d, want 24", fixture, types[0].Size)
+		}
+	}
+}
+
+// memberLocExprs counts the members in d whose location is an
+// expression rather than a constant.
+func memberLocExprs(tb testing.TB, d *dwarf.Data) int {
+	tb.Helper()
+	var n int
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if e == nil {
+			return n
+		}
+		if _, ok := e.Val(dwarf.AttrDataMemberLoc).([]byte); ok && e.Tag == dwarf.TagMember {
+			n++
+		}
+	}
+}
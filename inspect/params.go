@@ -0,0 +1,765 @@
+package inspect
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/psanford/pptrace/internal/dwarfutil"
+	"github.com/psanford/pptrace/internal/vlog"
+)
+
+// EntryParam is a function parameter and where its value can be
+// read when a uprobe on the function's first instruction fires.
+type EntryParam struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Parts are kernel fetch args reading the parameter: one for a
+	// scalar, or one per field for composites such as Go strings.
+	Parts []ParamPart `json:"parts,omitempty"`
+	// Unavailable explains why the parameter can't be read, in
+	// which case Parts is empty.
+	Unavailable string `json:"unavailable,omitempty"`
+	// Warning is set if the debug info doesn't say where the
+	// parameter is at entry and it was placed by the calling
+	// convention alone, which may be wrong in optimized code.
+	Warning string `json:"warning,omitempty"`
+}
+
+// ParamPart is a fetch arg reading a scalar part of a parameter.
+type ParamPart struct {
+	// Name is the parameter's name, followed by Field for parts of
+	// composites, e.g. s_len.
+	Name string `json:"name"`
+	// Field is the path to the part within a composite, with
+	// fields separated by _, or empty for scalars.
+	Field string `json:"field,omitempty"`
+	Fetch string `json:"fetch"`
+	Type  string `json:"type"`
+}
+
+// callABI describes how arguments are passed on entry to a
+// function, in terms of the registers a uprobe can read.
+type callABI struct {
+	// ints are the kernel names of the integer argument registers
+	// in the order they're assigned. floats are the floating
+	// point argument registers, which uprobes can't read.
+	ints   []string
+	floats []string
+	// stackBase is the offset from the stack pointer at entry of
+	// the first argument passed on the stack.
+	stackBase int64
+	// goABI is set for Go's ABI0 and ABIInternal, which assign
+	// each scalar of an argument its own register.
+	goABI bool
+	// aapcs is set for the arm64 procedure call standard, where
+	// composites over 16 bytes are passed by reference.
+	aapcs bool
+}
+
+func regNames(prefix string, n int) []string {
+	regs := make([]string, n)
+	for i := range regs {
+		regs[i] = prefix + strconv.Itoa(i)
+	}
+	return regs
+}
+
+var (
+	goABIInternalAMD64 = callABI{
+		ints:      []string{"ax", "bx", "cx", "di", "si", "r8", "r9", "r10", "r11"},
+		floats:    regNames("X", 15),
+		stackBase: 8,
+		goABI:     true,
+	}
+	goABIInternalARM64 = callABI{
+		ints:      regNames("x", 16),
+		floats:    regNames("F", 16),
+		stackBase: 8,
+		goABI:     true,
+	}
+	goABI0 = callABI{
+		stackBase: 8,
+		goABI:     true,
+	}
+	sysVAMD64 = callABI{
+		ints:      []string{"di", "si", "dx", "cx", "r8", "r9"},
+		floats:    regNames("xmm", 8),
+		stackBase: 8,
+	}
+	aapcs64 = callABI{
+		ints:   regNames("x", 8),
+		floats: regNames("v", 8),
+		aapcs:  true,
+	}
+)
+
+// goRegABIMinor is the minor version of the first Go 1 release
+// passing arguments in registers on each architecture.
+var goRegABIMinor = map[elf.Machine]int{
+	elf.EM_X86_64:  17,
+	elf.EM_AARCH64: 18,
+}
+
+var goVersionRe = regexp.MustCompile(`go1\.(\d+)`)
+
+// dwarfRegs are the kernel names of the integer DWARF registers,
+// indexed by DWARF register number.
+var dwarfRegs = map[elf.Machine][]string{
+	elf.EM_X86_64:  {"ax", "dx", "cx", "bx", "si", "di", "bp", "sp", "r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15"},
+	elf.EM_AARCH64: append(regNames("x", 31), "sp"),
+}
+
+// entryCFA is the offset from the stack pointer of the canonical
+// frame address at a function's first instruction: above the
+// return address on x86-64, which the call pushed, and the stack
+// pointer itself on arm64, where it's in the link register.
+var entryCFA = map[elf.Machine]int64{
+	elf.EM_X86_64:  8,
+	elf.EM_AARCH64: 0,
+}
+
+// opCallFrameCFA is the DW_OP_call_frame_cfa opcode, the frame base
+// compilers usually give functions.
+const opCallFrameCFA = 0x9c
+
+// findABI returns the calling convention of functions in exe. Go
+// binaries use the register ABI from the Go release it was
+// introduced in on their architecture, and the stack based ABI0
+// before it; binaries of an unknown Go version are assumed to be
+// recent.
+func findABI(path string, exe *elf.File) (*callABI, error) {
+	if bi := readGoBuildInfo(path, &elfExe{exe}); bi != nil {
+		minor, ok := goRegABIMinor[exe.Machine]
+		if !ok {
+			return nil, fmt.Errorf("%s: Go parameters by name aren't supported on %s", path, exe.Machine)
+		}
+		if m := goVersionRe.FindStringSubmatch(bi.GoVersion); m != nil {
+			if v, _ := strconv.Atoi(m[1]); v < minor {
+				return &goABI0, nil
+			}
+		}
+		if exe.Machine == elf.EM_AARCH64 {
+			return &goABIInternalARM64, nil
+		}
+		return &goABIInternalAMD64, nil
+	}
+
+	switch exe.Machine {
+	case elf.EM_X86_64:
+		return &sysVAMD64, nil
+	case elf.EM_AARCH64:
+		return &aapcs64, nil
+	}
+	return nil, fmt.Errorf("%s: parameters by name aren't supported on %s", path, exe.Machine)
+}
+
+// EntryParams returns the parameters of the function at address
+// addr of the ELF file at path, from its DWARF, with the fetch args
+// that read them at the function's entry. Parameters are read from
+// where their DWARF location, or the entry of its location list
+// covering addr, places them. Those it doesn't place are located by
+// the calling convention, which for Go binaries depends on the Go
+// version they were built with.
+func EntryParams(path string, addr uint64) ([]EntryParam, error) {
+	exe, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Open elf %s err: %s", path, err)
+	}
+	defer exe.Close()
+
+	abi, err := findABI(path, exe)
+	if err != nil {
+		return nil, err
+	}
+
+	dwarfPath, err := dwarfutil.FindDwarf(path)
+	if err != nil {
+		return nil, err
+	}
+	df, err := elf.Open(dwarfPath)
+	if err != nil {
+		return nil, fmt.Errorf("Open debug ELF %s err: %s", dwarfPath, err)
+	}
+	defer df.Close()
+	d, loclists, err := dwarfutil.ELFDataLocLists(df, dwarfPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: read dwarf err: %s", dwarfPath, err)
+	}
+
+	cu, sub, entries, err := subprogramParams(d, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// memory pieces relative to the frame base can only be read
+	// when it's the CFA, whose value at entry is known
+	frameBase, _ := sub.Val(dwarf.AttrFrameBase).([]byte)
+	cfaFrame := len(frameBase) == 1 && frameBase[0] == opCallFrameCFA
+
+	ptrSize := int64(8)
+	if exe.Class == elf.ELFCLASS32 {
+		ptrSize = 4
+	}
+
+	params := make([]paramLayout, 0, len(entries))
+	for _, e := range entries {
+		p := paramLayout{regOK: true, ptrSize: ptrSize}
+		p.Name, _ = originAttr(d, e, dwarf.AttrName).(string)
+		off, ok := originAttr(d, e, dwarf.AttrType).(dwarf.Offset)
+		if !ok {
+			p.err = "it has no type in the debug info"
+			params = append(params, p)
+			continue
+		}
+		t, err := d.Type(off)
+		if err != nil {
+			p.err = fmt.Sprintf("read its type err: %s", err)
+			params = append(params, p)
+			continue
+		}
+		p.Type = t.String()
+		p.size = t.Size()
+		if err := p.flatten(t, "", 0); err != nil {
+			p.err = err.Error()
+		}
+
+		field := e.AttrField(dwarf.AttrLocation)
+		switch {
+		case field == nil:
+		case field.Class == dwarf.ClassExprLoc:
+			// a single expression holds for the whole function, but
+			// at -O0 that's a stack slot filled by the prologue, so
+			// only registers are trusted
+			loc, _ := field.Val.([]byte)
+			if pieces, ok := dwarfutil.LocationPieces(loc); ok && allRegs(pieces) {
+				p.pieces = pieces
+			}
+		case field.Class == dwarf.ClassLocListPtr || field.Class == dwarf.ClassLocList:
+			loc, ok, err := loclists.At(cu, field, addr)
+			switch {
+			case err != nil:
+				p.Warning = fmt.Sprintf("read its location list err: %s", err)
+			case !ok:
+				p.Warning = "no entry of its location list covers the function's entry, so it may be unavailable"
+			default:
+				pieces, ok := dwarfutil.LocationPieces(loc)
+				if !ok || hasFbreg(pieces) && !cfaFrame {
+					p.Warning = "its location at the function's entry is a DWARF expression pptrace can't evaluate"
+					break
+				}
+				p.pieces = pieces
+			}
+		}
+		params = append(params, p)
+	}
+
+	return abi.assign(params, dwarfRegs[exe.Machine], entryCFA[exe.Machine]), nil
+}
+
+func allRegs(pieces []dwarfutil.LocPiece) bool {
+	for _, piece := range pieces {
+		if piece.Kind != dwarfutil.LocReg {
+			return false
+		}
+	}
+	return true
+}
+
+func hasFbreg(pieces []dwarfutil.LocPiece) bool {
+	for _, piece := range pieces {
+		if piece.Kind == dwarfutil.LocFbreg {
+			return true
+		}
+	}
+	return false
+}
+
+// subprogramParams returns the compilation unit and entry of the
+// subprogram whose code starts at addr, and its formal parameter
+// entries.
+func subprogramParams(d *dwarf.Data, addr uint64) (cu, sub *dwarf.Entry, params []*dwarf.Entry, err error) {
+	r := d.Reader()
+	cu, err = r.SeekPC(addr)
+	if err != nil {
+		// not every compiler emits unit ranges; search them all
+		r.Seek(0)
+	}
+
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("read dwarf err: %w", err)
+		}
+		if e == nil {
+			return nil, nil, nil, fmt.Errorf("no debug info for the function at 0x%x", addr)
+		}
+		if e.Tag == dwarf.TagCompileUnit {
+			cu = e
+		}
+		if e.Tag != dwarf.TagSubprogram {
+			continue
+		}
+		if low, _, ok := dwarfutil.PCRange(e); !ok || low != addr {
+			if e.Children {
+				r.SkipChildren()
+			}
+			continue
+		}
+
+		for e.Children {
+			c, err := r.Next()
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("read dwarf err: %w", err)
+			}
+			if c == nil || c.Tag == 0 {
+				break
+			}
+			if c.Children {
+				r.SkipChildren()
+			}
+			if c.Tag != dwarf.TagFormalParameter {
+				continue
+			}
+			// Go marks its result parameters as variable parameters
+			if isOutput, _ := c.Val(dwarf.AttrVarParam).(bool); isOutput {
+				continue
+			}
+			params = append(params, c)
+		}
+		return cu, e, params, nil
+	}
+}
+
+// originAttr returns attribute attr of e, looking through its
+// abstract origin as out of line copies of inlined functions
+// describe their names and parameters by reference.
+func originAttr(d *dwarf.Data, e *dwarf.Entry, attr dwarf.Attr) interface{} {
+	if v := e.Val(attr); v != nil {
+		return v
+	}
+	off, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+	if !ok {
+		return nil
+	}
+	r := d.Reader()
+	r.Seek(off)
+	origin, err := r.Next()
+	if err != nil || origin == nil {
+		return nil
+	}
+	return origin.Val(attr)
+}
+
+var unsafeNameRe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// safeParamName makes name usable as a fetch arg name.
+func safeParamName(name string) string {
+	name = unsafeNameRe.ReplaceAllString(name, "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// paramLeaf is a scalar part of a parameter.
+type paramLeaf struct {
+	// field is the path to the part, each field preceded by _
+	field  string
+	off    int64
+	size   int64
+	signed bool
+	ptr    bool
+	float  bool
+}
+
+// part returns a fetch arg reading leaf l of p.
+func (p *paramLayout) part(l paramLeaf, fetch, typ string) ParamPart {
+	return ParamPart{
+		Name:  safeParamName(p.Name) + l.field,
+		Field: strings.TrimPrefix(l.field, "_"),
+		Fetch: fetch,
+		Type:  typ,
+	}
+}
+
+func (l paramLeaf) fetchType() string {
+	switch {
+	case l.float, l.ptr:
+		return fmt.Sprintf("x%d", l.size*8)
+	case l.signed:
+		return fmt.Sprintf("s%d", l.size*8)
+	}
+	return fmt.Sprintf("u%d", l.size*8)
+}
+
+// paramLayout is a parameter broken down into its scalar parts.
+type paramLayout struct {
+	EntryParam
+	size    int64
+	ptrSize int64
+	leaves  []paramLeaf
+	// regOK is cleared for types Go never passes in registers:
+	// those containing arrays of more than one element.
+	regOK bool
+	// pieces is where the debug info places the parameter at
+	// entry, if it does.
+	pieces []dwarfutil.LocPiece
+	// err is why the parameter can't be read, if set.
+	err string
+}
+
+// maxParamLeaves bounds the scalars a parameter is broken into, the
+// kernel's limit on fetch args for a probe.
+const maxParamLeaves = 128
+
+// flatten appends the scalar parts of a value of type t at offset
+// off to p.leaves.
+func (p *paramLayout) flatten(t dwarf.Type, field string, off int64) error {
+	if len(p.leaves) >= maxParamLeaves {
+		return fmt.Errorf("it has more than %d parts", maxParamLeaves)
+	}
+
+	leaf := paramLeaf{field: field, off: off, size: t.Size()}
+	switch t := t.(type) {
+	case *dwarf.TypedefType:
+		return p.flatten(t.Type, field, off)
+	case *dwarf.QualType:
+		return p.flatten(t.Type, field, off)
+	case *dwarf.IntType, *dwarf.CharType:
+		leaf.signed = true
+	case *dwarf.UintType, *dwarf.UcharType, *dwarf.BoolType, *dwarf.EnumType, *dwarf.AddrType:
+	case *dwarf.PtrType, *dwarf.FuncType:
+		leaf.ptr = true
+		leaf.size = p.ptrSize
+	case *dwarf.FloatType:
+		leaf.float = true
+	case *dwarf.ComplexType:
+		half := t.Size() / 2
+		p.leaves = append(p.leaves,
+			paramLeaf{field: field + "_real", off: off, size: half, float: true},
+			paramLeaf{field: field + "_imag", off: off + half, size: half, float: true})
+		return nil
+	case *dwarf.StructType:
+		if t.Kind == "union" {
+			return fmt.Errorf("it's a union")
+		}
+		if t.Incomplete {
+			return fmt.Errorf("its type %s is incomplete", t)
+		}
+		for _, f := range t.Field {
+			if f.BitSize != 0 {
+				return fmt.Errorf("field %s is a bitfield", f.Name)
+			}
+			if err := p.flatten(f.Type, field+"_"+safeParamName(f.Name), off+f.ByteOffset); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *dwarf.ArrayType:
+		if t.Count < 0 || t.Type.Size() < 0 {
+			return fmt.Errorf("array %s has an unknown size", t)
+		}
+		if t.Count > 1 {
+			p.regOK = false
+		}
+		for i := int64(0); i < t.Count; i++ {
+			if err := p.flatten(t.Type, fmt.Sprintf("%s_%d", field, i), off+i*t.Type.Size()); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("can't read a %s", t)
+	}
+
+	switch leaf.size {
+	case 1, 2, 4, 8:
+	default:
+		return fmt.Errorf("can't read a %d byte %s", leaf.size, t)
+	}
+	p.leaves = append(p.leaves, leaf)
+	return nil
+}
+
+// align returns the alignment of the parameter, that of its most
+// aligned scalar.
+func (p *paramLayout) align() int64 {
+	align := int64(1)
+	for _, l := range p.leaves {
+		if l.size > align {
+			align = l.size
+		}
+	}
+	return align
+}
+
+func alignUp(n, align int64) int64 {
+	return (n + align - 1) / align * align
+}
+
+// assign works out where each parameter is at entry, by the
+// calling convention or its DWARF location. regs are the names of
+// the DWARF registers and cfa the offset of the CFA from the stack
+// pointer at entry, for reading DWARF locations.
+func (a *callABI) assign(params []paramLayout, regs []string, cfa int64) []EntryParam {
+	var (
+		nextInt, nextFloat int
+		stack              int64
+		out                = make([]EntryParam, 0, len(params))
+	)
+
+	// onStack places p in the next stack slot with the given
+	// alignment
+	onStack := func(p *paramLayout, align int64) {
+		stack = alignUp(stack, align)
+		for _, l := range p.leaves {
+			p.Parts = append(p.Parts, p.part(l, fmt.Sprintf("%+d(%%sp)", a.stackBase+stack+l.off), l.fetchType()))
+		}
+		stack += p.size
+	}
+
+	// lost is a parameter whose registers or stack slot couldn't
+	// be worked out, so nor can those of any that follow
+	var lost string
+	for i := range params {
+		p := &params[i]
+		if p.err != "" {
+			p.Unavailable = p.err
+			out = append(out, p.EntryParam)
+			lost = p.Name
+			continue
+		}
+
+		var nInt, nFloat int
+		for _, l := range p.leaves {
+			if l.float {
+				nFloat++
+			} else {
+				nInt++
+			}
+		}
+
+		// the convention is followed even for parameters the debug
+		// info places, to keep track of the registers and stack
+		// used by those that follow
+		switch {
+		case lost != "":
+			p.Unavailable = fmt.Sprintf("the location of the parameter before it, %s, is unknown", lost)
+		case a.goABI:
+			if p.regOK && nextInt+nInt <= len(a.ints) && nextFloat+nFloat <= len(a.floats) {
+				a.inRegisters(p, p.leaves, &nextInt, &nextFloat)
+			} else {
+				onStack(p, p.align())
+			}
+		default:
+			a.assignC(p, &nextInt, &nextFloat, onStack)
+		}
+		if p.pieces != nil {
+			p.Parts, p.Unavailable = nil, ""
+			p.fromPieces(regs, cfa)
+			vlog.Debugf("parameter %s: located by the debug info", p.Name)
+		} else {
+			vlog.Debugf("parameter %s: located by the calling convention", p.Name)
+		}
+
+		if p.Unavailable != "" {
+			p.Parts = nil
+		}
+		out = append(out, p.EntryParam)
+	}
+	return out
+}
+
+// fromPieces reads p from the pieces of its DWARF location. regs
+// and cfa are as for assign.
+func (p *paramLayout) fromPieces(regs []string, cfa int64) {
+	var pos int64
+	for _, piece := range p.pieces {
+		size := int64(piece.Size)
+		if size == 0 {
+			size = p.size
+		}
+		for _, l := range p.leaves {
+			if l.off < pos || l.off >= pos+size {
+				continue
+			}
+			rel := l.off - pos
+			if rel+l.size > size {
+				p.Unavailable = fmt.Sprintf("%s straddles two pieces of its location", safeParamName(p.Name)+l.field)
+				return
+			}
+
+			switch piece.Kind {
+			case dwarfutil.LocMissing:
+				p.Unavailable = fmt.Sprintf("%s has been optimized out", safeParamName(p.Name)+l.field)
+				return
+			case dwarfutil.LocReg:
+				if piece.Reg >= uint64(len(regs)) {
+					p.Unavailable = fmt.Sprintf("%s is in DWARF register %d, which uprobes can't read", safeParamName(p.Name)+l.field, piece.Reg)
+					return
+				}
+				typ := l.fetchType()
+				if rel != 0 || size > l.size && len(p.leaves) > 1 {
+					// a value sharing the register is extracted
+					// as a bitfield
+					typ = fmt.Sprintf("b%d@%d/64", l.size*8, rel*8)
+				}
+				p.Parts = append(p.Parts, p.part(l, "%"+regs[piece.Reg], typ))
+			case dwarfutil.LocBreg:
+				if piece.Reg >= uint64(len(regs)) {
+					p.Unavailable = fmt.Sprintf("%s is relative to DWARF register %d, which uprobes can't read", safeParamName(p.Name)+l.field, piece.Reg)
+					return
+				}
+				p.Parts = append(p.Parts, p.part(l, fmt.Sprintf("%+d(%%%s)", piece.Offset+rel, regs[piece.Reg]), l.fetchType()))
+			case dwarfutil.LocFbreg:
+				p.Parts = append(p.Parts, p.part(l, fmt.Sprintf("%+d(%%sp)", cfa+piece.Offset+rel), l.fetchType()))
+			}
+		}
+		pos += size
+	}
+	if len(p.Parts) < len(p.leaves) {
+		p.Unavailable = "its location describes only part of it"
+	}
+}
+
+// inRegisters assigns leaves to the next integer and floating point
+// registers in turn.
+func (a *callABI) inRegisters(p *paramLayout, leaves []paramLeaf, nextInt, nextFloat *int) {
+	for _, l := range leaves {
+		if l.float {
+			if p.Unavailable == "" {
+				p.Unavailable = fmt.Sprintf("%s is passed in floating point register %s, which uprobes can't read", safeParamName(p.Name)+l.field, a.floats[*nextFloat])
+			}
+			*nextFloat++
+			continue
+		}
+		p.Parts = append(p.Parts, p.part(l, "%"+a.ints[*nextInt], l.fetchType()))
+		*nextInt++
+	}
+}
+
+// assignC places a parameter by the System V x86-64 or AAPCS64
+// rules: composites of up to 16 bytes are split into eightbytes,
+// each passed in a floating point register if it holds only
+// floating point values and in an integer register otherwise.
+// Bigger composites are passed on the stack, or by reference for
+// AAPCS64.
+func (a *callABI) assignC(p *paramLayout, nextInt, nextFloat *int, onStack func(*paramLayout, int64)) {
+	stackAlign := p.align()
+	if stackAlign < 8 {
+		stackAlign = 8
+	}
+
+	// AAPCS64 passes structs of up to four floating point members,
+	// homogeneous floating point aggregates, in one register each,
+	// even those over 16 bytes
+	if a.aapcs && len(p.leaves) > 1 && len(p.leaves) <= 4 {
+		allFloat := true
+		for _, l := range p.leaves {
+			allFloat = allFloat && l.float
+		}
+		if allFloat {
+			if *nextFloat+len(p.leaves) > len(a.floats) {
+				*nextFloat = len(a.floats)
+				onStack(p, stackAlign)
+				return
+			}
+			a.inRegisters(p, p.leaves, nextInt, nextFloat)
+			return
+		}
+	}
+
+	if p.size > 16 {
+		if !a.aapcs {
+			onStack(p, stackAlign)
+			return
+		}
+		if *nextInt >= len(a.ints) {
+			// the pointer to the copy is itself on the stack;
+			// reading through it takes a double dereference
+			onStack(&paramLayout{size: 8}, 8)
+			p.Unavailable = "it's passed by reference on the stack"
+			return
+		}
+		reg := a.ints[*nextInt]
+		*nextInt++
+		for _, l := range p.leaves {
+			p.Parts = append(p.Parts, p.part(l, fmt.Sprintf("+%d(%%%s)", l.off, reg), l.fetchType()))
+		}
+		return
+	}
+
+	eightbytes := make([][]paramLeaf, (p.size+7)/8)
+	for _, l := range p.leaves {
+		if l.off%l.size != 0 {
+			onStack(p, stackAlign)
+			return
+		}
+		eightbytes[l.off/8] = append(eightbytes[l.off/8], l)
+	}
+	var nInt, nFloat int
+	for _, eb := range eightbytes {
+		if isFloatEightbyte(eb) {
+			nFloat++
+		} else {
+			nInt++
+		}
+	}
+	if *nextInt+nInt > len(a.ints) || *nextFloat+nFloat > len(a.floats) {
+		if a.aapcs && nInt > 0 {
+			// a composite that doesn't fit uses no more registers
+			*nextInt = len(a.ints)
+		}
+		onStack(p, stackAlign)
+		return
+	}
+
+	for _, eb := range eightbytes {
+		if isFloatEightbyte(eb) {
+			if p.Unavailable == "" {
+				p.Unavailable = fmt.Sprintf("%s is passed in floating point register %s, which uprobes can't read", p.Name, a.floats[*nextFloat])
+			}
+			*nextFloat++
+			continue
+		}
+		reg := a.ints[*nextInt]
+		*nextInt++
+		for _, l := range eb {
+			typ := l.fetchType()
+			if len(eb) > 1 || l.off%8 != 0 {
+				// a value sharing the register is extracted
+				// as a bitfield
+				typ = fmt.Sprintf("b%d@%d/64", l.size*8, l.off%8*8)
+			}
+			p.Parts = append(p.Parts, p.part(l, "%"+reg, typ))
+		}
+	}
+}
+
+// isFloatEightbyte reports whether an eightbyte of a composite
+// holds only floating point values. Empty ones, padding, are
+// classed with the integers.
+func isFloatEightbyte(leaves []paramLeaf) bool {
+	if len(leaves) == 0 {
+		return false
+	}
+	for _, l := range leaves {
+		if !l.float {
+			return false
+		}
+	}
+	return true
+}
+
+// ParamNames returns the names of params, for error messages.
+func ParamNames(params []EntryParam) string {
+	names := make([]string, len(params))
+	for i, p := range params {
+		names[i] = p.Name
+	}
+	return strings.Join(names, ", ")
+}
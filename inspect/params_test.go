@@ -0,0 +1,246 @@
+package inspect
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// partsString formats the parts of params as name=fetch:type, with
+// unreadable params as name=!, for comparing.
+func partsString(params []EntryParam) string {
+	var out []string
+	for _, p := range params {
+		if p.Unavailable != "" {
+			out = append(out, p.Name+"=!")
+			continue
+		}
+		for _, part := range p.Parts {
+			out = append(out, part.Name+"="+part.Fetch+":"+part.Type)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func TestEntryParams(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		function string
+		want     string
+	}{
+		{"c-nopie", "add", "p=%di:x64 k=%si:s32"},
+		{"c-pie", "scale", "f=%di:x64 by=%si:s64"},
+		{"c-dwarf4", "label", "name=%di:x64 seen=%si:x64"},
+		// main's parameters are in location lists of the split units
+		{"split-O2/c-split", "main", "argc=%di:s32 argv=%si:x64"},
+		{"split4-O2/c-split", "main", "argc=%di:s32 argv=%si:x64"},
+		{"dwp4-O2/c-split", "main", "argc=%di:s32 argv=%si:x64"},
+		{"go-exe", "main.mixed", "i=%ax:s64 f=! p=%bx:x64 s_str=%cx:x64 s_len=%di:s64 b=%si:u8"},
+		{"go-noopt", "main.mixed", "i=%ax:s64 f=! p=%bx:x64 s_str=%cx:x64 s_len=%di:s64 b=%si:u8"},
+		{"go-exe", "main.(*Point).Scale", "p=%ax:x64 by=%bx:s64"},
+		{"go-exe", "main.Point.Sum", "p_X=%ax:s64 p_Y=%bx:s64"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture+"/"+tt.function, func(t *testing.T) {
+			f, path := openELF(t, tt.fixture)
+			params, err := EntryParams(path, symbolValue(t, f, tt.function))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := partsString(params); got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+var (
+	tInt32   = &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "int"}}}
+	tInt64   = &dwarf.IntType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "long"}}}
+	tBool    = &dwarf.BoolType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 1, Name: "bool"}}}
+	tFloat64 = &dwarf.FloatType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 8, Name: "double"}}}
+	tFloat32 = &dwarf.FloatType{BasicType: dwarf.BasicType{CommonType: dwarf.CommonType{ByteSize: 4, Name: "float"}}}
+	tPtr     = &dwarf.PtrType{CommonType: dwarf.CommonType{ByteSize: 8}, Type: tInt64}
+	tString  = structType("string", field{"str", tPtr}, field{"len", tInt64})
+)
+
+type field struct {
+	name string
+	typ  dwarf.Type
+}
+
+// structType returns a struct of fields laid out at their natural
+// alignment.
+func structType(name string, fields ...field) *dwarf.StructType {
+	t := &dwarf.StructType{StructName: name, Kind: "struct"}
+	var off, align int64 = 0, 1
+	for _, f := range fields {
+		a := f.typ.Size()
+		if at, ok := f.typ.(*dwarf.ArrayType); ok {
+			a = at.Type.Size()
+		}
+		if a > align {
+			align = a
+		}
+		off = alignUp(off, a)
+		t.Field = append(t.Field, &dwarf.StructField{Name: f.name, Type: f.typ, ByteOffset: off})
+		off += f.typ.Size()
+	}
+	t.ByteSize = alignUp(off, align)
+	return t
+}
+
+func arrayType(elem dwarf.Type, n int64) *dwarf.ArrayType {
+	return &dwarf.ArrayType{CommonType: dwarf.CommonType{ByteSize: elem.Size() * n}, Type: elem, Count: n}
+}
+
+// params returns the layouts of parameters named a, b, c... of the
+// given types.
+func params(t *testing.T, types ...dwarf.Type) []paramLayout {
+	t.Helper()
+	var out []paramLayout
+	for i, typ := range types {
+		p := paramLayout{
+			EntryParam: EntryParam{Name: string(rune('a' + i))},
+			size:       typ.Size(),
+			ptrSize:    8,
+			regOK:      true,
+		}
+		if err := p.flatten(typ, "", 0); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// repeat returns n copies of typ followed by rest.
+func repeat(typ dwarf.Type, n int, rest ...dwarf.Type) []dwarf.Type {
+	var out []dwarf.Type
+	for i := 0; i < n; i++ {
+		out = append(out, typ)
+	}
+	return append(out, rest...)
+}
+
+func TestAssign(t *testing.T) {
+	pair := structType("pair", field{"x", tInt64}, field{"y", tInt64})
+	ints := structType("ints", field{"x", tInt32}, field{"y", tInt32}, field{"z", tInt64})
+	mixed := structType("mixed", field{"d", tFloat64}, field{"n", tInt64})
+	triple := structType("triple", field{"x", tInt64}, field{"y", tInt64}, field{"z", tInt64})
+	hfa2 := structType("hfa2", field{"x", tFloat32}, field{"y", tFloat32})
+	hfa3 := structType("hfa3", field{"x", tFloat64}, field{"y", tFloat64}, field{"z", tFloat64})
+	point := structType("Point", field{"X", tInt64}, field{"Y", tInt64})
+	arr := arrayType(tInt64, 2)
+
+	tests := []struct {
+		name    string
+		abi     *callABI
+		machine elf.Machine
+		types   []dwarf.Type
+		want    string
+	}{
+		// System V x86-64
+		{"sysv/mixed", &sysVAMD64, elf.EM_X86_64,
+			[]dwarf.Type{tInt64, tFloat64, tPtr, tInt32},
+			"a=%di:s64 b=! c=%si:x64 d=%dx:s32"},
+		{"sysv/struct16", &sysVAMD64, elf.EM_X86_64,
+			[]dwarf.Type{pair, tInt64},
+			"a_x=%di:s64 a_y=%si:s64 b=%dx:s64"},
+		{"sysv/shared eightbyte", &sysVAMD64, elf.EM_X86_64,
+			[]dwarf.Type{ints, tInt64},
+			"a_x=%di:b32@0/64 a_y=%di:b32@32/64 a_z=%si:s64 b=%dx:s64"},
+		{"sysv/float and int eightbytes", &sysVAMD64, elf.EM_X86_64,
+			[]dwarf.Type{mixed, tInt64, tFloat64, tInt64},
+			"a=! b=%si:s64 c=! d=%dx:s64"},
+		{"sysv/struct over 16 bytes", &sysVAMD64, elf.EM_X86_64,
+			[]dwarf.Type{triple, tInt64},
+			"a_x=+8(%sp):s64 a_y=+16(%sp):s64 a_z=+24(%sp):s64 b=%di:s64"},
+		{"sysv/exhausted", &sysVAMD64, elf.EM_X86_64,
+			repeat(tInt64, 8),
+			"a=%di:s64 b=%si:s64 c=%dx:s64 d=%cx:s64 e=%r8:s64 f=%r9:s64 g=+8(%sp):s64 h=+16(%sp):s64"},
+		{"sysv/struct spills, later int doesn't", &sysVAMD64, elf.EM_X86_64,
+			repeat(tInt64, 5, pair, tInt64),
+			"a=%di:s64 b=%si:s64 c=%dx:s64 d=%cx:s64 e=%r8:s64 f_x=+8(%sp):s64 f_y=+16(%sp):s64 g=%r9:s64"},
+
+		// AAPCS64
+		{"aapcs/mixed", &aapcs64, elf.EM_AARCH64,
+			[]dwarf.Type{tInt64, tFloat64, tPtr, tInt32},
+			"a=%x0:s64 b=! c=%x1:x64 d=%x2:s32"},
+		{"aapcs/struct16", &aapcs64, elf.EM_AARCH64,
+			[]dwarf.Type{pair, tInt64},
+			"a_x=%x0:s64 a_y=%x1:s64 b=%x2:s64"},
+		{"aapcs/by reference", &aapcs64, elf.EM_AARCH64,
+			[]dwarf.Type{triple, tInt64},
+			"a_x=+0(%x0):s64 a_y=+8(%x0):s64 a_z=+16(%x0):s64 b=%x1:s64"},
+		{"aapcs/hfa", &aapcs64, elf.EM_AARCH64,
+			[]dwarf.Type{hfa2, tInt64},
+			"a=! b=%x0:s64"},
+		{"aapcs/hfa over 16 bytes", &aapcs64, elf.EM_AARCH64,
+			[]dwarf.Type{hfa3, tInt64},
+			"a=! b=%x0:s64"},
+		{"aapcs/hfa spills", &aapcs64, elf.EM_AARCH64,
+			repeat(tFloat64, 7, hfa3, tFloat64, tInt64),
+			"a=! b=! c=! d=! e=! f=! g=! h_x=+0(%sp):x64 h_y=+8(%sp):x64 h_z=+16(%sp):x64 i=+24(%sp):x64 j=%x0:s64"},
+		{"aapcs/exhausted", &aapcs64, elf.EM_AARCH64,
+			repeat(tInt64, 9),
+			"a=%x0:s64 b=%x1:s64 c=%x2:s64 d=%x3:s64 e=%x4:s64 f=%x5:s64 g=%x6:s64 h=%x7:s64 i=+0(%sp):s64"},
+		{"aapcs/struct spills and so does later int", &aapcs64, elf.EM_AARCH64,
+			repeat(tInt64, 7, pair, tInt64),
+			"a=%x0:s64 b=%x1:s64 c=%x2:s64 d=%x3:s64 e=%x4:s64 f=%x5:s64 g=%x6:s64 h_x=+0(%sp):s64 h_y=+8(%sp):s64 i=+16(%sp):s64"},
+
+		// Go ABIInternal
+		{"go amd64/mixed", &goABIInternalAMD64, elf.EM_X86_64,
+			[]dwarf.Type{tInt64, tFloat64, tPtr, tString, tBool},
+			"a=%ax:s64 b=! c=%bx:x64 d_str=%cx:x64 d_len=%di:s64 e=%si:u8"},
+		{"go amd64/struct", &goABIInternalAMD64, elf.EM_X86_64,
+			[]dwarf.Type{point, tInt64},
+			"a_X=%ax:s64 a_Y=%bx:s64 b=%cx:s64"},
+		{"go amd64/array on stack", &goABIInternalAMD64, elf.EM_X86_64,
+			[]dwarf.Type{arr, tInt64},
+			"a_0=+8(%sp):s64 a_1=+16(%sp):s64 b=%ax:s64"},
+		{"go amd64/spill then registers", &goABIInternalAMD64, elf.EM_X86_64,
+			repeat(tInt64, 8, tString, tInt64),
+			"a=%ax:s64 b=%bx:s64 c=%cx:s64 d=%di:s64 e=%si:s64 f=%r8:s64 g=%r9:s64 h=%r10:s64 i_str=+8(%sp):x64 i_len=+16(%sp):s64 j=%r11:s64"},
+		{"go arm64/mixed", &goABIInternalARM64, elf.EM_AARCH64,
+			[]dwarf.Type{tInt64, tFloat64, tPtr, tString, tBool},
+			"a=%x0:s64 b=! c=%x1:x64 d_str=%x2:x64 d_len=%x3:s64 e=%x4:u8"},
+		{"go arm64/exhausted", &goABIInternalARM64, elf.EM_AARCH64,
+			repeat(tInt64, 17),
+			"a=%x0:s64 b=%x1:s64 c=%x2:s64 d=%x3:s64 e=%x4:s64 f=%x5:s64 g=%x6:s64 h=%x7:s64 " +
+				"i=%x8:s64 j=%x9:s64 k=%x10:s64 l=%x11:s64 m=%x12:s64 n=%x13:s64 o=%x14:s64 p=%x15:s64 q=+8(%sp):s64"},
+
+		// Go ABI0
+		{"go abi0", &goABI0, elf.EM_X86_64,
+			[]dwarf.Type{tInt64, tString, tBool, tInt32},
+			"a=+8(%sp):s64 b_str=+16(%sp):x64 b_len=+24(%sp):s64 c=+32(%sp):u8 d=+36(%sp):s32"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.abi.assign(params(t, tt.types...), dwarfRegs[tt.machine], entryCFA[tt.machine])
+			if len(got) != len(tt.types) {
+				t.Fatalf("got %d params, want %d", len(got), len(tt.types))
+			}
+			if s := partsString(got); s != tt.want {
+				t.Errorf("got  %s\nwant %s", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssignFloatReasons(t *testing.T) {
+	got := sysVAMD64.assign(params(t, tInt64, tFloat64, tFloat64), nil, 8)
+	for i, reg := range []string{"", "xmm0", "xmm1"} {
+		want := ""
+		if reg != "" {
+			want = fmt.Sprintf("%s is passed in floating point register %s, which uprobes can't read", got[i].Name, reg)
+		}
+		if got[i].Unavailable != want {
+			t.Errorf("%s: got %q, want %q", got[i].Name, got[i].Unavailable, want)
+		}
+	}
+}
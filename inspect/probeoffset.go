@@ -0,0 +1,270 @@
+package inspect
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/psanford/pptrace/internal/dwarfutil"
+	"github.com/psanford/pptrace/internal/vlog"
+	"github.com/spf13/cobra"
+)
+
+var probeFirst bool
+
+func probeOffsetCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "probe-offset <file> <function>",
+		Short: "Show the file offset a uprobe on a function would use",
+		Long:  "Show the symbol a function name resolves to and the file offset pptrace trace would place a uprobe at, for cross-checking against e.g. perf probe -x <file> -F or the uprobe_events of another tool.",
+		Run:   probeOffsetAction,
+
+		ValidArgsFunction: completeFileThen(CompleteFunctions),
+	}
+
+	cmd.Flags().BoolVarP(&probeFirst, "first", "", false, "If a Go function name matches several methods or instantiations, use the first")
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+
+	return &cmd
+}
+
+func probeOffsetAction(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		log.Fatalf("Usage: probe-offset <file> <function>")
+	}
+
+	probe, err := ResolveProbe(args[0], args[1], probeFirst)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if structuredOutput() {
+		printStructured(probe)
+		return
+	}
+
+	fmt.Printf("Function: %s\n", probe.Function)
+	fmt.Printf("Symbol: %s\n", probe.Symbol)
+	if probe.FromDWARF {
+		fmt.Println("Symbol source: debug info (the file has no symbol for it)")
+	}
+	fmt.Printf("Symbol value: 0x%x\n", probe.Value)
+	fmt.Printf("Segment: vaddr 0x%x offset 0x%x %s\n", probe.SegmentVaddr, probe.SegmentOffset, progFlags(probe.SegmentFlags))
+	fmt.Printf("Probe offset: 0x%x\n", probe.Offset)
+	fmt.Printf("Uprobe: %s:0x%x\n", probe.Binary, probe.Offset)
+	if !probe.Executable {
+		fmt.Println("warning: the symbol is in a non-executable segment; the probe may never be hit")
+	}
+}
+
+// ProbeOffset is where a uprobe on a function is placed.
+type ProbeOffset struct {
+	Binary   string `json:"binary"`
+	Function string `json:"function"`
+	// Machine is the file's architecture, which decides the
+	// registers fetch args can read.
+	Machine elf.Machine `json:"machine"`
+	// Class is the file's word size, which decides the size of
+	// fetch args that don't give one.
+	Class elf.Class `json:"class"`
+	// Symbol is the symbol Function resolved to, which differs for
+	// Go methods and generic functions named the way they're
+	// written in source.
+	Symbol string `json:"symbol"`
+	// FromDWARF is set if the file has no symbol for the function,
+	// as when it's stripped, and Value is the low_pc of its
+	// subprogram in the debug info instead, which like a symbol's
+	// value is translated to a file offset by its segment.
+	FromDWARF bool `json:"from_dwarf,omitempty"`
+	// Value is the symbol's virtual address.
+	Value uint64 `json:"value"`
+	// SegmentVaddr and SegmentOffset locate the PT_LOAD segment
+	// containing the symbol; Offset is Value translated by them.
+	SegmentVaddr  uint64       `json:"segment_vaddr"`
+	SegmentOffset uint64       `json:"segment_offset"`
+	SegmentFlags  elf.ProgFlag `json:"segment_flags"`
+	Offset        uint64       `json:"offset"`
+	Executable    bool         `json:"executable"`
+}
+
+// ResolveProbe finds the function symbol of the ELF file at path
+// that function names and the file offset a uprobe on it must use.
+// With first an ambiguous Go name resolves to the first match
+// instead of failing. If the file has no symbol for the function,
+// its address may come from the debug info instead.
+func ResolveProbe(path, function string, first bool) (*ProbeOffset, error) {
+	exe, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Open elf %s err: %s", path, err)
+	}
+
+	defer exe.Close()
+
+	symbols, err := ELFSymbols(exe)
+	stripped := errors.Is(err, ErrStripped)
+	if err != nil && !stripped {
+		return nil, fmt.Errorf("Get symbols err: %s", err)
+	}
+
+	matches, imported := findFunction(symbols, function)
+	if len(matches) == 0 && imported {
+		return nil, fmt.Errorf("function %s is imported by %s, not defined in it; trace the shared library that provides it", function, path)
+	}
+	var (
+		fromDWARF bool
+		dwarfErr  error
+	)
+	noSymtab := exe.Section(".symtab") == nil
+	if len(matches) == 0 {
+		// a function with no symbol, such as a static C function
+		// in a file stripped of its symbol table, may still be in
+		// the debug info
+		matches, dwarfErr = dwarfFunctions(path, function)
+		fromDWARF = len(matches) > 0
+		if fromDWARF {
+			vlog.Debugf("%s has no symbol in %s; found %d definitions in its debug info", function, path, len(matches))
+		}
+	}
+	if len(matches) > 1 && fromDWARF && !first {
+		var addrs strings.Builder
+		for _, m := range matches {
+			fmt.Fprintf(&addrs, "\n  0x%x", m.Value)
+		}
+		return nil, fmt.Errorf("function %s has no symbol in %s and its debug info defines it %d times, as with static functions of the same name in different files, at:%s\nuse --first to trace the first", function, path, len(matches), addrs.String())
+	}
+	if len(matches) == 0 {
+		if inlines, _ := InlinedOnly(path, function); inlines != nil {
+			return nil, inlinedAwayError(path, function, inlines)
+		}
+		if noSymtab && dwarfErr != nil {
+			return nil, fmt.Errorf("function %s not found in %s: it's stripped of its symbol table, and finding the function without one needs debug info: %s\n"+
+				"install its debug package, set DEBUGINFOD_URLS, or trace an unstripped build", function, path, dwarfErr)
+		}
+		if noSymtab {
+			return nil, fmt.Errorf("function %s not found in %s: it's stripped of its symbol table, and its debug info doesn't define %s either", function, path, function)
+		}
+		return nil, fmt.Errorf("function %s not found in %s", function, path)
+	}
+	if len(matches) > 1 && !first {
+		var names strings.Builder
+		for _, m := range matches {
+			fmt.Fprintf(&names, "\n  %s", m.Name)
+		}
+		return nil, fmt.Errorf("function %s matches several functions in %s:%s\nuse one of these names, or --first to trace the first", function, path, names.String())
+	}
+
+	sym := matches[0]
+
+	probe := ProbeOffset{
+		Binary:    path,
+		Function:  function,
+		Machine:   exe.Machine,
+		Class:     exe.Class,
+		Symbol:    sym.Name,
+		Value:     sym.Value,
+		FromDWARF: fromDWARF,
+	}
+
+	// the symbol's virtual address is translated to a file offset
+	// using the PT_LOAD segment that contains it. For executables
+	// the two differ by the link address; for shared objects they
+	// are often, but not always, equal.
+	for _, prog := range exe.Progs {
+		if prog.Type != elf.PT_LOAD || sym.Value < prog.Vaddr || sym.Value >= prog.Vaddr+prog.Memsz {
+			continue
+		}
+		probe.SegmentVaddr = prog.Vaddr
+		probe.SegmentOffset = prog.Off
+		probe.SegmentFlags = prog.Flags
+		probe.Offset = sym.Value - prog.Vaddr + prog.Off
+		probe.Executable = prog.Flags&elf.PF_X != 0
+		vlog.Debugf("%s: symbol %s at 0x%x in segment vaddr 0x%x offset 0x%x, probe offset 0x%x",
+			function, sym.Name, sym.Value, prog.Vaddr, prog.Off, probe.Offset)
+		return &probe, nil
+	}
+	return nil, fmt.Errorf("%s in %s: address 0x%x is not in any loadable segment", function, path, sym.Value)
+}
+
+// dwarfFunctions returns symbols for the out of line copies of
+// function in the debug info of the ELF file at path, with the
+// low_pc of each subprogram as the value.
+func dwarfFunctions(path, function string) ([]elf.Symbol, error) {
+	dwarfPath, err := dwarfutil.FindDwarfWithOptions(path, dwarfutil.FindOptions{
+		DebuginfodURLs: dwarfutil.DebuginfodURLsFromEnv(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := elf.Open(dwarfPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, err := dwarfutil.ELFData(f, dwarfPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var syms []elf.Symbol
+	seen := make(map[uint64]bool)
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil {
+			return syms, nil
+		}
+		if e.Tag != dwarf.TagSubprogram {
+			continue
+		}
+		low, high, ok := dwarfutil.PCRange(e)
+		if !ok || seen[low] {
+			continue
+		}
+		name, _ := originAttr(d, e, dwarf.AttrName).(string)
+		linkage, _ := originAttr(d, e, dwarf.AttrLinkageName).(string)
+		if name != function && linkage != function {
+			continue
+		}
+		seen[low] = true
+		syms = append(syms, elf.Symbol{
+			Name:  function,
+			Info:  elf.ST_INFO(elf.STB_LOCAL, elf.STT_FUNC),
+			Value: low,
+			Size:  high - low,
+		})
+	}
+}
+
+// maxInlineCallers is how many of the functions an inlined away
+// function was inlined into are listed in its error.
+const maxInlineCallers = 5
+
+// inlinedAwayError explains that function exists only as inlined
+// copies, so has no code of its own to probe.
+func inlinedAwayError(path, function string, inlines []Inline) error {
+	var callers []string
+	seen := make(map[string]bool)
+	for _, in := range inlines {
+		if in.Caller == "" || seen[in.Caller] {
+			continue
+		}
+		seen[in.Caller] = true
+		callers = append(callers, in.Caller)
+	}
+	list := strings.Join(callers, ", ")
+	if len(callers) > maxInlineCallers {
+		list = strings.Join(callers[:maxInlineCallers], ", ") + fmt.Sprintf(" and %d more", len(callers)-maxInlineCallers)
+	}
+	if list == "" {
+		list = "its callers"
+	}
+	return fmt.Errorf("function %s in %s was inlined away: it has no symbol because every call to it was inlined, into %s\n"+
+		"trace one of those callers instead, or rebuild with inlining disabled for it (-gcflags=-l for Go, -fno-inline or __attribute__((noinline)) for C)",
+		function, path, list)
+}
@@ -0,0 +1,144 @@
+package inspect
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestResolveProbe(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		function string
+		first    bool
+		// symbol is the symbol the function should resolve to, or
+		// a prefix of it ending in [ for generic functions
+		symbol string
+		err    string
+	}{
+		{fixture: "c-nopie", function: "add", symbol: "add"},
+		{fixture: "c-pie", function: "add", symbol: "add"},
+		{fixture: "c-pie", function: "scale", symbol: "scale"},
+		{fixture: "c-dwarf4", function: "main", symbol: "main"},
+		{fixture: "c-nodebug", function: "add", symbol: "add"},
+		{fixture: "c-pie", function: "printf", err: "imported"},
+		{fixture: "c-pie", function: "nosuchfunc", err: "not found"},
+		{fixture: "go-exe", function: "main.mixed", symbol: "main.mixed"},
+		{fixture: "go-pie", function: "main.mixed", symbol: "main.mixed"},
+		{fixture: "go-exe", function: "main.Point.Scale", symbol: "main.(*Point).Scale"},
+		{fixture: "go-exe", function: "main.Max", err: "matches several functions"},
+		{fixture: "go-exe", function: "main.Max", first: true, symbol: "main.Max["},
+		{fixture: "go-exe", function: "main.Max[int]", symbol: "main.Max[go.shape.int]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture+"/"+tt.function, func(t *testing.T) {
+			f, path := openELF(t, tt.fixture)
+			probe, err := ResolveProbe(path, tt.function, tt.first)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got err %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.HasSuffix(tt.symbol, "[") {
+				if !strings.HasPrefix(probe.Symbol, tt.symbol) {
+					t.Errorf("got symbol %s, want an instantiation %s...]", probe.Symbol, tt.symbol)
+				}
+			} else if probe.Symbol != tt.symbol {
+				t.Errorf("got symbol %s, want %s", probe.Symbol, tt.symbol)
+			}
+			if !probe.Executable {
+				t.Errorf("probe at 0x%x isn't in an executable segment", probe.Value)
+			}
+			if probe.Machine != f.Machine {
+				t.Errorf("got machine %s, want %s", probe.Machine, f.Machine)
+			}
+			if probe.Class != f.Class {
+				t.Errorf("got class %s, want %s", probe.Class, f.Class)
+			}
+
+			// the probe's file offset must hold the function's code
+			want := codeAt(t, f, probe.Value, 16)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := data[probe.Offset : probe.Offset+16]; !bytes.Equal(got, want) {
+				t.Errorf("offset 0x%x holds % x, want the code at 0x%x, % x", probe.Offset, got, probe.Value, want)
+			}
+		})
+	}
+}
+
+func TestResolveProbeFromDWARF(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		function string
+		// symbol is the symbol of the function in the fixture's
+		// separate debug file, whose value the probe should use
+		symbol string
+	}{
+		{"c-stripped", "add", "add"},
+		{"c-stripped", "scale", "scale"},
+		{"c-stripped", "main", "main"},
+		// GCC names the symbols of nested functions step.0 or the
+		// like, so only the debug info has step
+		{"c-nopie", "step", "step."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture+"/"+tt.function, func(t *testing.T) {
+			f, path := openELF(t, tt.fixture)
+			probe, err := ResolveProbe(path, tt.function, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !probe.FromDWARF {
+				t.Errorf("resolved to symbol %s, want the debug info", probe.Symbol)
+			}
+
+			syms := f
+			if debug, err := elf.Open(path + ".debug"); err == nil {
+				defer debug.Close()
+				syms = debug
+			}
+			var want uint64
+			all, err := syms.Symbols()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range all {
+				if s.Name == tt.symbol || strings.HasSuffix(tt.symbol, ".") && strings.HasPrefix(s.Name, tt.symbol) {
+					want = s.Value
+				}
+			}
+			if want == 0 || probe.Value != want {
+				t.Errorf("got value 0x%x, want the symbol's, 0x%x", probe.Value, want)
+			}
+
+			wantCode := codeAt(t, f, probe.Value, 16)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := data[probe.Offset : probe.Offset+16]; !bytes.Equal(got, wantCode) {
+				t.Errorf("offset 0x%x holds % x, want the code at 0x%x, % x", probe.Offset, got, probe.Value, wantCode)
+			}
+		})
+	}
+}
+
+func TestResolveProbeStrippedNotFound(t *testing.T) {
+	_, path := openELF(t, "c-stripped")
+	_, err := ResolveProbe(path, "nosuchfunc", false)
+	if err == nil || !strings.Contains(err.Error(), "stripped of its symbol table") {
+		t.Errorf("got err %v, want one saying the file is stripped", err)
+	}
+}
@@ -0,0 +1,144 @@
+package inspect
+
+import (
+	"bufio"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Mapping is a line of /proc/<pid>/maps.
+type Mapping struct {
+	Start  uint64 `json:"start"`
+	End    uint64 `json:"end"`
+	Perms  string `json:"perms"`
+	Offset uint64 `json:"offset"`
+	Path   string `json:"path,omitempty"`
+}
+
+// ProcessMaps returns the memory mappings of the running process
+// pid.
+func ProcessMaps(pid int) ([]Mapping, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []Mapping
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// address perms offset dev inode path
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		start, end, ok := strings.Cut(fields[0], "-")
+		if !ok {
+			continue
+		}
+		var m Mapping
+		if m.Start, err = strconv.ParseUint(start, 16, 64); err != nil {
+			continue
+		}
+		if m.End, err = strconv.ParseUint(end, 16, 64); err != nil {
+			continue
+		}
+		if m.Offset, err = strconv.ParseUint(fields[2], 16, 64); err != nil {
+			continue
+		}
+		m.Perms = fields[1]
+		if len(fields) > 5 {
+			m.Path = strings.Join(fields[5:], " ")
+		}
+		out = append(out, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// PIDArg returns the process id named by arg if arg is a number
+// and not an existing file.
+func PIDArg(arg string) (int, bool) {
+	pid, err := strconv.Atoi(arg)
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	if _, err := os.Stat(arg); err == nil {
+		return 0, false
+	}
+	return pid, true
+}
+
+// ProcessExecutable returns the path of pid's executable. If the
+// file has been deleted or replaced since the process started,
+// e.g. by a rebuild, the /proc/<pid>/exe link is returned instead
+// since it still refers to the running image.
+func ProcessExecutable(pid int) (string, error) {
+	link := fmt.Sprintf("/proc/%d/exe", pid)
+	path, err := os.Readlink(link)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(path, " (deleted)") {
+		return link, nil
+	}
+	return path, nil
+}
+
+// ProcessFileMappings returns pid's mappings of the file at path,
+// which may be a /proc/<pid>/exe link as returned by
+// ProcessExecutable.
+func ProcessFileMappings(pid int, path string) ([]Mapping, error) {
+	if path == fmt.Sprintf("/proc/%d/exe", pid) {
+		// maps shows the link's target
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		path = target
+	}
+
+	maps, err := ProcessMaps(pid)
+	if err != nil {
+		return nil, err
+	}
+	var out []Mapping
+	for _, m := range maps {
+		if m.Path == path {
+			out = append(out, m)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("%s is not mapped by process %d", path, pid)
+	}
+	return out, nil
+}
+
+// LoadBias returns the difference between the addresses f is
+// mapped at in a process, given the process's mappings of it, and
+// the virtual addresses in f's program headers. Runtime addresses
+// minus the bias are addresses in the file. It's zero for non-PIE
+// executables.
+func LoadBias(f *elf.File, maps []Mapping) (uint64, error) {
+	for _, m := range maps {
+		for _, p := range f.Progs {
+			if p.Type != elf.PT_LOAD || p.Filesz == 0 {
+				continue
+			}
+			// mappings start at the page containing the segment's
+			// file offset, which is congruent to its vaddr
+			if m.Offset > p.Off || p.Off >= m.Offset+(m.End-m.Start) {
+				continue
+			}
+			return m.Start + (p.Off - m.Offset) - p.Vaddr, nil
+		}
+	}
+	return 0, errors.New("no mapping of a loadable segment found")
+}
@@ -0,0 +1,71 @@
+package inspect
+
+import (
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	sortKey     string
+	sortReverse bool
+)
+
+func addSortFlags(cmd *cobra.Command, keys ...string) {
+	cmd.Flags().StringVarP(&sortKey, "sort", "", "", "Sort by "+strings.Join(keys, ", ")+" instead of the default order")
+	cmd.Flags().BoolVarP(&sortReverse, "reverse", "", false, "Reverse the order")
+}
+
+// sortKeys returns the fields of the i'th element of a list that
+// it can be sorted by. Unset fields aren't valid sort keys.
+type sortKeys struct {
+	name func(i int) string
+	addr func(i int) uint64
+	size func(i int) uint64
+}
+
+// sortList stably sorts slice by the --sort key and applies
+// --reverse, exiting if the key isn't one of keys.
+func sortList(slice interface{}, keys sortKeys) {
+	var less func(i, j int) bool
+	switch {
+	case sortKey == "":
+	case sortKey == "name" && keys.name != nil:
+		less = func(i, j int) bool { return keys.name(i) < keys.name(j) }
+	case sortKey == "addr" && keys.addr != nil:
+		less = func(i, j int) bool { return keys.addr(i) < keys.addr(j) }
+	case sortKey == "size" && keys.size != nil:
+		less = func(i, j int) bool { return keys.size(i) < keys.size(j) }
+	default:
+		var valid []string
+		for _, k := range []struct {
+			name string
+			ok   bool
+		}{{"name", keys.name != nil}, {"addr", keys.addr != nil}, {"size", keys.size != nil}} {
+			if k.ok {
+				valid = append(valid, k.name)
+			}
+		}
+		log.Fatalf("Unknown sort key %q; use %s", sortKey, strings.Join(valid, ", "))
+	}
+
+	if less != nil {
+		if sortReverse {
+			// swapping the comparison keeps ties in table order
+			asc := less
+			less = func(i, j int) bool { return asc(j, i) }
+		}
+		sort.SliceStable(slice, less)
+		return
+	}
+	if sortReverse {
+		swap := reflect.Swapper(slice)
+		n := reflect.ValueOf(slice).Len()
+		for i := 0; i < n/2; i++ {
+			swap(i, n-1-i)
+		}
+	}
+}
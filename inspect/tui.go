@@ -0,0 +1,604 @@
+package inspect
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/psanford/pptrace/internal/dwarfutil"
+	"github.com/spf13/cobra"
+)
+
+func tuiCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "tui <file>",
+		Short: "Browse a binary interactively",
+		Long:  "Browse the sections, symbols, functions and types of a binary in a terminal UI. Type to filter the current list, Enter to open an entry, Esc to go back and Tab to switch lists.",
+		Run:   tuiAction,
+	}
+
+	return &cmd
+}
+
+func tuiAction(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		log.Fatalf("Usage: tui <file>")
+	}
+
+	bin, err := OpenBinary(args[0])
+	if err != nil {
+		log.Fatalf("Open binary err: %s", err)
+	}
+	defer bin.Close()
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		log.Fatalf("Open terminal err: %s", err)
+	}
+	defer tty.Close()
+
+	ui := newTUI(bin, tty)
+	if err := ui.run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// tuiItem is a row of a list. open, if set, returns the list shown
+// when the row is selected.
+type tuiItem struct {
+	text string
+	open func() *tuiList
+}
+
+// tuiList is a filterable, scrollable list of rows. Detail views
+// are lists whose rows can't be opened.
+type tuiList struct {
+	title string
+	load  func() []tuiItem
+	items []tuiItem
+
+	filter string
+	// match holds the indexes of the items passing the filter
+	match  []int
+	cursor int
+	top    int
+}
+
+func (l *tuiList) ensureLoaded() {
+	if l.load != nil {
+		l.items = l.load()
+		l.load = nil
+		l.refilter()
+	}
+}
+
+func (l *tuiList) refilter() {
+	l.match = l.match[:0]
+	f := strings.ToLower(l.filter)
+	for i, it := range l.items {
+		if f == "" || strings.Contains(strings.ToLower(it.text), f) {
+			l.match = append(l.match, i)
+		}
+	}
+	l.cursor = 0
+	l.top = 0
+}
+
+func (l *tuiList) move(n int) {
+	l.cursor += n
+	if l.cursor >= len(l.match) {
+		l.cursor = len(l.match) - 1
+	}
+	if l.cursor < 0 {
+		l.cursor = 0
+	}
+}
+
+func (l *tuiList) selected() *tuiItem {
+	if l.cursor >= len(l.match) {
+		return nil
+	}
+	return &l.items[l.match[l.cursor]]
+}
+
+func detailList(title string, lines []string) *tuiList {
+	items := make([]tuiItem, len(lines))
+	for i, line := range lines {
+		items[i] = tuiItem{text: line}
+	}
+	l := tuiList{title: title, items: items}
+	l.refilter()
+	return &l
+}
+
+type tui struct {
+	bin Binary
+	tty *os.File
+	out *bufio.Writer
+
+	tabs  []*tuiList
+	tab   int
+	stack []*tuiList
+
+	// debug info is loaded on first use
+	dwarfLoaded bool
+	dwarfErr    error
+	funcByPC    map[uint64]FunctionArgs
+	funcByName  map[string]FunctionArgs
+	types       map[string]Type
+	typeNames   []string
+}
+
+func newTUI(bin Binary, tty *os.File) *tui {
+	ui := tui{
+		bin: bin,
+		tty: tty,
+		out: bufio.NewWriter(tty),
+	}
+	ui.tabs = []*tuiList{
+		{title: "Sections", load: ui.sectionItems},
+		{title: "Symbols", load: ui.symbolItems},
+		{title: "Functions", load: ui.functionItems},
+		{title: "Types", load: ui.typeItems},
+	}
+	return &ui
+}
+
+func (ui *tui) current() *tuiList {
+	if len(ui.stack) > 0 {
+		return ui.stack[len(ui.stack)-1]
+	}
+	return ui.tabs[ui.tab]
+}
+
+func (ui *tui) sectionItems() []tuiItem {
+	var items []tuiItem
+	for _, s := range ui.bin.Sections() {
+		items = append(items, tuiItem{
+			text: fmt.Sprintf("%016x %10d  %s", s.Addr, s.Size, s.Name),
+		})
+	}
+	return items
+}
+
+func (ui *tui) symbolItems() []tuiItem {
+	syms, err := ui.bin.Symbols()
+	if err != nil {
+		return []tuiItem{{text: fmt.Sprintf("read symbols err: %s", err)}}
+	}
+	var items []tuiItem
+	for _, s := range syms {
+		s := s
+		name := s.Name
+		if d := demangledName(s.Name); d != "" {
+			name = d
+		}
+		items = append(items, tuiItem{
+			text: fmt.Sprintf("%016x %8d  %s", s.Value, s.Size, name),
+			open: func() *tuiList {
+				lines := []string{
+					"name:      " + s.Name,
+					"demangled: " + demangledName(s.Name),
+					fmt.Sprintf("value:     0x%x", s.Value),
+					fmt.Sprintf("size:      %d", s.Size),
+					fmt.Sprintf("function:  %t", s.Func),
+				}
+				return detailList(s.Name, lines)
+			},
+		})
+	}
+	return items
+}
+
+func (ui *tui) functionItems() []tuiItem {
+	funcs, err := Functions(ui.bin, "", true)
+	if err != nil {
+		return []tuiItem{{text: fmt.Sprintf("read symbols err: %s", err)}}
+	}
+	var items []tuiItem
+	for _, f := range funcs {
+		f := f
+		name := f.Name
+		if f.Demangled != "" {
+			name = f.Demangled
+		}
+		items = append(items, tuiItem{
+			text: fmt.Sprintf("%016x %8d  %s", f.Value, f.Size, name),
+			open: func() *tuiList {
+				return detailList(f.Name, ui.functionDetail(f))
+			},
+		})
+	}
+	return items
+}
+
+func (ui *tui) functionDetail(f Function) []string {
+	lines := []string{
+		"name:  " + f.Name,
+		fmt.Sprintf("value: 0x%x", f.Value),
+		fmt.Sprintf("size:  %d", f.Size),
+		"",
+	}
+	if f.Demangled != "" {
+		lines = append([]string{"demangled: " + f.Demangled}, lines...)
+	}
+
+	if err := ui.loadDWARF(); err != nil {
+		return append(lines, fmt.Sprintf("no debug info: %s", err))
+	}
+
+	def, ok := ui.funcByPC[f.Value]
+	if !ok {
+		def, ok = ui.funcByName[f.Name]
+	}
+	if !ok {
+		return append(lines, "no DWARF entry for this function")
+	}
+	lines = append(lines, "defined at "+srcLocation(def.File, def.Line), "params:")
+	for _, p := range def.Params {
+		lines = append(lines, fmt.Sprintf("    %s %s", p.Name, p.Type))
+	}
+	if len(def.Returns) > 0 {
+		lines = append(lines, "returns: "+strings.Join(def.Returns, ", "))
+	}
+	return lines
+}
+
+func (ui *tui) typeItems() []tuiItem {
+	if err := ui.loadDWARF(); err != nil {
+		return []tuiItem{{text: fmt.Sprintf("no debug info: %s", err)}}
+	}
+	var items []tuiItem
+	for _, name := range ui.typeNames {
+		t := ui.types[name]
+		items = append(items, tuiItem{
+			text: fmt.Sprintf("%-8s %6d  %s", t.Kind, t.Size, t.Name),
+			open: func() *tuiList {
+				return detailList(t.Name, typeDetail(t))
+			},
+		})
+	}
+	return items
+}
+
+func typeDetail(t Type) []string {
+	lines := []string{fmt.Sprintf("%s %s (size %d, align %d)", t.Kind, t.Name, t.Size, t.Align), ""}
+	var add func(members []Member, indent string)
+	add = func(members []Member, indent string) {
+		for _, m := range members {
+			line := fmt.Sprintf("%s%4d %-24s %s", indent, m.Offset, m.Name, m.Type)
+			if m.Padding > 0 {
+				line += fmt.Sprintf("  <-- %d bytes padding", m.Padding)
+			}
+			lines = append(lines, line)
+			add(m.Members, indent+"    ")
+		}
+	}
+	add(t.Members, "")
+	for _, e := range t.Enumerators {
+		lines = append(lines, fmt.Sprintf("%-24s %d", e.Name, e.Value))
+	}
+	return lines
+}
+
+// loadDWARF reads the function signatures and composite types
+// from the binary's debug info, once.
+func (ui *tui) loadDWARF() error {
+	if ui.dwarfLoaded {
+		return ui.dwarfErr
+	}
+	ui.dwarfLoaded = true
+
+	var d *dwarf.Data
+	d, ui.dwarfErr = ui.bin.DWARF()
+	if ui.dwarfErr != nil {
+		return ui.dwarfErr
+	}
+
+	// both lookups below walk all of the debug info, so read it
+	// into a tree once for them to share
+	if _, err := dwarfutil.DataTree(d); err != nil {
+		return err
+	}
+
+	all := func(string) bool { return true }
+	ui.funcByPC = make(map[uint64]FunctionArgs)
+	ui.funcByName = make(map[string]FunctionArgs)
+	funcs, err := FuncArgs(d, all)
+	if err != nil {
+		return err
+	}
+	for _, f := range funcs {
+		// C++ and Rust symbols are mangled, so match definitions
+		// by address and fall back to the name for declarations
+		if f.LowPC != 0 {
+			ui.funcByPC[f.LowPC] = f
+		}
+		if _, ok := ui.funcByName[f.Function]; !ok {
+			ui.funcByName[f.Function] = f
+		}
+	}
+	ui.types = make(map[string]Type)
+	types, err := Types(d, TypeFilter{Match: all, CompositeOnly: true})
+	if err != nil {
+		return err
+	}
+	for _, t := range types {
+		if _, ok := ui.types[t.Name]; !ok {
+			ui.typeNames = append(ui.typeNames, t.Name)
+		}
+		ui.types[t.Name] = t
+	}
+	return nil
+}
+
+// run takes over the terminal until the user quits.
+func (ui *tui) run() error {
+	saved, err := ui.stty("-g")
+	if err != nil {
+		return fmt.Errorf("tui requires a terminal: %s", err)
+	}
+	if _, err := ui.stty("raw", "-echo"); err != nil {
+		return err
+	}
+	// alternate screen, hidden cursor
+	fmt.Fprint(ui.tty, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(ui.tty, "\x1b[?25h\x1b[?1049l")
+		ui.stty(strings.TrimSpace(saved))
+	}()
+
+	buf := make([]byte, 64)
+	for {
+		l := ui.current()
+		l.ensureLoaded()
+		ui.render()
+
+		n, err := ui.tty.Read(buf)
+		if err != nil {
+			return err
+		}
+		for _, k := range parseKeys(buf[:n]) {
+			if !ui.handle(k) {
+				return nil
+			}
+		}
+	}
+}
+
+// tuiKey is a key press: either a printable rune or one of the
+// named keys below.
+type tuiKey struct {
+	name string
+	r    rune
+}
+
+// parseKeys splits terminal input into key presses, decoding the
+// escape sequences for the keys the UI uses.
+func parseKeys(b []byte) []tuiKey {
+	seqs := map[string]string{
+		"\x1b[A": "up", "\x1b[B": "down",
+		"\x1bOA": "up", "\x1bOB": "down",
+		"\x1b[5~": "pgup", "\x1b[6~": "pgdn",
+		"\x1b[H": "home", "\x1b[F": "end",
+		"\x1b[1~": "home", "\x1b[4~": "end",
+		"\x1b[Z": "backtab",
+	}
+
+	var keys []tuiKey
+	for len(b) > 0 {
+		if b[0] == 0x1b {
+			matched := false
+			for seq, name := range seqs {
+				if strings.HasPrefix(string(b), seq) {
+					keys = append(keys, tuiKey{name: name})
+					b = b[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if matched {
+				continue
+			}
+			if len(b) > 1 && b[1] == '[' {
+				// an unknown sequence; drop it
+				i := 2
+				for i < len(b) && (b[i] < 0x40 || b[i] > 0x7e) {
+					i++
+				}
+				b = b[min(i+1, len(b)):]
+				continue
+			}
+			keys = append(keys, tuiKey{name: "esc"})
+			b = b[1:]
+			continue
+		}
+
+		switch b[0] {
+		case 0x03:
+			keys = append(keys, tuiKey{name: "quit"})
+		case '\t':
+			keys = append(keys, tuiKey{name: "tab"})
+		case '\r', '\n':
+			keys = append(keys, tuiKey{name: "enter"})
+		case 0x7f, 0x08:
+			keys = append(keys, tuiKey{name: "backspace"})
+		case 0x15:
+			keys = append(keys, tuiKey{name: "clear"})
+		default:
+			r, size := utf8.DecodeRune(b)
+			if r >= 0x20 && r != utf8.RuneError {
+				keys = append(keys, tuiKey{r: r})
+			}
+			b = b[size:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// handle applies a key press, returning false to quit.
+func (ui *tui) handle(k tuiKey) bool {
+	l := ui.current()
+	rows, _ := ui.size()
+	page := rows - 4
+
+	switch k.name {
+	case "quit":
+		return false
+	case "up":
+		l.move(-1)
+	case "down":
+		l.move(1)
+	case "pgup":
+		l.move(-page)
+	case "pgdn":
+		l.move(page)
+	case "home":
+		l.move(-len(l.match))
+	case "end":
+		l.move(len(l.match))
+	case "tab", "backtab":
+		if len(ui.stack) == 0 {
+			d := 1
+			if k.name == "backtab" {
+				d = len(ui.tabs) - 1
+			}
+			ui.tab = (ui.tab + d) % len(ui.tabs)
+		}
+	case "enter":
+		if it := l.selected(); it != nil && it.open != nil {
+			ui.stack = append(ui.stack, it.open())
+		}
+	case "esc":
+		switch {
+		case l.filter != "":
+			l.filter = ""
+			l.refilter()
+		case len(ui.stack) > 0:
+			ui.stack = ui.stack[:len(ui.stack)-1]
+		default:
+			return false
+		}
+	case "backspace":
+		if l.filter != "" {
+			_, size := utf8.DecodeLastRuneInString(l.filter)
+			l.filter = l.filter[:len(l.filter)-size]
+			l.refilter()
+		}
+	case "clear":
+		l.filter = ""
+		l.refilter()
+	case "":
+		l.filter += string(k.r)
+		l.refilter()
+	}
+	return true
+}
+
+func (ui *tui) render() {
+	rows, cols := ui.size()
+	l := ui.current()
+	w := ui.out
+
+	line := func(s string, reverse bool) {
+		if utf8.RuneCountInString(s) > cols {
+			s = string([]rune(s)[:cols])
+		}
+		if reverse {
+			fmt.Fprintf(w, "\x1b[7m%s\x1b[0m\x1b[K\r\n", s)
+		} else {
+			fmt.Fprintf(w, "%s\x1b[K\r\n", s)
+		}
+	}
+
+	fmt.Fprint(w, "\x1b[H")
+
+	// tab bar, or the path to the current detail view
+	var bar strings.Builder
+	if len(ui.stack) == 0 {
+		for i, t := range ui.tabs {
+			if i == ui.tab {
+				fmt.Fprintf(&bar, "\x1b[7m %s \x1b[0m ", t.title)
+			} else {
+				fmt.Fprintf(&bar, " %s  ", t.title)
+			}
+		}
+		fmt.Fprintf(w, "%s\x1b[K\r\n", bar.String())
+	} else {
+		titles := []string{ui.tabs[ui.tab].title}
+		for _, s := range ui.stack {
+			titles = append(titles, s.title)
+		}
+		line(" "+strings.Join(titles, " > "), true)
+	}
+
+	line(fmt.Sprintf("filter: %s_   (%d/%d)", l.filter, len(l.match), len(l.items)), false)
+
+	height := rows - 3
+	if height < 1 {
+		height = 1
+	}
+	if l.cursor < l.top {
+		l.top = l.cursor
+	}
+	if l.cursor >= l.top+height {
+		l.top = l.cursor - height + 1
+	}
+	for i := 0; i < height; i++ {
+		idx := l.top + i
+		if idx >= len(l.match) {
+			line("", false)
+			continue
+		}
+		line(l.items[l.match[idx]].text, idx == l.cursor)
+	}
+
+	help := "up/down move  tab switch list  enter open  esc back/quit  type to filter  ctrl-c quit"
+	if utf8.RuneCountInString(help) > cols {
+		help = string([]rune(help)[:cols])
+	}
+	fmt.Fprintf(w, "\x1b[2m%s\x1b[0m\x1b[K", help)
+	w.Flush()
+}
+
+// size returns the terminal's rows and columns, falling back to
+// 24x80 if they can't be read.
+func (ui *tui) size() (rows, cols int) {
+	out, err := ui.stty("size")
+	if err == nil {
+		fields := strings.Fields(out)
+		if len(fields) == 2 {
+			rows, _ = strconv.Atoi(fields[0])
+			cols, _ = strconv.Atoi(fields[1])
+		}
+	}
+	if rows <= 0 || cols <= 0 {
+		return 24, 80
+	}
+	return rows, cols
+}
+
+// stty runs stty against the terminal. There's no raw mode
+// support in the standard library, so this avoids a dependency on
+// a terminal package.
+func (ui *tui) stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = ui.tty
+	out, err := cmd.Output()
+	return string(out), err
+}
@@ -0,0 +1,139 @@
+package inspect
+
+import (
+	"debug/dwarf"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func unitsCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "units <file> [filter]",
+		Short: "List DWARF compilation units",
+		Run:   unitsAction,
+	}
+
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+	cmd.Flags().BoolVarP(&noCRCCheck, "no-crc-check", "", false, "Don't verify debuglink CRC of separate debug files")
+	cmd.Flags().StringVarP(&debugFile, "debug-file", "", "", "Read DWARF from this file instead of searching for debug info")
+
+	return &cmd
+}
+
+func unitsAction(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		log.Fatalf("Usage: units <file> [filter]")
+	}
+
+	var filterString string
+	if len(args) > 1 {
+		filterString = args[1]
+	}
+
+	bin, dwarfInfo := openDwarf(args[0])
+	defer bin.Close()
+
+	units, err := CompileUnits(dwarfInfo, filterString)
+	if err != nil {
+		log.Fatalf("read compile units err: %s", err)
+	}
+
+	if structuredOutput() {
+		printStructured(units)
+		return
+	}
+
+	for _, u := range units {
+		fmt.Printf("%016x %016x %-10s %s\n", u.LowPC, u.HighPC, u.Language, u.Name)
+		if u.Producer != "" {
+			fmt.Printf("\t%s\n", u.Producer)
+		}
+	}
+}
+
+// CompileUnit describes a DWARF compilation unit.
+type CompileUnit struct {
+	Name     string `json:"name"`
+	Producer string `json:"producer"`
+	Language string `json:"language"`
+	LowPC    uint64 `json:"lowpc"`
+	HighPC   uint64 `json:"highpc"`
+}
+
+// CompileUnits returns the compilation units in d whose name
+// contains filter.
+func CompileUnits(d *dwarf.Data, filter string) ([]CompileUnit, error) {
+	units := make([]CompileUnit, 0)
+
+	r := d.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			break
+		}
+
+		if entry.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+
+		name, _ := entry.Val(dwarf.AttrName).(string)
+		if filter != "" && !strings.Contains(name, filter) {
+			r.SkipChildren()
+			continue
+		}
+
+		u := CompileUnit{
+			Name: name,
+		}
+		u.Producer, _ = entry.Val(dwarf.AttrProducer).(string)
+		if lang, ok := entry.Val(dwarf.AttrLanguage).(int64); ok {
+			u.Language = languageName(lang)
+		}
+
+		ranges, err := d.Ranges(entry)
+		if err == nil {
+			for i, rng := range ranges {
+				if i == 0 || rng[0] < u.LowPC {
+					u.LowPC = rng[0]
+				}
+				if rng[1] > u.HighPC {
+					u.HighPC = rng[1]
+				}
+			}
+		}
+
+		units = append(units, u)
+
+		r.SkipChildren()
+	}
+
+	return units, nil
+}
+
+var languageNames = map[int64]string{
+	0x01:   "C89",
+	0x02:   "C",
+	0x04:   "C++",
+	0x0c:   "C99",
+	0x16:   "Go",
+	0x1a:   "C++11",
+	0x1c:   "Rust",
+	0x1d:   "C11",
+	0x21:   "C++14",
+	0x2b:   "C17",
+	0x8001: "Mips_Assembler",
+}
+
+func languageName(lang int64) string {
+	if name, ok := languageNames[lang]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", lang)
+}
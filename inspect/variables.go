@@ -0,0 +1,60 @@
+package inspect
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+func variablesCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "variables <file> [<variable-name>|-all]",
+		Short: "Show global variables",
+		Run:   variablesAction,
+	}
+
+	cmd.Flags().BoolVarP(&allFlag, "all", "", false, "Show all variables")
+	cmd.Flags().BoolVarP(&exactMatch, "exact", "e", false, "Match on exact name")
+	cmd.Flags().BoolVarP(&noCRCCheck, "no-crc-check", "", false, "Don't verify debuglink CRC of separate debug files")
+	cmd.Flags().StringVarP(&debugFile, "debug-file", "", "", "Read DWARF from this file instead of searching for debug info")
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+
+	return &cmd
+}
+
+func variablesAction(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		log.Fatalf("Usage: variables <file> [<variable-name>|-all]")
+	}
+
+	if len(args) < 2 && !allFlag {
+		log.Fatalf("Usage: variables <file> [<variable-name>|-all]")
+	}
+
+	var matchName string
+	if !allFlag {
+		matchName = args[1]
+	}
+
+	bin, dwarfInfo := openDwarf(args[0])
+	defer bin.Close()
+
+	vars, err := Variables(dwarfInfo, nameMatcher(matchName))
+	if err != nil {
+		log.Fatalf("Read DWARF err: %s", err)
+	}
+
+	if structuredOutput() {
+		printStructured(vars)
+		return
+	}
+
+	for _, v := range vars {
+		addr := "                "
+		if v.Address != nil {
+			addr = fmt.Sprintf("%016x", *v.Address)
+		}
+		fmt.Printf("%s %s %s\n", addr, v.Name, v.Type)
+	}
+}
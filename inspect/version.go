@@ -0,0 +1,100 @@
+package inspect
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/json"
+	"fmt"
+)
+
+// ntFDOPackage is the type of a .note.package note, which holds
+// JSON describing the distro package a file was built for. See
+// https://systemd.io/ELF_PACKAGE_METADATA/.
+const ntFDOPackage = 0xcafe1a7e
+
+// VersionInfo is what a binary records about its version and how
+// it was built, outside of Go build info.
+type VersionInfo struct {
+	// Comment is the .comment section's strings, usually the
+	// versions of the compilers and linker used.
+	Comment []string `json:"comment,omitempty"`
+	// SONAME is the DT_SONAME of a shared object.
+	SONAME string `json:"soname,omitempty"`
+	// Package is the JSON of a .note.package note.
+	Package json.RawMessage `json:"package,omitempty"`
+}
+
+func (v *VersionInfo) empty() bool {
+	return len(v.Comment) == 0 && v.SONAME == "" && len(v.Package) == 0
+}
+
+// ELFVersionInfo reads the version markers of f. It returns nil if
+// f has none.
+func ELFVersionInfo(f *elf.File) (*VersionInfo, error) {
+	var v VersionInfo
+
+	if s := f.Section(".comment"); s != nil && s.Type != elf.SHT_NOBITS {
+		data, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("read .comment err: %w", err)
+		}
+		// each object linked in adds its compiler's string, so
+		// they repeat
+		seen := make(map[string]bool)
+		for _, c := range bytes.Split(data, []byte{0}) {
+			c = bytes.TrimSpace(c)
+			if len(c) > 0 && !seen[string(c)] {
+				seen[string(c)] = true
+				v.Comment = append(v.Comment, string(c))
+			}
+		}
+	}
+
+	if names, err := f.DynString(elf.DT_SONAME); err == nil && len(names) > 0 {
+		v.SONAME = names[0]
+	}
+
+	if s := f.Section(".note.package"); s != nil && s.Type == elf.SHT_NOTE {
+		data, err := s.Data()
+		if err != nil {
+			return nil, fmt.Errorf("read .note.package err: %w", err)
+		}
+		desc := findNote(f, data, "FDO", ntFDOPackage)
+		desc = bytes.TrimRight(desc, "\x00")
+		if len(desc) > 0 {
+			v.Package = json.RawMessage(desc)
+			if !json.Valid(desc) {
+				// still show it, as a string
+				v.Package, _ = json.Marshal(string(desc))
+			}
+		}
+	}
+
+	if v.empty() {
+		return nil, nil
+	}
+	return &v, nil
+}
+
+// findNote returns the descriptor of the first note in data with
+// the given owner name and type.
+func findNote(f *elf.File, data []byte, name string, typ uint32) []byte {
+	bo := f.ByteOrder
+	align := func(n uint32) uint64 { return (uint64(n) + 3) &^ 3 }
+	for len(data) >= 12 {
+		namesz := bo.Uint32(data)
+		descsz := bo.Uint32(data[4:])
+		ntype := bo.Uint32(data[8:])
+		data = data[12:]
+		if align(namesz)+align(descsz) > uint64(len(data)) {
+			return nil
+		}
+		n := string(bytes.TrimRight(data[:namesz], "\x00"))
+		desc := data[align(namesz) : align(namesz)+uint64(descsz)]
+		data = data[align(namesz)+align(descsz):]
+		if n == name && ntype == typ {
+			return desc
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package inspect
+
+import (
+	"debug/elf"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func whichCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "which <file|pid> <symbol>",
+		Short: "Find the shared library that defines a symbol",
+		Long:  "Find the shared library that defines a symbol. Candidates are the file and its DT_NEEDED libraries, or the files mapped by a running process.",
+		Run:   whichAction,
+	}
+
+	return &cmd
+}
+
+func whichAction(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		log.Fatalf("Usage: which <file|pid> <symbol>")
+	}
+
+	var (
+		candidates []string
+		err        error
+	)
+	if pid, perr := strconv.Atoi(args[0]); perr == nil {
+		candidates, err = ProcessLibraries(pid)
+	} else {
+		candidates, err = NeededLibraries(args[0])
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	path, err := FindDefinition(candidates, args[1], false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(path)
+}
+
+// FindDefinition returns the first of candidates whose symbol
+// tables define symbol. If funcOnly is set only function symbols
+// are considered.
+func FindDefinition(candidates []string, symbol string, funcOnly bool) (string, error) {
+	for _, path := range candidates {
+		f, err := elf.Open(path)
+		if err != nil {
+			continue
+		}
+		syms, _ := ELFSymbols(f)
+		f.Close()
+
+		for _, sym := range syms {
+			if sym.Name != symbol || sym.Section == elf.SHN_UNDEF || sym.Value == 0 {
+				continue
+			}
+			if funcOnly && elf.ST_TYPE(sym.Info) != elf.STT_FUNC {
+				continue
+			}
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s is not defined in any of %s", symbol, strings.Join(candidates, " "))
+}
+
+// NeededLibraries returns path followed by the shared libraries
+// it depends on, directly or indirectly, in the breadth first
+// order the dynamic loader searches them. Libraries that can't be
+// located are skipped.
+func NeededLibraries(path string) ([]string, error) {
+	out := []string{path}
+	seen := map[string]bool{path: true}
+
+	for i := 0; i < len(out); i++ {
+		f, err := elf.Open(out[i])
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			continue
+		}
+		deps, err := Dependencies(f)
+		f.Close()
+		if err != nil {
+			continue
+		}
+
+		dirs := libSearchDirs(out[i], deps)
+		for _, lib := range deps.Needed {
+			p := findLibrary(lib, dirs)
+			if p == "" || seen[p] {
+				continue
+			}
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+
+	return out, nil
+}
+
+// defaultLibDirs are searched after any rpath, LD_LIBRARY_PATH
+// and runpath entries.
+var defaultLibDirs = []string{
+	"/lib", "/usr/lib", "/lib64", "/usr/lib64",
+}
+
+// libSearchDirs returns the directories the dynamic loader
+// searches for the DT_NEEDED entries of the file at path.
+func libSearchDirs(path string, deps *Deps) []string {
+	origin := filepath.Dir(path)
+	expand := func(paths []string) []string {
+		out := make([]string, 0, len(paths))
+		for _, p := range paths {
+			p = strings.ReplaceAll(p, "${ORIGIN}", origin)
+			p = strings.ReplaceAll(p, "$ORIGIN", origin)
+			out = append(out, p)
+		}
+		return out
+	}
+
+	var dirs []string
+	// DT_RPATH is ignored when DT_RUNPATH is present
+	if len(deps.RunPath) == 0 {
+		dirs = append(dirs, expand(deps.RPath)...)
+	}
+	if env := os.Getenv("LD_LIBRARY_PATH"); env != "" {
+		dirs = append(dirs, strings.Split(env, ":")...)
+	}
+	dirs = append(dirs, expand(deps.RunPath)...)
+
+	// multiarch directories, e.g. /usr/lib/x86_64-linux-gnu
+	multiarch, _ := filepath.Glob("/usr/lib/*-linux-gnu*")
+	for _, d := range multiarch {
+		dirs = append(dirs, "/lib/"+filepath.Base(d), d)
+	}
+	return append(dirs, defaultLibDirs...)
+}
+
+func findLibrary(name string, dirs []string) string {
+	if strings.Contains(name, "/") {
+		return name
+	}
+	for _, dir := range dirs {
+		p := filepath.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// ProcessLibraries returns the executable and shared libraries
+// mapped by the running process pid.
+func ProcessLibraries(pid int) ([]string, error) {
+	maps, err := ProcessMaps(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	seen := make(map[string]bool)
+	for _, m := range maps {
+		if !strings.HasPrefix(m.Path, "/") || seen[m.Path] {
+			continue
+		}
+		seen[m.Path] = true
+		out = append(out, m.Path)
+	}
+
+	return out, nil
+}
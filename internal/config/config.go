@@ -0,0 +1,146 @@
+// Package config loads pptrace's config file, which sets defaults
+// for command line flags and defines named trace presets.
+//
+// The file is YAML. Top level keys are flag names without the
+// leading dashes and apply to every command with that flag, except
+// for presets, a mapping of preset names to targets:
+//
+//	instance: mytrace
+//	buffer-size-kb: 8192
+//	demangle: true
+//	debug-dir: [/opt/debug, ~/debug]
+//	presets:
+//	  opens: ./prog do_open path=+0(%di):string flags=%si:u32
+//	  io:
+//	    - ./prog do_read fd=%di:s32
+//	    - ./prog do_write fd=%di:s32
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/psanford/pptrace/internal/yaml"
+)
+
+// File is a loaded config file.
+type File struct {
+	// Path is where the file was read from, or empty if there
+	// was none.
+	Path string
+	// Flags maps flag names to their values. Repeatable flags
+	// may have several.
+	Flags map[string][]string
+	// Presets maps preset names to targets, each in the form
+	// "binary function [arg_expression...]".
+	Presets map[string][]string
+}
+
+// Current is the config file in use, empty until one is loaded.
+var Current = &File{}
+
+// DefaultPath returns the config file used when none is given,
+// pptrace/config.yaml in the user's config directory (usually
+// ~/.config).
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pptrace", "config.yaml"), nil
+}
+
+// Load reads the config file at path. If path is empty the default
+// path is used, and it's not an error for that not to exist.
+func Load(path string) (*File, error) {
+	optional := path == ""
+	if optional {
+		p, err := DefaultPath()
+		if err != nil {
+			return &File{}, nil
+		}
+		path = p
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && optional {
+		return &File{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	v, err := yaml.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	doc, ok := v.(map[string]interface{})
+	if !ok && v != nil {
+		return nil, fmt.Errorf("%s: expected a mapping of settings", path)
+	}
+
+	f := File{
+		Path:    path,
+		Flags:   make(map[string][]string),
+		Presets: make(map[string][]string),
+	}
+	for key, val := range doc {
+		if key == "presets" {
+			presets, ok := val.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: presets must be a mapping of names to targets", path)
+			}
+			for name, targets := range presets {
+				list, err := stringList(targets)
+				if err != nil {
+					return nil, fmt.Errorf("%s: preset %s: %w", path, name, err)
+				}
+				f.Presets[name] = list
+			}
+			continue
+		}
+
+		list, err := stringList(val)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, key, err)
+		}
+		f.Flags[key] = list
+	}
+	return &f, nil
+}
+
+// PresetNames returns the names of the presets in f, sorted.
+func (f *File) PresetNames() []string {
+	names := make([]string, 0, len(f.Presets))
+	for name := range f.Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stringList returns the scalar or sequence of scalars v as a list.
+// Nulls are empty strings.
+func stringList(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case nil:
+		return []string{""}, nil
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			switch item := item.(type) {
+			case string:
+				list = append(list, item)
+			case nil:
+				list = append(list, "")
+			default:
+				return nil, fmt.Errorf("expected a value or a list of values")
+			}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("expected a value or a list of values")
+}
@@ -0,0 +1,1107 @@
+// Package demangle converts mangled C++ (Itanium ABI) and Rust
+// (legacy and v0) symbol names into their source form.
+//
+// It covers the constructs compilers commonly emit for functions
+// and data: nested names, templates, substitutions, operators,
+// constructors and destructors, and the usual type qualifiers.
+// Names it can't parse are reported as errors rather than
+// guessed at.
+package demangle
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNotMangled is returned for names that don't use a
+// recognized mangling scheme.
+var ErrNotMangled = errors.New("not a mangled name")
+
+// ToString demangles name.
+func ToString(name string) (string, error) {
+	switch {
+	case strings.HasPrefix(name, "_R"):
+		return rustV0(name[2:])
+	case strings.HasPrefix(name, "_Z"):
+		if s, ok := rustLegacy(name); ok {
+			return s, nil
+		}
+		return itanium(name)
+	}
+	return "", ErrNotMangled
+}
+
+// Filter returns the demangled form of name, or name itself
+// if it can't be demangled.
+func Filter(name string) string {
+	s, err := ToString(name)
+	if err != nil {
+		return name
+	}
+	return s
+}
+
+type parseError struct {
+	pos int
+	msg string
+}
+
+func (e *parseError) Error() string {
+	return fmt.Sprintf("demangle: %s at offset %d", e.msg, e.pos)
+}
+
+// itanium demangles a name in the Itanium C++ ABI scheme.
+func itanium(name string) (s string, err error) {
+	st := &state{str: name, pos: 2, packIdx: -1}
+
+	defer func() {
+		if r := recover(); r != nil {
+			pe, ok := r.(*parseError)
+			if !ok {
+				panic(r)
+			}
+			err = pe
+		}
+	}()
+
+	enc := st.encoding()
+
+	// clone suffixes like .cold or .constprop.0
+	var suffix string
+	if st.pos < len(st.str) {
+		if st.str[st.pos] != '.' {
+			st.fail("trailing characters")
+		}
+		suffix = " [clone " + st.str[st.pos:] + "]"
+	}
+
+	return enc + suffix, nil
+}
+
+type state struct {
+	str  string
+	pos  int
+	subs []node
+	// template args of the most recent top level template
+	targs []node
+	// packIdx is the element of a template argument pack being
+	// expanded, or -1
+	packIdx int
+	// sawPack is the last argument pack referenced
+	sawPack *packNode
+}
+
+func (st *state) fail(msg string) {
+	panic(&parseError{pos: st.pos, msg: msg})
+}
+
+func (st *state) peek() byte {
+	if st.pos >= len(st.str) {
+		return 0
+	}
+	return st.str[st.pos]
+}
+
+func (st *state) next() byte {
+	c := st.peek()
+	if c == 0 {
+		st.fail("unexpected end of name")
+	}
+	st.pos++
+	return c
+}
+
+func (st *state) consume(prefix string) bool {
+	if strings.HasPrefix(st.str[st.pos:], prefix) {
+		st.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+func (st *state) expect(c byte) {
+	if st.next() != c {
+		st.pos--
+		st.fail(fmt.Sprintf("expected %q", c))
+	}
+}
+
+// maxNumber bounds the numbers in a name, far beyond any real
+// length or index, so they can't overflow.
+const maxNumber = 1 << 30
+
+func (st *state) number() int {
+	neg := st.consume("n")
+	start := st.pos
+	n := 0
+	for isDigit(st.peek()) {
+		n = n*10 + int(st.next()-'0')
+		if n > maxNumber {
+			st.fail("number too large")
+		}
+	}
+	if start == st.pos {
+		st.fail("expected number")
+	}
+	if neg {
+		return -n
+	}
+	return n
+}
+
+// seqID parses a base 36 substitution or template param index
+// terminated by '_'. A bare '_' is index 0.
+func (st *state) seqID() int {
+	if st.consume("_") {
+		return 0
+	}
+	n := 0
+	for {
+		c := st.next()
+		switch {
+		case c == '_':
+			return n + 1
+		case isDigit(c):
+			n = n*36 + int(c-'0')
+		case c >= 'A' && c <= 'Z':
+			n = n*36 + int(c-'A') + 10
+		default:
+			st.pos--
+			st.fail("bad sequence id")
+		}
+		if n > maxNumber {
+			st.fail("sequence id too large")
+		}
+	}
+}
+
+// baseName returns the last component of a qualified name,
+// without any template args. It's the name constructors and
+// destructors of that class are printed with.
+func baseName(s string) string {
+	if i := strings.Index(s, "[abi:"); i > 0 {
+		s = s[:i]
+	}
+	if strings.HasSuffix(s, ">") {
+		depth := 0
+		for i := len(s) - 1; i >= 0; i-- {
+			switch s[i] {
+			case '>':
+				depth++
+			case '<':
+				depth--
+			}
+			if depth == 0 {
+				s = s[:i]
+				break
+			}
+		}
+	}
+	if i := strings.LastIndex(s, "::"); i >= 0 {
+		s = s[i+2:]
+	}
+	return s
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// node is a parsed name or type.
+type node interface {
+	// decl prints the node with inner as the declarator, which
+	// lets pointers to functions and arrays come out in C syntax.
+	decl(inner string) string
+}
+
+func str(n node) string {
+	return n.decl("")
+}
+
+type nameNode struct {
+	s string
+}
+
+func (n *nameNode) decl(inner string) string {
+	return n.s + inner
+}
+
+type qualNode struct {
+	base  node
+	quals string
+}
+
+func (n *qualNode) decl(inner string) string {
+	return n.base.decl(n.quals + inner)
+}
+
+type ptrNode struct {
+	elem node
+	op   string
+}
+
+func (n *ptrNode) decl(inner string) string {
+	switch n.elem.(type) {
+	case *funcNode, *arrayNode:
+		return n.elem.decl("(" + n.op + inner + ")")
+	}
+	return n.elem.decl(n.op + inner)
+}
+
+type funcNode struct {
+	ret    node
+	params []node
+	quals  string
+}
+
+func (n *funcNode) decl(inner string) string {
+	s := "(" + paramList(n.params) + ")" + n.quals
+	if inner != "" {
+		s = inner + s
+	}
+	if n.ret == nil {
+		return s
+	}
+	return n.ret.decl(" " + s)
+}
+
+type arrayNode struct {
+	elem node
+	dim  string
+}
+
+func (n *arrayNode) decl(inner string) string {
+	// multidimensional arrays print all their bounds together
+	var (
+		dims string
+		elem node = n
+	)
+	for {
+		a, ok := elem.(*arrayNode)
+		if !ok {
+			break
+		}
+		dims += "[" + a.dim + "]"
+		elem = a.elem
+	}
+	if inner != "" {
+		return elem.decl(" " + inner + " " + dims)
+	}
+	return elem.decl(" " + dims)
+}
+
+// packNode is a template argument pack. Its elements are
+// spliced into the enclosing parameter or argument list.
+type packNode struct {
+	elems []node
+}
+
+func (n *packNode) decl(inner string) string {
+	return joinNodes(n.elems) + inner
+}
+
+func joinNodes(nodes []node) string {
+	var s []string
+	for _, n := range nodes {
+		if p, ok := n.(*packNode); ok {
+			if ps := joinNodes(p.elems); ps != "" {
+				s = append(s, ps)
+			}
+			continue
+		}
+		s = append(s, str(n))
+	}
+	return strings.Join(s, ", ")
+}
+
+func endsInEmptyPack(args []node) bool {
+	if len(args) == 0 {
+		return false
+	}
+	p, ok := args[len(args)-1].(*packNode)
+	return ok && joinNodes(p.elems) == ""
+}
+
+// qualify applies cv-qualifiers to t. Qualifiers t already has
+// aren't repeated, and qualifiers on an array apply to its
+// elements.
+func qualify(t node, quals string) node {
+	switch t := t.(type) {
+	case *funcNode:
+		// qualifiers on a function type apply to the function
+		return &funcNode{ret: t.ret, params: t.params, quals: t.quals + quals}
+	case *arrayNode:
+		return &arrayNode{qualify(t.elem, quals), t.dim}
+	case *qualNode:
+		var add string
+		for _, q := range strings.Fields(quals) {
+			if !strings.Contains(t.quals+" ", " "+q+" ") {
+				add += " " + q
+			}
+		}
+		if add == "" {
+			return t
+		}
+		return &qualNode{t.base, t.quals + add}
+	}
+	return &qualNode{t, quals}
+}
+
+// reference applies a reference to t, collapsing references to
+// references the way C++ does.
+func reference(t node, op string) node {
+	if inner, ok := t.(*ptrNode); ok && (inner.op == "&" || inner.op == "&&") {
+		if op == "&&" {
+			return inner
+		}
+		return &ptrNode{inner.elem, "&"}
+	}
+	return &ptrNode{t, op}
+}
+
+func paramList(params []node) string {
+	if len(params) == 1 && str(params[0]) == "void" {
+		return ""
+	}
+	return joinNodes(params)
+}
+
+func (st *state) functionType() node {
+	st.expect('F')
+	st.consume("Y")
+	ret := st.typ()
+	var params []node
+	for !st.consume("E") {
+		// trailing ref-qualifier
+		if st.consume("RE") || st.consume("OE") {
+			break
+		}
+		params = append(params, st.typ())
+	}
+	return &funcNode{ret: ret, params: params}
+}
+
+// packExpansion parses the pattern of a pack expansion and
+// instantiates it once per element of the pack it refers to.
+func (st *state) packExpansion() node {
+	start := st.pos
+	st.sawPack = nil
+	pattern := st.typ()
+	pack := st.sawPack
+	if pack == nil {
+		return &nameNode{str(pattern) + "..."}
+	}
+
+	end := st.pos
+	subs := st.subs
+	savedIdx := st.packIdx
+
+	out := &packNode{}
+	for i := range pack.elems {
+		st.pos = start
+		st.packIdx = i
+		st.subs = append([]node(nil), subs...)
+		out.elems = append(out.elems, st.typ())
+	}
+
+	st.pos = end
+	st.subs = subs
+	st.packIdx = savedIdx
+	return out
+}
+
+// withTemplate appends template args to name.
+func withTemplate(name string, args []node) string {
+	if strings.HasSuffix(name, "<") {
+		// operator< <T>
+		name += " "
+	}
+	return name + templateList(args)
+}
+
+func templateList(args []node) string {
+	out := "<" + joinNodes(args)
+	// c++filt doesn't separate the closing brackets when the
+	// last arg is an empty pack
+	if strings.HasSuffix(out, ">") && !endsInEmptyPack(args) {
+		out += " "
+	}
+	return out + ">"
+}
+
+// encoding ::= <name> <bare-function-type>
+//
+//	::= <name>
+//	::= <special-name>
+func (st *state) encoding() string {
+	return st.encodingRet(true)
+}
+
+// encodingRet parses an encoding, printing the return type of
+// template functions if showRet is set.
+func (st *state) encodingRet(showRet bool) string {
+	if s, ok := st.specialName(); ok {
+		return s
+	}
+
+	n := st.name()
+	if n.args != nil {
+		st.targs = n.args
+	}
+	if st.peek() == 0 || st.peek() == 'E' || st.peek() == '.' {
+		// data
+		return n.s
+	}
+
+	var ret node
+	if n.template && !n.ctorDtor {
+		ret = st.typ()
+	}
+	params := st.bareFunctionType()
+
+	s := n.s + "(" + paramList(params) + ")" + n.quals
+	if ret != nil && showRet {
+		s = str(ret) + " " + s
+	}
+	return s
+}
+
+func (st *state) bareFunctionType() []node {
+	var params []node
+	for st.peek() != 0 && st.peek() != 'E' && st.peek() != '.' {
+		params = append(params, st.typ())
+	}
+	if len(params) == 0 {
+		st.fail("missing function parameters")
+	}
+	return params
+}
+
+func (st *state) specialName() (string, bool) {
+	switch {
+	case st.consume("TV"):
+		return "vtable for " + str(st.typ()), true
+	case st.consume("TT"):
+		return "VTT for " + str(st.typ()), true
+	case st.consume("TI"):
+		return "typeinfo for " + str(st.typ()), true
+	case st.consume("TS"):
+		return "typeinfo name for " + str(st.typ()), true
+	case st.consume("Th"):
+		st.number()
+		st.expect('_')
+		return "non-virtual thunk to " + st.encoding(), true
+	case st.consume("Tv"):
+		st.number()
+		st.expect('_')
+		st.number()
+		st.expect('_')
+		return "virtual thunk to " + st.encoding(), true
+	case st.consume("GTt"):
+		return "transaction clone for " + st.encoding(), true
+	case st.consume("GV"):
+		return "guard variable for " + st.name().s, true
+	case st.consume("GR"):
+		n := st.name()
+		if st.peek() != '_' {
+			st.seqID()
+		} else {
+			st.next()
+		}
+		return "reference temporary for " + n.s, true
+	}
+	return "", false
+}
+
+// parsedName is a function or data name along with the details
+// the encoding needs to print it.
+type parsedName struct {
+	s string
+	// quals are the cv and ref qualifiers of a member function
+	quals string
+	// args are the innermost template args in the name, which
+	// template params in the function's type refer to
+	args     []node
+	template bool
+	ctorDtor bool
+}
+
+func (st *state) name() parsedName {
+	switch st.peek() {
+	case 'N':
+		return st.nestedName()
+	case 'Z':
+		return st.localName()
+	}
+
+	var n node
+	if st.consume("St") {
+		u, _ := st.unqualifiedName("")
+		n = &nameNode{"std::" + u}
+	} else if st.peek() == 'S' {
+		n = st.substitution()
+		if st.peek() != 'I' {
+			st.fail("substitution is not a template")
+		}
+	} else {
+		u, _ := st.unqualifiedName("")
+		n = &nameNode{u}
+	}
+
+	if st.peek() == 'I' {
+		st.addSub(n)
+		args := st.templateArgs()
+		return parsedName{s: withTemplate(str(n), args), args: args, template: true}
+	}
+	return parsedName{s: str(n)}
+}
+
+// nested-name ::= N [<CV-qualifiers>] [<ref-qualifier>] <prefix> <unqualified-name> E
+func (st *state) nestedName() parsedName {
+	st.expect('N')
+
+	quals := st.cvQualifiers()
+	if st.consume("R") {
+		quals += " &"
+	} else if st.consume("O") {
+		quals += " &&"
+	}
+
+	var (
+		cur      string
+		last     string
+		args     []node
+		template bool
+		ctorDtor bool
+	)
+	for st.peek() != 'E' {
+		template = false
+		addable := true
+		c := st.peek()
+		switch {
+		case c == 'S':
+			if cur != "" {
+				st.fail("substitution inside prefix")
+			}
+			if st.consume("St") {
+				cur = "std"
+			} else {
+				cur = str(st.substitution())
+			}
+			last = baseName(cur)
+			addable = false
+		case c == 'T':
+			cur = str(st.templateParam())
+			last = baseName(cur)
+		case c == 'I':
+			if cur == "" {
+				st.fail("template args without a name")
+			}
+			args = st.templateArgs()
+			cur = withTemplate(cur, args)
+			template = true
+		case c == 'M':
+			// data member prefix of a closure
+			st.next()
+			continue
+		default:
+			u, isCtorDtor := st.unqualifiedName(last)
+			ctorDtor = isCtorDtor
+			if !isCtorDtor && !strings.HasPrefix(u, "{unnamed") {
+				last = baseName(u)
+			}
+			if cur == "" {
+				cur = u
+			} else {
+				cur += "::" + u
+			}
+		}
+
+		if addable && st.peek() != 'E' {
+			st.addSub(&nameNode{cur})
+		}
+	}
+	st.expect('E')
+
+	return parsedName{s: cur, quals: quals, args: args, template: template, ctorDtor: ctorDtor}
+}
+
+// local-name ::= Z <encoding> E <entity name> [<discriminator>]
+//
+//	::= Z <encoding> E s [<discriminator>]
+func (st *state) localName() parsedName {
+	st.expect('Z')
+	saved := st.targs
+	fn := st.encodingRet(false)
+	st.targs = saved
+	st.expect('E')
+
+	var n parsedName
+	if st.consume("s") {
+		n.s = fn + "::string literal"
+	} else {
+		if st.consume("d") {
+			// default argument scope
+			if st.peek() != '_' {
+				st.number()
+			}
+			st.expect('_')
+		}
+		inner := st.name()
+		n = inner
+		n.s = fn + "::" + inner.s
+	}
+
+	if st.consume("_") {
+		if st.consume("_") {
+			st.number()
+			st.expect('_')
+		} else {
+			st.next()
+		}
+	}
+	return n
+}
+
+// unqualifiedName parses a source, operator, constructor or
+// destructor name. enclosing is the name of the class a
+// constructor or destructor belongs to.
+func (st *state) unqualifiedName(enclosing string) (string, bool) {
+	c := st.peek()
+	var s string
+	ctorDtor := false
+	switch {
+	case isDigit(c):
+		s = st.sourceName()
+	case c == 'C':
+		st.next()
+		st.consume("I")
+		if k := st.next(); k < '1' || k > '5' {
+			st.fail("bad constructor")
+		}
+		if enclosing == "" {
+			st.fail("constructor outside of a class")
+		}
+		s = enclosing
+		ctorDtor = true
+	case c == 'D':
+		st.next()
+		if k := st.next(); k < '0' || k > '5' {
+			st.fail("bad destructor")
+		}
+		if enclosing == "" {
+			st.fail("destructor outside of a class")
+		}
+		s = "~" + enclosing
+		ctorDtor = true
+	case st.consume("Ut"):
+		s = fmt.Sprintf("{unnamed type#%d}", st.discriminator())
+	case st.consume("Ul"):
+		var params []node
+		for !st.consume("E") {
+			params = append(params, st.typ())
+		}
+		s = fmt.Sprintf("{lambda(%s)#%d}", paramList(params), st.discriminator())
+	case c == 'L':
+		// internal linkage
+		st.next()
+		s = st.sourceName()
+	case c >= 'a' && c <= 'z':
+		s = st.operatorName()
+	default:
+		st.fail("bad unqualified name")
+	}
+
+	// abi tags
+	for st.consume("B") {
+		s += "[abi:" + st.sourceName() + "]"
+	}
+	return s, ctorDtor
+}
+
+// discriminator parses the index of an unnamed type or lambda,
+// which counts from 1.
+func (st *state) discriminator() int {
+	id := 1
+	if st.peek() != '_' {
+		id = st.number() + 2
+	}
+	st.expect('_')
+	return id
+}
+
+func (st *state) sourceName() string {
+	n := st.number()
+	if n <= 0 || st.pos+n > len(st.str) {
+		st.fail("bad source name length")
+	}
+	s := st.str[st.pos : st.pos+n]
+	st.pos += n
+	if strings.HasPrefix(s, "_GLOBAL_") && len(s) > 9 && (s[8] == '.' || s[8] == '_' || s[8] == '$') && s[9] == 'N' {
+		return "(anonymous namespace)"
+	}
+	return s
+}
+
+var operators = map[string]string{
+	"nw": "new", "na": "new[]", "dl": "delete", "da": "delete[]",
+	"ps": "+", "ng": "-", "ad": "&", "de": "*", "co": "~",
+	"pl": "+", "mi": "-", "ml": "*", "dv": "/", "rm": "%",
+	"an": "&", "or": "|", "eo": "^", "aS": "=",
+	"pL": "+=", "mI": "-=", "mL": "*=", "dV": "/=", "rM": "%=",
+	"aN": "&=", "oR": "|=", "eO": "^=",
+	"ls": "<<", "rs": ">>", "lS": "<<=", "rS": ">>=",
+	"eq": "==", "ne": "!=", "lt": "<", "gt": ">", "le": "<=", "ge": ">=", "ss": "<=>",
+	"nt": "!", "aa": "&&", "oo": "||", "pp": "++", "mm": "--",
+	"cm": ",", "pm": "->*", "pt": "->", "cl": "()", "ix": "[]", "qu": "?",
+	"aw": "co_await",
+}
+
+func (st *state) operatorName() string {
+	if st.pos+2 > len(st.str) {
+		st.fail("bad operator")
+	}
+	code := st.str[st.pos : st.pos+2]
+	st.pos += 2
+
+	switch code {
+	case "cv":
+		return "operator " + str(st.typ())
+	case "li":
+		return "operator\"\" " + st.sourceName()
+	}
+
+	op, ok := operators[code]
+	if !ok {
+		st.pos -= 2
+		st.fail("unknown operator")
+	}
+	if op[0] >= 'a' && op[0] <= 'z' {
+		return "operator " + op
+	}
+	return "operator" + op
+}
+
+func (st *state) cvQualifiers() string {
+	var q string
+	if st.consume("r") {
+		q += " restrict"
+	}
+	if st.consume("V") {
+		q += " volatile"
+	}
+	if st.consume("K") {
+		q += " const"
+	}
+	return q
+}
+
+func (st *state) addSub(n node) {
+	st.subs = append(st.subs, n)
+}
+
+var stdSubs = map[byte]string{
+	't': "std",
+	'a': "std::allocator",
+	'b': "std::basic_string",
+	's': "std::basic_string<char, std::char_traits<char>, std::allocator<char> >",
+	'i': "std::basic_istream<char, std::char_traits<char> >",
+	'o': "std::basic_ostream<char, std::char_traits<char> >",
+	'd': "std::basic_iostream<char, std::char_traits<char> >",
+}
+
+func (st *state) substitution() node {
+	st.expect('S')
+	if s, ok := stdSubs[st.peek()]; ok {
+		st.next()
+		return &nameNode{s}
+	}
+	id := st.seqID()
+	if id >= len(st.subs) {
+		st.fail("substitution out of range")
+	}
+	if ref, ok := st.subs[id].(*paramRef); ok {
+		return st.lookupParam(ref.id)
+	}
+	return st.subs[id]
+}
+
+// paramRef is a substitution for a template param. It's
+// resolved when the substitution is used rather than when it's
+// recorded, since a param seen inside a local name's scope
+// refers to the enclosing function's args by the time it's
+// referenced again in that function's signature.
+type paramRef struct {
+	id int
+}
+
+func (n *paramRef) decl(inner string) string {
+	return fmt.Sprintf("T%d", n.id) + inner
+}
+
+func (st *state) templateParam() node {
+	st.expect('T')
+	return st.lookupParam(st.seqID())
+}
+
+// lookupID re-reads the index of the template param that
+// starts at pos.
+func (st *state) lookupID(pos int) int {
+	saved := st.pos
+	st.pos = pos + 1
+	id := st.seqID()
+	st.pos = saved
+	return id
+}
+
+func (st *state) lookupParam(id int) node {
+	if id >= len(st.targs) {
+		st.fail("template parameter out of range")
+	}
+	arg := st.targs[id]
+	if p, ok := arg.(*packNode); ok {
+		st.sawPack = p
+		if st.packIdx >= 0 && st.packIdx < len(p.elems) {
+			return p.elems[st.packIdx]
+		}
+	}
+	return arg
+}
+
+func (st *state) templateArgs() []node {
+	st.expect('I')
+	var args []node
+	for !st.consume("E") {
+		args = append(args, st.templateArg())
+	}
+	return args
+}
+
+func (st *state) templateArg() node {
+	switch st.peek() {
+	case 'L':
+		return st.exprPrimary()
+	case 'X':
+		st.next()
+		e := st.expression()
+		st.expect('E')
+		return e
+	case 'J':
+		// argument pack
+		st.next()
+		p := &packNode{}
+		for !st.consume("E") {
+			p.elems = append(p.elems, st.templateArg())
+		}
+		return p
+	}
+	return st.typ()
+}
+
+// expression parses the small subset of expressions that show
+// up in SFINAE style template args: literals, template params
+// and scoped names like std::is_signed<T>::value.
+func (st *state) expression() node {
+	switch {
+	case st.peek() == 'L':
+		return st.exprPrimary()
+	case st.peek() == 'T':
+		return st.templateParam()
+	case st.consume("sr"):
+		var scope string
+		if st.consume("N") {
+			scope = str(st.typ())
+			for !st.consume("E") {
+				u, _ := st.unqualifiedName("")
+				if st.peek() == 'I' {
+					u = withTemplate(u, st.templateArgs())
+				}
+				scope += "::" + u
+			}
+		} else {
+			scope = str(st.typ())
+		}
+		u, _ := st.unqualifiedName("")
+		if st.peek() == 'I' {
+			u = withTemplate(u, st.templateArgs())
+		}
+		return &nameNode{scope + "::" + u}
+	}
+	st.fail("unsupported template expression")
+	return nil
+}
+
+var literalSuffix = map[string]string{
+	"i": "", "j": "u", "l": "l", "m": "ul", "x": "ll", "y": "ull",
+}
+
+// exprPrimary parses a literal template argument.
+func (st *state) exprPrimary() node {
+	st.expect('L')
+
+	if st.consume("_Z") {
+		enc := st.encoding()
+		st.expect('E')
+		return &nameNode{enc}
+	}
+
+	t := st.typ()
+	start := st.pos
+	for st.peek() != 'E' {
+		st.next()
+	}
+	val := st.str[start:st.pos]
+	st.expect('E')
+
+	if strings.HasPrefix(val, "n") {
+		val = "-" + val[1:]
+	}
+
+	ts := str(t)
+	if ts == "bool" {
+		switch val {
+		case "0":
+			return &nameNode{"false"}
+		case "1":
+			return &nameNode{"true"}
+		}
+	}
+	if b, ok := t.(*builtinNode); ok {
+		if suffix, ok := literalSuffix[b.code]; ok {
+			return &nameNode{val + suffix}
+		}
+	}
+	return &nameNode{"(" + ts + ")" + val}
+}
+
+type builtinNode struct {
+	nameNode
+	code string
+}
+
+var builtinTypes = map[byte]string{
+	'v': "void", 'w': "wchar_t", 'b': "bool", 'c': "char",
+	'a': "signed char", 'h': "unsigned char", 's': "short",
+	't': "unsigned short", 'i': "int", 'j': "unsigned int",
+	'l': "long", 'm': "unsigned long", 'x': "long long",
+	'y': "unsigned long long", 'n': "__int128",
+	'o': "unsigned __int128", 'f': "float", 'd': "double",
+	'e': "long double", 'g': "__float128", 'z': "...",
+}
+
+var builtinDTypes = map[byte]string{
+	'n': "decltype(nullptr)", 'i': "char32_t", 's': "char16_t",
+	'u': "char8_t", 'a': "auto", 'c': "decltype(auto)",
+	'h': "half", 'd': "decimal64", 'e': "decimal128", 'f': "decimal32",
+}
+
+func (st *state) typ() node {
+	c := st.peek()
+
+	if b, ok := builtinTypes[c]; ok {
+		st.next()
+		return &builtinNode{nameNode{b}, string(c)}
+	}
+	if c == 'D' && st.pos+1 < len(st.str) {
+		if b, ok := builtinDTypes[st.str[st.pos+1]]; ok {
+			st.pos += 2
+			return &builtinNode{nameNode{b}, st.str[st.pos-2 : st.pos]}
+		}
+	}
+
+	var t node
+	switch c {
+	case 'u':
+		st.next()
+		return &nameNode{st.sourceName()}
+	case 'r', 'V', 'K':
+		quals := st.cvQualifiers()
+		if st.peek() == 'F' {
+			// the unqualified type of a member function isn't
+			// a substitution candidate
+			t = qualify(st.functionType(), quals)
+		} else {
+			t = qualify(st.typ(), quals)
+		}
+	case 'P':
+		st.next()
+		t = &ptrNode{st.typ(), "*"}
+	case 'R':
+		st.next()
+		t = reference(st.typ(), "&")
+	case 'O':
+		st.next()
+		t = reference(st.typ(), "&&")
+	case 'F':
+		t = st.functionType()
+	case 'A':
+		st.next()
+		var dim string
+		if isDigit(st.peek()) {
+			dim = fmt.Sprint(st.number())
+		}
+		st.expect('_')
+		t = &arrayNode{st.typ(), dim}
+	case 'M':
+		st.next()
+		class := st.typ()
+		member := st.typ()
+		if f, ok := member.(*funcNode); ok {
+			t = &ptrNode{f, str(class) + "::*"}
+		} else {
+			t = &ptrNode{member, " " + str(class) + "::*"}
+		}
+	case 'T':
+		start := st.pos
+		t = st.templateParam()
+		ref := &paramRef{st.lookupID(start)}
+		if st.peek() != 'I' {
+			st.addSub(ref)
+			return t
+		}
+		st.addSub(ref)
+		t = &nameNode{withTemplate(str(t), st.templateArgs())}
+	case 'S':
+		if st.consume("St") {
+			u, _ := st.unqualifiedName("")
+			t = &nameNode{"std::" + u}
+			if st.peek() == 'I' {
+				st.addSub(t)
+				t = &nameNode{withTemplate(str(t), st.templateArgs())}
+			}
+			break
+		}
+		t = st.substitution()
+		if st.peek() != 'I' {
+			// substitutions aren't themselves substitutable
+			return t
+		}
+		t = &nameNode{withTemplate(str(t), st.templateArgs())}
+	case 'D':
+		switch {
+		case st.consume("Dp"):
+			t = st.packExpansion()
+		case st.consume("Dt"), st.consume("DT"):
+			st.fail("unsupported decltype")
+		default:
+			st.fail("unsupported type")
+		}
+	case 'N', 'Z':
+		t = &nameNode{st.name().s}
+	default:
+		if isDigit(c) {
+			u, _ := st.unqualifiedName("")
+			t = &nameNode{u}
+			if st.peek() == 'I' {
+				st.addSub(t)
+				t = &nameNode{withTemplate(u, st.templateArgs())}
+			}
+		} else {
+			st.fail("unsupported type")
+		}
+	}
+
+	st.addSub(t)
+	return t
+}
@@ -0,0 +1,146 @@
+package demangle
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestItanium(t *testing.T) {
+	tests := []struct {
+		mangled, want string
+	}{
+		{"_Z3addii", "add(int, int)"},
+		{"_ZN3foo3barEv", "foo::bar()"},
+		{"_Z1fPKcRi", "f(char const*, int&)"},
+		{"_ZNK3Foo3getEv", "Foo::get() const"},
+		{"_ZN3FooC1Ev", "Foo::Foo()"},
+		{"_ZN3FooD2Ev", "Foo::~Foo()"},
+		{"_ZN3FooplERKS_", "Foo::operator+(Foo const&)"},
+		{"_ZTV3Foo", "vtable for Foo"},
+		{"_ZTI3Foo", "typeinfo for Foo"},
+		{"_ZZ4mainE5count", "main::count"},
+		{"_Z1fA10_i", "f(int [10])"},
+		{"_Z1fPFviE", "f(void (*)(int))"},
+		{"_Z3addii.cold", "add(int, int) [clone .cold]"},
+
+		// substitutions: S_ is the first substitutable component,
+		// S0_ the second and so on, and St, Sa and Ss are the
+		// abbreviations for std::, std::allocator and std::string
+		{"_ZNSt6vectorIiSaIiEE9push_backERKi", "std::vector<int, std::allocator<int> >::push_back(int const&)"},
+		{"_Z1fSt6vectorIiSaIiEES1_", "f(std::vector<int, std::allocator<int> >, std::vector<int, std::allocator<int> >)"},
+		{"_ZN2ns3MapIiSsE6insertERKiRKSs", "ns::Map<int, std::basic_string<char, std::char_traits<char>, std::allocator<char> > >::insert(int const&, std::basic_string<char, std::char_traits<char>, std::allocator<char> > const&)"},
+		{"_ZNSt3__112basic_stringIcNS_11char_traitsIcEENS_9allocatorIcEEE6appendEPKc", "std::__1::basic_string<char, std::__1::char_traits<char>, std::__1::allocator<char> >::append(char const*)"},
+
+		// templates, with the return type of function templates
+		// and template parameters referenced by T_
+		{"_Z5firstIiET_S0_", "int first<int>(int)"},
+		{"_Z4sortIPiEvT_S1_", "void sort<int*>(int*, int*)"},
+		{"_ZN5Outer5InnerIiE3getEv", "Outer::Inner<int>::get()"},
+		{"_ZN3foo3barIJidEEEvDpT_", "void foo::bar<int, double>(int, double)"},
+	}
+
+	for _, tt := range tests {
+		got, err := ToString(tt.mangled)
+		if err != nil {
+			t.Errorf("%s: %s", tt.mangled, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.mangled, got, tt.want)
+		}
+	}
+}
+
+func TestNotMangled(t *testing.T) {
+	for _, name := range []string{"", "main", "main.main", "printf", "_start", "Z3addii"} {
+		if got, err := ToString(name); !errors.Is(err, ErrNotMangled) {
+			t.Errorf("%q: got %q, %v; want ErrNotMangled", name, got, err)
+		}
+		if got := Filter(name); got != name {
+			t.Errorf("Filter(%q) = %q, want it unchanged", name, got)
+		}
+	}
+}
+
+// garbage are mangled names to derive malformed input from.
+var garbage = []string{
+	"_ZNSt6vectorIiSaIiEE9push_backERKi",
+	"_ZN3foo3barIJidEEEvDpT_",
+	"_Z1fPFviE",
+	"_ZZ4mainE5count",
+	"_RNvXs_Cs33OfmowbDHc_1tINtB4_3FoohENtB4_2Tr2go",
+	"_RINvNtNtCscKkwsb9kWaL_3std3sys9backtrace28___rust_begin_short_backtraceFEuuECs33OfmowbDHc_1t",
+	"_ZN60_$LT$alloc..vec..Vec$LT$T$GT$$u20$as$u20$core..ops..Drop$GT$4drop17h0123456789abcdefE",
+}
+
+// demangleWithin demangles name, failing the test if that panics or
+// takes longer than limit.
+func demangleWithin(t *testing.T, name string, limit time.Duration) {
+	t.Helper()
+	done := make(chan interface{}, 1)
+	go func() {
+		defer func() { done <- recover() }()
+		ToString(name)
+	}()
+	select {
+	case r := <-done:
+		if r != nil {
+			t.Fatalf("%q: panic: %v", name, r)
+		}
+	case <-time.After(limit):
+		t.Fatalf("%q: still demangling after %s", name, limit)
+	}
+}
+
+func TestGarbage(t *testing.T) {
+	const alphabet = "_ZRNSEIJKLTVXYBCMsDdpfvcilmnjtxyuhab0123456789$."
+	rng := rand.New(rand.NewSource(1))
+
+	var inputs []string
+	for _, name := range garbage {
+		// every truncation
+		for i := range name {
+			inputs = append(inputs, name[:i])
+		}
+		// and random replacements, insertions and deletions
+		for i := 0; i < 200; i++ {
+			b := []byte(name)
+			for n := rng.Intn(4) + 1; n > 0; n-- {
+				pos := 2 + rng.Intn(len(b)-2)
+				c := alphabet[rng.Intn(len(alphabet))]
+				switch rng.Intn(3) {
+				case 0:
+					b[pos] = c
+				case 1:
+					b = append(b[:pos], append([]byte{c}, b[pos:]...)...)
+				default:
+					b = append(b[:pos], b[pos+1:]...)
+				}
+			}
+			inputs = append(inputs, string(b))
+		}
+	}
+	// and random strings in each scheme
+	for i := 0; i < 2000; i++ {
+		b := make([]byte, rng.Intn(40))
+		for j := range b {
+			b[j] = alphabet[rng.Intn(len(alphabet))]
+		}
+		inputs = append(inputs, "_Z"+string(b), "_R"+string(b))
+	}
+
+	for _, in := range inputs {
+		demangleWithin(t, in, time.Second)
+	}
+}
+
+func FuzzToString(f *testing.F) {
+	for _, name := range garbage {
+		f.Add(name)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		demangleWithin(t, name, time.Second)
+	})
+}
@@ -0,0 +1,586 @@
+package demangle
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var rustEscapes = map[string]string{
+	"SP": "@",
+	"BP": "*",
+	"RF": "&",
+	"LT": "<",
+	"GT": ">",
+	"LP": "(",
+	"RP": ")",
+	"C":  ",",
+}
+
+// rustLegacy demangles a Rust symbol in the legacy scheme, which
+// is an Itanium nested name whose last component is a hash like
+// h0123456789abcdef. The hash is dropped from the output.
+func rustLegacy(name string) (string, bool) {
+	s := strings.TrimPrefix(name, "_Z")
+	// drop compiler suffixes like .llvm.1234 that follow the hash
+	if loc := rustHashRe.FindStringIndex(s); loc != nil {
+		s = s[:loc[1]]
+	}
+	if !strings.HasPrefix(s, "N") || !strings.HasSuffix(s, "E") {
+		return "", false
+	}
+	s = s[1 : len(s)-1]
+
+	var parts []string
+	for len(s) > 0 {
+		i := 0
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil || n == 0 || i+n > len(s) {
+			return "", false
+		}
+		parts = append(parts, s[i:i+n])
+		s = s[i+n:]
+	}
+
+	if len(parts) < 2 || !isRustHash(parts[len(parts)-1]) {
+		return "", false
+	}
+	parts = parts[:len(parts)-1]
+
+	for i, p := range parts {
+		dp, ok := rustUnescape(p)
+		if !ok {
+			return "", false
+		}
+		parts[i] = dp
+	}
+
+	return strings.Join(parts, "::"), true
+}
+
+var rustHashRe = regexp.MustCompile(`17h[0-9a-f]{16}E`)
+
+func isRustHash(s string) bool {
+	if len(s) != 17 || s[0] != 'h' {
+		return false
+	}
+	for _, c := range s[1:] {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func rustUnescape(s string) (string, bool) {
+	if strings.HasPrefix(s, "_$") {
+		s = s[1:]
+	}
+
+	var out strings.Builder
+	for len(s) > 0 {
+		switch {
+		case s[0] == '$':
+			end := strings.IndexByte(s[1:], '$')
+			if end < 0 {
+				return "", false
+			}
+			esc := s[1 : end+1]
+			s = s[end+2:]
+			if r, ok := rustEscapes[esc]; ok {
+				out.WriteString(r)
+				continue
+			}
+			if !strings.HasPrefix(esc, "u") {
+				return "", false
+			}
+			c, err := strconv.ParseUint(esc[1:], 16, 32)
+			if err != nil {
+				return "", false
+			}
+			out.WriteRune(rune(c))
+		case strings.HasPrefix(s, ".."):
+			out.WriteString("::")
+			s = s[2:]
+		default:
+			out.WriteByte(s[0])
+			s = s[1:]
+		}
+	}
+	return out.String(), true
+}
+
+// rustV0 demangles the part of a Rust v0 symbol after "_R".
+func rustV0(sym string) (s string, err error) {
+	// an optional encoding version
+	i := 0
+	for i < len(sym) && isDigit(sym[i]) {
+		i++
+	}
+	if i > 0 {
+		return "", fmt.Errorf("demangle: unsupported rust mangling version %s", sym[:i])
+	}
+
+	p := &v0Parser{sym: sym}
+
+	defer func() {
+		if r := recover(); r != nil {
+			pe, ok := r.(*parseError)
+			if !ok {
+				panic(r)
+			}
+			err = pe
+		}
+	}()
+
+	var out strings.Builder
+	p.path(&out, true)
+	// anything left is the instantiating crate or a vendor
+	// suffix, neither of which is shown
+	return out.String(), nil
+}
+
+type v0Parser struct {
+	sym string
+	pos int
+	// depth guards against backref loops
+	depth int
+	// bound is the number of lifetimes bound by enclosing
+	// for<...> binders
+	bound uint64
+}
+
+func (p *v0Parser) fail(msg string) {
+	panic(&parseError{pos: p.pos + 2, msg: msg})
+}
+
+func (p *v0Parser) peek() byte {
+	if p.pos >= len(p.sym) {
+		return 0
+	}
+	return p.sym[p.pos]
+}
+
+func (p *v0Parser) next() byte {
+	c := p.peek()
+	if c == 0 {
+		p.fail("unexpected end of name")
+	}
+	p.pos++
+	return c
+}
+
+func (p *v0Parser) consume(c byte) bool {
+	if p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// base62 parses a base 62 number terminated by '_'. A bare '_'
+// is 0, otherwise the value is one more than the digits encode.
+func (p *v0Parser) base62() uint64 {
+	if p.consume('_') {
+		return 0
+	}
+	var n uint64
+	for {
+		c := p.next()
+		var d uint64
+		switch {
+		case c == '_':
+			return n + 1
+		case isDigit(c):
+			d = uint64(c - '0')
+		case c >= 'a' && c <= 'z':
+			d = uint64(c-'a') + 10
+		case c >= 'A' && c <= 'Z':
+			d = uint64(c-'A') + 36
+		default:
+			p.fail("bad base 62 number")
+		}
+		n = n*62 + d
+	}
+}
+
+func (p *v0Parser) disambiguator() uint64 {
+	if p.consume('s') {
+		return p.base62() + 1
+	}
+	return 0
+}
+
+func (p *v0Parser) ident() string {
+	p.disambiguator()
+	if p.consume('u') {
+		p.fail("unsupported punycode identifier")
+	}
+	start := p.pos
+	if p.consume('0') {
+		// lengths have no leading zeros, so this is an empty name
+	} else {
+		for isDigit(p.peek()) {
+			p.pos++
+		}
+	}
+	n, err := strconv.Atoi(p.sym[start:p.pos])
+	if err != nil {
+		p.fail("bad identifier length")
+	}
+	p.consume('_')
+	if n > len(p.sym)-p.pos {
+		p.fail("identifier too long")
+	}
+	s := p.sym[p.pos : p.pos+n]
+	p.pos += n
+	return s
+}
+
+// backref runs fn with the parser positioned at a back
+// reference's target.
+func (p *v0Parser) backref(fn func()) {
+	target := p.base62()
+	if target >= uint64(p.pos) {
+		p.fail("bad back reference")
+	}
+	if p.depth > 64 {
+		p.fail("back reference loop")
+	}
+	saved := p.pos
+	p.pos = int(target)
+	p.depth++
+	fn()
+	p.depth--
+	p.pos = saved
+}
+
+// path prints a path. In value position generic args are
+// written with a turbofish (foo::<T>).
+func (p *v0Parser) path(out *strings.Builder, value bool) {
+	switch c := p.next(); c {
+	case 'C':
+		out.WriteString(p.ident())
+	case 'M':
+		p.disambiguator()
+		p.skipPath()
+		out.WriteByte('<')
+		p.typ(out)
+		out.WriteByte('>')
+	case 'X':
+		p.disambiguator()
+		p.skipPath()
+		out.WriteByte('<')
+		p.typ(out)
+		out.WriteString(" as ")
+		p.path(out, false)
+		out.WriteByte('>')
+	case 'Y':
+		out.WriteByte('<')
+		p.typ(out)
+		out.WriteString(" as ")
+		p.path(out, false)
+		out.WriteByte('>')
+	case 'N':
+		ns := p.next()
+		p.path(out, value)
+		dis := p.peekDisambiguator()
+		name := p.ident()
+		switch {
+		case ns >= 'a' && ns <= 'z':
+			if name != "" {
+				out.WriteString("::" + name)
+			}
+		case ns == 'C':
+			out.WriteString("::{closure")
+			if name != "" {
+				out.WriteString(":" + name)
+			}
+			fmt.Fprintf(out, "#%d}", dis)
+		case ns == 'S':
+			out.WriteString("::{shim")
+			if name != "" {
+				out.WriteString(":" + name)
+			}
+			fmt.Fprintf(out, "#%d}", dis)
+		default:
+			fmt.Fprintf(out, "::{%c", ns)
+			if name != "" {
+				out.WriteString(":" + name)
+			}
+			fmt.Fprintf(out, "#%d}", dis)
+		}
+	case 'I':
+		p.path(out, value)
+		if value {
+			out.WriteString("::")
+		}
+		out.WriteByte('<')
+		for i := 0; !p.consume('E'); i++ {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			p.genericArg(out)
+		}
+		out.WriteByte('>')
+	case 'B':
+		p.backref(func() { p.path(out, value) })
+	default:
+		p.pos--
+		p.fail("bad path")
+	}
+}
+
+// peekDisambiguator returns the disambiguator of the identifier
+// at the current position without consuming it.
+func (p *v0Parser) peekDisambiguator() uint64 {
+	saved := p.pos
+	d := p.disambiguator()
+	p.pos = saved
+	return d
+}
+
+func (p *v0Parser) skipPath() {
+	var discard strings.Builder
+	p.path(&discard, false)
+}
+
+func (p *v0Parser) genericArg(out *strings.Builder) {
+	switch {
+	case p.consume('L'):
+		p.lifetime(out, p.base62())
+	case p.consume('K'):
+		p.constant(out)
+	default:
+		p.typ(out)
+	}
+}
+
+// lifetime prints a lifetime given its de Bruijn index. Index 0
+// is the erased lifetime.
+func (p *v0Parser) lifetime(out *strings.Builder, n uint64) {
+	if n == 0 || n > p.bound {
+		out.WriteString("'_")
+		return
+	}
+	depth := p.bound - n
+	if depth < 26 {
+		out.WriteByte('\'')
+		out.WriteByte(byte('a' + depth))
+		return
+	}
+	fmt.Fprintf(out, "'_%d", depth)
+}
+
+// binder prints an optional for<...> binder. The returned func
+// ends the binder's scope.
+func (p *v0Parser) binder(out *strings.Builder) func() {
+	if !p.consume('G') {
+		return func() {}
+	}
+	n := p.base62() + 1
+	out.WriteString("for<")
+	for i := uint64(0); i < n; i++ {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		p.bound++
+		p.lifetime(out, 1)
+	}
+	out.WriteString("> ")
+	return func() { p.bound -= n }
+}
+
+var v0BasicTypes = map[byte]string{
+	'a': "i8", 'b': "bool", 'c': "char", 'd': "f64", 'e': "str",
+	'f': "f32", 'h': "u8", 'i': "isize", 'j': "usize", 'l': "i32",
+	'm': "u32", 'n': "i128", 'o': "u128", 's': "i16", 't': "u16",
+	'u': "()", 'v': "...", 'x': "i64", 'y': "u64", 'z': "!",
+	'p': "_",
+}
+
+func (p *v0Parser) typ(out *strings.Builder) {
+	c := p.peek()
+	if t, ok := v0BasicTypes[c]; ok {
+		p.pos++
+		out.WriteString(t)
+		return
+	}
+
+	switch c {
+	case 'R', 'Q':
+		p.pos++
+		out.WriteByte('&')
+		if p.consume('L') {
+			if n := p.base62(); n != 0 {
+				p.lifetime(out, n)
+				out.WriteByte(' ')
+			}
+		}
+		if c == 'Q' {
+			out.WriteString("mut ")
+		}
+		p.typ(out)
+	case 'P':
+		p.pos++
+		out.WriteString("*const ")
+		p.typ(out)
+	case 'O':
+		p.pos++
+		out.WriteString("*mut ")
+		p.typ(out)
+	case 'A':
+		p.pos++
+		out.WriteByte('[')
+		p.typ(out)
+		out.WriteString("; ")
+		p.constant(out)
+		out.WriteByte(']')
+	case 'S':
+		p.pos++
+		out.WriteByte('[')
+		p.typ(out)
+		out.WriteByte(']')
+	case 'T':
+		p.pos++
+		out.WriteByte('(')
+		n := 0
+		for ; !p.consume('E'); n++ {
+			if n > 0 {
+				out.WriteString(", ")
+			}
+			p.typ(out)
+		}
+		if n == 1 {
+			out.WriteByte(',')
+		}
+		out.WriteByte(')')
+	case 'F':
+		p.pos++
+		p.fnSig(out)
+	case 'D':
+		p.pos++
+		p.dynBounds(out)
+	case 'B':
+		p.pos++
+		p.backref(func() { p.typ(out) })
+	default:
+		p.path(out, false)
+	}
+}
+
+func (p *v0Parser) fnSig(out *strings.Builder) {
+	defer p.binder(out)()
+	if p.consume('U') {
+		out.WriteString("unsafe ")
+	}
+	if p.consume('K') {
+		abi := "C"
+		if !p.consume('C') {
+			abi = strings.ReplaceAll(p.ident(), "_", "-")
+		}
+		fmt.Fprintf(out, "extern %q ", abi)
+	}
+	out.WriteString("fn(")
+	for i := 0; !p.consume('E'); i++ {
+		if i > 0 {
+			out.WriteString(", ")
+		}
+		p.typ(out)
+	}
+	out.WriteByte(')')
+	if p.peek() == 'u' {
+		p.pos++
+		return
+	}
+	out.WriteString(" -> ")
+	p.typ(out)
+}
+
+func (p *v0Parser) dynBounds(out *strings.Builder) {
+	out.WriteString("dyn ")
+	end := p.binder(out)
+	for i := 0; !p.consume('E'); i++ {
+		if i > 0 {
+			out.WriteString(" + ")
+		}
+		p.dynTrait(out)
+	}
+	end()
+	// the object lifetime bound
+	p.expect('L')
+	if n := p.base62(); n != 0 {
+		out.WriteString(" + ")
+		p.lifetime(out, n)
+	}
+}
+
+func (p *v0Parser) expect(c byte) {
+	if p.next() != c {
+		p.pos--
+		p.fail(fmt.Sprintf("expected %q", c))
+	}
+}
+
+func (p *v0Parser) dynTrait(out *strings.Builder) {
+	var trait strings.Builder
+	p.path(&trait, false)
+	s := trait.String()
+
+	var assoc []string
+	for p.consume('p') {
+		var b strings.Builder
+		b.WriteString(p.ident() + " = ")
+		p.typ(&b)
+		assoc = append(assoc, b.String())
+	}
+	if len(assoc) > 0 {
+		if strings.HasSuffix(s, ">") {
+			s = s[:len(s)-1] + ", " + strings.Join(assoc, ", ") + ">"
+		} else {
+			s += "<" + strings.Join(assoc, ", ") + ">"
+		}
+	}
+	out.WriteString(s)
+}
+
+func (p *v0Parser) constant(out *strings.Builder) {
+	if p.consume('B') {
+		p.backref(func() { p.constant(out) })
+		return
+	}
+	if p.consume('p') {
+		out.WriteByte('_')
+		return
+	}
+
+	t := p.next()
+	neg := p.consume('n')
+	start := p.pos
+	for p.peek() != '_' {
+		p.next()
+	}
+	hex := p.sym[start:p.pos]
+	p.pos++
+
+	v, err := strconv.ParseUint(hex, 16, 64)
+	if hex != "" && err != nil {
+		p.fail("bad constant")
+	}
+
+	switch t {
+	case 'b':
+		out.WriteString(strconv.FormatBool(v != 0))
+	case 'c':
+		out.WriteString(strconv.QuoteRune(rune(v)))
+	default:
+		if neg {
+			out.WriteByte('-')
+		}
+		fmt.Fprint(out, v)
+	}
+}
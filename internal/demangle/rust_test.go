@@ -0,0 +1,65 @@
+package demangle
+
+import "testing"
+
+func TestRustLegacy(t *testing.T) {
+	tests := []struct {
+		mangled, want string
+	}{
+		{"_ZN3std2io5stdio6_print17h1234567890abcdefE", "std::io::stdio::_print"},
+		{"_ZN4core3ptr13drop_in_place17h0123456789abcdefE", "core::ptr::drop_in_place"},
+		{"_ZN14rustc_demangle2v07Printer10print_type28_$u7b$$u7b$closure$u7d$$u7d$17h43416ca92e5b2774E", "rustc_demangle::v0::Printer::print_type::{{closure}}"},
+		{"_ZN102_$LT$std..panicking..begin_panic_handler..FormatStringPayload$u20$as$u20$core..panic..PanicPayload$GT$3get17ha39363536bd5c768E", "<std::panicking::begin_panic_handler::FormatStringPayload as core::panic::PanicPayload>::get"},
+		{"_ZN104_$LT$core..iter..sources..from_fn..FromFn$LT$F$GT$$u20$as$u20$core..iter..traits..iterator..Iterator$GT$4next17hfd188c6148d91fceE", "<core::iter::sources::from_fn::FromFn<F> as core::iter::traits::iterator::Iterator>::next"},
+	}
+
+	for _, tt := range tests {
+		got, err := ToString(tt.mangled)
+		if err != nil {
+			t.Errorf("%s: %s", tt.mangled, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.mangled, got, tt.want)
+		}
+	}
+}
+
+// The v0 names are from rustc 1.90, and the wanted forms from
+// rustc-demangle without the crate disambiguators.
+func TestRustV0(t *testing.T) {
+	tests := []struct {
+		mangled, want string
+	}{
+		{"_RNvCsj4CZ6flxxfE_7___rustc10rust_panic", "__rustc::rust_panic"},
+		{"_RNvNtCs1234_7mycrate3foo3bar", "mycrate::foo::bar"},
+
+		// generic args, with B back references to earlier paths
+		{"_RINvCs1234_7mycrate4funcjEB2_", "mycrate::func::<usize>"},
+		{"_RINvCs1234_7mycrate4swapRShEB2_", "mycrate::swap::<&[u8]>"},
+		{"_RNvMCs33OfmowbDHc_1tINtB2_3FooReE4showB2_", "<t::Foo<&str>>::show"},
+		{"_RNvMNtCs1234_7mycrate3fooNtB2_3Bar3new", "<mycrate::foo::Bar>::new"},
+
+		// trait impls
+		{"_RNvXs_Cs33OfmowbDHc_1tINtB4_3FoohENtB4_2Tr2go", "<t::Foo<u8> as t::Tr>::go"},
+		{"_RNvXs19_NtCs5GmCzIpY9Qj_4core3fmtReNtB6_5Debug3fmtCs33OfmowbDHc_1t", "<&str as core::fmt::Debug>::fmt"},
+		{"_RNvXs3_NtNtCs5GmCzIpY9Qj_4core4hash3sipINtB5_6HasherNtB5_11Sip13RoundsENtB7_6Hasher5writeCs33OfmowbDHc_1t", "<core::hash::sip::Hasher<core::hash::sip::Sip13Rounds> as core::hash::Hasher>::write"},
+		{"_RINvYNtNtNtCscKkwsb9kWaL_3std4hash6random11RandomStateNtNtCs5GmCzIpY9Qj_4core4hash11BuildHasher8hash_oneRNtNtCscmSb185pVu_5alloc6string6StringECs33OfmowbDHc_1t", "<std::hash::random::RandomState as core::hash::BuildHasher>::hash_one::<&alloc::string::String>"},
+
+		// function types, tuples and closures
+		{"_RINvNtNtCscKkwsb9kWaL_3std3sys9backtrace28___rust_begin_short_backtraceFEuuECs33OfmowbDHc_1t", "std::sys::backtrace::__rust_begin_short_backtrace::<fn(), ()>"},
+		{"_RINvMs6_NtCsgyaJDGyq3nG_9hashbrown3rawINtB6_8RawTableTNtNtCscmSb185pVu_5alloc6string6StringINtNtBU_3vec3VecmEEE14reserve_rehashNCINvNtB8_3map11make_hasherBQ_B1r_NtNtNtCscKkwsb9kWaL_3std4hash6random11RandomStateE0ECs33OfmowbDHc_1t.llvm.5789803183260405703",
+			"<hashbrown::raw::RawTable<(alloc::string::String, alloc::vec::Vec<u32>)>>::reserve_rehash::<hashbrown::map::make_hasher<alloc::string::String, alloc::vec::Vec<u32>, std::hash::random::RandomState>::{closure#0}>"},
+	}
+
+	for _, tt := range tests {
+		got, err := ToString(tt.mangled)
+		if err != nil {
+			t.Errorf("%s: %s", tt.mangled, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.mangled, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package dwarfutil
+
+import (
+	"debug/dwarf"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/psanford/pptrace/internal/vlog"
+)
+
+// maxCached is how many files' DWARF data and trees are kept. A
+// process rarely looks at more than a binary and its libraries at
+// once, and each tree can take hundreds of MB for a large binary.
+const maxCached = 4
+
+// fileKey identifies a version of a file.
+type fileKey struct {
+	path  string
+	size  int64
+	mtime time.Time
+}
+
+var cache struct {
+	sync.Mutex
+	data  map[fileKey]*dwarf.Data
+	trees map[*dwarf.Data]*Node
+	// order holds the cached data oldest first, for eviction
+	order []*dwarf.Data
+}
+
+// FileData returns the DWARF data of the file at path, calling load
+// to read it unless it was read before and the file hasn't changed
+// since. Data shared this way also shares its DataTree.
+func FileData(path string, load func() (*dwarf.Data, error)) (*dwarf.Data, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return load()
+	}
+	key := fileKey{path, fi.Size(), fi.ModTime()}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if d, ok := cache.data[key]; ok {
+		vlog.Tracef("debug info for %s: reusing cached DWARF", path)
+		return d, nil
+	}
+
+	d, err := load()
+	if err != nil {
+		return nil, err
+	}
+	if cache.data == nil {
+		cache.data = make(map[fileKey]*dwarf.Data)
+	}
+	cache.data[key] = d
+	cache.order = append(cache.order, d)
+	evict()
+	return d, nil
+}
+
+// DataTree returns the tree of all of d's entries. The tree is
+// built on the first call for d and reused after, including by
+// Units, so it must not be modified.
+func DataTree(d *dwarf.Data) (*Node, error) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if root, ok := cache.trees[d]; ok {
+		return root, nil
+	}
+
+	start := time.Now()
+	root, err := Tree(d.Reader())
+	if err != nil {
+		return nil, err
+	}
+	vlog.Debugf("built DWARF tree of %d entries in %s", len(root.OffsetMap), time.Since(start).Round(time.Millisecond))
+
+	if cache.trees == nil {
+		cache.trees = make(map[*dwarf.Data]*Node)
+	}
+	cache.trees[d] = root
+	if !cached(d) {
+		cache.order = append(cache.order, d)
+	}
+	evict()
+	return root, nil
+}
+
+func cached(d *dwarf.Data) bool {
+	for _, c := range cache.order {
+		if c == d {
+			return true
+		}
+	}
+	return false
+}
+
+// evict drops the oldest data and trees beyond maxCached. The cache
+// lock must be held.
+func evict() {
+	for len(cache.order) > maxCached {
+		old := cache.order[0]
+		cache.order = cache.order[1:]
+		delete(cache.trees, old)
+		for k, d := range cache.data {
+			if d == old {
+				delete(cache.data, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package dwarfutil
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// resetCache empties the cache, for tests that count loads.
+func resetCache() {
+	cache.Lock()
+	defer cache.Unlock()
+	cache.data = nil
+	cache.trees = nil
+	cache.order = nil
+}
+
+// loader returns a load func for FileData reading the DWARF of the
+// ELF file at path and counting its calls in n.
+func loader(path string, n *int) func() (*dwarf.Data, error) {
+	return func() (*dwarf.Data, error) {
+		*n++
+		f, err := elf.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ELFData(f, path)
+	}
+}
+
+func TestFileDataReused(t *testing.T) {
+	resetCache()
+	t.Cleanup(resetCache)
+	_, src := openELF(t, "c-nopie")
+	path := filepath.Join(t.TempDir(), "c-nopie")
+	copyFile(t, src, path, nil)
+
+	var loads int
+	d1, err := FileData(path, loader(path, &loads))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, err := FileData(path, loader(path, &loads))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 1 || d1 != d2 {
+		t.Errorf("got %d loads for an unchanged file, want 1 and the same data", loads)
+	}
+
+	tree1, err := DataTree(d1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree2, err := DataTree(d2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree1 != tree2 {
+		t.Error("got a new tree for the same data")
+	}
+
+	// a changed file is read again
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	d3, err := FileData(path, loader(path, &loads))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 2 || d3 == d1 {
+		t.Errorf("got %d loads after the file changed, want 2 and new data", loads)
+	}
+}
+
+func TestFileDataEvicts(t *testing.T) {
+	resetCache()
+	t.Cleanup(resetCache)
+	_, src := openELF(t, "c-nopie")
+	dir := t.TempDir()
+
+	var paths []string
+	for i := 0; i <= maxCached; i++ {
+		path := filepath.Join(dir, string(rune('a'+i)))
+		copyFile(t, src, path, nil)
+		paths = append(paths, path)
+	}
+	var loads int
+	for _, path := range paths {
+		if _, err := FileData(path, loader(path, &loads)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first was evicted, the last is still cached
+	if _, err := FileData(paths[len(paths)-1], loader(paths[len(paths)-1], &loads)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FileData(paths[0], loader(paths[0], &loads)); err != nil {
+		t.Fatal(err)
+	}
+	if want := maxCached + 2; loads != want {
+		t.Errorf("got %d loads, want %d", loads, want)
+	}
+}
+
+func BenchmarkFindDwarfCached(b *testing.B) {
+	_, path := openELF(b, "go-exe")
+	var loads int
+
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			d, err := loader(path, &loads)()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := Tree(d.Reader()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		resetCache()
+		defer resetCache()
+		lookup := func() {
+			d, err := FileData(path, loader(path, &loads))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := DataTree(d); err != nil {
+				b.Fatal(err)
+			}
+		}
+		lookup()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			lookup()
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package dwarfutil
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FindDwarfWithDebuginfod is like FindDwarf but falls back to
+// downloading the debug file from one of the given debuginfod
+// servers when no local debug file is found. If urls is nil,
+// DEBUGINFOD_URLS is used.
+func FindDwarfWithDebuginfod(path string, urls []string) (string, error) {
+	if urls == nil {
+		urls = DebuginfodURLsFromEnv()
+	}
+	return FindDwarfWithOptions(path, FindOptions{
+		DebuginfodURLs: urls,
+	})
+}
+
+// DebuginfodURLsFromEnv returns the servers listed in the
+// space separated DEBUGINFOD_URLS environment variable.
+func DebuginfodURLsFromEnv() []string {
+	return strings.Fields(os.Getenv("DEBUGINFOD_URLS"))
+}
+
+// DefaultDebuginfodCacheDir returns $DEBUGINFOD_CACHE_PATH if set,
+// otherwise a pptrace specific directory under the user cache dir.
+func DefaultDebuginfodCacheDir() string {
+	if p := os.Getenv("DEBUGINFOD_CACHE_PATH"); p != "" {
+		return p
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "pptrace", "debuginfod")
+}
+
+var debuginfodClient = &http.Client{
+	Timeout: 90 * time.Second,
+}
+
+func fetchDebuginfod(buildID string, urls []string, cacheDir string) (string, error) {
+	dest := filepath.Join(cacheDir, buildID, "debuginfo")
+	if existsAndHasDwarf(dest) {
+		return dest, nil
+	}
+
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return "", err
+	}
+
+	var errs []string
+	for _, u := range urls {
+		u = strings.TrimSuffix(u, "/") + "/buildid/" + buildID + "/debuginfo"
+		err := download(u, dest)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if !existsAndHasDwarf(dest) {
+			os.Remove(dest)
+			errs = append(errs, fmt.Sprintf("%s: no dwarf in response", u))
+			continue
+		}
+		return dest, nil
+	}
+
+	return "", fmt.Errorf("%s", strings.Join(errs, "; "))
+}
+
+func download(url, dest string) error {
+	resp, err := debuginfodClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(dest), ".debuginfo-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = io.Copy(f, resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", url, err)
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), dest)
+}
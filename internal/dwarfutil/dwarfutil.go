@@ -2,23 +2,40 @@ package dwarfutil
 
 import (
 	"bufio"
+	"bytes"
+	"compress/zlib"
 	"debug/dwarf"
 	"debug/elf"
 	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
+	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/psanford/pptrace/internal/vlog"
 )
 
 type Node struct {
 	Entry     dwarf.Entry
 	Children  []*Node
 	OffsetMap map[dwarf.Offset]*Node
+	// AddressSize is the size of a target address in bytes. It's
+	// only set on the root.
+	AddressSize int
+
+	// outside reads entries missing from OffsetMap, on the roots
+	// of units passed by Units
+	outside *outsideEntries
 }
 
-func Tree(r *dwarf.Reader) *Node {
+// Tree reads the entries from r into a tree, the root being the
+// first entry read, or an empty node if there are none.
+func Tree(r *dwarf.Reader) (*Node, error) {
 	var (
 		first = true
 		stack = make([]*Node, 0)
@@ -30,15 +47,16 @@ func Tree(r *dwarf.Reader) *Node {
 		if err == io.EOF || entry == nil {
 			break
 		} else if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 
 		if first {
 			first = false
 			root = &Node{
-				Entry:     *entry,
-				Children:  make([]*Node, 0),
-				OffsetMap: make(map[dwarf.Offset]*Node),
+				Entry:       *entry,
+				Children:    make([]*Node, 0),
+				OffsetMap:   make(map[dwarf.Offset]*Node),
+				AddressSize: r.AddressSize(),
 			}
 
 			stack = append(stack, root)
@@ -64,15 +82,320 @@ func Tree(r *dwarf.Reader) *Node {
 		}
 	}
 
-	return root
+	if root == nil {
+		root = &Node{OffsetMap: make(map[dwarf.Offset]*Node)}
+	}
+	return root, nil
+}
+
+// Walk calls fn for every descendant of n, depth first.
+func (n *Node) Walk(fn func(*Node)) {
+	for _, child := range n.Children {
+		fn(child)
+		child.Walk(fn)
+	}
+}
+
+// PCRange returns the low and high pc for a subprogram or other
+// entry with DW_AT_low_pc/DW_AT_high_pc. Since DWARF4, high_pc may
+// be encoded as a constant offset from low_pc rather than as an
+// address; both forms are handled. ok is false if the entry has
+// no low_pc.
+func PCRange(e *dwarf.Entry) (low, high uint64, ok bool) {
+	lowField := e.AttrField(dwarf.AttrLowpc)
+	if lowField == nil {
+		return 0, 0, false
+	}
+	low, ok = fieldUint(lowField.Val)
+	if !ok {
+		return 0, 0, false
+	}
+
+	highField := e.AttrField(dwarf.AttrHighpc)
+	if highField == nil {
+		return low, low, true
+	}
+	high, hok := fieldUint(highField.Val)
+	if !hok {
+		return low, low, true
+	}
+
+	if highField.Class == dwarf.ClassConstant {
+		high += low
+	}
+
+	return low, high, true
+}
+
+// opAddr is the DW_OP_addr location expression opcode.
+const opAddr = 0x03
+
+// StaticAddr returns the address of a location expression that
+// consists of a single DW_OP_addr, as used for global variables.
+func StaticAddr(loc []byte, order binary.ByteOrder) (uint64, bool) {
+	if len(loc) == 0 || loc[0] != opAddr {
+		return 0, false
+	}
+	switch len(loc) - 1 {
+	case 4:
+		return uint64(order.Uint32(loc[1:])), true
+	case 8:
+		return order.Uint64(loc[1:]), true
+	}
+	return 0, false
+}
+
+// DWARF expression opcodes understood by MemberOffset.
+const (
+	opConstu     = 0x10
+	opConsts     = 0x11
+	opPlus       = 0x22
+	opPlusUconst = 0x23
+	opLit0       = 0x30
+	opLit31      = 0x4f
+)
+
+// MemberOffset evaluates a DW_AT_data_member_location expression,
+// returning the member's offset from the start of its struct. Only
+// the constant forms compilers emit in practice, such as
+// DW_OP_plus_uconst N, are supported; ok is false for anything
+// else.
+func MemberOffset(loc []byte) (int64, bool) {
+	// the address of the containing struct is pushed before
+	// evaluation; using 0 leaves the offset on the stack
+	stack := []int64{0}
+	for len(loc) > 0 {
+		op := loc[0]
+		loc = loc[1:]
+		switch {
+		case op == opConstu:
+			v, n := uleb128(loc)
+			if n == 0 {
+				return 0, false
+			}
+			stack = append(stack, int64(v))
+			loc = loc[n:]
+		case op == opConsts:
+			v, n := sleb128(loc)
+			if n == 0 {
+				return 0, false
+			}
+			stack = append(stack, v)
+			loc = loc[n:]
+		case op >= opLit0 && op <= opLit31:
+			stack = append(stack, int64(op-opLit0))
+		case op == opPlusUconst:
+			v, n := uleb128(loc)
+			if n == 0 {
+				return 0, false
+			}
+			stack[len(stack)-1] += int64(v)
+			loc = loc[n:]
+		case op == opPlus:
+			if len(stack) < 2 {
+				return 0, false
+			}
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			stack[len(stack)-1] += top
+		default:
+			return 0, false
+		}
+	}
+	return stack[len(stack)-1], true
+}
+
+// DWARF expression opcodes understood by LocationPieces.
+const (
+	opReg0   = 0x50
+	opReg31  = 0x6f
+	opBreg0  = 0x70
+	opBreg31 = 0x8f
+	opRegx   = 0x90
+	opFbreg  = 0x91
+	opBregx  = 0x92
+	opPiece  = 0x93
+)
+
+// LocKind is where a piece of a value is.
+type LocKind int
+
+const (
+	// LocMissing pieces have been optimized out.
+	LocMissing LocKind = iota
+	// LocReg pieces are held in register Reg.
+	LocReg
+	// LocBreg pieces are in memory at register Reg plus Offset.
+	LocBreg
+	// LocFbreg pieces are in memory at the frame base plus Offset.
+	LocFbreg
+)
+
+// LocPiece is part of a value described by a location expression.
+type LocPiece struct {
+	Kind   LocKind
+	Reg    uint64
+	Offset int64
+	// Size is the size of the piece in bytes, or 0 if the
+	// expression describes the whole value with no DW_OP_piece.
+	Size uint64
+}
+
+// LocationPieces decodes a location expression made of register,
+// register relative and frame base relative locations, optionally
+// split into pieces, as compilers describe arguments. ok is false
+// for expressions computing the value some other way.
+func LocationPieces(loc []byte) (pieces []LocPiece, ok bool) {
+	var cur *LocPiece
+	for len(loc) > 0 {
+		op := loc[0]
+		loc = loc[1:]
+
+		if op != opPiece {
+			if cur != nil {
+				// two locations for one piece is a computation
+				return nil, false
+			}
+			cur = &LocPiece{}
+		}
+
+		// n is the operand bytes read, 0 if they're truncated
+		var n int
+		switch {
+		case op >= opReg0 && op <= opReg31:
+			cur.Kind, cur.Reg = LocReg, uint64(op-opReg0)
+			continue
+		case op == opRegx:
+			cur.Kind = LocReg
+			cur.Reg, n = uleb128(loc)
+		case op >= opBreg0 && op <= opBreg31:
+			cur.Kind, cur.Reg = LocBreg, uint64(op-opBreg0)
+			cur.Offset, n = sleb128(loc)
+		case op == opBregx:
+			cur.Kind = LocBreg
+			cur.Reg, n = uleb128(loc)
+			if n > 0 {
+				var m int
+				cur.Offset, m = sleb128(loc[n:])
+				n += m
+				if m == 0 {
+					n = 0
+				}
+			}
+		case op == opFbreg:
+			cur.Kind = LocFbreg
+			cur.Offset, n = sleb128(loc)
+		case op == opPiece:
+			if cur == nil {
+				// a piece with no location is optimized out
+				cur = &LocPiece{Kind: LocMissing}
+			}
+			cur.Size, n = uleb128(loc)
+			pieces = append(pieces, *cur)
+			cur = nil
+		default:
+			return nil, false
+		}
+		if n == 0 {
+			return nil, false
+		}
+		loc = loc[n:]
+	}
+
+	if cur != nil {
+		if len(pieces) > 0 {
+			// only the last piece may omit DW_OP_piece
+			return nil, false
+		}
+		pieces = append(pieces, *cur)
+	}
+	return pieces, len(pieces) > 0
+}
+
+// uleb128 decodes an unsigned LEB128 value from b, returning it
+// and the number of bytes read, or 0 bytes if b is truncated.
+func uleb128(b []byte) (uint64, int) {
+	var (
+		v     uint64
+		shift uint
+	)
+	for i, c := range b {
+		v |= uint64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// sleb128 decodes a signed LEB128 value from b, returning it and
+// the number of bytes read, or 0 bytes if b is truncated.
+func sleb128(b []byte) (int64, int) {
+	var (
+		v     int64
+		shift uint
+	)
+	for i, c := range b {
+		v |= int64(c&0x7f) << shift
+		shift += 7
+		if c&0x80 == 0 {
+			if shift < 64 && c&0x40 != 0 {
+				v |= -1 << shift
+			}
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
+
+func fieldUint(v interface{}) (uint64, bool) {
+	switch v := v.(type) {
+	case uint64:
+		return v, true
+	case int64:
+		return uint64(v), true
+	}
+	return 0, false
+}
+
+// ErrNoDebugInfo is returned by FindDwarf when neither the file
+// nor any separate debug file has DWARF.
+var ErrNoDebugInfo = errors.New("no debug symbols found")
+
+// FindOptions controls how FindDwarfWithOptions searches for
+// separate debug files.
+type FindOptions struct {
+	// SkipCRCCheck disables verification of the .gnu_debuglink
+	// CRC32 against candidate debug files.
+	SkipCRCCheck bool
+
+	// DebuginfodURLs are debuginfod servers to query by build id
+	// when no local debug file is found. The network is only used
+	// if this is non-empty.
+	DebuginfodURLs []string
+
+	// DebuginfodCacheDir is where downloaded debug files are stored.
+	// If empty, DefaultDebuginfodCacheDir is used.
+	DebuginfodCacheDir string
 }
 
+// ExtraDebugDirs are searched for separate debug files after
+// /usr/lib/debug, by build id and by debuglink in the same layout.
+var ExtraDebugDirs []string
+
 // FindDwarf returns the path to the elf containing debug
 // symbols for the given path. If debug symbols are present
 // in the original path, that path is returned.
 //
 // Logic based on https://sourceware.org/gdb/onlinedocs/gdb/Separate-Debug-Files.html
 func FindDwarf(path string) (string, error) {
+	return FindDwarfWithOptions(path, FindOptions{})
+}
+
+// FindDwarfWithOptions is like FindDwarf but allows the search
+// behavior to be customized.
+func FindDwarfWithOptions(path string, opts FindOptions) (string, error) {
 	e, err := elf.Open(path)
 	if err != nil {
 		return "", err
@@ -86,32 +409,61 @@ func FindDwarf(path string) (string, error) {
 
 	buildID := readBuildID(e)
 
-	pathsToCheck := make([]string, 0, 4)
+	debugDirs := append([]string{"/usr/lib/debug"}, ExtraDebugDirs...)
 
 	if buildID != "" {
 		prefix := buildID[:2]
 		suffix := buildID[2:] + ".debug"
 
-		pathsToCheck = append(pathsToCheck, filepath.Join("/usr/lib/debug/.build-id", prefix, suffix))
+		for _, dir := range debugDirs {
+			p := filepath.Join(dir, ".build-id", prefix, suffix)
+			vlog.Tracef("debug info for %s: checking %s", path, p)
+			if existsAndHasDwarf(p) {
+				vlog.Debugf("debug info for %s: using %s", path, p)
+				return p, nil
+			}
+		}
 	}
 
 	dbgLink := readDebugLink(e)
 	if dbgLink != nil {
 		origDir := filepath.Dir(path)
-		pathsToCheck = append(pathsToCheck,
+		pathsToCheck := []string{
 			filepath.Join(origDir, dbgLink.name),
 			filepath.Join(origDir, ".debug", dbgLink.name),
-			filepath.Join("/usr/lib/debug", origDir, dbgLink.name),
-		)
+		}
+		for _, dir := range debugDirs {
+			pathsToCheck = append(pathsToCheck, filepath.Join(dir, origDir, dbgLink.name))
+		}
+
+		for _, p := range pathsToCheck {
+			vlog.Tracef("debug info for %s: checking %s", path, p)
+			if !existsAndHasDwarf(p) {
+				continue
+			}
+			if !opts.SkipCRCCheck && !crcMatches(p, dbgLink.crc) {
+				log.Printf("skipping %s: debuglink crc mismatch", p)
+				continue
+			}
+			vlog.Debugf("debug info for %s: using %s", path, p)
+			return p, nil
+		}
 	}
 
-	for _, p := range pathsToCheck {
-		if existsAndHasDwarf(p) {
+	if buildID != "" && len(opts.DebuginfodURLs) > 0 {
+		cacheDir := opts.DebuginfodCacheDir
+		if cacheDir == "" {
+			cacheDir = DefaultDebuginfodCacheDir()
+		}
+		p, err := fetchDebuginfod(buildID, opts.DebuginfodURLs, cacheDir)
+		if err == nil {
+			vlog.Debugf("debug info for %s: using %s from debuginfod", path, p)
 			return p, nil
 		}
+		log.Printf("debuginfod lookup failed: %s", err)
 	}
 
-	return "", fmt.Errorf("no debug symbols found")
+	return "", ErrNoDebugInfo
 }
 
 func existsAndHasDwarf(p string) bool {
@@ -124,56 +476,137 @@ func existsAndHasDwarf(p string) bool {
 	return err == nil
 }
 
-func readDebugLink(e *elf.File) *debugLink {
-	s := e.Section(".gnu_debuglink")
+// crcMatches reports whether the CRC32 of the file at p
+// matches the checksum recorded in .gnu_debuglink.
+func crcMatches(p string, want uint32) bool {
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return false
+	}
+
+	return h.Sum32() == want
+}
+
+// SectionData returns the uncompressed contents of the named section.
+// Sections compressed with SHF_COMPRESSED are handled by debug/elf;
+// for .debug_* names the legacy GNU .zdebug_* form is also checked.
+func SectionData(e *elf.File, name string) ([]byte, error) {
+	s := e.Section(name)
+	if s != nil {
+		return s.Data()
+	}
+
+	if !strings.HasPrefix(name, ".debug_") {
+		return nil, fmt.Errorf("section %s not found", name)
+	}
+
+	zname := ".zdebug_" + name[len(".debug_"):]
+	s = e.Section(zname)
 	if s == nil {
+		return nil, fmt.Errorf("section %s not found", name)
+	}
+
+	data, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	return decompressZdebug(data)
+}
+
+// maxZlibRatio is the most deflate can compress data by, so a
+// larger claimed size is corrupt.
+const maxZlibRatio = 1032
+
+// decompressZdebug decodes a legacy .zdebug section: the magic
+// "ZLIB", an 8 byte big endian uncompressed size, then zlib data.
+func decompressZdebug(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "ZLIB" {
+		// not actually compressed
+		return data, nil
+	}
+
+	size := binary.BigEndian.Uint64(data[4:12])
+	if size > uint64(len(data)-12)*maxZlibRatio {
+		return nil, fmt.Errorf("decompress zdebug err: size %d is too large for %d bytes of compressed data", size, len(data)-12)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data[12:]))
+	if err != nil {
+		return nil, fmt.Errorf("decompress zdebug err: %w", err)
+	}
+	defer zr.Close()
+
+	// the size is only trusted as far as the data bears it out
+	out, err := io.ReadAll(io.LimitReader(zr, int64(size)+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompress zdebug err: %w", err)
+	}
+	if uint64(len(out)) != size {
+		return nil, fmt.Errorf("decompress zdebug err: got %d bytes, header says %d", len(out), size)
+	}
+
+	return out, nil
+}
+
+func readDebugLink(e *elf.File) *debugLink {
+	data, err := SectionData(e, ".gnu_debuglink")
+	if err != nil {
 		return nil
 	}
 
-	r := bufio.NewReader(s.Open())
+	r := bufio.NewReader(bytes.NewReader(data))
 	name, err := r.ReadBytes(0)
 	if err != nil {
 		return nil
 	}
 
-	pad := len(name) % 4
+	// the crc is aligned to the next 4 byte boundary after the name
+	pad := (4 - len(name)%4) % 4
 	_, err = r.Discard(pad)
 	if err != nil {
 		return nil
 	}
 
-	crc32 := make([]byte, 4)
-	_, err = io.ReadFull(r, crc32)
+	crc := make([]byte, 4)
+	_, err = io.ReadFull(r, crc)
 	if err != nil {
 		return nil
 	}
 
 	return &debugLink{
 		name: string(name[:len(name)-1]),
-		crc:  crc32,
+		crc:  e.ByteOrder.Uint32(crc),
 	}
 }
 
 type debugLink struct {
 	name string
-	crc  []byte
+	crc  uint32
 }
 
 func readBuildID(e *elf.File) string {
-	s := e.Section(".note.gnu.build-id")
-	if s == nil {
+	data, err := SectionData(e, ".note.gnu.build-id")
+	if err != nil {
 		return ""
 	}
 
-	r := s.Open()
+	r := bytes.NewReader(data)
 	var bh buildIDHeader
 
-	err := binary.Read(r, binary.LittleEndian, bh)
+	err = binary.Read(r, e.ByteOrder, &bh)
 	if err != nil {
 		return ""
 	}
 
-	name := make([]byte, bh.Namesz)
+	// the name is padded to a 4 byte boundary
+	name := make([]byte, (bh.Namesz+3)&^3)
 
 	_, err = io.ReadFull(r, name)
 	if err != nil {
@@ -0,0 +1,184 @@
+package dwarfutil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// zdebug returns data compressed as a .zdebug section whose header
+// gives its size as size.
+func zdebug(data []byte, size uint64) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("ZLIB")
+	binary.Write(&buf, binary.BigEndian, size)
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestDecompressZdebug(t *testing.T) {
+	data := bytes.Repeat([]byte("debug info "), 100)
+	huge := zdebug(nil, 0)
+	binary.BigEndian.PutUint64(huge[4:], 1<<62)
+
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte
+		err  string
+	}{
+		{name: "compressed", in: zdebug(data, uint64(len(data))), want: data},
+		{name: "empty", in: zdebug(nil, 0), want: []byte{}},
+		{name: "uncompressed", in: data, want: data},
+		{name: "size too small", in: zdebug(data, uint64(len(data))-1), err: "header says"},
+		{name: "size too large", in: zdebug(data, uint64(len(data))+1), err: "header says"},
+		{name: "size beyond ratio", in: huge, err: "too large"},
+		{name: "corrupt", in: append([]byte("ZLIB\x00\x00\x00\x00\x00\x00\x00\x10"), "not zlib"...), err: "zlib"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decompressZdebug(tt.in)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got err %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("got %d bytes, want %d", len(got), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestCompressedSections(t *testing.T) {
+	tests := []struct {
+		fixture string
+		// section is how .debug_info is stored
+		section string
+		flags   elf.SectionFlag
+	}{
+		{"c-nopie", ".debug_info", 0},
+		{"c-zdebug", ".zdebug_info", 0},
+		{"c-gz", ".debug_info", elf.SHF_COMPRESSED},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			f, path := openELF(t, tt.fixture)
+			s := f.Section(tt.section)
+			if s == nil || s.Flags&elf.SHF_COMPRESSED != tt.flags {
+				t.Fatalf("fixture has no %s section with flags %s", tt.section, tt.flags)
+			}
+
+			info, err := SectionData(f, ".debug_info")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the first unit's header gives its length, then its
+			// version
+			if len(info) < 6 || int(binary.LittleEndian.Uint32(info))+4 > len(info) {
+				t.Fatalf("got %d bytes of .debug_info, which doesn't start with a unit header", len(info))
+			}
+			if v := binary.LittleEndian.Uint16(info[4:]); v < 2 || v > 5 {
+				t.Errorf("got unit version %d, want 2 to 5", v)
+			}
+
+			d, err := ELFData(f, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			add := findEntry(t, d, dwarf.TagSubprogram, "add")
+			if low, high, ok := PCRange(add); !ok || high <= low {
+				t.Errorf("add: got pc range 0x%x-0x%x, %v", low, high, ok)
+			}
+		})
+	}
+}
+
+func TestPCRange(t *testing.T) {
+	field := func(attr dwarf.Attr, val interface{}, class dwarf.Class) dwarf.Field {
+		return dwarf.Field{Attr: attr, Val: val, Class: class}
+	}
+	tests := []struct {
+		name      string
+		fields    []dwarf.Field
+		low, high uint64
+		ok        bool
+	}{
+		{
+			name: "address",
+			fields: []dwarf.Field{
+				field(dwarf.AttrLowpc, uint64(0x1000), dwarf.ClassAddress),
+				field(dwarf.AttrHighpc, uint64(0x1040), dwarf.ClassAddress),
+			},
+			low: 0x1000, high: 0x1040, ok: true,
+		},
+		{
+			name: "offset",
+			fields: []dwarf.Field{
+				field(dwarf.AttrLowpc, uint64(0x1000), dwarf.ClassAddress),
+				field(dwarf.AttrHighpc, int64(0x40), dwarf.ClassConstant),
+			},
+			low: 0x1000, high: 0x1040, ok: true,
+		},
+		{
+			name: "no high_pc",
+			fields: []dwarf.Field{
+				field(dwarf.AttrLowpc, uint64(0x1000), dwarf.ClassAddress),
+			},
+			low: 0x1000, high: 0x1000, ok: true,
+		},
+		{name: "no low_pc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			low, high, ok := PCRange(&dwarf.Entry{Tag: dwarf.TagSubprogram, Field: tt.fields})
+			if low != tt.low || high != tt.high || ok != tt.ok {
+				t.Errorf("got 0x%x, 0x%x, %v; want 0x%x, 0x%x, %v", low, high, ok, tt.low, tt.high, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPCRangeDWARF4(t *testing.T) {
+	f, path := openELF(t, "c-dwarf4")
+	d, err := ELFData(f, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"main", "add", "scale"} {
+		e := findEntry(t, d, dwarf.TagSubprogram, name)
+		if hf := e.AttrField(dwarf.AttrHighpc); hf == nil || hf.Class != dwarf.ClassConstant {
+			t.Fatalf("%s: fixture's high_pc isn't an offset: %+v", name, hf)
+		}
+		low, high, ok := PCRange(e)
+		if !ok {
+			t.Fatalf("%s: no pc range", name)
+		}
+		for _, s := range syms {
+			if s.Name != name || elf.ST_TYPE(s.Info) != elf.STT_FUNC {
+				continue
+			}
+			if low != s.Value || high != s.Value+s.Size {
+				t.Errorf("%s: got 0x%x-0x%x, want the symbol's 0x%x-0x%x", name, low, high, s.Value, s.Value+s.Size)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package dwarfutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/psanford/pptrace/internal/fixture"
+)
+
+// copyFile copies src to dst, appending extra.
+func copyFile(tb testing.TB, src, dst string, extra []byte) {
+	tb.Helper()
+	data, err := os.ReadFile(src)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(dst, append(data, extra...), 0755); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestFindDwarfDebuglinkCRC(t *testing.T) {
+	exe := fixture.Path(t, "c-debuglink")
+
+	got, err := FindDwarf(exe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := exe + ".debug"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// a trailing byte leaves the debug file readable but changes
+	// its CRC
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "c-debuglink")
+	copyFile(t, exe, stale, nil)
+	copyFile(t, exe+".debug", stale+".debug", []byte{0})
+
+	if got, err := FindDwarf(stale); !errors.Is(err, ErrNoDebugInfo) {
+		t.Errorf("with a corrupted debug file got %q, %v; want ErrNoDebugInfo", got, err)
+	}
+	got, err = FindDwarfWithOptions(stale, FindOptions{SkipCRCCheck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := stale + ".debug"; got != want {
+		t.Errorf("with SkipCRCCheck got %s, want %s", got, want)
+	}
+}
+
+func TestFindDwarfInFile(t *testing.T) {
+	for _, name := range []string{"c-nopie", "c-gz", "go-exe"} {
+		path := fixture.Path(t, name)
+		got, err := FindDwarf(path)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if got != path {
+			t.Errorf("%s: got %s, want the file itself", name, got)
+		}
+	}
+	if got, err := FindDwarf(fixture.Path(t, "c-nodebug")); !errors.Is(err, ErrNoDebugInfo) {
+		t.Errorf("c-nodebug: got %q, %v; want ErrNoDebugInfo", got, err)
+	}
+}
@@ -0,0 +1,390 @@
+package dwarfutil
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Attribute forms, which debug/dwarf doesn't export, and the GNU
+// ones DWARF 4 split units use.
+const (
+	formAddr          = 0x01
+	formBlock2        = 0x03
+	formBlock4        = 0x04
+	formData2         = 0x05
+	formData4         = 0x06
+	formData8         = 0x07
+	formString        = 0x08
+	formBlock         = 0x09
+	formBlock1        = 0x0a
+	formData1         = 0x0b
+	formFlag          = 0x0c
+	formSdata         = 0x0d
+	formStrp          = 0x0e
+	formUdata         = 0x0f
+	formRefAddr       = 0x10
+	formRef1          = 0x11
+	formRef2          = 0x12
+	formRef4          = 0x13
+	formRef8          = 0x14
+	formRefUdata      = 0x15
+	formIndirect      = 0x16
+	formSecOffset     = 0x17
+	formExprloc       = 0x18
+	formFlagPresent   = 0x19
+	formStrx          = 0x1a
+	formAddrx         = 0x1b
+	formRefSup4       = 0x1c
+	formStrpSup       = 0x1d
+	formData16        = 0x1e
+	formLineStrp      = 0x1f
+	formRefSig8       = 0x20
+	formImplicitConst = 0x21
+	formLoclistx      = 0x22
+	formRnglistx      = 0x23
+	formRefSup8       = 0x24
+	formStrx1         = 0x25
+	formStrx2         = 0x26
+	formStrx3         = 0x27
+	formStrx4         = 0x28
+	formAddrx1        = 0x29
+	formAddrx2        = 0x2a
+	formAddrx3        = 0x2b
+	formAddrx4        = 0x2c
+
+	formGNUAddrIndex = 0x1f01
+	formGNUStrIndex  = 0x1f02
+	formGNURefAlt    = 0x1f20
+	formGNUStrpAlt   = 0x1f21
+)
+
+// DWARF 5 range list entry kinds used for translated DWARF 4 lists.
+const (
+	rleEndOfList   = 0x00
+	rleOffsetPair  = 0x04
+	rleBaseAddress = 0x05
+)
+
+// gnuHeaderGrowth is how much longer the header of a DWARF 5 split
+// compile unit is than a DWARF 4 one: a unit type, and the dwo id
+// that DWARF 4 had in an attribute.
+const gnuHeaderGrowth = 20 - 11
+
+// locListAttrs are the attributes whose DW_FORM_sec_offset values
+// are location lists, and rangeListAttrs those whose are range lists.
+var (
+	locListAttrs = map[dwarf.Attr]bool{
+		dwarf.AttrLocation:      true,
+		dwarf.AttrStringLength:  true,
+		dwarf.AttrReturnAddr:    true,
+		dwarf.AttrDataMemberLoc: true,
+		dwarf.AttrFrameBase:     true,
+		dwarf.AttrSegment:       true,
+		dwarf.AttrStaticLink:    true,
+		dwarf.AttrUseLocation:   true,
+		dwarf.AttrVtableElemLoc: true,
+	}
+	rangeListAttrs = map[dwarf.Attr]bool{
+		dwarf.AttrRanges:     true,
+		dwarf.AttrStartScope: true,
+	}
+)
+
+// convertGNU returns unit, a GCC DWARF 4 split unit whose header
+// is h, as a DWARF 5 split compile unit. Its references move along
+// by the longer header, and its location and range lists, in GNU
+// and DWARF 4 formats, are translated to DWARF 5 lists appended to
+// m's. loc is the part's .debug_loc.dwo.
+func (m *splitMerge) convertGNU(unit []byte, h splitHeader, decls []abbrevDecl, loc []byte) ([]byte, splitHeader, error) {
+	order := m.order
+	addrSize := int(unit[h.size-1])
+	body := unit[h.size:]
+
+	type listRef struct {
+		val    []byte
+		ranges bool
+	}
+	var (
+		id    uint64
+		hasID bool
+		lists []listRef
+	)
+	err := unitFields(body, decls, addrSize, order, func(attr dwarf.Attr, form uint64, val []byte) error {
+		var ref, max uint64
+		switch form {
+		case formRef1:
+			ref, max = uint64(val[0]), math.MaxUint8
+		case formRef2:
+			ref, max = uint64(order.Uint16(val)), math.MaxUint16
+		case formRef4:
+			ref, max = uint64(order.Uint32(val)), math.MaxUint32
+		case formRef8:
+			ref, max = order.Uint64(val), math.MaxUint64
+		case formRefUdata:
+			return errors.New("DW_FORM_ref_udata in a DWARF 4 split unit is not supported")
+		case formData8:
+			if attr == attrGNUDwoID {
+				id, hasID = order.Uint64(val), true
+			}
+			return nil
+		case formSecOffset:
+			if locListAttrs[attr] || rangeListAttrs[attr] {
+				lists = append(lists, listRef{val, rangeListAttrs[attr]})
+			}
+			return nil
+		default:
+			return nil
+		}
+		if ref > max-gnuHeaderGrowth {
+			return fmt.Errorf("reference 0x%x out of range", ref)
+		}
+		ref += gnuHeaderGrowth
+		switch form {
+		case formRef1:
+			val[0] = byte(ref)
+		case formRef2:
+			order.PutUint16(val, uint16(ref))
+		case formRef4:
+			order.PutUint32(val, uint32(ref))
+		case formRef8:
+			order.PutUint64(val, ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, h, err
+	}
+	if !hasID {
+		return nil, h, errors.New("DWARF 4 split unit has no DW_AT_GNU_dwo_id")
+	}
+
+	s := m.skeletons[id]
+	if s == nil {
+		s = &skeleton{}
+	}
+	// a list may be used by several entries
+	locs := make(map[uint32]int)
+	ranges := make(map[uint32]int)
+	for _, l := range lists {
+		off := order.Uint32(l.val)
+		var (
+			at  int
+			ok  bool
+			err error
+		)
+		if l.ranges {
+			if at, ok = ranges[off]; !ok {
+				at, err = m.translateRanges(uint64(s.rangesBase)+uint64(off), s.lowPC, addrSize)
+				ranges[off] = at
+			}
+		} else {
+			if at, ok = locs[off]; !ok {
+				at, err = m.translateLoc(loc, uint64(off))
+				locs[off] = at
+			}
+		}
+		if err != nil {
+			return nil, h, err
+		}
+		if uint64(at) > math.MaxUint32 {
+			return nil, h, errors.New("merged lists too large")
+		}
+		order.PutUint32(l.val, uint32(at))
+	}
+
+	out := make([]byte, h.size+gnuHeaderGrowth, len(unit)+gnuHeaderGrowth)
+	order.PutUint32(out, uint32(len(out)+len(body)-4))
+	order.PutUint16(out[4:], 5)
+	out[6] = utSplitCompile
+	out[7] = byte(addrSize)
+	order.PutUint32(out[8:], order.Uint32(unit[h.abbrevAt:]))
+	order.PutUint64(out[12:], id)
+	out = append(out, body...)
+	h, err = readSplitHeader(out, order)
+	return out, h, err
+}
+
+// translateLoc appends the GNU split location list at off in loc to
+// m.loclists as a DWARF 5 list, returning where it starts. The GNU
+// entry kinds have the numbers of the DWARF 5 ones they became, but
+// a 4 byte length and a 2 byte expression size.
+func (m *splitMerge) translateLoc(loc []byte, off uint64) (int, error) {
+	start := len(m.loclists)
+	b := reader{data: loc, off: off, order: m.order}
+	out := m.loclists
+	for {
+		kind := b.u8()
+		switch kind {
+		case lleEndOfList:
+			if b.err != nil {
+				return 0, fmt.Errorf(".debug_loc.dwo: %w", b.err)
+			}
+			m.loclists = append(out, lleEndOfList)
+			return start, nil
+		case lleBaseAddressx:
+			out = appendUleb(append(out, kind), b.uleb())
+			continue
+		case lleStartxEndx:
+			out = appendUleb(append(out, kind), b.uleb())
+			out = appendUleb(out, b.uleb())
+		case lleStartxLength:
+			out = appendUleb(append(out, kind), b.uleb())
+			out = appendUleb(out, uint64(b.u32()))
+		default:
+			return 0, fmt.Errorf(".debug_loc.dwo: unknown entry kind 0x%x at 0x%x", kind, b.off-1)
+		}
+		expr := b.bytes(uint64(b.u16()))
+		if b.err != nil {
+			return 0, fmt.Errorf(".debug_loc.dwo: %w", b.err)
+		}
+		out = appendUleb(out, uint64(len(expr)))
+		out = append(out, expr...)
+	}
+}
+
+// translateRanges appends the DWARF 4 range list at off in m.ranges
+// to m.rnglists as a DWARF 5 list, returning where it starts. Its
+// offsets are from base, the skeleton's low_pc, which the list
+// starts by setting as the converted unit has no low_pc of its own.
+func (m *splitMerge) translateRanges(off, base uint64, addrSize int) (int, error) {
+	start := len(m.rnglists)
+	b := reader{data: m.ranges, off: off, order: m.order, addrSize: addrSize}
+	maxAddr := ^uint64(0)
+	if addrSize == 4 {
+		maxAddr = 0xffffffff
+	}
+	out := m.appendAddr(append(m.rnglists, rleBaseAddress), base, addrSize)
+	for {
+		begin, end := b.addr(), b.addr()
+		if b.err != nil {
+			return 0, fmt.Errorf(".debug_ranges: %w", b.err)
+		}
+		switch {
+		case begin == 0 && end == 0:
+			m.rnglists = append(out, rleEndOfList)
+			return start, nil
+		case begin == maxAddr:
+			out = m.appendAddr(append(out, rleBaseAddress), end, addrSize)
+		default:
+			out = appendUleb(append(out, rleOffsetPair), begin)
+			out = appendUleb(out, end)
+		}
+	}
+}
+
+func (m *splitMerge) appendAddr(b []byte, addr uint64, addrSize int) []byte {
+	var buf [8]byte
+	if addrSize == 4 {
+		m.order.PutUint32(buf[:], uint32(addr))
+	} else {
+		m.order.PutUint64(buf[:], addr)
+	}
+	return append(b, buf[:addrSize]...)
+}
+
+// unitFields calls fn with the attribute, form and encoded value of
+// each attribute of the entries in data, the part of a unit after
+// its header, as decls describe them.
+func unitFields(data []byte, decls []abbrevDecl, addrSize int, order binary.ByteOrder, fn func(attr dwarf.Attr, form uint64, val []byte) error) error {
+	byCode := make(map[uint64]*abbrevDecl, len(decls))
+	for i := range decls {
+		byCode[decls[i].code] = &decls[i]
+	}
+	for at := 0; at < len(data); {
+		code, n := uleb128(data[at:])
+		if n == 0 {
+			return fmt.Errorf("truncated entry at 0x%x", at)
+		}
+		at += n
+		if code == 0 {
+			continue
+		}
+		d := byCode[code]
+		if d == nil {
+			return fmt.Errorf("unknown abbrev code %d at 0x%x", code, at-n)
+		}
+		for _, f := range d.fields {
+			form := f.form
+			if form == formIndirect {
+				if form, n = uleb128(data[at:]); n == 0 {
+					return fmt.Errorf("truncated entry at 0x%x", at)
+				}
+				at += n
+			}
+			size, err := formSize(data[at:], form, addrSize, order)
+			if err != nil {
+				return fmt.Errorf("entry at 0x%x: %w", at, err)
+			}
+			if err := fn(dwarf.Attr(f.attr), form, data[at:at+size]); err != nil {
+				return err
+			}
+			at += size
+		}
+	}
+	return nil
+}
+
+// formSize returns the size of the value of form at the start of b.
+func formSize(b []byte, form uint64, addrSize int, order binary.ByteOrder) (int, error) {
+	var size uint64
+	switch form {
+	case formFlagPresent, formImplicitConst:
+	case formData1, formRef1, formFlag, formStrx1, formAddrx1:
+		size = 1
+	case formData2, formRef2, formStrx2, formAddrx2:
+		size = 2
+	case formStrx3, formAddrx3:
+		size = 3
+	case formData4, formRef4, formStrp, formSecOffset, formRefAddr, formLineStrp, formStrpSup, formRefSup4, formStrx4, formAddrx4, formGNURefAlt, formGNUStrpAlt:
+		size = 4
+	case formData8, formRef8, formRefSig8, formRefSup8:
+		size = 8
+	case formData16:
+		size = 16
+	case formAddr:
+		size = uint64(addrSize)
+	case formSdata, formUdata, formRefUdata, formStrx, formAddrx, formLoclistx, formRnglistx, formGNUAddrIndex, formGNUStrIndex:
+		_, n := uleb128(b)
+		if n == 0 {
+			return 0, errors.New("truncated value")
+		}
+		size = uint64(n)
+	case formString:
+		i := bytes.IndexByte(b, 0)
+		if i < 0 {
+			return 0, errors.New("unterminated string")
+		}
+		size = uint64(i) + 1
+	case formBlock1:
+		if len(b) < 1 {
+			return 0, errors.New("truncated value")
+		}
+		size = 1 + uint64(b[0])
+	case formBlock2:
+		if len(b) < 2 {
+			return 0, errors.New("truncated value")
+		}
+		size = 2 + uint64(order.Uint16(b))
+	case formBlock4:
+		if len(b) < 4 {
+			return 0, errors.New("truncated value")
+		}
+		size = 4 + uint64(order.Uint32(b))
+	case formBlock, formExprloc:
+		v, n := uleb128(b)
+		if n == 0 || v > uint64(len(b)) {
+			return 0, errors.New("truncated value")
+		}
+		size = uint64(n) + v
+	default:
+		return 0, fmt.Errorf("unknown form 0x%x", form)
+	}
+	if size > uint64(len(b)) {
+		return 0, errors.New("truncated value")
+	}
+	return int(size), nil
+}
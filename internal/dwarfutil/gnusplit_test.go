@@ -0,0 +1,84 @@
+package dwarfutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestTranslateLoc(t *testing.T) {
+	// a GNU split location list: a base address, an entry by
+	// start index and length, and one by start and end index
+	loc := []byte{
+		0xff, // before the list
+		lleBaseAddressx, 0x01,
+		lleStartxLength, 0x02, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x55,
+		lleStartxEndx, 0x03, 0x04, 0x02, 0x00, 0x50, 0x9f,
+		lleEndOfList,
+	}
+	m := splitMerge{order: binary.LittleEndian, loclists: []byte{0xaa}}
+	start, err := m.translateLoc(loc, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 1 {
+		t.Errorf("got list at %d, want 1", start)
+	}
+	want := []byte{
+		0xaa,
+		lleBaseAddressx, 0x01,
+		lleStartxLength, 0x02, 0x10, 0x01, 0x55,
+		lleStartxEndx, 0x03, 0x04, 0x02, 0x50, 0x9f,
+		lleEndOfList,
+	}
+	if !bytes.Equal(m.loclists, want) {
+		t.Errorf("got loclists % x, want % x", m.loclists, want)
+	}
+
+	for _, bad := range [][]byte{
+		{0x08, 0x00},
+		{lleStartxLength, 0x02, 0x10},
+	} {
+		m := splitMerge{order: binary.LittleEndian}
+		if _, err := m.translateLoc(bad, 0); err == nil {
+			t.Errorf("translateLoc(% x) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestTranslateRanges(t *testing.T) {
+	// a DWARF 4 range list of 4 byte addresses with a base address
+	// selection entry
+	m := splitMerge{
+		order: binary.LittleEndian,
+		ranges: []byte{
+			0x10, 0x00, 0x00, 0x00, 0x20, 0x00, 0x00, 0x00,
+			0xff, 0xff, 0xff, 0xff, 0x00, 0x50, 0x00, 0x00,
+			0x04, 0x00, 0x00, 0x00, 0x08, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		},
+	}
+	start, err := m.translateRanges(0, 0x1000, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 0 {
+		t.Errorf("got list at %d, want 0", start)
+	}
+	want := []byte{
+		rleBaseAddress, 0x00, 0x10, 0x00, 0x00,
+		rleOffsetPair, 0x10, 0x20,
+		rleBaseAddress, 0x00, 0x50, 0x00, 0x00,
+		rleOffsetPair, 0x04, 0x08,
+		rleEndOfList,
+	}
+	if !bytes.Equal(m.rnglists, want) {
+		t.Errorf("got rnglists % x, want % x", m.rnglists, want)
+	}
+
+	// a list running off the end of the section
+	m = splitMerge{order: binary.LittleEndian, ranges: []byte{0x10, 0x00}}
+	if _, err := m.translateRanges(0, 0, 8); err == nil {
+		t.Error("translateRanges of a truncated list succeeded, want an error")
+	}
+}
@@ -0,0 +1,48 @@
+package dwarfutil
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"os"
+	"testing"
+
+	"github.com/psanford/pptrace/internal/fixture"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	fixture.Cleanup()
+	os.Exit(code)
+}
+
+// openELF builds the named fixture and opens it, closing it when
+// the test ends.
+func openELF(tb testing.TB, name string) (*elf.File, string) {
+	tb.Helper()
+	path := fixture.Path(tb, name)
+	f, err := elf.Open(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { f.Close() })
+	return f, path
+}
+
+// findEntry returns the first entry of d with the given tag and
+// name.
+func findEntry(tb testing.TB, d *dwarf.Data, tag dwarf.Tag, name string) *dwarf.Entry {
+	tb.Helper()
+	r := d.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if e == nil {
+			tb.Fatalf("no %s %s in the debug info", tag, name)
+		}
+		if e.Tag == tag && e.Val(dwarf.AttrName) == name {
+			return e
+		}
+	}
+}
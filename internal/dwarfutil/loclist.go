@@ -0,0 +1,326 @@
+package dwarfutil
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+)
+
+// LocLists reads the location lists of an ELF file, from
+// .debug_loc for DWARF 2-4 units and .debug_loclists for DWARF 5,
+// which debug/dwarf doesn't decode.
+type LocLists struct {
+	order    binary.ByteOrder
+	addrSize int
+	loc      []byte
+	loclists []byte
+	addr     []byte
+	units    []unitHeader
+}
+
+// unitHeader is the extent and DWARF version of a unit in
+// .debug_info.
+type unitHeader struct {
+	start, end dwarf.Offset
+	version    int
+}
+
+// NewLocLists loads the location list sections of f.
+func NewLocLists(f *elf.File) (*LocLists, error) {
+	info, err := SectionData(f, ".debug_info")
+	if err != nil {
+		return nil, err
+	}
+	// each is optional; a unit referring to a missing one fails
+	// when its list is read
+	loc, _ := SectionData(f, ".debug_loc")
+	loclists, _ := SectionData(f, ".debug_loclists")
+	addr, _ := SectionData(f, ".debug_addr")
+	return newLocLists(f, info, loc, loclists, addr)
+}
+
+// newLocLists returns the location lists of f's units in info,
+// with the given sections.
+func newLocLists(f *elf.File, info, loc, loclists, addr []byte) (*LocLists, error) {
+	l := LocLists{
+		order:    f.ByteOrder,
+		addrSize: 8,
+		loc:      loc,
+		loclists: loclists,
+		addr:     addr,
+	}
+	if f.Class == elf.ELFCLASS32 {
+		l.addrSize = 4
+	}
+	var err error
+	if l.units, err = unitHeaders(info, f.ByteOrder); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func unitHeaders(info []byte, order binary.ByteOrder) ([]unitHeader, error) {
+	var units []unitHeader
+	for off := 0; off < len(info); {
+		if len(info)-off < 6 {
+			return nil, fmt.Errorf("truncated unit header at 0x%x", off)
+		}
+		length := uint64(order.Uint32(info[off:]))
+		hdr := 4
+		if length == 0xffffffff {
+			if len(info)-off < 14 {
+				return nil, fmt.Errorf("truncated unit header at 0x%x", off)
+			}
+			length = order.Uint64(info[off+4:])
+			hdr = 12
+		}
+		end := uint64(off) + uint64(hdr) + length
+		if end > uint64(len(info)) || end <= uint64(off) {
+			return nil, fmt.Errorf("bad unit length at 0x%x", off)
+		}
+		units = append(units, unitHeader{
+			start:   dwarf.Offset(off),
+			end:     dwarf.Offset(end),
+			version: int(order.Uint16(info[off+hdr:])),
+		})
+		off = int(end)
+	}
+	return units, nil
+}
+
+func (l *LocLists) unitVersion(cu *dwarf.Entry) int {
+	for _, u := range l.units {
+		if cu.Offset >= u.start && cu.Offset < u.end {
+			return u.version
+		}
+	}
+	return 0
+}
+
+// At returns the location expression that the location list
+// attribute field of an entry in compilation unit cu gives for pc.
+// ok is false if no entry of the list covers pc.
+func (l *LocLists) At(cu *dwarf.Entry, field *dwarf.Field, pc uint64) (expr []byte, ok bool, err error) {
+	base, _ := cu.Val(dwarf.AttrLowpc).(uint64)
+
+	switch field.Class {
+	case dwarf.ClassLocListPtr:
+		off, ok := fieldUint(field.Val)
+		if !ok {
+			return nil, false, fmt.Errorf("bad location list offset %v", field.Val)
+		}
+		if l.unitVersion(cu) < 5 {
+			return l.atLoc(off, base, pc)
+		}
+		return l.atLoclists(cu, off, base, pc)
+	case dwarf.ClassLocList:
+		// DW_FORM_loclistx indexes the unit's table of offsets
+		idx, ok := fieldUint(field.Val)
+		if !ok {
+			return nil, false, fmt.Errorf("bad location list index %v", field.Val)
+		}
+		tableBase, ok := fieldUint(cu.Val(dwarf.AttrLoclistsBase))
+		if !ok {
+			return nil, false, fmt.Errorf("location list index without DW_AT_loclists_base")
+		}
+		at := tableBase + idx*4
+		if at+4 > uint64(len(l.loclists)) {
+			return nil, false, fmt.Errorf("location list index %d out of range", idx)
+		}
+		off := tableBase + uint64(l.order.Uint32(l.loclists[at:]))
+		return l.atLoclists(cu, off, base, pc)
+	}
+	return nil, false, fmt.Errorf("attribute of class %s isn't a location list", field.Class)
+}
+
+// atLoc searches the DWARF 2-4 .debug_loc list at off.
+func (l *LocLists) atLoc(off, base, pc uint64) ([]byte, bool, error) {
+	b := reader{data: l.loc, off: off, order: l.order, addrSize: l.addrSize}
+	maxAddr := ^uint64(0)
+	if l.addrSize == 4 {
+		maxAddr = 0xffffffff
+	}
+	for {
+		begin, end := b.addr(), b.addr()
+		if b.err != nil {
+			return nil, false, fmt.Errorf(".debug_loc: %w", b.err)
+		}
+		if begin == 0 && end == 0 {
+			return nil, false, nil
+		}
+		if begin == maxAddr {
+			base = end
+			continue
+		}
+		expr := b.bytes(uint64(b.u16()))
+		if b.err != nil {
+			return nil, false, fmt.Errorf(".debug_loc: %w", b.err)
+		}
+		if base+begin <= pc && pc < base+end {
+			return expr, true, nil
+		}
+	}
+}
+
+// DWARF 5 location list entry kinds.
+const (
+	lleEndOfList       = 0x00
+	lleBaseAddressx    = 0x01
+	lleStartxEndx      = 0x02
+	lleStartxLength    = 0x03
+	lleOffsetPair      = 0x04
+	lleDefaultLocation = 0x05
+	lleBaseAddress     = 0x06
+	lleStartEnd        = 0x07
+	lleStartLength     = 0x08
+)
+
+// atLoclists searches the DWARF 5 .debug_loclists list at off.
+func (l *LocLists) atLoclists(cu *dwarf.Entry, off, base, pc uint64) ([]byte, bool, error) {
+	addrBase, _ := fieldUint(cu.Val(dwarf.AttrAddrBase))
+	addrx := func(idx uint64) (uint64, error) {
+		at := addrBase + idx*uint64(l.addrSize)
+		if at+uint64(l.addrSize) > uint64(len(l.addr)) {
+			return 0, fmt.Errorf("address index %d out of range", idx)
+		}
+		if l.addrSize == 4 {
+			return uint64(l.order.Uint32(l.addr[at:])), nil
+		}
+		return l.order.Uint64(l.addr[at:]), nil
+	}
+
+	b := reader{data: l.loclists, off: off, order: l.order, addrSize: l.addrSize}
+	var def []byte
+	for {
+		var (
+			start, end uint64
+			err        error
+		)
+		kind := b.u8()
+		switch kind {
+		case lleEndOfList:
+			if b.err != nil {
+				return nil, false, fmt.Errorf(".debug_loclists: %w", b.err)
+			}
+			return def, def != nil, nil
+		case lleBaseAddressx:
+			base, err = addrx(b.uleb())
+			if err != nil {
+				return nil, false, err
+			}
+			continue
+		case lleBaseAddress:
+			base = b.addr()
+			continue
+		case lleStartxEndx:
+			if start, err = addrx(b.uleb()); err == nil {
+				end, err = addrx(b.uleb())
+			}
+		case lleStartxLength:
+			if start, err = addrx(b.uleb()); err == nil {
+				end = start + b.uleb()
+			}
+		case lleOffsetPair:
+			start = base + b.uleb()
+			end = base + b.uleb()
+		case lleDefaultLocation:
+		case lleStartEnd:
+			start, end = b.addr(), b.addr()
+		case lleStartLength:
+			start = b.addr()
+			end = start + b.uleb()
+		default:
+			return nil, false, fmt.Errorf(".debug_loclists: unknown entry kind 0x%x at 0x%x", kind, b.off-1)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		expr := b.bytes(b.uleb())
+		if b.err != nil {
+			return nil, false, fmt.Errorf(".debug_loclists: %w", b.err)
+		}
+		if kind == lleDefaultLocation {
+			def = expr
+			continue
+		}
+		if start <= pc && pc < end {
+			return expr, true, nil
+		}
+	}
+}
+
+// reader decodes values from a section, recording the first error.
+type reader struct {
+	data     []byte
+	off      uint64
+	order    binary.ByteOrder
+	addrSize int
+	err      error
+}
+
+func (r *reader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if r.off+n > uint64(len(r.data)) || r.off+n < r.off {
+		r.err = fmt.Errorf("read past end of section at 0x%x", r.off)
+		return nil
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *reader) u8() uint8 {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) u16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return r.order.Uint16(b)
+}
+
+func (r *reader) u32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return r.order.Uint32(b)
+}
+
+func (r *reader) addr() uint64 {
+	b := r.bytes(uint64(r.addrSize))
+	if b == nil {
+		return 0
+	}
+	if r.addrSize == 4 {
+		return uint64(r.order.Uint32(b))
+	}
+	return r.order.Uint64(b)
+}
+
+func (r *reader) uleb() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if r.off > uint64(len(r.data)) {
+		r.err = fmt.Errorf("read past end of section at 0x%x", r.off)
+		return 0
+	}
+	v, n := uleb128(r.data[r.off:])
+	if n == 0 {
+		r.err = fmt.Errorf("truncated LEB128 at 0x%x", r.off)
+		return 0
+	}
+	r.off += uint64(n)
+	return v
+}
@@ -0,0 +1,736 @@
+package dwarfutil
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/psanford/pptrace/internal/vlog"
+)
+
+// DWARF 5 unit types debug/dwarf doesn't export, and the GNU
+// attributes GCC's DWARF 4 split units use for what DWARF 5 put in
+// unit headers and standard attributes.
+const (
+	utSkeleton     = 0x04
+	utSplitCompile = 0x05
+	utSplitType    = 0x06
+
+	attrGNUDwoName    = 0x2130
+	attrGNUDwoID      = 0x2131
+	attrGNURangesBase = 0x2132
+	attrGNUAddrBase   = 0x2133
+)
+
+// Column ids of a .debug_cu_index. Version 2 indexes, which GNU dwp
+// writes for DWARF 4, use the same ids, except that 5 is
+// .debug_loc.dwo rather than .debug_loclists.dwo and 8 isn't
+// .debug_rnglists.dwo.
+const (
+	sectInfo       = 1
+	sectAbbrev     = 3
+	sectLocLists   = 5
+	sectStrOffsets = 6
+	sectRngLists   = 8
+)
+
+// listsHeaderSize is the size of the header of a .debug_loclists or
+// .debug_rnglists contribution, which a unit's base points past.
+const listsHeaderSize = 12
+
+// ELFData returns the DWARF data of f, which was opened from path.
+// If f was built with split DWARF (-gsplit-dwarf), its skeleton units
+// only point at the real debug info, so that's read from path.dwp
+// or from each unit's .dwo file and merged in. Both DWARF 5 split
+// units and GCC's DWARF 4 ones, which use GNU extensions, are
+// supported; the latter are converted to DWARF 5 units as they're
+// merged, as debug/dwarf only reads string and address indexes in
+// those.
+//
+// Attributes a split unit leaves to its skeleton, such as its
+// address and string offset bases and line table, are added to the
+// split unit. Its address range stays on the skeleton.
+func ELFData(f *elf.File, path string) (*dwarf.Data, error) {
+	d, _, err := readELFData(f, path)
+	return d, err
+}
+
+// ELFDataLocLists is ELFData that also returns the location lists of
+// the data's units. Those of split units are only in the merged
+// sections, so NewLocLists can't find them.
+func ELFDataLocLists(f *elf.File, path string) (*dwarf.Data, *LocLists, error) {
+	d, merged, err := readELFData(f, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var l *LocLists
+	if merged != nil {
+		l, err = newLocLists(f, merged.info, merged.loc, merged.loclists, merged.addr)
+	} else {
+		l, err = NewLocLists(f)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("read location lists err: %w", err)
+	}
+	return d, l, nil
+}
+
+// mergedSections are the sections of f's DWARF data with split units
+// merged in that location lists are read from.
+type mergedSections struct {
+	info, loc, loclists, addr []byte
+}
+
+// readELFData is ELFData, also returning the merged sections if f has
+// split units.
+func readELFData(f *elf.File, path string) (*dwarf.Data, *mergedSections, error) {
+	d, err := f.DWARF()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := SectionData(f, ".debug_info")
+	if err != nil {
+		return d, nil, nil
+	}
+	skeletons, err := readSkeletons(d, info, f.ByteOrder)
+	if err != nil {
+		vlog.Debugf("%s: split DWARF: %s", path, err)
+		return d, nil, nil
+	}
+	if len(skeletons) == 0 {
+		return d, nil, nil
+	}
+
+	parts, err := dwoParts(path, skeletons, f.ByteOrder)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merged, sections, err := mergeSplit(f, parts, skeletons)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: merge split DWARF err: %w", path, err)
+	}
+	return merged, sections, nil
+}
+
+// skeleton is what a skeleton unit tells about its split unit.
+type skeleton struct {
+	dwoName  string
+	compDir  string
+	addrBase int64
+	stmtList int64
+	hasAddr  bool
+	hasStmt  bool
+	// lowPC and rangesBase are what the DWARF 4 range lists of the
+	// split unit are relative to: its base address, and where they
+	// start in .debug_ranges.
+	lowPC      uint64
+	rangesBase int64
+}
+
+// readSkeletons returns the skeleton units of d by dwo id: DWARF 5
+// skeleton units, and DWARF 4 compile units naming a .dwo with GNU
+// attributes.
+func readSkeletons(d *dwarf.Data, info []byte, order binary.ByteOrder) (map[uint64]*skeleton, error) {
+	skeletons := make(map[uint64]*skeleton)
+	r := d.Reader()
+	for off := 0; off < len(info); {
+		h, err := readSplitHeader(info[off:], order)
+		if err != nil {
+			return nil, err
+		}
+		if h.unitType == utSkeleton || h.version < 5 {
+			r.Seek(dwarf.Offset(off + h.size))
+			cu, err := r.Next()
+			if err != nil {
+				return nil, err
+			}
+			if cu == nil {
+				break
+			}
+			if id, s, ok := readSkeleton(h, cu); ok {
+				skeletons[id] = s
+			}
+		}
+		off += h.length
+	}
+	return skeletons, nil
+}
+
+// readSkeleton returns the dwo id and skeleton of the unit with
+// header h and top entry cu, if it's a skeleton.
+func readSkeleton(h splitHeader, cu *dwarf.Entry) (uint64, *skeleton, bool) {
+	s := &skeleton{}
+	s.compDir, _ = cu.Val(dwarf.AttrCompDir).(string)
+	s.stmtList, s.hasStmt = cu.Val(dwarf.AttrStmtList).(int64)
+	s.lowPC, _ = cu.Val(dwarf.AttrLowpc).(uint64)
+	if h.unitType == utSkeleton {
+		s.dwoName, _ = cu.Val(dwarf.AttrDwoName).(string)
+		s.addrBase, s.hasAddr = cu.Val(dwarf.AttrAddrBase).(int64)
+		return h.dwoID, s, true
+	}
+
+	// a DWARF 4 skeleton is a compile unit with GNU attributes for
+	// its dwo id and name and its split unit's bases
+	id, ok := cu.Val(attrGNUDwoID).(int64)
+	if !ok {
+		return 0, nil, false
+	}
+	s.dwoName, _ = cu.Val(attrGNUDwoName).(string)
+	s.addrBase, s.hasAddr = cu.Val(attrGNUAddrBase).(int64)
+	s.rangesBase, _ = cu.Val(attrGNURangesBase).(int64)
+	return uint64(id), s, true
+}
+
+// splitHeader is a unit header as far as merging needs it.
+type splitHeader struct {
+	length   int // whole unit, including the length field
+	size     int // header size
+	version  int
+	unitType int
+	// abbrevAt is where the abbrev offset is in the header.
+	abbrevAt int
+	dwoID    uint64
+}
+
+func readSplitHeader(b []byte, order binary.ByteOrder) (splitHeader, error) {
+	var h splitHeader
+	if len(b) < 11 {
+		return h, errors.New("truncated unit header")
+	}
+	length := order.Uint32(b)
+	if length == 0xffffffff {
+		return h, errors.New("64-bit DWARF is not supported")
+	}
+	h.length = int(length) + 4
+	if h.length > len(b) {
+		return h, errors.New("bad unit length")
+	}
+	h.version = int(order.Uint16(b[4:]))
+	if h.version < 5 {
+		// version, abbrev offset, address size
+		h.abbrevAt = 6
+		h.size = 11
+		return h, nil
+	}
+	h.unitType = int(b[6])
+	h.abbrevAt = 8
+	h.size = 12
+	switch h.unitType {
+	case utSkeleton, utSplitCompile:
+		h.size += 8
+	case utSplitType, 0x02:
+		h.size += 12
+	}
+	if h.size > h.length {
+		return h, errors.New("truncated unit header")
+	}
+	if h.unitType == utSkeleton || h.unitType == utSplitCompile {
+		h.dwoID = order.Uint64(b[12:])
+	}
+	return h, nil
+}
+
+// dwoPart is the split debug info of one .dwo file or one unit of a
+// .dwp.
+type dwoPart struct {
+	info       []byte
+	abbrev     []byte
+	strOffsets []byte
+	str        *dwoStr
+	// loclists and rnglists are the lists of DWARF 5 units; loc
+	// is the GNU format location lists of DWARF 4 ones.
+	loclists []byte
+	rnglists []byte
+	loc      []byte
+}
+
+// dwoStr is a string section, which a .dwp shares among its units.
+type dwoStr struct {
+	data []byte
+	// base is where data starts in the merged section, or -1.
+	base int
+}
+
+// dwoParts finds the split debug info of the binary at path: its
+// .dwp package if there is one, otherwise a .dwo for each skeleton
+// that can be found.
+func dwoParts(path string, skeletons map[uint64]*skeleton, order binary.ByteOrder) ([]dwoPart, error) {
+	dwp := path + ".dwp"
+	if _, err := os.Stat(dwp); err == nil {
+		vlog.Debugf("debug info for %s: reading split units from %s", path, dwp)
+		return dwpParts(dwp, order)
+	}
+
+	var parts []dwoPart
+	seen := make(map[string]bool)
+	for _, s := range skeletons {
+		name := findDwo(path, s)
+		if name == "" {
+			// the unit's functions are still listed by the
+			// symbol table, just without debug info
+			vlog.Debugf("debug info for %s: split DWARF file %s not found", path, s.dwoName)
+			continue
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		vlog.Debugf("debug info for %s: reading split unit from %s", path, name)
+
+		e, err := elf.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		part, err := readDwoSections(e)
+		e.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// findDwo returns the path of s's .dwo file. Its name is relative to
+// the compilation directory, which may not exist where the binary
+// is used, so the binary's directory is tried too.
+func findDwo(path string, s *skeleton) string {
+	var candidates []string
+	if filepath.IsAbs(s.dwoName) {
+		candidates = append(candidates, s.dwoName)
+	} else {
+		candidates = append(candidates, filepath.Join(s.compDir, s.dwoName))
+	}
+	dir := filepath.Dir(path)
+	candidates = append(candidates,
+		filepath.Join(dir, s.dwoName),
+		filepath.Join(dir, filepath.Base(s.dwoName)),
+	)
+	for _, c := range candidates {
+		if fi, err := os.Stat(c); err == nil && fi.Mode().IsRegular() {
+			return c
+		}
+	}
+	return ""
+}
+
+func readDwoSections(e *elf.File) (dwoPart, error) {
+	var part dwoPart
+	var err error
+	part.info, err = SectionData(e, ".debug_info.dwo")
+	if err != nil {
+		return part, err
+	}
+	part.abbrev, err = SectionData(e, ".debug_abbrev.dwo")
+	if err != nil {
+		return part, err
+	}
+	part.strOffsets, _ = SectionData(e, ".debug_str_offsets.dwo")
+	part.loclists, _ = SectionData(e, ".debug_loclists.dwo")
+	part.rnglists, _ = SectionData(e, ".debug_rnglists.dwo")
+	part.loc, _ = SectionData(e, ".debug_loc.dwo")
+	str, _ := SectionData(e, ".debug_str.dwo")
+	part.str = &dwoStr{data: str, base: -1}
+	return part, nil
+}
+
+// dwpParts splits the .dwp at path into the contributions of each
+// unit listed in its .debug_cu_index.
+func dwpParts(path string, order binary.ByteOrder) ([]dwoPart, error) {
+	e, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer e.Close()
+
+	all, err := readDwoSections(e)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	index, err := SectionData(e, ".debug_cu_index")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(index) < 16 {
+		return nil, fmt.Errorf("%s: truncated .debug_cu_index", path)
+	}
+	// version 5 is a 2 byte version and 2 of padding, GNU's
+	// version 2 a 4 byte version
+	version := order.Uint32(index)
+	if version != 2 {
+		version = uint32(order.Uint16(index))
+	}
+	if version != 2 && version != 5 {
+		return nil, fmt.Errorf("%s: .debug_cu_index version %d is not supported", path, version)
+	}
+	sections := int(order.Uint32(index[4:]))
+	units := int(order.Uint32(index[8:]))
+	slots := int(order.Uint32(index[12:]))
+
+	// header, hash table, index table, column ids, then an offset
+	// and a size for each unit and section
+	offsets := 16 + slots*12 + sections*4
+	sizes := offsets + units*sections*4
+	if sizes+units*sections*4 > len(index) {
+		return nil, fmt.Errorf("%s: truncated .debug_cu_index", path)
+	}
+	columns := make([]int, sections)
+	for i := range columns {
+		columns[i] = int(order.Uint32(index[16+slots*12+i*4:]))
+	}
+
+	contribution := func(section []byte, unit, col int) ([]byte, error) {
+		at := (unit*sections + col) * 4
+		off := int(order.Uint32(index[offsets+at:]))
+		size := int(order.Uint32(index[sizes+at:]))
+		if off+size > len(section) {
+			return nil, fmt.Errorf("%s: .debug_cu_index entry out of range", path)
+		}
+		return section[off : off+size], nil
+	}
+
+	parts := make([]dwoPart, 0, units)
+	for u := 0; u < units; u++ {
+		part := dwoPart{str: all.str}
+		for col, id := range columns {
+			var err error
+			switch id {
+			case sectInfo:
+				part.info, err = contribution(all.info, u, col)
+			case sectAbbrev:
+				part.abbrev, err = contribution(all.abbrev, u, col)
+			case sectStrOffsets:
+				part.strOffsets, err = contribution(all.strOffsets, u, col)
+			case sectLocLists:
+				if version == 2 {
+					part.loc, err = contribution(all.loc, u, col)
+				} else {
+					part.loclists, err = contribution(all.loclists, u, col)
+				}
+			case sectRngLists:
+				if version == 5 {
+					part.rnglists, err = contribution(all.rnglists, u, col)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// splitMerge holds the sections split units are merged into. They
+// start as the main file's so offsets into them stay valid; each
+// part's abbrevs, strings, string offsets and lists are appended
+// after and its units adjusted to point at them.
+type splitMerge struct {
+	order      binary.ByteOrder
+	skeletons  map[uint64]*skeleton
+	info       []byte
+	abbrev     []byte
+	str        []byte
+	strOffsets []byte
+	loclists   []byte
+	rnglists   []byte
+	// ranges is the main file's .debug_ranges, which DWARF 4 split
+	// units' range lists are in
+	ranges []byte
+}
+
+// mergeSplit returns f's DWARF data with the units of parts
+// appended, and the merged sections location lists are read from.
+func mergeSplit(f *elf.File, parts []dwoPart, skeletons map[uint64]*skeleton) (*dwarf.Data, *mergedSections, error) {
+	section := func(name string) []byte {
+		b, _ := SectionData(f, name)
+		return b
+	}
+
+	m := splitMerge{
+		order:      f.ByteOrder,
+		skeletons:  skeletons,
+		info:       append([]byte(nil), section(".debug_info")...),
+		abbrev:     append([]byte(nil), section(".debug_abbrev")...),
+		str:        append([]byte(nil), section(".debug_str")...),
+		strOffsets: append([]byte(nil), section(".debug_str_offsets")...),
+		loclists:   append([]byte(nil), section(".debug_loclists")...),
+		rnglists:   append([]byte(nil), section(".debug_rnglists")...),
+		ranges:     section(".debug_ranges"),
+	}
+	for _, part := range parts {
+		if err := m.addPart(part); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	d, err := dwarf.New(m.abbrev, section(".debug_aranges"), section(".debug_frame"), m.info, section(".debug_line"), section(".debug_pubnames"), m.ranges, m.str)
+	if err != nil {
+		return nil, nil, err
+	}
+	added := map[string][]byte{
+		".debug_addr":        section(".debug_addr"),
+		".debug_line_str":    section(".debug_line_str"),
+		".debug_rnglists":    m.rnglists,
+		".debug_str_offsets": m.strOffsets,
+	}
+	for _, name := range []string{".debug_addr", ".debug_line_str", ".debug_rnglists", ".debug_str_offsets"} {
+		if b := added[name]; len(b) > 0 {
+			if err := d.AddSection(name, b); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return d, &mergedSections{
+		info:     m.info,
+		loc:      section(".debug_loc"),
+		loclists: m.loclists,
+		addr:     section(".debug_addr"),
+	}, nil
+}
+
+// addPart appends the units of part and the sections they use.
+func (m *splitMerge) addPart(part dwoPart) error {
+	order := m.order
+	if part.str.base < 0 {
+		part.str.base = len(m.str)
+		m.str = append(m.str, part.str.data...)
+	}
+
+	var strOffsetsBase int64 = -1
+	if len(part.strOffsets) > 0 {
+		entries := part.strOffsets
+		// a contribution starts with a header of its length,
+		// version and padding
+		if len(entries) >= 8 && order.Uint16(entries[4:]) == 5 && int(order.Uint32(entries))+4 <= len(entries) {
+			m.strOffsets = append(m.strOffsets, entries[:8]...)
+			entries = entries[8:]
+		}
+		strOffsetsBase = int64(len(m.strOffsets))
+		var entry [4]byte
+		for i := 0; i+4 <= len(entries); i += 4 {
+			order.PutUint32(entry[:], order.Uint32(entries[i:])+uint32(part.str.base))
+			m.strOffsets = append(m.strOffsets, entry[:]...)
+		}
+	}
+
+	// DWARF 5 units index their lists through the offset table
+	// after the contribution's header
+	var loclistsBase, rnglistsBase int64 = -1, -1
+	if len(part.loclists) >= listsHeaderSize {
+		loclistsBase = int64(len(m.loclists) + listsHeaderSize)
+		m.loclists = append(m.loclists, part.loclists...)
+	}
+	if len(part.rnglists) >= listsHeaderSize {
+		rnglistsBase = int64(len(m.rnglists) + listsHeaderSize)
+		m.rnglists = append(m.rnglists, part.rnglists...)
+	}
+
+	for off := 0; off < len(part.info); {
+		h, err := readSplitHeader(part.info[off:], order)
+		if err != nil {
+			return err
+		}
+		unit := append([]byte(nil), part.info[off:off+h.length]...)
+		off += h.length
+		decls, err := parseAbbrevs(part.abbrev, int(order.Uint32(unit[h.abbrevAt:])))
+		if err != nil {
+			return err
+		}
+
+		var extra []abbrevAttr
+		if strOffsetsBase >= 0 {
+			extra = append(extra, abbrevAttr{dwarf.AttrStrOffsetsBase, strOffsetsBase})
+		}
+		switch h.version {
+		case 4:
+			if unit, h, err = m.convertGNU(unit, h, decls, part.loc); err != nil {
+				return err
+			}
+		case 5:
+			if loclistsBase >= 0 {
+				extra = append(extra, abbrevAttr{dwarf.AttrLoclistsBase, loclistsBase})
+			}
+			if rnglistsBase >= 0 {
+				extra = append(extra, abbrevAttr{dwarf.AttrRnglistsBase, rnglistsBase})
+			}
+		default:
+			return fmt.Errorf("split unit version %d is not supported", h.version)
+		}
+		if h.unitType == utSplitCompile {
+			if s := m.skeletons[h.dwoID]; s != nil {
+				if s.hasAddr {
+					extra = append(extra, abbrevAttr{dwarf.AttrAddrBase, s.addrBase})
+				}
+				if s.hasStmt {
+					extra = append(extra, abbrevAttr{dwarf.AttrStmtList, s.stmtList})
+				}
+			} else {
+				vlog.Debugf("split unit %#x has no skeleton", h.dwoID)
+			}
+		}
+
+		code, _ := uleb128(unit[h.size:])
+		order.PutUint32(unit[h.abbrevAt:], uint32(len(m.abbrev)))
+		m.abbrev = append(m.abbrev, rewriteAbbrevs(decls, code, extra)...)
+		m.info = append(m.info, unit...)
+	}
+	return nil
+}
+
+// abbrevDecl is a declaration of an abbrev table.
+type abbrevDecl struct {
+	code     uint64
+	tag      uint64
+	children bool
+	fields   []abbrevField
+}
+
+// abbrevField is an attribute of an abbrevDecl. val is the value of
+// a DW_FORM_implicit_const.
+type abbrevField struct {
+	attr, form uint64
+	val        int64
+}
+
+var errTruncatedAbbrev = errors.New("truncated abbrev table")
+
+// parseAbbrevs reads the abbrev table at off in abbrev.
+func parseAbbrevs(abbrev []byte, off int) ([]abbrevDecl, error) {
+	if off > len(abbrev) {
+		return nil, errors.New("abbrev offset out of range")
+	}
+	b := abbrev[off:]
+	var decls []abbrevDecl
+	for {
+		code, n := uleb128(b)
+		if n == 0 {
+			return nil, errTruncatedAbbrev
+		}
+		b = b[n:]
+		if code == 0 {
+			return decls, nil
+		}
+		d := abbrevDecl{code: code}
+		d.tag, n = uleb128(b)
+		if n == 0 || len(b) < n+1 {
+			return nil, errTruncatedAbbrev
+		}
+		d.children = b[n] != 0
+		b = b[n+1:]
+
+		for {
+			var f abbrevField
+			if f.attr, n = uleb128(b); n == 0 {
+				return nil, errTruncatedAbbrev
+			}
+			b = b[n:]
+			if f.form, n = uleb128(b); n == 0 {
+				return nil, errTruncatedAbbrev
+			}
+			b = b[n:]
+			if f.attr == 0 && f.form == 0 {
+				break
+			}
+			if f.form == formImplicitConst {
+				if f.val, n = sleb128(b); n == 0 {
+					return nil, errTruncatedAbbrev
+				}
+				b = b[n:]
+			}
+			d.fields = append(d.fields, f)
+		}
+		decls = append(decls, d)
+	}
+}
+
+// abbrevAttr is an attribute added to a unit's top entry.
+type abbrevAttr struct {
+	attr dwarf.Attr
+	val  int64
+}
+
+// rewriteAbbrevs encodes decls as an abbrev table, with attrs added
+// to the declaration whose code is code. They're added as
+// DW_FORM_implicit_const, which keeps their value in the abbrev, so
+// entries using it need no change. Attributes the declaration
+// already has are left as they are. The GNU index forms of DWARF 4
+// split units become the DWARF 5 forms they were standardized as,
+// which are encoded the same way.
+func rewriteAbbrevs(decls []abbrevDecl, code uint64, attrs []abbrevAttr) []byte {
+	var out []byte
+	for _, d := range decls {
+		out = appendUleb(out, d.code)
+		out = appendUleb(out, d.tag)
+		if d.children {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+
+		has := make(map[dwarf.Attr]bool)
+		for _, f := range d.fields {
+			has[dwarf.Attr(f.attr)] = true
+			form := f.form
+			switch form {
+			case formGNUAddrIndex:
+				form = formAddrx
+			case formGNUStrIndex:
+				form = formStrx
+			}
+			out = appendUleb(out, f.attr)
+			out = appendUleb(out, form)
+			if form == formImplicitConst {
+				out = appendSleb(out, f.val)
+			}
+		}
+		if d.code == code {
+			for _, a := range attrs {
+				if has[a.attr] {
+					continue
+				}
+				out = appendUleb(out, uint64(a.attr))
+				out = appendUleb(out, formImplicitConst)
+				out = appendSleb(out, a.val)
+			}
+		}
+		out = append(out, 0, 0)
+	}
+	return append(out, 0)
+}
+
+func appendUleb(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if v == 0 {
+			return b
+		}
+	}
+}
+
+func appendSleb(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		done := (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0)
+		if !done {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if done {
+			return b
+		}
+	}
+}
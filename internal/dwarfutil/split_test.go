@@ -0,0 +1,163 @@
+package dwarfutil
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"strings"
+	"testing"
+)
+
+// splitFixtures are the split DWARF builds: .dwo files and .dwp
+// packages of DWARF 5 and GCC's GNU DWARF 4 units, unoptimized and
+// with location and range lists.
+var splitFixtures = []string{
+	"split/c-split",
+	"split4/c-split",
+	"dwp/c-split",
+	"dwp4/c-split",
+	"split-O2/c-split",
+	"split4-O2/c-split",
+	"dwp-O2/c-split",
+	"dwp4-O2/c-split",
+}
+
+func TestELFDataSplit(t *testing.T) {
+	for _, name := range splitFixtures {
+		t.Run(name, func(t *testing.T) {
+			f, path := openELF(t, name)
+			d, locs, err := ELFDataLocLists(f, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the split units' functions are where the symbol
+			// table puts them, with their parameters' names and
+			// types
+			syms := funcSymbols(t, f)
+			for _, fn := range []string{"add", "label", "main"} {
+				e := findEntry(t, d, dwarf.TagSubprogram, fn)
+				if low, _, ok := PCRange(e); !ok || low != syms[fn] {
+					t.Errorf("%s: got low_pc 0x%x, want its symbol's, 0x%x", fn, low, syms[fn])
+				}
+			}
+
+			params := map[string]map[string]string{
+				"add":  {"p": "*struct point", "k": "int"},
+				"main": {"argc": "int", "argv": "**char"},
+			}
+			var lists int
+			for fn, want := range params {
+				cu, got := subprogramParams(t, d, fn)
+				if len(got) != len(want) {
+					t.Fatalf("%s has %d parameters, want %d", fn, len(got), len(want))
+				}
+				for _, p := range got {
+					name, _ := p.Val(dwarf.AttrName).(string)
+					off, _ := p.Val(dwarf.AttrType).(dwarf.Offset)
+					typ, err := d.Type(off)
+					if err != nil {
+						t.Errorf("%s parameter %s: %s", fn, name, err)
+						continue
+					}
+					if typ.String() != want[name] {
+						t.Errorf("%s parameter %s has type %s, want %s", fn, name, typ, want[name])
+					}
+
+					// at the function's entry each parameter
+					// is in a register, whether the location
+					// is a list or not
+					field := p.AttrField(dwarf.AttrLocation)
+					if field == nil {
+						t.Errorf("%s parameter %s has no location", fn, name)
+						continue
+					}
+					if field.Class != dwarf.ClassLocListPtr && field.Class != dwarf.ClassLocList {
+						continue
+					}
+					lists++
+					expr, ok, err := locs.At(cu, field, syms[fn])
+					if err != nil || !ok || len(expr) == 0 || expr[0] < opReg0 || expr[0] > opReg31 {
+						t.Errorf("%s parameter %s: got location % x, %v, %v, want a register at the entry", fn, name, expr, ok, err)
+					}
+				}
+			}
+			if strings.Contains(name, "-O2/") && lists == 0 {
+				t.Error("no parameter has a location list")
+			}
+
+			// every range list in the split units reads
+			r := d.Reader()
+			for {
+				e, err := r.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if e == nil {
+					break
+				}
+				if e.Val(dwarf.AttrRanges) == nil || e.Tag == dwarf.TagCompileUnit || e.Tag == dwarf.TagSkeletonUnit {
+					continue
+				}
+				ranges, err := d.Ranges(e)
+				if err != nil || len(ranges) == 0 {
+					t.Errorf("%s at 0x%x: got ranges %v, %v", e.Tag, e.Offset, ranges, err)
+				}
+			}
+		})
+	}
+}
+
+// funcSymbols returns the values of f's function symbols by name.
+func funcSymbols(tb testing.TB, f *elf.File) map[string]uint64 {
+	tb.Helper()
+	syms, err := f.Symbols()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	out := make(map[string]uint64)
+	for _, s := range syms {
+		if elf.ST_TYPE(s.Info) == elf.STT_FUNC {
+			out[s.Name] = s.Value
+		}
+	}
+	return out
+}
+
+// subprogramParams returns the formal parameters of the subprogram
+// name in d, with the unit it's in.
+func subprogramParams(tb testing.TB, d *dwarf.Data, name string) (*dwarf.Entry, []*dwarf.Entry) {
+	tb.Helper()
+	r := d.Reader()
+	var cu *dwarf.Entry
+	for {
+		e, err := r.Next()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		if e == nil {
+			tb.Fatalf("no subprogram %s", name)
+		}
+		switch {
+		case e.Tag == dwarf.TagCompileUnit:
+			cu = e
+		case e.Tag == dwarf.TagSubprogram && e.Val(dwarf.AttrName) == name:
+			var params []*dwarf.Entry
+			for e.Children {
+				c, err := r.Next()
+				if err != nil {
+					tb.Fatal(err)
+				}
+				if c == nil || c.Tag == 0 {
+					return cu, params
+				}
+				if c.Tag == dwarf.TagFormalParameter {
+					params = append(params, c)
+				}
+				if c.Children {
+					r.SkipChildren()
+				}
+			}
+			return cu, params
+		}
+	}
+}
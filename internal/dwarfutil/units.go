@@ -0,0 +1,124 @@
+package dwarfutil
+
+import (
+	"debug/dwarf"
+	"io"
+)
+
+// Lookup returns the node at off, or nil if there's none. On the
+// root of a unit passed by Units, entries outside the unit are read
+// on demand.
+func (n *Node) Lookup(off dwarf.Offset) *Node {
+	if node, ok := n.OffsetMap[off]; ok {
+		return node
+	}
+	if n.outside == nil {
+		return nil
+	}
+	return n.outside.node(off)
+}
+
+// Units calls fn with each compilation unit of d, along with a root
+// to resolve offsets through with Lookup. If DataTree has built d's
+// tree, its units are used. Otherwise each unit is read in turn and
+// dropped once fn returns, so memory use is bounded by the largest
+// unit and the entries other units refer to, such as shared types,
+// rather than all of d.
+func Units(d *dwarf.Data, fn func(root, cu *Node) error) error {
+	cache.Lock()
+	tree := cache.trees[d]
+	cache.Unlock()
+	if tree != nil {
+		for _, cu := range tree.Children {
+			if err := fn(tree, cu); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	outside := &outsideEntries{
+		r:     d.Reader(),
+		nodes: make(map[dwarf.Offset]*Node),
+	}
+	r := d.Reader()
+	for {
+		entry, err := r.Next()
+		if err == io.EOF || entry == nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		offsets := make(map[dwarf.Offset]*Node)
+		cu, err := readSubtree(r, entry, offsets)
+		if err != nil {
+			return err
+		}
+		root := &Node{
+			Entry:       *entry,
+			Children:    []*Node{cu},
+			OffsetMap:   offsets,
+			AddressSize: r.AddressSize(),
+			outside:     outside,
+		}
+		if err := fn(root, cu); err != nil {
+			return err
+		}
+	}
+}
+
+// readSubtree reads the children of entry from r, which must be
+// positioned just after it, recording each node in offsets.
+func readSubtree(r *dwarf.Reader, entry *dwarf.Entry, offsets map[dwarf.Offset]*Node) (*Node, error) {
+	node := &Node{Entry: *entry}
+	offsets[entry.Offset] = node
+	if !entry.Children {
+		return node, nil
+	}
+
+	for {
+		child, err := r.Next()
+		if err == io.EOF || child == nil {
+			return node, nil
+		} else if err != nil {
+			return nil, err
+		}
+		// an empty entry ends the list of children
+		if child.Tag == 0 {
+			return node, nil
+		}
+
+		childNode, err := readSubtree(r, child, offsets)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, childNode)
+	}
+}
+
+// outsideEntries reads the entries a unit refers to in other
+// units, keeping them for later lookups.
+type outsideEntries struct {
+	r     *dwarf.Reader
+	nodes map[dwarf.Offset]*Node
+}
+
+func (o *outsideEntries) node(off dwarf.Offset) *Node {
+	if node, ok := o.nodes[off]; ok {
+		return node
+	}
+
+	o.r.Seek(off)
+	entry, err := o.r.Next()
+	if err != nil || entry == nil || entry.Offset != off {
+		o.nodes[off] = nil
+		return nil
+	}
+	node, err := readSubtree(o.r, entry, o.nodes)
+	if err != nil {
+		o.nodes[off] = nil
+		return nil
+	}
+	return node
+}
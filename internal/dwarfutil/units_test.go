@@ -0,0 +1,136 @@
+package dwarfutil
+
+import (
+	"debug/dwarf"
+	"errors"
+	"testing"
+)
+
+// typeRefs calls fn with each entry of d that has a type, along
+// with the type it resolves to through Units.
+func typeRefs(d *dwarf.Data, fn func(e *Node, typ *Node)) error {
+	return Units(d, func(root, cu *Node) error {
+		cu.Walk(func(n *Node) {
+			if off, ok := n.Entry.Val(dwarf.AttrType).(dwarf.Offset); ok {
+				fn(n, root.Lookup(off))
+			}
+		})
+		return nil
+	})
+}
+
+func elfData(tb testing.TB, name string) *dwarf.Data {
+	tb.Helper()
+	f, path := openELF(tb, name)
+	d, err := ELFData(f, path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return d
+}
+
+func TestUnitsLookup(t *testing.T) {
+	// Go binaries refer to types in other units, which are read
+	// on demand when units are streamed
+	for _, name := range []string{"c-nopie", "go-exe"} {
+		t.Run(name, func(t *testing.T) {
+			resetCache()
+			t.Cleanup(resetCache)
+			d := elfData(t, name)
+
+			streamed := make(map[dwarf.Offset]dwarf.Offset)
+			var units int
+			err := Units(d, func(root, cu *Node) error {
+				units++
+				if cu.Entry.Tag != dwarf.TagCompileUnit {
+					t.Errorf("unit at 0x%x is a %s", cu.Entry.Offset, cu.Entry.Tag)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = typeRefs(d, func(e, typ *Node) {
+				if typ == nil {
+					t.Errorf("type of entry at 0x%x doesn't resolve", e.Entry.Offset)
+					return
+				}
+				streamed[e.Entry.Offset] = typ.Entry.Offset
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(streamed) == 0 {
+				t.Fatal("no entries with types")
+			}
+
+			// once the tree is built, its units give the same
+			// answers
+			tree, err := DataTree(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tree.Children) != units {
+				t.Errorf("tree has %d units, streaming read %d", len(tree.Children), units)
+			}
+			fromTree := make(map[dwarf.Offset]dwarf.Offset)
+			err = typeRefs(d, func(e, typ *Node) {
+				if typ != nil {
+					fromTree[e.Entry.Offset] = typ.Entry.Offset
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fromTree) != len(streamed) {
+				t.Errorf("tree resolved %d types, streaming %d", len(fromTree), len(streamed))
+			}
+			for off, typ := range streamed {
+				if fromTree[off] != typ {
+					t.Errorf("entry at 0x%x: type at 0x%x streaming, 0x%x from the tree", off, typ, fromTree[off])
+				}
+			}
+		})
+	}
+}
+
+func TestUnitsStopsOnError(t *testing.T) {
+	resetCache()
+	d := elfData(t, "go-exe")
+	stop := errors.New("stop")
+	var units int
+	err := Units(d, func(root, cu *Node) error {
+		units++
+		return stop
+	})
+	if err != stop || units != 1 {
+		t.Errorf("got err %v after %d units, want the callback's after 1", err, units)
+	}
+}
+
+func BenchmarkUnitsLookup(b *testing.B) {
+	d := elfData(b, "go-exe")
+	lookup := func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var n int
+			if err := typeRefs(d, func(e, typ *Node) { n++ }); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("streamed", func(b *testing.B) {
+		resetCache()
+		lookup(b)
+	})
+	b.Run("tree", func(b *testing.B) {
+		resetCache()
+		defer resetCache()
+		if _, err := DataTree(d); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		lookup(b)
+	})
+}
@@ -0,0 +1,247 @@
+// Package fixture builds the programs in testdata for tests, in the
+// same variants as testdata/build.sh. Each variant is built the
+// first time a test asks for it; tests of variants whose toolchain
+// isn't installed are skipped.
+package fixture
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// variant is one way of building a fixture.
+type variant struct {
+	// tools must all be installed to build it
+	tools []string
+	build func(out string) error
+}
+
+var variants = map[string]variant{
+	"c-nopie":    cc("c-nopie", "-g", "-no-pie"),
+	"c-pie":      cc("c-pie", "-g", "-fPIE", "-pie"),
+	"c-dwarf2":   cc("c-dwarf2", "-gdwarf-2", "-gstrict-dwarf"),
+	"c-dwarf4":   cc("c-dwarf4", "-gdwarf-4"),
+	"c-dwarf5":   cc("c-dwarf5", "-gdwarf-5"),
+	"c-nodebug":  cc("c-nodebug"),
+	"c-gz":       cc("c-gz", "-g", "-gz=zlib"),
+	"c-rdynamic": cc("c-rdynamic", "-g", "-rdynamic"),
+	"c-zdebug": {
+		tools: []string{"gcc", "objcopy"},
+		build: func(out string) error {
+			return runAll(out,
+				gccArgs("c-zdebug", "-g"),
+				[]string{"objcopy", "--compress-debug-sections=zlib-gnu", "c-zdebug"},
+			)
+		},
+	},
+	"c-debuglink": {
+		tools: []string{"gcc", "objcopy", "strip"},
+		build: func(out string) error {
+			return runAll(out,
+				gccArgs("c-debuglink", "-g", "-fPIE", "-pie"),
+				[]string{"objcopy", "--only-keep-debug", "c-debuglink", "c-debuglink.debug"},
+				[]string{"strip", "--strip-debug", "c-debuglink"},
+				[]string{"objcopy", "--add-gnu-debuglink=c-debuglink.debug", "c-debuglink"},
+			)
+		},
+	},
+	"c-stripped": {
+		tools: []string{"gcc", "objcopy", "strip"},
+		build: func(out string) error {
+			return runAll(out,
+				gccArgs("c-stripped", "-g", "-no-pie"),
+				[]string{"objcopy", "--only-keep-debug", "c-stripped", "c-stripped.debug"},
+				[]string{"strip", "--strip-all", "c-stripped"},
+				[]string{"objcopy", "--add-gnu-debuglink=c-stripped.debug", "c-stripped"},
+			)
+		},
+	},
+	"split/c-split":     split5,
+	"split4/c-split":    split4,
+	"dwp/c-split":       dwp("dwp", "llvm-dwp", "split", split5),
+	"dwp4/c-split":      dwp("dwp4", "dwp", "split4", split4),
+	"split-O2/c-split":  split5O2,
+	"split4-O2/c-split": split4O2,
+	"dwp-O2/c-split":    dwp("dwp-O2", "llvm-dwp", "split-O2", split5O2),
+	"dwp4-O2/c-split":   dwp("dwp4-O2", "dwp", "split4-O2", split4O2),
+	"go-exe":            goBuild("go-exe"),
+	"go-pie":            goBuild("go-pie", "-buildmode=pie"),
+	"go-stripped":       goBuild("go-stripped", "-ldflags=-s"),
+	"go-noopt":          goBuild("go-noopt", "-gcflags=all=-N -l"),
+	"go-arm64":          goBuild("go-arm64"),
+}
+
+var (
+	split5 = split("split", "-gdwarf-5")
+	// GCC's DWARF 4 split units use the GNU extensions that
+	// became DWARF 5's
+	split4 = split("split4", "-gdwarf-4")
+	// optimized builds put parameters in location lists and
+	// scopes in range lists, which are split too
+	split5O2 = split("split-O2", "-gdwarf-5", "-O2")
+	split4O2 = split("split4-O2", "-gdwarf-4", "-O2")
+)
+
+var (
+	mu    sync.Mutex
+	dir   string
+	built = make(map[string]error)
+)
+
+// Path returns the path of the named variant, building it if it
+// hasn't been yet. Names are those of build.sh's outputs, such as
+// c-pie, split/c-split or go-exe. The test is skipped if a tool the
+// variant needs isn't installed.
+func Path(tb testing.TB, name string) string {
+	tb.Helper()
+	v, ok := variants[name]
+	if !ok {
+		tb.Fatalf("unknown fixture %s", name)
+	}
+	for _, tool := range v.tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			tb.Skipf("fixture %s needs %s: %s", name, tool, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if dir == "" {
+		d, err := os.MkdirTemp("", "pptrace-fixture")
+		if err != nil {
+			tb.Fatal(err)
+		}
+		dir = d
+	}
+	if err := ensure(name, v); err != nil {
+		tb.Fatalf("build fixture %s: %s", name, err)
+	}
+	return filepath.Join(dir, name)
+}
+
+// ensure builds the variant v named name in dir unless it's been
+// built already. mu must be held.
+func ensure(name string, v variant) error {
+	err, done := built[name]
+	if !done {
+		err = v.build(dir)
+		built[name] = err
+	}
+	return err
+}
+
+// Cleanup removes the fixtures built so far. Packages using Path
+// call it from TestMain once their tests have run.
+func Cleanup() {
+	mu.Lock()
+	defer mu.Unlock()
+	if dir != "" {
+		os.RemoveAll(dir)
+	}
+	dir = ""
+	built = make(map[string]error)
+}
+
+// testdata returns the repository's testdata directory.
+func testdata() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "testdata")
+}
+
+func gccArgs(name string, flags ...string) []string {
+	args := append([]string{"gcc", "-O0"}, flags...)
+	return append(args, "-o", name, filepath.Join(testdata(), "prog.c"))
+}
+
+func cc(name string, flags ...string) variant {
+	return variant{
+		tools: []string{"gcc"},
+		build: func(out string) error {
+			return runAll(out, gccArgs(name, flags...))
+		},
+	}
+}
+
+// split builds with -gsplit-dwarf in its own directory, as the .dwo
+// files are left next to the objects.
+func split(sub string, flags ...string) variant {
+	return variant{
+		tools: []string{"gcc"},
+		build: func(out string) error {
+			out = filepath.Join(out, sub)
+			if err := os.MkdirAll(out, 0755); err != nil {
+				return err
+			}
+			flags := append([]string{"-g", "-gsplit-dwarf"}, flags...)
+			return runAll(out, gccArgs("c-split", flags...))
+		},
+	}
+}
+
+// dwp packs the .dwo files of the split variant src, built in
+// from, into a .dwp next to a copy of its binary.
+func dwp(sub, tool, from string, src variant) variant {
+	return variant{
+		tools: []string{"gcc", tool},
+		build: func(out string) error {
+			if err := ensure(from+"/c-split", src); err != nil {
+				return err
+			}
+			dst := filepath.Join(out, sub)
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return err
+			}
+			return runAll(filepath.Join(out, from),
+				[]string{"cp", "c-split", filepath.Join(dst, "c-split")},
+				[]string{tool, "-e", "c-split", "-o", filepath.Join(dst, "c-split.dwp")},
+			)
+		},
+	}
+}
+
+func goBuild(name string, flags ...string) variant {
+	return variant{
+		tools: []string{"go"},
+		build: func(out string) error {
+			args := append([]string{"go", "build"}, flags...)
+			args = append(args, "-o", filepath.Join(out, name), ".")
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Dir = filepath.Join(testdata(), "goprog")
+			cmd.Env = append(os.Environ(), "GOFLAGS=", "CGO_ENABLED=0")
+			if strings.HasPrefix(name, "go-arm64") {
+				cmd.Env = append(cmd.Env, "GOARCH=arm64")
+			}
+			return run(cmd)
+		},
+	}
+}
+
+// runAll runs each command in dir in turn, stopping at the first
+// to fail.
+func runAll(dir string, cmds ...[]string) error {
+	for _, args := range cmds {
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Dir = dir
+		if err := run(cmd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func run(cmd *exec.Cmd) error {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %s\n%s", strings.Join(cmd.Args, " "), err, out.String())
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Package render writes command results in the output format
+// chosen with --format.
+package render
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/psanford/pptrace/internal/yaml"
+)
+
+// Format is an output format.
+type Format string
+
+const (
+	// Text is each command's own human readable output.
+	Text Format = "text"
+	JSON Format = "json"
+	YAML Format = "yaml"
+)
+
+// Output is the format selected with the global --format flag.
+var Output = Text
+
+func (f *Format) String() string {
+	return string(*f)
+}
+
+// Set implements pflag.Value.
+func (f *Format) Set(s string) error {
+	switch Format(s) {
+	case Text, JSON, YAML:
+		*f = Format(s)
+		return nil
+	}
+	return fmt.Errorf("unknown format %q, must be text, json or yaml", s)
+}
+
+// Type implements pflag.Value.
+func (f *Format) Type() string {
+	return "format"
+}
+
+// Render writes v to w in format, which must be JSON or YAML. YAML
+// is rendered from v's JSON encoding, so json struct tags name the
+// fields of both.
+func Render(w io.Writer, format Format, v interface{}) error {
+	switch format {
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case YAML:
+		return yaml.Encode(w, v)
+	}
+	return fmt.Errorf("%s output has no structured form", format)
+}
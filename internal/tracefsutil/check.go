@@ -0,0 +1,46 @@
+package tracefsutil
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/psanford/tracefs"
+)
+
+// Check verifies that tracefs is mounted and that the current
+// process can create uprobes, returning an error describing how
+// to fix the problem if not.
+func Check() error {
+	dir := filepath.Clean(Dir(&tracefs.DefaultInstance))
+
+	if _, err := os.Stat(filepath.Join(dir, "trace")); err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return permissionError(dir)
+		}
+		return fmt.Errorf("tracefs is not mounted at %s; mount it with:\n\tmount -t tracefs nodev %s", dir, dir)
+	}
+
+	events := filepath.Join(dir, "uprobe_events")
+	if _, err := os.Stat(events); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%s does not exist; the kernel was built without CONFIG_UPROBE_EVENTS", events)
+	}
+
+	// opening without O_TRUNC leaves existing probes alone
+	f, err := os.OpenFile(events, os.O_WRONLY, 0)
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			return permissionError(dir)
+		}
+		return fmt.Errorf("open %s err: %w", events, err)
+	}
+	f.Close()
+
+	return nil
+}
+
+func permissionError(dir string) error {
+	return fmt.Errorf("no permission to write to %s; run as root or grant CAP_SYS_ADMIN (CAP_PERFMON on 5.8+ kernels, with tracefs permissions opened up)", dir)
+}
@@ -0,0 +1,156 @@
+package tracefsutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/psanford/tracefs"
+)
+
+// HostOrder is the byte order of ring buffer pages and records,
+// the host's; every architecture pptrace supports is little endian.
+var HostOrder = binary.LittleEndian
+
+// FormatField is one field of an event format description, e.g.
+//
+//	field:unsigned long __probe_ip;	offset:8;	size:8;	signed:0;
+type FormatField struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Size   int    `json:"size"`
+	Signed bool   `json:"signed"`
+}
+
+// EventFormat is a parsed events/<group>/<event>/format file.
+type EventFormat struct {
+	ID     uint16        `json:"id"`
+	Name   string        `json:"name"`
+	Fields []FormatField `json:"fields"`
+	// Print is the printf style format the kernel renders the
+	// event's fields with in the text trace.
+	Print string `json:"print"`
+}
+
+var FormatFieldRe = regexp.MustCompile(`field:\s*(.+?)\s*;\s*offset:(\d+);\s*size:(\d+);(?:\s*signed:(\d+);)?`)
+
+// ParseFormat parses an event format file, or the header_page
+// file which has the same field syntax.
+func ParseFormat(data []byte) (*EventFormat, error) {
+	var f EventFormat
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "name:"):
+			f.Name = strings.TrimSpace(strings.TrimPrefix(line, "name:"))
+		case strings.HasPrefix(line, "ID:"):
+			id, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "ID:")), 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("bad event id %q", line)
+			}
+			f.ID = uint16(id)
+		case strings.HasPrefix(line, "print fmt:"):
+			f.Print = strings.TrimSpace(strings.TrimPrefix(line, "print fmt:"))
+		case strings.HasPrefix(line, "field:"):
+			m := FormatFieldRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("bad format field %q", line)
+			}
+			// the declaration is "<type> <name>", with any array
+			// suffix on the name belonging to the type
+			decl := m[1]
+			i := strings.LastIndexAny(decl, " \t")
+			if i < 0 {
+				return nil, fmt.Errorf("bad format field %q", line)
+			}
+			field := FormatField{
+				Type: strings.TrimSpace(decl[:i]),
+				Name: decl[i+1:],
+			}
+			if j := strings.IndexByte(field.Name, '['); j >= 0 {
+				field.Type += field.Name[j:]
+				field.Name = field.Name[:j]
+			}
+			field.Offset, _ = strconv.Atoi(m[2])
+			field.Size, _ = strconv.Atoi(m[3])
+			field.Signed = m[4] == "1"
+			f.Fields = append(f.Fields, field)
+		}
+	}
+	return &f, scanner.Err()
+}
+
+// Field returns the field called name.
+func (f *EventFormat) Field(name string) (FormatField, bool) {
+	for _, field := range f.Fields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return FormatField{}, false
+}
+
+// Uint reads an unsigned integer field from rec, or 0 if the
+// field doesn't fit.
+func (field FormatField) Uint(rec []byte) uint64 {
+	if field.Offset+field.Size > len(rec) {
+		return 0
+	}
+	b := rec[field.Offset : field.Offset+field.Size]
+	switch field.Size {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(HostOrder.Uint16(b))
+	case 4:
+		return uint64(HostOrder.Uint32(b))
+	case 8:
+		return HostOrder.Uint64(b)
+	}
+	return 0
+}
+
+// Int reads a field as a signed integer, sign extending from its
+// size.
+func (field FormatField) Int(rec []byte) int64 {
+	v := field.Uint(rec)
+	shift := 64 - 8*uint(field.Size)
+	return int64(v<<shift) >> shift
+}
+
+// Format renders a fetch arg field the way the kernel prints it
+// in trace_pipe.
+func (field FormatField) Format(rec []byte) string {
+	switch {
+	case strings.HasPrefix(field.Type, "__data_loc"):
+		// offset in the low 16 bits, length in the high 16
+		loc := field.Uint(rec)
+		off, n := int(loc&0xffff), int(loc>>16)
+		if off+n > len(rec) {
+			return `""`
+		}
+		return strconv.Quote(strings.TrimRight(string(rec[off:off+n]), "\x00"))
+	case strings.HasPrefix(field.Type, "x"):
+		return fmt.Sprintf("0x%x", field.Uint(rec))
+	case field.Signed || strings.HasPrefix(field.Type, "s"):
+		return strconv.FormatInt(field.Int(rec), 10)
+	}
+	return strconv.FormatUint(field.Uint(rec), 10)
+}
+
+// ReadEventFormat reads and parses the format of event group/event
+// in inst.
+func ReadEventFormat(inst *tracefs.Instance, group, event string) (*EventFormat, error) {
+	data, err := ReadFile(inst, filepath.Join("events", group, event, "format"))
+	if err != nil {
+		return nil, err
+	}
+	return ParseFormat(data)
+}
@@ -0,0 +1,180 @@
+package tracefsutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/psanford/tracefs"
+)
+
+// Probe is a dynamic probe parsed from uprobe_events or
+// kprobe_events.
+type Probe struct {
+	// Kind is "uprobe" or "kprobe".
+	Kind   string `json:"kind"`
+	Return bool   `json:"return"`
+	Group  string `json:"group"`
+	Event  string `json:"event"`
+	// Target is path:offset for uprobes and symbol[+offset]
+	// for kprobes.
+	Target    string   `json:"target"`
+	Path      string   `json:"path,omitempty"`
+	Offset    uint64   `json:"offset,omitempty"`
+	FetchArgs []string `json:"fetch_args,omitempty"`
+}
+
+func (p Probe) String() string {
+	typ := "p"
+	if p.Return {
+		typ = "r"
+	}
+	s := fmt.Sprintf("%s:%s/%s %s", typ, p.Group, p.Event, p.Target)
+	if len(p.FetchArgs) > 0 {
+		s += " " + strings.Join(p.FetchArgs, " ")
+	}
+	return s
+}
+
+// UprobeEvent converts p back into a tracefs.UprobeEvent, suitable
+// for passing to RemoveUprobeEvent.
+func (p Probe) UprobeEvent() *tracefs.UprobeEvent {
+	e := tracefs.UprobeEvent{
+		ReturnProbe: p.Return,
+		Group:       p.Group,
+		Event:       p.Event,
+		Path:        p.Path,
+		Offset:      p.Offset,
+	}
+	for _, a := range p.FetchArgs {
+		e.FetchArgs = append(e.FetchArgs, rawFetchArg(a))
+	}
+	return &e
+}
+
+// rawFetchArg is a fetch arg already in the kernel's syntax.
+type rawFetchArg string
+
+func (a rawFetchArg) Type() string {
+	if i := strings.LastIndex(string(a), ":"); i >= 0 {
+		return string(a[i+1:])
+	}
+	return ""
+}
+
+func (a rawFetchArg) String() string {
+	return string(a)
+}
+
+// ListProbes returns the uprobes and kprobes currently registered.
+func ListProbes(inst *tracefs.Instance) ([]Probe, error) {
+	var probes []Probe
+	for _, kind := range []string{"uprobe", "kprobe"} {
+		data, err := ReadFile(inst, kind+"_events")
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		p, err := ParseProbes(kind, string(data))
+		if err != nil {
+			return nil, err
+		}
+		probes = append(probes, p...)
+	}
+	return probes, nil
+}
+
+// ParseProbes parses the contents of a uprobe_events or
+// kprobe_events file.
+func ParseProbes(kind, data string) ([]Probe, error) {
+	var probes []Probe
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		p, err := parseProbe(kind, line)
+		if err != nil {
+			return nil, err
+		}
+		probes = append(probes, p)
+	}
+	return probes, nil
+}
+
+func parseProbe(kind, line string) (Probe, error) {
+	p := Probe{
+		Kind: kind,
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return p, fmt.Errorf("malformed %s event: %q", kind, line)
+	}
+
+	typ, name, ok := strings.Cut(fields[0], ":")
+	if !ok || typ == "" {
+		return p, fmt.Errorf("malformed %s event: %q", kind, line)
+	}
+	// kretprobes may have a maxactive count, e.g. r16
+	p.Return = typ[0] == 'r'
+
+	p.Group, p.Event, ok = strings.Cut(name, "/")
+	if !ok {
+		p.Group, p.Event = "", name
+	}
+
+	p.Target = fields[1]
+	p.FetchArgs = fields[2:]
+
+	if kind == "uprobe" {
+		i := strings.LastIndex(p.Target, ":")
+		if i < 0 {
+			return p, fmt.Errorf("malformed uprobe target: %q", p.Target)
+		}
+		p.Path = p.Target[:i]
+		off := p.Target[i+1:]
+		// ref_ctr_offset may follow in parens
+		if j := strings.Index(off, "("); j >= 0 {
+			off = off[:j]
+		}
+		offset, err := strconv.ParseUint(off, 0, 64)
+		if err != nil {
+			return p, fmt.Errorf("malformed uprobe offset: %q", p.Target)
+		}
+		p.Offset = offset
+	}
+
+	return p, nil
+}
+
+// RemoveProbe disables and unregisters p. If removing the exact
+// probe fails (e.g. the target binary no longer exists), removal
+// by name alone is attempted.
+func RemoveProbe(inst *tracefs.Instance, p Probe) error {
+	// the kernel refuses to remove enabled events
+	os.WriteFile(Path(inst, filepath.Join("events", p.Group, p.Event, "enable")), []byte("0"), 0644)
+
+	if p.Kind == "uprobe" {
+		err := inst.RemoveUprobeEvent(p.UprobeEvent())
+		if err == nil {
+			return nil
+		}
+	}
+
+	f, err := os.OpenFile(Path(inst, p.Kind+"_events"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "-:%s/%s\n", p.Group, p.Event)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,240 @@
+package tracefsutil
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/psanford/tracefs"
+)
+
+// Dir returns the directory backing inst.
+func Dir(inst *tracefs.Instance) string {
+	// tracefs doesn't expose the instance path directly, but it does
+	// expose the path of the uprobes enable file which lives at a
+	// fixed location under it.
+	p := inst.UprobeEnablePath(&tracefs.UprobeEvent{})
+	return strings.TrimSuffix(p, filepath.Join("events", "uprobes", "enable"))
+}
+
+// Path returns the path of the named file in inst.
+func Path(inst *tracefs.Instance, name string) string {
+	return filepath.Join(Dir(inst), name)
+}
+
+// ReadFile reads the named file from inst, trimming surrounding
+// whitespace.
+func ReadFile(inst *tracefs.Instance, name string) ([]byte, error) {
+	data, err := os.ReadFile(Path(inst, name))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(data), nil
+}
+
+// WriteFile writes data to the named file in inst.
+func WriteFile(inst *tracefs.Instance, name string, data []byte) error {
+	return os.WriteFile(Path(inst, name), data, 0644)
+}
+
+// AvailableTracers returns the tracers listed in inst's
+// available_tracers file.
+func AvailableTracers(inst *tracefs.Instance) ([]tracefs.Tracer, error) {
+	data, err := ReadFile(inst, "available_tracers")
+	if err != nil {
+		return nil, err
+	}
+
+	var tracers []tracefs.Tracer
+	for _, t := range strings.Fields(string(data)) {
+		tracers = append(tracers, tracefs.Tracer(t))
+	}
+	return tracers, nil
+}
+
+// Snapshot returns the current contents of inst's trace buffer
+// without consuming it.
+func Snapshot(inst *tracefs.Instance) ([]byte, error) {
+	return os.ReadFile(Path(inst, "trace"))
+}
+
+// ClearTrace empties inst's trace buffer.
+func ClearTrace(inst *tracefs.Instance) error {
+	return WriteFile(inst, "trace", nil)
+}
+
+// BufferSizeKB returns inst's per-CPU ring buffer size in KB.
+func BufferSizeKB(inst *tracefs.Instance) (int, error) {
+	data, err := ReadFile(inst, "buffer_size_kb")
+	if err != nil {
+		return 0, err
+	}
+	// before the buffer is first used the kernel reports
+	// e.g. "7 (expanded: 1408)"
+	if i := bytes.Index(data, []byte("(expanded: ")); i >= 0 {
+		data = bytes.TrimSuffix(data[i+len("(expanded: "):], []byte(")"))
+	}
+	return strconv.Atoi(string(bytes.TrimSpace(data)))
+}
+
+// SetBufferSizeKB sets inst's per-CPU ring buffer size and returns
+// the size the kernel actually applied, which may be rounded.
+func SetBufferSizeKB(inst *tracefs.Instance, kb int) (int, error) {
+	err := WriteFile(inst, "buffer_size_kb", []byte(strconv.Itoa(kb)))
+	if err != nil {
+		return 0, err
+	}
+	return BufferSizeKB(inst)
+}
+
+// CPUStats are the ring buffer statistics for a single CPU.
+type CPUStats struct {
+	CPU     int
+	Entries uint64
+	Overrun uint64
+	Dropped uint64
+}
+
+// Stats reads the per-CPU ring buffer statistics for inst.
+func Stats(inst *tracefs.Instance) ([]CPUStats, error) {
+	dirs, err := filepath.Glob(Path(inst, filepath.Join("per_cpu", "cpu*")))
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []CPUStats
+	for _, dir := range dirs {
+		cpu, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "cpu"))
+		if err != nil {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "stats"))
+		if err != nil {
+			return nil, err
+		}
+
+		st := CPUStats{
+			CPU: cpu,
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			key, val, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			n, err := strconv.ParseUint(strings.TrimSpace(val), 10, 64)
+			if err != nil {
+				continue
+			}
+			switch key {
+			case "entries":
+				st.Entries = n
+			case "overrun":
+				st.Overrun = n
+			case "dropped events":
+				st.Dropped = n
+			}
+		}
+		stats = append(stats, st)
+	}
+
+	return stats, nil
+}
+
+// Options returns the names of the trace options inst supports.
+func Options(inst *tracefs.Instance) ([]string, error) {
+	entries, err := os.ReadDir(Path(inst, "options"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+// Option returns whether the named trace option is set in inst.
+func Option(inst *tracefs.Instance, name string) (bool, error) {
+	data, err := ReadFile(inst, filepath.Join("options", name))
+	if err != nil {
+		return false, err
+	}
+	return string(data) == "1", nil
+}
+
+// SetOption sets the named trace option in inst and returns
+// its previous value.
+func SetOption(inst *tracefs.Instance, name string, on bool) (bool, error) {
+	prev, err := Option(inst, name)
+	if err != nil {
+		return false, err
+	}
+	val := "0"
+	if on {
+		val = "1"
+	}
+	return prev, WriteFile(inst, filepath.Join("options", name), []byte(val))
+}
+
+// SupportsArrayArgs reports whether the kernel accepts array
+// fetch arg types such as x8[16], added in Linux 4.20, going by
+// the probe syntax described in its tracefs README.
+func SupportsArrayArgs(inst *tracefs.Instance) (bool, error) {
+	data, err := ReadFile(inst, "README")
+	if err != nil {
+		return false, err
+	}
+	return bytes.Contains(data, []byte("<array-size>")), nil
+}
+
+// LastError returns the most recent entry of the kernel's
+// error_log, which explains why a write to a control file such as
+// uprobe_events failed. It's empty if there is none or the kernel
+// predates error_log (Linux 5.4).
+func LastError(inst *tracefs.Instance) string {
+	data, err := ReadFile(inst, "error_log")
+	if err != nil || len(data) == 0 {
+		return ""
+	}
+	// each entry starts with a "[timestamp]" line followed by
+	// indented command and caret lines
+	lines := strings.Split(string(data), "\n")
+	start := 0
+	for i, line := range lines {
+		if strings.HasPrefix(line, "[") {
+			start = i
+		}
+	}
+	return strings.Join(lines[start:], "\n")
+}
+
+// MountPoints are where tracefs is looked for: its own mount point,
+// then under debugfs, where older kernels only have it.
+var MountPoints = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+
+// UseRoot points tracefs.DefaultInstance, which the tracefs package
+// and pptrace's commands work in, at the tracefs mounted at dir. If
+// dir is empty the first of MountPoints with tracefs mounted is
+// used. It returns the directory chosen.
+func UseRoot(dir string) string {
+	if dir == "" {
+		dir = MountPoints[0]
+		for _, p := range MountPoints {
+			// without permission to look, assume it's there and
+			// leave Check to explain
+			_, err := os.Stat(filepath.Join(p, "trace"))
+			if err == nil || errors.Is(err, fs.ErrPermission) {
+				dir = p
+				break
+			}
+		}
+	}
+	dir = filepath.Clean(dir)
+	tracefs.DefaultInstance = tracefs.RootInstance(dir)
+	return dir
+}
@@ -0,0 +1,26 @@
+// Package vlog logs messages that are only shown at the verbosity
+// chosen with the global -v flag. Warnings and errors are logged
+// with the log package as usual.
+package vlog
+
+import "log"
+
+// Level is the verbosity: 0 for none, 1 (-v) for debug messages,
+// 2 (-vv) for tracing detail as well.
+var Level int
+
+// Debugf logs a debug message, such as a setup step or which
+// source a value came from, if Level is at least 1.
+func Debugf(format string, args ...interface{}) {
+	if Level >= 1 {
+		log.Printf(format, args...)
+	}
+}
+
+// Tracef logs a detailed message, such as each place searched, if
+// Level is at least 2.
+func Tracef(format string, args ...interface{}) {
+	if Level >= 2 {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,385 @@
+package yaml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// line is a line of a YAML document with its indentation removed.
+type line struct {
+	num    int
+	indent int
+	text   string
+}
+
+// Decode parses a block style YAML document: mappings, sequences,
+// flow sequences like [a, b], empty collections, plain and quoted
+// scalars and comments. Mappings are returned as
+// map[string]interface{}, sequences as []interface{}, null as nil
+// and other scalars as strings. An empty document is nil.
+func Decode(data []byte) (interface{}, error) {
+	var lines []line
+	for i, raw := range strings.Split(string(data), "\n") {
+		if lead := raw[:len(raw)-len(strings.TrimLeft(raw, " \t"))]; strings.Contains(lead, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", i+1)
+		}
+		text := strings.TrimRight(stripComment(raw), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		lines = append(lines, line{
+			num:    i + 1,
+			indent: len(text) - len(trimmed),
+			text:   trimmed,
+		})
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	p := parser{lines: lines}
+	var (
+		v   interface{}
+		err error
+	)
+	if _, _, ok, _ := splitKey(lines[0].text); !ok && !isEntry(lines[0].text) {
+		// a document that's a single scalar or flow collection
+		p.pos++
+		v, err = value(lines[0].text)
+		if err != nil {
+			err = fmt.Errorf("line %d: %s", lines[0].num, err)
+		}
+	} else {
+		v, err = p.block(lines[0].indent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", lines[p.pos].num)
+	}
+	return v, nil
+}
+
+type parser struct {
+	lines []line
+	pos   int
+}
+
+// isEntry reports whether text is a sequence entry.
+func isEntry(text string) bool {
+	return strings.HasPrefix(text+" ", "- ")
+}
+
+// block parses the mapping or sequence whose entries start at
+// indent.
+func (p *parser) block(indent int) (interface{}, error) {
+	if isEntry(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *parser) sequence(indent int) ([]interface{}, error) {
+	out := make([]interface{}, 0)
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent || !isEntry(l.text) {
+			return nil, fmt.Errorf("line %d: expected a sequence entry starting with -", l.num)
+		}
+		item := strings.TrimLeft(l.text[1:], " ")
+		if _, _, ok, _ := splitKey(item); ok || isEntry(item) {
+			// a collection starting on the entry's line: read the
+			// rest of the line as the first line of a block
+			// indented to where it starts
+			inner := indent + len(l.text) - len(item)
+			p.lines[p.pos] = line{num: l.num, indent: inner, text: item}
+			v, err := p.block(inner)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+			continue
+		}
+		p.pos++
+
+		v, err := p.nested(l, item, indent)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func (p *parser) mapping(indent int) (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		key, val, ok, err := splitKey(l.text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", l.num, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", l.num)
+		}
+		if _, dup := out[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.num, key)
+		}
+		p.pos++
+
+		if val == "" && p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isEntry(p.lines[p.pos].text) {
+			// a sequence may be at the same indentation as its key
+			v, err := p.sequence(indent)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = v
+			continue
+		}
+		v, err := p.nested(l, val, indent)
+		if err != nil {
+			return nil, err
+		}
+		out[key] = v
+	}
+	return out, nil
+}
+
+// nested returns the value following a key or "-" on line l: val
+// if it's given, or the block indented under l, or null.
+func (p *parser) nested(l line, val string, indent int) (interface{}, error) {
+	if val != "" {
+		v, err := value(val)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", l.num, err)
+		}
+		return v, nil
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return p.block(p.lines[p.pos].indent)
+	}
+	return nil, nil
+}
+
+// splitKey splits a "key: value" line. The value may be empty if
+// a block follows. The key may be quoted.
+func splitKey(text string) (key, val string, ok bool, err error) {
+	end := 0
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		end = quotedEnd(text)
+		if end < 0 {
+			return "", "", false, nil
+		}
+	}
+	i := strings.Index(text[end:]+" ", ": ")
+	if i < 0 || end+i == 0 {
+		return "", "", false, nil
+	}
+	i += end
+	if end > 0 && i != end {
+		return "", "", false, nil
+	}
+	key, err = scalar(text[:i])
+	if err != nil {
+		return "", "", false, err
+	}
+	return key, strings.TrimSpace(text[i+1:]), true, nil
+}
+
+// quotedEnd returns the index just past the quoted scalar s starts
+// with, or -1 if it's unterminated.
+func quotedEnd(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote && quote == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == quote:
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// value returns the value of a scalar or flow collection.
+func value(s string) (interface{}, error) {
+	switch {
+	case s == "{}":
+		return map[string]interface{}{}, nil
+	case strings.HasPrefix(s, "["):
+		return flowSequence(s)
+	case s == "~" || s == "null":
+		return nil, nil
+	}
+	return scalar(s)
+}
+
+// scalar returns the value of a plain or quoted scalar.
+func scalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		if len(s) < 2 || quotedEnd(s) != len(s) {
+			return "", fmt.Errorf("bad double quoted string %s", s)
+		}
+		return unquote(s[1 : len(s)-1])
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || quotedEnd(s) != len(s) {
+			return "", fmt.Errorf("bad single quoted string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	return s, nil
+}
+
+// escapes are the single character escapes of double quoted
+// scalars.
+var escapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t",
+	'n': "\n", 'v': "\v", 'f': "\f", 'r': "\r", 'e': "\x1b",
+	' ': " ", '"': `"`, '/': "/", '\\': `\`, 'N': "\u0085",
+	'_': "\u00a0", 'L': "\u2028", 'P': "\u2029",
+}
+
+// unquote returns the value of the contents s of a double quoted
+// scalar.
+func unquote(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", fmt.Errorf("bad escape at the end of %q", s)
+		}
+		if e, ok := escapes[s[i]]; ok {
+			b.WriteString(e)
+			continue
+		}
+		var n int
+		switch s[i] {
+		case 'x':
+			n = 2
+		case 'u':
+			n = 4
+		case 'U':
+			n = 8
+		}
+		if n == 0 || i+n >= len(s) {
+			return "", fmt.Errorf("bad escape \\%c in %q", s[i], s)
+		}
+		r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+		if err != nil {
+			return "", fmt.Errorf("bad escape \\%s in %q", s[i:i+1+n], s)
+		}
+		i += n
+		// a UTF-16 surrogate pair, as JSON encoders write
+		if r >= 0xd800 && r < 0xdc00 && strings.HasPrefix(s[i+1:], `\u`) && len(s) >= i+7 {
+			if lo, err := strconv.ParseUint(s[i+3:i+7], 16, 32); err == nil && lo >= 0xdc00 && lo < 0xe000 {
+				r = 0x10000 + (r-0xd800)<<10 + (lo - 0xdc00)
+				i += 6
+			}
+		}
+		if r > utf8.MaxRune {
+			return "", fmt.Errorf("bad escape in %q", s)
+		}
+		b.WriteRune(rune(r))
+	}
+	return b.String(), nil
+}
+
+// flowSequence parses a one line sequence such as [a, "b c"].
+func flowSequence(s string) ([]interface{}, error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("unterminated sequence %s", s)
+	}
+	inner := strings.TrimSpace(s[1 : len(s)-1])
+	out := make([]interface{}, 0)
+	if inner == "" {
+		return out, nil
+	}
+
+	var (
+		item  strings.Builder
+		quote byte
+	)
+	flush := func() error {
+		v, err := value(strings.TrimSpace(item.String()))
+		item.Reset()
+		out = append(out, v)
+		return err
+	}
+	for i := 0; i < len(inner); i++ {
+		c := inner[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' && i+1 < len(inner) {
+				item.WriteByte(c)
+				i++
+				c = inner[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && opensQuote(inner, i):
+			quote = c
+		case c == ',':
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		item.WriteByte(c)
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated string in %s", s)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// stripComment removes a # comment, which starts a line or follows
+// a space outside of quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && opensQuote(line, i):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// opensQuote reports whether the quote at s[i] starts a quoted
+// scalar rather than being part of a plain one, like the ' in
+// don't.
+func opensQuote(s string, i int) bool {
+	return i == 0 || strings.IndexByte(" \t[,:-", s[i-1]) >= 0
+}
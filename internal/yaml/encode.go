@@ -0,0 +1,171 @@
+// Package yaml writes and reads the block style subset of YAML
+// pptrace uses for --format yaml output and config files. Output is
+// written from a value's JSON encoding, so json struct tags name the
+// fields, and reads back with Decode.
+package yaml
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// node is a decoded JSON value. Objects keep their keys in the
+// order they were encoded, which for structs is field order.
+type node struct {
+	// kind is '{', '[' or 0 for a scalar
+	kind   byte
+	keys   []string
+	vals   []*node
+	scalar string
+}
+
+// Encode writes the JSON encoding of v to w as block style YAML.
+func Encode(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	n, err := decodeNode(dec)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	switch {
+	case n.kind == 0:
+		fmt.Fprintln(bw, n.scalar)
+	case len(n.vals) == 0:
+		fmt.Fprintln(bw, emptyCollection(n))
+	case n.kind == '{':
+		writeMapping(bw, n, 0, false)
+	default:
+		writeSequence(bw, n, 0, false)
+	}
+	return bw.Flush()
+}
+
+func decodeNode(dec *json.Decoder) (*node, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := tok.(type) {
+	case json.Delim:
+		n := node{kind: byte(t)}
+		for dec.More() {
+			if n.kind == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.keys = append(n.keys, key.(string))
+			}
+			val, err := decodeNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.vals = append(n.vals, val)
+		}
+		// the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return &n, nil
+	case string:
+		return &node{scalar: yamlString(t)}, nil
+	case json.Number:
+		return &node{scalar: t.String()}, nil
+	case bool:
+		return &node{scalar: fmt.Sprint(t)}, nil
+	case nil:
+		return &node{scalar: "null"}, nil
+	}
+	return nil, fmt.Errorf("unexpected JSON token %v", tok)
+}
+
+// writeMapping writes the keys of n at indent. With inline the
+// first key follows a sequence entry's "- " on the current line.
+func writeMapping(w io.Writer, n *node, indent int, inline bool) {
+	for i, key := range n.keys {
+		if i > 0 || !inline {
+			io.WriteString(w, strings.Repeat(" ", indent))
+		}
+		io.WriteString(w, yamlString(key)+":")
+		writeValue(w, n.vals[i], indent+2, false)
+	}
+}
+
+// writeSequence writes the entries of n at indent. With inline
+// the first entry follows another entry's "- " on the current line.
+func writeSequence(w io.Writer, n *node, indent int, inline bool) {
+	for i, val := range n.vals {
+		if i > 0 || !inline {
+			io.WriteString(w, strings.Repeat(" ", indent))
+		}
+		io.WriteString(w, "-")
+		writeValue(w, val, indent+2, true)
+	}
+}
+
+// writeValue writes n following a key or "-", nesting
+// collections at indent. Mappings and sequences in a sequence
+// start on the entry's line.
+func writeValue(w io.Writer, n *node, indent int, inSequence bool) {
+	switch {
+	case n.kind == 0:
+		io.WriteString(w, " "+n.scalar+"\n")
+	case len(n.vals) == 0:
+		io.WriteString(w, " "+emptyCollection(n)+"\n")
+	case inSequence:
+		io.WriteString(w, " ")
+		if n.kind == '{' {
+			writeMapping(w, n, indent, true)
+		} else {
+			writeSequence(w, n, indent, true)
+		}
+	default:
+		io.WriteString(w, "\n")
+		if n.kind == '{' {
+			writeMapping(w, n, indent, false)
+		} else {
+			writeSequence(w, n, indent, false)
+		}
+	}
+}
+
+func emptyCollection(n *node) string {
+	if n.kind == '{' {
+		return "{}"
+	}
+	return "[]"
+}
+
+// plainRe matches strings that can be written unquoted without
+// being read back as something else.
+var plainRe = regexp.MustCompile(`^(?:[A-Za-z_/]|\.[A-Za-z_])[A-Za-z0-9_/.()*+<>=, -]*$`)
+
+// yamlReserved are plain scalars YAML reads as booleans, null or
+// special floats.
+var yamlReserved = map[string]bool{
+	"true": true, "false": true, "null": true, "yes": true, "no": true,
+	"on": true, "off": true, "y": true, "n": true, ".inf": true, ".nan": true,
+}
+
+// yamlString returns s as a YAML scalar, double quoted unless it's
+// safe to leave plain. A JSON string is a valid YAML double quoted
+// one.
+func yamlString(s string) string {
+	if plainRe.MatchString(s) && !strings.HasSuffix(s, " ") && !yamlReserved[strings.ToLower(s)] {
+		return s
+	}
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
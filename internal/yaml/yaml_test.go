@@ -0,0 +1,198 @@
+package yaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	type section struct {
+		Name  string   `json:"name"`
+		Flags []string `json:"flags"`
+	}
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{"text", "text\n"},
+		{[]int{}, "[]\n"},
+		{map[string]int{}, "{}\n"},
+		{
+			[]section{{Name: ".text", Flags: []string{"alloc", "exec"}}, {Name: "true", Flags: nil}},
+			`- name: .text
+  flags:
+    - alloc
+    - exec
+- name: "true"
+  flags: null
+`,
+		},
+		{
+			map[string]interface{}{"a:b": "x #y", "count": 12, "nested": [][]string{{"a"}, {}}},
+			`"a:b": "x #y"
+count: 12
+nested:
+  - - a
+  - []
+`,
+		},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := Encode(&buf, tt.v); err != nil {
+			t.Fatalf("Encode(%v): %s", tt.v, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("Encode(%v):\n%s\nwant:\n%s", tt.v, buf.String(), tt.want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want interface{}
+	}{
+		{"", nil},
+		{"# only a comment\n---\n", nil},
+		{"text", "text"},
+		{
+			`instance: mytrace # a comment
+debug-dir: [/opt/debug, "~/debug", 'it''s']
+empty:
+quoted: "a\tb \"c\" é\U0001F600😀 #"
+"a: b": 'x'
+presets:
+  io:
+  - ./prog do_read
+  - ./prog do_write
+`,
+			map[string]interface{}{
+				"instance":  "mytrace",
+				"debug-dir": []interface{}{"/opt/debug", "~/debug", "it's"},
+				"empty":     nil,
+				"quoted":    "a\tb \"c\" é\U0001F600\U0001F600 #",
+				"a: b":      "x",
+				"presets": map[string]interface{}{
+					"io": []interface{}{"./prog do_read", "./prog do_write"},
+				},
+			},
+		},
+		{
+			`- name: a
+  flags:
+    - - x
+      - ~
+    - {}
+-
+  - 1
+- []
+`,
+			[]interface{}{
+				map[string]interface{}{
+					"name":  "a",
+					"flags": []interface{}{[]interface{}{"x", nil}, map[string]interface{}{}},
+				},
+				[]interface{}{"1"},
+				[]interface{}{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := Decode([]byte(tt.doc))
+		if err != nil {
+			t.Errorf("Decode(%q): %s", tt.doc, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Decode(%q) = %#v, want %#v", tt.doc, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, doc := range []string{
+		"a: 1\n\tb: 2",
+		"a: 1\n  b: 2",
+		"a: 1\na: 2",
+		"- a\nb: c",
+		"a: [b",
+		`a: "b`,
+		`a: "\q"`,
+		`a: "\u12"`,
+	} {
+		if v, err := Decode([]byte(doc)); err == nil {
+			t.Errorf("Decode(%q) = %#v, want an error", doc, v)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	type probe struct {
+		Binary   string            `json:"binary"`
+		Offset   uint64            `json:"offset"`
+		Args     []string          `json:"args"`
+		Labels   map[string]string `json:"labels"`
+		Parts    [][]interface{}   `json:"parts"`
+		Disabled bool              `json:"disabled"`
+	}
+	v := []probe{
+		{
+			Binary: "/usr/bin/prog",
+			Offset: 0x1136,
+			Args:   []string{"fd=%di:s32", "path=+0(%si):string", "", "- x", "null", "a: b", "#c", " lead", "q\"uo'te", "tab\there", " <&>"},
+			Labels: map[string]string{"key: colon": "v", "": "empty", "yes": "no"},
+			Parts:  [][]interface{}{{1.5, nil, true}, {}},
+		},
+		{},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, v); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Decode of\n%s: %s", buf.String(), err)
+	}
+
+	// scalars other than null read back as strings
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var want interface{}
+	if err := dec.Decode(&want); err != nil {
+		t.Fatal(err)
+	}
+	want = scalarStrings(want)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nfrom\n%s\nwant %#v", got, buf.String(), want)
+	}
+}
+
+// scalarStrings returns the JSON value v with its numbers and
+// booleans as strings, the way Decode reads them.
+func scalarStrings(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = scalarStrings(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = scalarStrings(e)
+		}
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return v
+}
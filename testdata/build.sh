@@ -0,0 +1,136 @@
+#!/bin/sh
+# build.sh builds prog.c and goprog in the variants pptrace has to
+# cope with: PIE and non-PIE, DWARF 4 and 5, compressed debug
+# sections, separate debug files found by debuglink and build id,
+# and split DWARF. Variants whose toolchain isn't installed are
+# skipped. Tests build the same variants with internal/fixture.
+#
+# usage: testdata/build.sh [outdir]   (default testdata/out)
+
+set -e
+
+dir=$(cd "$(dirname "$0")" && pwd)
+out=${1:-$dir/out}
+mkdir -p "$out"
+
+have() {
+	command -v "$1" >/dev/null 2>&1
+}
+
+skip() {
+	echo "skip $1: $2 not found" >&2
+}
+
+built() {
+	echo "built $out/$1"
+}
+
+if have gcc; then
+	cc() {
+		name=$1
+		shift
+		gcc -O0 "$@" -o "$out/$name" "$dir/prog.c"
+		built "$name"
+	}
+
+	cc c-nopie -g -no-pie
+	cc c-pie -g -fPIE -pie
+	cc c-dwarf2 -gdwarf-2 -gstrict-dwarf
+	cc c-dwarf4 -gdwarf-4
+	cc c-dwarf5 -gdwarf-5
+	cc c-nodebug
+	cc c-gz -g -gz=zlib
+	cc c-rdynamic -g -rdynamic
+
+	# split DWARF leaves .dwo files next to the objects, so it's
+	# built in its own directory. GCC's DWARF 4 split units use
+	# the GNU extensions that became DWARF 5's.
+	# Optimized builds put parameters in location lists and scopes
+	# in range lists, which are split too.
+	splitcc() {
+		sub=$1
+		shift
+		mkdir -p "$out/$sub"
+		(cd "$out/$sub" && gcc -O0 -g -gsplit-dwarf "$@" -o c-split "$dir/prog.c")
+		built "$sub/c-split"
+	}
+	splitcc split -gdwarf-5
+	splitcc split4 -gdwarf-4
+	splitcc split-O2 -gdwarf-5 -O2
+	splitcc split4-O2 -gdwarf-4 -O2
+
+	# GNU dwp only handles DWARF 4
+	packdwp() {
+		tool=$1
+		from=$2
+		to=$3
+		if ! have "$tool"; then
+			skip "$to" "$tool"
+			return
+		fi
+		mkdir -p "$out/$to"
+		cp "$out/$from/c-split" "$out/$to/c-split"
+		if (cd "$out/$from" && $tool -e c-split -o "$out/$to/c-split.dwp"); then
+			built "$to/c-split"
+		else
+			echo "skip $to: $tool failed" >&2
+			rm -rf "${out:?}/$to"
+		fi
+	}
+	packdwp llvm-dwp split dwp
+	packdwp dwp split4 dwp4
+	packdwp llvm-dwp split-O2 dwp-O2
+	packdwp dwp split4-O2 dwp4-O2
+
+	if have objcopy && have strip; then
+		# c-zdebug has legacy .zdebug sections rather than
+		# SHF_COMPRESSED ones
+		cc c-zdebug -g
+		objcopy --compress-debug-sections=zlib-gnu "$out/c-zdebug"
+
+		# c-debuglink finds c-debuglink.debug next to it
+		cp "$out/c-pie" "$out/c-debuglink"
+		objcopy --only-keep-debug "$out/c-debuglink" "$out/c-debuglink.debug"
+		strip --strip-debug "$out/c-debuglink"
+		(cd "$out" && objcopy --add-gnu-debuglink=c-debuglink.debug c-debuglink)
+		built c-debuglink
+
+		# c-stripped has no symbol table either, so its functions
+		# are only found in c-stripped.debug
+		cp "$out/c-nopie" "$out/c-stripped"
+		objcopy --only-keep-debug "$out/c-stripped" "$out/c-stripped.debug"
+		strip --strip-all "$out/c-stripped"
+		(cd "$out" && objcopy --add-gnu-debuglink=c-stripped.debug c-stripped)
+		built c-stripped
+
+		# c-buildid's debug info is under debug/.build-id, for
+		# --debug-dir
+		gcc -O0 -g -Wl,--build-id -o "$out/c-buildid" "$dir/prog.c"
+		id=$(readelf -n "$out/c-buildid" | sed -n 's/.*Build ID: //p')
+		mkdir -p "$out/debug/.build-id/$(echo "$id" | cut -c1-2)"
+		objcopy --only-keep-debug "$out/c-buildid" "$out/debug/.build-id/$(echo "$id" | cut -c1-2)/$(echo "$id" | cut -c3-).debug"
+		strip --strip-debug "$out/c-buildid"
+		built c-buildid
+	else
+		skip "c-zdebug, c-debuglink, c-stripped and c-buildid" "objcopy or strip"
+	fi
+else
+	skip "C programs" gcc
+fi
+
+if have go; then
+	gobuild() {
+		name=$1
+		shift
+		(cd "$dir/goprog" && go build "$@" -o "$out/$name" .)
+		built "$name"
+	}
+
+	gobuild go-exe
+	gobuild go-pie -buildmode=pie
+	gobuild go-stripped -ldflags=-s
+	gobuild go-noopt -gcflags=all=-N\ -l
+	GOARCH=arm64 gobuild go-arm64
+else
+	skip "Go programs" go
+fi
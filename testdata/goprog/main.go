@@ -0,0 +1,41 @@
+// Command goprog is a small Go program for trying pptrace's inspect
+// and trace commands against the builds made by build.sh.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type Point struct {
+	X, Y int
+}
+
+//go:noinline
+func mixed(i int, f float64, p *Point, s string, b byte) int {
+	return i + p.X + len(s) + int(b) + int(f)
+}
+
+//go:noinline
+func (p *Point) Scale(by int) Point {
+	return Point{p.X * by, p.Y * by}
+}
+
+//go:noinline
+func (p Point) Sum() int {
+	return p.X + p.Y
+}
+
+//go:noinline
+func Max[T int | float64](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func main() {
+	p := &Point{1, 2}
+	fmt.Println(mixed(len(os.Args), 1.5, p, "hello", 'x'), p.Scale(3))
+	fmt.Println(p.Sum(), Max(len(os.Args), 2), Max(1.5, 2.5))
+}
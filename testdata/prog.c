@@ -0,0 +1,68 @@
+// prog.c is a small C program for trying pptrace's inspect and
+// trace commands against the builds made by build.sh.
+#include <stdio.h>
+#include <stdint.h>
+
+struct point {
+	int x, y;
+};
+
+struct flags {
+	unsigned int ready : 1;
+	unsigned int mode : 3;
+	uint16_t id;
+	union {
+		int32_t i;
+		float f;
+	} val;
+	struct {
+		char tag[4];
+		long n;
+	} inner;
+};
+
+struct flags current;
+static int calls;
+
+__attribute__((noinline)) int add(struct point *p, int k)
+{
+	calls++;
+	return p->x + p->y + k;
+}
+
+__attribute__((noinline)) static long scale(struct flags *f, long by)
+{
+	return f->inner.n * by + f->mode;
+}
+
+__attribute__((noinline)) long label(const char *name, volatile long *seen)
+{
+	*seen += name[0];
+	return *seen;
+}
+
+// step is a GCC nested function, which the debug info puts in the
+// lexical block of the loop it's defined in
+__attribute__((noinline)) int nested(int n)
+{
+	int total = 0;
+	for (int i = 0; i < n; i++) {
+		__attribute__((noinline)) int step(int k)
+		{
+			return k * i;
+		}
+		total += step(n);
+	}
+	return total;
+}
+
+int main(int argc, char **argv)
+{
+	struct point p = {1, 2};
+	volatile long seen = 0;
+	current.mode = 5;
+	current.inner.n = argc;
+	printf("%d %ld\n", add(&p, argc), scale(&current, 3));
+	printf("%ld %d\n", label(argv[0], &seen), nested(argc));
+	return calls > 1;
+}
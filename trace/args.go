@@ -0,0 +1,221 @@
+package trace
+
+import (
+	"debug/elf"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/psanford/pptrace/inspect"
+)
+
+// fetchArg is a compiled uprobe fetch argument in the kernel's
+// NAME=FETCHARG[:TYPE] syntax.
+type fetchArg struct {
+	name  string
+	fetch string
+	typ   string
+
+	// elem and count are set for array types, typ being
+	// elem[count]
+	elem  string
+	count int
+}
+
+func (a fetchArg) Type() string {
+	return a.typ
+}
+
+func (a fetchArg) String() string {
+	s := a.name + "=" + a.fetch
+	if a.typ != "" {
+		s += ":" + a.typ
+	}
+	return s
+}
+
+var argNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// fetchTypeRe matches the fetcharg types the kernel accepts:
+// sized unsigned, signed and hex integers, strings, symbols and
+// bitfields of the form b<width>@<offset>/<container size>.
+var fetchTypeRe = regexp.MustCompile(`^(?:[usx](?:8|16|32|64)|string|ustring|symbol|symstr|char|b\d+@\d+/(?:8|16|32|64))$`)
+
+// arrayTypeRe matches an array type suffix such as x8[16].
+var arrayTypeRe = regexp.MustCompile(`^([usx](?:8|16|32|64))\[(\d+)\]$`)
+
+// derefRe matches a memory fetch arg, +offset(fetcharg).
+var derefRe = regexp.MustCompile(`^([+-]?\d+)\((.+)\)$`)
+
+const (
+	// maxArrayLen is the kernel's limit on array lengths.
+	maxArrayLen = 64
+	// maxProbeArgs is the kernel's limit on fetch args per probe.
+	maxProbeArgs = 128
+)
+
+// defaultFetchType returns the type of args without a suffix in a
+// file of class, an unsigned integer the size of its pointers. It's
+// what the kernel uses too, an unsigned long, but is made explicit
+// so the rules pptrace writes show how each arg is printed.
+func defaultFetchType(class elf.Class) string {
+	if class == elf.ELFCLASS32 {
+		return "u32"
+	}
+	return "u64"
+}
+
+// compileArg compiles an arg expression of the form
+// [name=]fetcharg[:type] for a probe in a file of class. Unnamed
+// args are called argN where N is the 1-based position, and untyped
+// args are pointer sized unsigned integers.
+func compileArg(expr string, pos int, class elf.Class) (fetchArg, error) {
+	arg := fetchArg{
+		name: fmt.Sprintf("arg%d", pos),
+		typ:  defaultFetchType(class),
+	}
+
+	rest := expr
+	if name, fetch, ok := strings.Cut(expr, "="); ok {
+		if !argNameRe.MatchString(name) {
+			return arg, fmt.Errorf("invalid arg name %q in %q", name, expr)
+		}
+		arg.name = name
+		rest = fetch
+	}
+
+	// a type suffix follows the last ':' outside of any parens
+	if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.Contains(rest[i:], ")") {
+		arg.typ = rest[i+1:]
+		rest = rest[:i]
+		if m := arrayTypeRe.FindStringSubmatch(arg.typ); m != nil {
+			arg.elem = m[1]
+			arg.count, _ = strconv.Atoi(m[2])
+			if arg.count < 1 || arg.count > maxArrayLen {
+				return arg, fmt.Errorf("array length %d in %q must be between 1 and %d", arg.count, expr, maxArrayLen)
+			}
+			if !derefRe.MatchString(rest) {
+				return arg, fmt.Errorf("array arg %q must read memory, e.g. +0(%%di):%s, or +0(%%x0):%s on arm64", expr, arg.typ, arg.typ)
+			}
+		} else if arg.typ != "cstring" && !fetchTypeRe.MatchString(arg.typ) {
+			return arg, fmt.Errorf("unknown type %q in %q; use u8-u64, s8-s64 or x8-x64 for unsigned, signed or hex integers, an array of them such as x8[16], cstring or string, or a bitfield b<width>@<offset>/<size>", arg.typ, expr)
+		}
+	}
+
+	if rest == "" {
+		return arg, fmt.Errorf("empty fetch arg in %q", expr)
+	}
+	arg.fetch = rest
+
+	// cstring is a char * arg: the kernel's string type reads from
+	// the address the fetch arg is at, so dereference the pointer
+	// first. A bad pointer prints as (fault).
+	if arg.typ == "cstring" {
+		arg.fetch = "+0(" + rest + ")"
+		arg.typ = "string"
+	}
+
+	return arg, nil
+}
+
+// expandArrays replaces array args with one arg per element,
+// named name_0, name_1 and so on, for kernels without array types.
+func expandArrays(args []fetchArg) []fetchArg {
+	var out []fetchArg
+	for _, a := range args {
+		if a.count == 0 {
+			out = append(out, a)
+			continue
+		}
+		// compileArg checked the fetch is a dereference
+		m := derefRe.FindStringSubmatch(a.fetch)
+		off, _ := strconv.ParseInt(m[1], 10, 64)
+		size, _ := strconv.ParseInt(a.elem[1:], 10, 64)
+		for i := 0; i < a.count; i++ {
+			out = append(out, fetchArg{
+				name:  fmt.Sprintf("%s_%d", a.name, i),
+				fetch: fmt.Sprintf("%+d(%s)", off+int64(i)*size/8, m[2]),
+				typ:   a.elem,
+			})
+		}
+	}
+	return out
+}
+
+// paramRef is an arg expression naming a parameter of the traced
+// function, [name=]param[:type]. No fetch arg is a bare identifier,
+// so the two can't be confused.
+type paramRef struct {
+	name  string
+	param string
+	typ   string
+}
+
+func parseParamRef(expr string) (paramRef, bool) {
+	var ref paramRef
+	rest := expr
+	if name, param, ok := strings.Cut(expr, "="); ok {
+		ref.name = name
+		rest = param
+	}
+	if param, typ, ok := strings.Cut(rest, ":"); ok {
+		ref.typ = typ
+		rest = param
+	}
+	if !argNameRe.MatchString(rest) {
+		return ref, false
+	}
+	ref.param = rest
+	return ref, true
+}
+
+// compileParamRef compiles ref to the fetch args reading the
+// parameter from params, one per part for composites, for a probe
+// in a file of class. Args are named after the parameter unless ref
+// names them. Warnings about the parameter go to warn.
+func compileParamRef(ref paramRef, expr string, params []inspect.EntryParam, class elf.Class, warn func(format string, args ...interface{})) ([]fetchArg, error) {
+	var param *inspect.EntryParam
+	for i := range params {
+		if params[i].Name == ref.param {
+			param = &params[i]
+			break
+		}
+	}
+	if param == nil {
+		if len(params) == 0 {
+			return nil, fmt.Errorf("no parameter %s in %q: the function has no parameters", ref.param, expr)
+		}
+		return nil, fmt.Errorf("no parameter %s in %q; the function's parameters are %s", ref.param, expr, inspect.ParamNames(params))
+	}
+	if param.Unavailable != "" {
+		return nil, fmt.Errorf("parameter %s can't be read: %s", ref.param, param.Unavailable)
+	}
+	if param.Warning != "" {
+		warn("warning: parameter %s: %s", ref.param, param.Warning)
+	}
+	if len(param.Parts) > 1 && ref.typ != "" {
+		return nil, fmt.Errorf("parameter %s in %q is a %s, read as %d parts; only scalars take a type", ref.param, expr, param.Type, len(param.Parts))
+	}
+
+	var args []fetchArg
+	for _, part := range param.Parts {
+		name := part.Name
+		if ref.name != "" {
+			name = ref.name
+			if part.Field != "" {
+				name += "_" + part.Field
+			}
+		}
+		typ := part.Type
+		if ref.typ != "" {
+			typ = ref.typ
+		}
+		arg, err := compileArg(name+"="+part.Fetch+":"+typ, 0, class)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %s", ref.param, err)
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
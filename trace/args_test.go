@@ -0,0 +1,36 @@
+package trace
+
+import (
+	"debug/elf"
+	"testing"
+)
+
+func TestCompileArg(t *testing.T) {
+	tests := []struct {
+		expr  string
+		class elf.Class
+		want  fetchArg
+	}{
+		{"%di", elf.ELFCLASS64, fetchArg{name: "arg1", fetch: "%di", typ: "u64"}},
+		{"%r0", elf.ELFCLASS32, fetchArg{name: "arg1", fetch: "%r0", typ: "u32"}},
+		{"flags=%si:x32", elf.ELFCLASS64, fetchArg{name: "flags", fetch: "%si", typ: "x32"}},
+		{"count=%dx:u64", elf.ELFCLASS32, fetchArg{name: "count", fetch: "%dx", typ: "u64"}},
+		{"fd=%di:s32", elf.ELFCLASS64, fetchArg{name: "fd", fetch: "%di", typ: "s32"}},
+	}
+	for _, tt := range tests {
+		got, err := compileArg(tt.expr, 1, tt.class)
+		if err != nil {
+			t.Errorf("compileArg(%q, %s): %s", tt.expr, tt.class, err)
+			continue
+		}
+		if got.name != tt.want.name || got.fetch != tt.want.fetch || got.typ != tt.want.typ {
+			t.Errorf("compileArg(%q, %s) = %s=%s:%s, want %s=%s:%s", tt.expr, tt.class, got.name, got.fetch, got.typ, tt.want.name, tt.want.fetch, tt.want.typ)
+		}
+	}
+
+	for _, expr := range []string{"%di:u128", "%di:int", "n=%di:", "bad-name=%di"} {
+		if _, err := compileArg(expr, 1, elf.ELFCLASS64); err == nil {
+			t.Errorf("compileArg(%q) succeeded, want an error", expr)
+		}
+	}
+}
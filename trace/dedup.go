@@ -0,0 +1,140 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// dedupWriter collapses runs of identical trace events written to
+// it. The first event of a run is written as is and, once the run
+// ends, a "(xN)" line gives how many times it occurred. Events are
+// identical if they differ only in time, CPU and flags. Other
+// lines, such as stack frames, end a run and pass through.
+type dedupWriter struct {
+	lineWriter
+	w io.Writer
+
+	last  Event
+	count int
+}
+
+func newDedupWriter(w io.Writer) *dedupWriter {
+	d := &dedupWriter{w: w}
+	d.line = d.writeLine
+	return d
+}
+
+// Flush ends the current run and writes out any trailing partial
+// line.
+func (d *dedupWriter) Flush() error {
+	if err := d.lineWriter.Flush(); err != nil {
+		return err
+	}
+	return d.endRun()
+}
+
+func (d *dedupWriter) writeLine(line string) error {
+	e, ok := parseEvent(line)
+	if ok && d.count > 0 && sameEvent(e, d.last) {
+		d.count++
+		return nil
+	}
+
+	if err := d.endRun(); err != nil {
+		return err
+	}
+	if ok {
+		d.last = e
+		d.count = 1
+	}
+	_, err := fmt.Fprintln(d.w, line)
+	return err
+}
+
+func (d *dedupWriter) endRun() error {
+	count := d.count
+	d.count = 0
+	if count < 2 {
+		return nil
+	}
+	_, err := fmt.Fprintf(d.w, "    (x%d)\n", count)
+	return err
+}
+
+func sameEvent(a, b Event) bool {
+	return a.Comm == b.Comm && a.PID == b.PID && a.Probe == b.Probe && a.IP == b.IP && a.Args == b.Args
+}
+
+// rateWriter passes on at most limit trace events per second of
+// trace time, dropping the rest along with the lines that follow
+// them, such as stack frames. The number dropped in each second is
+// reported at the start of the next one it passes an event in.
+type rateWriter struct {
+	lineWriter
+	w     io.Writer
+	limit int
+
+	second   float64
+	passed   int
+	dropped  int
+	dropping bool
+
+	// Total is the number of events dropped so far.
+	Total int
+}
+
+func newRateWriter(w io.Writer, limit int) *rateWriter {
+	r := &rateWriter{w: w, limit: limit}
+	r.line = r.writeLine
+	return r
+}
+
+// Flush writes out any trailing partial line and reports events
+// dropped since the last report.
+func (r *rateWriter) Flush() error {
+	if err := r.lineWriter.Flush(); err != nil {
+		return err
+	}
+	return r.reportDropped()
+}
+
+func (r *rateWriter) writeLine(line string) error {
+	e, ok := parseEvent(line)
+	if !ok {
+		if r.dropping {
+			return nil
+		}
+		_, err := fmt.Fprintln(r.w, line)
+		return err
+	}
+
+	if second := math.Floor(e.Timestamp); second != r.second {
+		if err := r.reportDropped(); err != nil {
+			return err
+		}
+		r.second = second
+		r.passed = 0
+	}
+
+	if r.passed >= r.limit {
+		r.dropping = true
+		r.dropped++
+		r.Total++
+		return nil
+	}
+	r.dropping = false
+	r.passed++
+	_, err := fmt.Fprintln(r.w, line)
+	return err
+}
+
+func (r *rateWriter) reportDropped() error {
+	dropped := r.dropped
+	r.dropped = 0
+	if dropped == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(r.w, "    (dropped %d events over --rate %d/s)\n", dropped, r.limit)
+	return err
+}
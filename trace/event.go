@@ -0,0 +1,138 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Event is a single parsed line of trace_pipe output.
+type Event struct {
+	Comm      string
+	PID       int
+	CPU       int
+	Flags     string
+	Timestamp float64
+	Probe     string
+	// IP is the probed address for uprobe events.
+	IP uint64
+	// Args holds the fetch args as formatted by the kernel.
+	Args string
+	// Raw is the unparsed line.
+	Raw string
+}
+
+// eventRe matches the default trace_pipe line format, e.g.
+//
+//	prog-1234    [003] d..1.  1234.567890: main_0_99: (0x401136) arg1=0x1
+//
+// The tgid column and irq-info flags are optional.
+var eventRe = regexp.MustCompile(`^\s*(.+)-(\d+)\s+(?:\(\s*[\d-]+\)\s+)?\[(\d+)\]\s+(?:([^\s]{4,5})\s+)?(\d+\.\d+):\s+([^:\s]+):\s?(.*)$`)
+
+var probeIPRe = regexp.MustCompile(`^\((0x[0-9a-f]+)(?: <-[^)]*)?\)\s*`)
+
+// parseEvent parses a trace_pipe line. ok is false for lines that
+// aren't events, such as user stack frames.
+func parseEvent(line string) (e Event, ok bool) {
+	m := eventRe.FindStringSubmatch(line)
+	if m == nil {
+		return e, false
+	}
+
+	e.Comm = m[1]
+	e.PID, _ = strconv.Atoi(m[2])
+	e.CPU, _ = strconv.Atoi(m[3])
+	e.Flags = m[4]
+	e.Timestamp, _ = strconv.ParseFloat(m[5], 64)
+	e.Probe = m[6]
+	e.Args = m[7]
+
+	if ipm := probeIPRe.FindStringSubmatch(e.Args); ipm != nil {
+		e.IP, _ = strconv.ParseUint(ipm[1], 0, 64)
+		e.Args = e.Args[len(ipm[0]):]
+	}
+
+	return e, true
+}
+
+// lineWriter splits what's written to it into lines, passing each
+// to line without its newline.
+type lineWriter struct {
+	line func(line string) error
+	buf  []byte
+}
+
+func (l *lineWriter) Write(b []byte) (int, error) {
+	l.buf = append(l.buf, b...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(l.buf[:i])
+		l.buf = l.buf[i+1:]
+		if err := l.line(line); err != nil {
+			return len(b), err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush writes out any trailing partial line.
+func (l *lineWriter) Flush() error {
+	if len(l.buf) == 0 {
+		return nil
+	}
+	line := string(l.buf)
+	l.buf = nil
+	return l.line(line)
+}
+
+// prettyWriter reformats trace_pipe output written to it into
+// aligned columns. Lines that aren't events pass through as is.
+type prettyWriter struct {
+	lineWriter
+	w     io.Writer
+	color bool
+}
+
+func newPrettyWriter(w io.Writer) *prettyWriter {
+	p := &prettyWriter{
+		w:     w,
+		color: isTerminal(w) && os.Getenv("NO_COLOR") == "",
+	}
+	p.line = p.writeLine
+	return p
+}
+
+func (p *prettyWriter) writeLine(line string) error {
+	e, ok := parseEvent(line)
+	if !ok {
+		_, err := fmt.Fprintln(p.w, line)
+		return err
+	}
+
+	probe := fmt.Sprintf("%-24s", e.Probe)
+	if p.color {
+		probe = "\x1b[36m" + probe + "\x1b[0m"
+	}
+	out := fmt.Sprintf("%16.6f  %16s %-7d [%03d]  %s %s", e.Timestamp, e.Comm, e.PID, e.CPU, probe, e.Args)
+	_, err := fmt.Fprintln(p.w, strings.TrimRight(out, " "))
+	return err
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
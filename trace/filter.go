@@ -0,0 +1,125 @@
+package trace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/psanford/pptrace/internal/tracefsutil"
+	"github.com/psanford/tracefs"
+)
+
+// builtinFilterFields are event fields that exist regardless of
+// the declared fetch args.
+var builtinFilterFields = map[string]bool{
+	"common_pid":           true,
+	"common_type":          true,
+	"common_flags":         true,
+	"common_preempt_count": true,
+	"comm":                 true,
+	"COMM":                 true,
+	"cpu":                  true,
+	"CPU":                  true,
+	"__probe_ip":           true,
+}
+
+// compileFilter translates a filter expression over the target's
+// fetch args into the kernel's event filter syntax. Positional
+// names (arg1, arg2, ...) are mapped to the name of the fetch arg
+// at that position, and every field referenced must be declared.
+func (t *traceTarget) compileFilter(expr string) (string, error) {
+	names := make(map[string]bool)
+	for _, a := range t.compiledArgs {
+		names[a.name] = true
+	}
+
+	var (
+		out strings.Builder
+		i   int
+	)
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == '"':
+			// copy string literals verbatim
+			end := strings.IndexByte(expr[i+1:], '"')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated string in filter %q", expr)
+			}
+			out.WriteString(expr[i : i+end+2])
+			i += end + 2
+		case isDigit(c):
+			j := i
+			for j < len(expr) && isIdentChar(expr[j]) {
+				j++
+			}
+			out.WriteString(expr[i:j])
+			i = j
+		case isIdentStart(c):
+			j := i
+			for j < len(expr) && isIdentChar(expr[j]) {
+				j++
+			}
+			ident := expr[i:j]
+			field, err := t.filterField(ident, names)
+			if err != nil {
+				return "", err
+			}
+			out.WriteString(field)
+			i = j
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+
+	return out.String(), nil
+}
+
+func (t *traceTarget) filterField(ident string, names map[string]bool) (string, error) {
+	if names[ident] || builtinFilterFields[ident] {
+		return ident, nil
+	}
+
+	var pos int
+	if n, _ := fmt.Sscanf(ident, "arg%d", &pos); n == 1 && fmt.Sprintf("arg%d", pos) == ident {
+		if pos >= 1 && pos <= len(t.compiledArgs) {
+			return t.compiledArgs[pos-1].name, nil
+		}
+	}
+
+	return "", fmt.Errorf("filter references %q which is not a fetch arg of %s", ident, t.function)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+// eventFile returns the path of a per-event control file.
+func eventFile(inst *tracefs.Instance, evt *tracefs.UprobeEvent, name string) string {
+	return tracefsutil.Path(inst, filepath.Join("events", evt.Group, evt.Event, name))
+}
+
+// setFilter installs filter on evt. If the kernel rejects it, the
+// parse error it records in the filter file is returned.
+func setFilter(inst *tracefs.Instance, evt *tracefs.UprobeEvent, filter string) error {
+	p := eventFile(inst, evt, "filter")
+	err := os.WriteFile(p, []byte(filter), 0644)
+	if err != nil {
+		msg, _ := os.ReadFile(p)
+		if len(msg) > 0 {
+			return fmt.Errorf("kernel rejected filter %q: %s", filter, strings.TrimSpace(string(msg)))
+		}
+		return fmt.Errorf("set filter %q err: %s", filter, err)
+	}
+	return nil
+}
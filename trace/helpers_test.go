@@ -0,0 +1,14 @@
+package trace
+
+import (
+	"os"
+	"testing"
+
+	"github.com/psanford/pptrace/internal/fixture"
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	fixture.Cleanup()
+	os.Exit(code)
+}
@@ -0,0 +1,100 @@
+package trace
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/psanford/pptrace/internal/tracefsutil"
+	"github.com/psanford/tracefs"
+)
+
+// compileHist returns the hist trigger aggregating hits of the
+// target by keys, a comma separated list of its fetch arg names,
+// each optionally with a kernel key modifier such as .hex.
+func (t *traceTarget) compileHist(keys string) (string, error) {
+	names := make(map[string]bool)
+	for _, a := range t.compiledArgs {
+		names[a.name] = true
+	}
+
+	for _, key := range strings.Split(keys, ",") {
+		name, _, _ := strings.Cut(key, ".")
+		if name == "" {
+			return "", fmt.Errorf("invalid --hist %q, expected fetch arg names separated by commas", keys)
+		}
+		if !names[name] {
+			return "", fmt.Errorf("--hist key %q is not a fetch arg of %s", name, t.function)
+		}
+	}
+
+	return "hist:keys=" + keys, nil
+}
+
+// setTrigger adds trigger to evt's triggers. If the kernel rejects
+// it, the explanation it records in error_log is returned.
+func setTrigger(inst *tracefs.Instance, evt *tracefs.UprobeEvent, trigger string) error {
+	err := appendEventFile(inst, evt, "trigger", trigger)
+	if err != nil {
+		if msg := tracefsutil.LastError(inst); msg != "" {
+			return fmt.Errorf("kernel rejected trigger %q: %s\n%s", trigger, err, msg)
+		}
+		return fmt.Errorf("set trigger %q err: %s", trigger, err)
+	}
+	return nil
+}
+
+// removeTrigger removes trigger from evt's triggers.
+func removeTrigger(inst *tracefs.Instance, evt *tracefs.UprobeEvent, trigger string) error {
+	return appendEventFile(inst, evt, "trigger", "!"+trigger)
+}
+
+// appendEventFile writes data to one of evt's control files
+// without truncating it: opening trigger with O_TRUNC clears the
+// triggers of every event in the instance.
+func appendEventFile(inst *tracefs.Instance, evt *tracefs.UprobeEvent, name, data string) error {
+	f, err := os.OpenFile(eventFile(inst, evt, name), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Histogram is the kernel's aggregation of one probe's hits.
+type Histogram struct {
+	Function string
+	Event    string
+	// Data is the event's hist file, one line per key with its
+	// hit count.
+	Data []byte
+}
+
+// Histograms reads back the histogram of each probe with a hist
+// trigger. It must be called before Stop, which removes them.
+func (t *Tracer) Histograms() ([]Histogram, error) {
+	var out []Histogram
+	for _, target := range t.targets {
+		if target.hist == "" {
+			continue
+		}
+		evt := target.Uprobe()
+		t.logf("cat %s", eventFile(t.inst, evt, "hist"))
+		if t.opts.DryRun {
+			continue
+		}
+		data, err := os.ReadFile(eventFile(t.inst, evt, "hist"))
+		if err != nil {
+			return nil, fmt.Errorf("read histogram of %s err: %s", target.function, err)
+		}
+		out = append(out, Histogram{
+			Function: target.function,
+			Event:    evt.Event,
+			Data:     data,
+		})
+	}
+	return out, nil
+}
@@ -0,0 +1,98 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// meterWriter counts the trace events written to it, showing the
+// count and rate on a status line that's redrawn once a second. The
+// lines themselves are passed on to w, unless w is nil. If status
+// isn't a terminal, a new line is written each second instead.
+type meterWriter struct {
+	lineWriter
+	w      io.Writer
+	status io.Writer
+	// redraw is written before each status to replace the last
+	redraw string
+
+	mu    sync.Mutex
+	count int
+	start time.Time
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newMeterWriter(w, status io.Writer) *meterWriter {
+	m := &meterWriter{
+		w:      w,
+		status: status,
+		start:  time.Now(),
+		done:   make(chan struct{}),
+	}
+	m.line = m.writeLine
+	if isTerminal(status) {
+		m.redraw = "\r\x1b[K"
+	}
+	m.wg.Add(1)
+	go m.refresh()
+	return m
+}
+
+func (m *meterWriter) refresh() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last := 0
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		m.mu.Lock()
+		count := m.count
+		m.mu.Unlock()
+		fmt.Fprintf(m.status, "%s%d events, %d/s", m.redraw, count, count-last)
+		if m.redraw == "" {
+			fmt.Fprintln(m.status)
+		}
+		last = count
+	}
+}
+
+func (m *meterWriter) writeLine(line string) error {
+	if _, ok := parseEvent(line); ok {
+		m.mu.Lock()
+		m.count++
+		m.mu.Unlock()
+	}
+	if m.w == nil {
+		return nil
+	}
+	_, err := fmt.Fprintln(m.w, line)
+	return err
+}
+
+// Stop stops refreshing the status line, passes on any trailing
+// partial line and replaces the status with the total count and
+// average rate.
+func (m *meterWriter) Stop() error {
+	close(m.done)
+	m.wg.Wait()
+
+	if err := m.Flush(); err != nil {
+		return err
+	}
+
+	elapsed := time.Since(m.start)
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(m.count) / elapsed.Seconds()
+	}
+	_, err := fmt.Fprintf(m.status, "%s%d events in %s, %.1f/s on average\n", m.redraw, m.count, elapsed.Round(time.Millisecond), rate)
+	return err
+}
@@ -0,0 +1,283 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/psanford/pptrace/internal/tracefsutil"
+)
+
+// Ring buffer record types, from the type_len field of each
+// record header.
+const (
+	rbTypePadding    = 29
+	rbTypeTimeExtend = 30
+	rbTypeTimeStamp  = 31
+
+	// the time delta occupies the 27 bits above type_len
+	rbTSShift = 27
+
+	// the top bits of a page's commit field flag missed events
+	rbCommitMask = 1<<30 - 1
+)
+
+// pageLayout locates the fields of a ring buffer page header, as
+// described by events/header_page.
+type pageLayout struct {
+	timestamp tracefsutil.FormatField
+	commit    tracefsutil.FormatField
+	data      int
+}
+
+func parsePageLayout(data []byte) (*pageLayout, error) {
+	f, err := tracefsutil.ParseFormat(data)
+	if err != nil {
+		return nil, err
+	}
+	var l pageLayout
+	var ok bool
+	if l.timestamp, ok = f.Field("timestamp"); !ok {
+		return nil, errors.New("header_page has no timestamp field")
+	}
+	if l.commit, ok = f.Field("commit"); !ok {
+		return nil, errors.New("header_page has no commit field")
+	}
+	dataField, ok := f.Field("data")
+	if !ok {
+		return nil, errors.New("header_page has no data field")
+	}
+	l.data = dataField.Offset
+	return &l, nil
+}
+
+// parsePage calls fn with the timestamp in nanoseconds and data of
+// each record on a ring buffer page read from trace_pipe_raw.
+func (l *pageLayout) parsePage(page []byte, fn func(ts uint64, rec []byte)) error {
+	if len(page) < l.data {
+		return errors.New("short ring buffer page")
+	}
+	ts := l.timestamp.Uint(page)
+	commit := int(l.commit.Uint(page) & rbCommitMask)
+	end := l.data + commit
+	if end > len(page) {
+		end = len(page)
+	}
+
+	p := page[l.data:end]
+	for len(p) >= 4 {
+		hdr := tracefsutil.HostOrder.Uint32(p)
+		typeLen := hdr & 0x1f
+		delta := uint64(hdr >> 5)
+		p = p[4:]
+
+		switch {
+		case typeLen == rbTypePadding:
+			if delta == 0 || len(p) < 4 {
+				// the rest of the page is unused
+				return nil
+			}
+			n := int(tracefsutil.HostOrder.Uint32(p))
+			if n > len(p) {
+				return nil
+			}
+			p = p[n:]
+			continue
+		case typeLen == rbTypeTimeExtend || typeLen == rbTypeTimeStamp:
+			if len(p) < 4 {
+				return nil
+			}
+			ext := uint64(tracefsutil.HostOrder.Uint32(p))<<rbTSShift + delta
+			p = p[4:]
+			if typeLen == rbTypeTimeExtend {
+				ts += ext
+			} else {
+				ts = ext
+			}
+			continue
+		}
+
+		var n int
+		if typeLen == 0 {
+			if len(p) < 4 {
+				return nil
+			}
+			n = (int(tracefsutil.HostOrder.Uint32(p)) - 4 + 3) &^ 3
+			p = p[4:]
+		} else {
+			n = int(typeLen) * 4
+		}
+		if n > len(p) {
+			return errors.New("ring buffer record overruns page")
+		}
+
+		ts += delta
+		fn(ts, p[:n])
+		p = p[n:]
+	}
+	return nil
+}
+
+// rawDecoder turns ring buffer records into Events using the
+// format of each probe.
+type rawDecoder struct {
+	formats map[uint16]*tracefsutil.EventFormat
+
+	mu    sync.Mutex
+	comms map[int]string
+}
+
+func (d *rawDecoder) decode(cpu int, ts uint64, rec []byte) (Event, bool) {
+	if len(rec) < 2 {
+		return Event{}, false
+	}
+	f, ok := d.formats[tracefsutil.HostOrder.Uint16(rec)]
+	if !ok {
+		return Event{}, false
+	}
+
+	e := Event{
+		CPU:       cpu,
+		Timestamp: float64(ts) / 1e9,
+		Probe:     f.Name,
+	}
+	var args []string
+	for _, field := range f.Fields {
+		switch {
+		case field.Name == "common_pid":
+			e.PID = int(field.Int(rec))
+		case field.Name == "__probe_ip":
+			e.IP = field.Uint(rec)
+		case strings.HasPrefix(field.Name, "common_"):
+		default:
+			args = append(args, field.Name+"="+field.Format(rec))
+		}
+	}
+	e.Args = strings.Join(args, " ")
+	e.Comm = d.comm(e.PID)
+	e.Raw = fmt.Sprintf("%16s-%-7d [%03d] %12.6f: %s: (0x%x) %s", e.Comm, e.PID, e.CPU, e.Timestamp, e.Probe, e.IP, e.Args)
+	e.Raw = strings.TrimRight(e.Raw, " ")
+	return e, true
+}
+
+// comm returns the command name of pid, which unlike trace_pipe
+// the raw records don't carry.
+func (d *rawDecoder) comm(pid int) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c, ok := d.comms[pid]; ok {
+		return c
+	}
+	c := "<...>"
+	if data, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm")); err == nil {
+		c = strings.TrimSpace(string(data))
+	}
+	d.comms[pid] = c
+	return c
+}
+
+// RawEvents reads events from each CPU's binary trace_pipe_raw
+// and decodes them using the probes' format files, avoiding the
+// kernel's text formatting. Events from different CPUs are not
+// ordered relative to each other. The channel is closed by Stop.
+func (t *Tracer) RawEvents() (<-chan Event, error) {
+	t.logf("cat %s", tracefsutil.Path(t.inst, filepath.Join("per_cpu", "cpu*", "trace_pipe_raw")))
+	events := make(chan Event)
+	if t.opts.DryRun {
+		close(events)
+		return events, nil
+	}
+
+	header, err := os.ReadFile(tracefsutil.Path(t.inst, filepath.Join("events", "header_page")))
+	if err != nil {
+		return nil, fmt.Errorf("read header_page err: %s", err)
+	}
+	layout, err := parsePageLayout(header)
+	if err != nil {
+		return nil, err
+	}
+
+	d := rawDecoder{
+		formats: make(map[uint16]*tracefsutil.EventFormat),
+		comms:   make(map[int]string),
+	}
+	for _, target := range t.targets {
+		data, err := os.ReadFile(eventFile(t.inst, target.Uprobe(), "format"))
+		if err != nil {
+			return nil, fmt.Errorf("read event format err: %s", err)
+		}
+		f, err := tracefsutil.ParseFormat(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse event format err: %s", err)
+		}
+		d.formats[f.ID] = f
+	}
+
+	cpuDirs, err := filepath.Glob(tracefsutil.Path(t.inst, filepath.Join("per_cpu", "cpu*")))
+	if err != nil || len(cpuDirs) == 0 {
+		return nil, errors.New("no per_cpu buffers found")
+	}
+
+	var files []*os.File
+	for _, dir := range cpuDirs {
+		f, err := os.Open(filepath.Join(dir, "trace_pipe_raw"))
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	t.onStop(func() error {
+		for _, f := range files {
+			f.Close()
+		}
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i, dir := range cpuDirs {
+		cpu, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "cpu"))
+		f := files[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			page := make([]byte, os.Getpagesize())
+			for {
+				// each read returns at most one page
+				n, err := f.Read(page)
+				if n > 0 {
+					layout.parsePage(page[:n], func(ts uint64, rec []byte) {
+						e, ok := d.decode(cpu, ts, rec)
+						if !ok {
+							return
+						}
+						select {
+						case events <- e:
+						case <-t.done:
+						}
+					})
+				}
+				if err != nil {
+					return
+				}
+				select {
+				case <-t.done:
+					return
+				default:
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+
+	return events, nil
+}
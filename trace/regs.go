@@ -0,0 +1,72 @@
+package trace
+
+import (
+	"debug/elf"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// kernelRegs are the register names fetch args can use on each
+// architecture, from the kernel's regoffset_table.
+var kernelRegs = map[elf.Machine]map[string]bool{
+	elf.EM_X86_64:  regSet("ax bx cx dx si di bp sp r8 r9 r10 r11 r12 r13 r14 r15 ip flags cs ss orig_ax"),
+	elf.EM_AARCH64: regSet("x0 x1 x2 x3 x4 x5 x6 x7 x8 x9 x10 x11 x12 x13 x14 x15 x16 x17 x18 x19 x20 x21 x22 x23 x24 x25 x26 x27 x28 x29 x30 sp pc pstate"),
+}
+
+// argRegsHint describes where each architecture's C calling
+// convention passes the first integer arguments.
+var argRegsHint = map[elf.Machine]string{
+	elf.EM_X86_64:  "x86-64 passes the first integer args in %di, %si, %dx, %cx, %r8 and %r9",
+	elf.EM_AARCH64: "arm64 passes the first integer args in %x0-%x7",
+}
+
+// hostMachine is the ELF machine of the running system, the only
+// one whose binaries uprobes can trace.
+var hostMachine = map[string]elf.Machine{
+	"amd64": elf.EM_X86_64,
+	"arm64": elf.EM_AARCH64,
+}[runtime.GOARCH]
+
+func regSet(names string) map[string]bool {
+	set := make(map[string]bool)
+	for _, n := range strings.Fields(names) {
+		set[n] = true
+	}
+	return set
+}
+
+var regRefRe = regexp.MustCompile(`%([A-Za-z0-9_]+)`)
+
+// checkRegisters returns an error if fetch refers to a register
+// that machine doesn't have, as when an x86-64 arg expression is
+// used on arm64. Fetch args for other architectures aren't checked.
+func checkRegisters(fetch string, machine elf.Machine) error {
+	regs, ok := kernelRegs[machine]
+	if !ok {
+		return nil
+	}
+	for _, m := range regRefRe.FindAllStringSubmatch(fetch, -1) {
+		if regs[m[1]] {
+			continue
+		}
+		msg := fmt.Sprintf("%%%s in %q is not a register of %s", m[1], fetch, machineName(machine))
+		if hint := argRegsHint[machine]; hint != "" {
+			msg += "; " + hint
+		}
+		return fmt.Errorf("%s", msg)
+	}
+	return nil
+}
+
+// machineName returns the name pptrace uses for machine.
+func machineName(machine elf.Machine) string {
+	switch machine {
+	case elf.EM_X86_64:
+		return "x86-64"
+	case elf.EM_AARCH64:
+		return "arm64"
+	}
+	return strings.TrimPrefix(machine.String(), "EM_")
+}
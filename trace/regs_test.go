@@ -0,0 +1,92 @@
+package trace
+
+import (
+	"debug/elf"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/psanford/pptrace/internal/fixture"
+)
+
+func TestCheckRegisters(t *testing.T) {
+	tests := []struct {
+		fetch   string
+		machine elf.Machine
+		err     string
+	}{
+		{"%di", elf.EM_X86_64, ""},
+		{"+8(%sp)", elf.EM_X86_64, ""},
+		{"+0(+8(%r15))", elf.EM_X86_64, ""},
+		{"%x0", elf.EM_X86_64, "%x0 in \"%x0\" is not a register of x86-64; x86-64 passes"},
+		{"%x0", elf.EM_AARCH64, ""},
+		{"+16(%sp)", elf.EM_AARCH64, ""},
+		{"+0(%x30)", elf.EM_AARCH64, ""},
+		{"%di", elf.EM_AARCH64, "%di in \"%di\" is not a register of arm64; arm64 passes the first integer args in %x0-%x7"},
+		{"+0(%x0):+8(%ax)", elf.EM_AARCH64, "%ax"},
+		{"%x31", elf.EM_AARCH64, "%x31"},
+		{"$stack0", elf.EM_AARCH64, ""},
+		{"%whatever", elf.EM_RISCV, ""},
+	}
+	for _, tt := range tests {
+		err := checkRegisters(tt.fetch, tt.machine)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s on %s: %s", tt.fetch, tt.machine, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s on %s: got err %v, want one containing %q", tt.fetch, tt.machine, err, tt.err)
+		}
+	}
+}
+
+func TestCompileArm64(t *testing.T) {
+	path := fixture.Path(t, "go-arm64")
+	tests := []struct {
+		args  []string
+		fetch string
+		err   string
+	}{
+		// parameters are located in arm64 registers
+		{args: []string{"i", "p"}, fetch: "%x0 %x1"},
+		{args: []string{"s"}, fetch: "%x2 %x3"},
+		{args: []string{"%x4:u8"}, fetch: "%x4"},
+		{args: []string{"%di:s64"}, err: "not a register of arm64"},
+	}
+	for _, tt := range tests {
+		var warnings []string
+		target := &traceTarget{
+			binary:         path,
+			function:       "main.mixed",
+			argExpressions: tt.args,
+			warnf: func(format string, args ...interface{}) {
+				warnings = append(warnings, fmt.Sprintf(format, args...))
+			},
+		}
+		err := target.Compile(0, "", "", false, false)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: got err %v, want one containing %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tt.args, err)
+			continue
+		}
+		var fetches []string
+		for _, a := range target.compiledArgs {
+			fetches = append(fetches, a.fetch)
+		}
+		if got := strings.Join(fetches, " "); got != tt.fetch {
+			t.Errorf("%q: got fetch args %s, want %s", tt.args, got, tt.fetch)
+		}
+		if hostMachine != 0 && hostMachine != elf.EM_AARCH64 {
+			if len(warnings) == 0 || !strings.Contains(warnings[0], "built for arm64") {
+				t.Errorf("%q: got warnings %q, want one that the binary is for arm64", tt.args, warnings)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package trace
+
+import (
+	"bufio"
+	"debug/dwarf"
+	"debug/elf"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+
+	"github.com/psanford/pptrace/inspect"
+	"github.com/psanford/pptrace/internal/dwarfutil"
+)
+
+// userFrameRe matches a user stack frame without symbol info,
+// e.g. " =>  <0000000000401136>".
+var userFrameRe = regexp.MustCompile(`^\s*=>\s+<([0-9a-f]+)>\s*$`)
+
+// stackSymbolizer annotates user stack frames in trace output with
+// the function and source line they correspond to. The kernel
+// reports runtime addresses, so PIE binaries and shared libraries
+// are only supported when traced via a pid whose mappings give
+// their load bias.
+type stackSymbolizer struct {
+	files []symbolFile
+}
+
+type symbolFile struct {
+	d *dwarf.Data
+	// bias is subtracted from runtime addresses within maps. If
+	// maps is nil the file isn't relocated.
+	bias uint64
+	maps []inspect.Mapping
+}
+
+func newStackSymbolizer(targets []*traceTarget) *stackSymbolizer {
+	var s stackSymbolizer
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		if seen[t.binary] {
+			continue
+		}
+		seen[t.binary] = true
+
+		dwarfPath, err := dwarfutil.FindDwarf(t.binary)
+		if err != nil {
+			continue
+		}
+		f, err := elf.Open(dwarfPath)
+		if err != nil {
+			continue
+		}
+
+		var sf symbolFile
+		if f.Type != elf.ET_EXEC {
+			if t.pid == 0 {
+				f.Close()
+				continue
+			}
+			sf.maps, sf.bias, err = processBias(t.pid, t.binary)
+			if err != nil {
+				f.Close()
+				continue
+			}
+		}
+		sf.d, err = dwarfutil.ELFData(f, dwarfPath)
+		if err != nil {
+			f.Close()
+			continue
+		}
+		s.files = append(s.files, sf)
+	}
+	return &s
+}
+
+// Copy copies r to w, annotating any stack frames it can resolve.
+func (s *stackSymbolizer) Copy(w io.Writer, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := userFrameRe.FindStringSubmatch(line); m != nil {
+			addr, err := strconv.ParseUint(m[1], 16, 64)
+			if err == nil {
+				line += s.symbolize(addr)
+			}
+		}
+		_, err := fmt.Fprintln(w, line)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (s *stackSymbolizer) symbolize(addr uint64) string {
+	for _, f := range s.files {
+		fileAddr := addr
+		if f.maps != nil {
+			if !mapped(f.maps, addr) {
+				continue
+			}
+			fileAddr -= f.bias
+		}
+		loc := inspect.Addr2Line(f.d, fileAddr)
+		if loc.Function == "" {
+			continue
+		}
+		if loc.File != "" {
+			return fmt.Sprintf(" %s %s:%d", loc.Function, loc.File, loc.Line)
+		}
+		return " " + loc.Function
+	}
+	return ""
+}
+
+// processBias returns pid's mappings of the binary at path and
+// the bias it was loaded with.
+func processBias(pid int, path string) ([]inspect.Mapping, uint64, error) {
+	maps, err := inspect.ProcessFileMappings(pid, path)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	bias, err := inspect.LoadBias(f, maps)
+	return maps, bias, err
+}
+
+func mapped(maps []inspect.Mapping, addr uint64) bool {
+	for _, m := range maps {
+		if addr >= m.Start && addr < m.End {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// quotedArgRe matches a string arg in trace output. The kernel
+// doesn't escape quotes within strings, so one containing a quote
+// is cut short at it.
+var quotedArgRe = regexp.MustCompile(`="([^"]*)"`)
+
+// strLimitWriter truncates the string args in trace lines written
+// to it to max bytes, marking those it shortens with "...".
+type strLimitWriter struct {
+	lineWriter
+	w   io.Writer
+	max int
+}
+
+func newStrLimitWriter(w io.Writer, max int) *strLimitWriter {
+	s := &strLimitWriter{w: w, max: max}
+	s.line = s.writeLine
+	return s
+}
+
+func (s *strLimitWriter) writeLine(line string) error {
+	_, err := fmt.Fprintln(s.w, s.truncate(line))
+	return err
+}
+
+func (s *strLimitWriter) truncate(line string) string {
+	return quotedArgRe.ReplaceAllStringFunc(line, func(m string) string {
+		// m is ="..."
+		str := m[2 : len(m)-1]
+		if len(str) <= s.max {
+			return m
+		}
+		return `="` + str[:s.max] + `"...`
+	})
+}
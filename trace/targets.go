@@ -0,0 +1,95 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/psanford/pptrace/internal/config"
+)
+
+// fileTarget is a target read from a --targets file or a preset,
+// with the line it came from for error messages.
+type fileTarget struct {
+	TraceTarget
+	line string
+}
+
+// readTargetsFile parses a file of targets, one per line in the
+// form "binary function [arg_expression...]". Blank lines and
+// lines starting with # are ignored. If pidExe is set the binary
+// is omitted and pidExe of process pid is used instead, as with
+// --pid-bin on the command line.
+func readTargetsFile(path string, pidExe string, pid int) ([]fileTarget, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var out []fileTarget
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		t, err := parseTarget(fields, fmt.Sprintf("%s:%d", path, lineNum), pidExe, pid)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// presetTargets returns the targets of the named config file
+// presets, parsed like the lines of a targets file.
+func presetTargets(names []string, pidExe string, pid int) ([]fileTarget, error) {
+	var out []fileTarget
+	for _, name := range names {
+		lines, ok := config.Current.Presets[name]
+		if !ok {
+			if len(config.Current.Presets) == 0 {
+				return nil, fmt.Errorf("unknown preset %q: no presets are defined in the config file", name)
+			}
+			return nil, fmt.Errorf("unknown preset %q, have: %s", name, strings.Join(config.Current.PresetNames(), ", "))
+		}
+		for i, line := range lines {
+			t, err := parseTarget(strings.Fields(line), fmt.Sprintf("preset %s[%d]", name, i), pidExe, pid)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
+
+// parseTarget parses the fields of a "binary function
+// [arg_expression...]" target from origin. If pidExe is set the
+// binary is omitted.
+func parseTarget(fields []string, origin string, pidExe string, pid int) (fileTarget, error) {
+	t := fileTarget{line: origin}
+	if pidExe != "" {
+		t.Binary = pidExe
+		t.PID = pid
+	} else if len(fields) > 0 {
+		t.Binary = fields[0]
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return t, fmt.Errorf("%s: expected <binary> <function> [arg_expression...]", origin)
+	}
+	t.Function = fields[0]
+	t.Args = fields[1:]
+	return t, nil
+}
@@ -0,0 +1,56 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"text/template"
+)
+
+// parseEventTemplate parses a --template and checks it can render
+// an Event, so mistakes such as unknown fields are caught before
+// tracing starts rather than on the first hit.
+func parseEventTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("event").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, Event{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// templateWriter renders each trace_pipe event written to it with
+// a template, followed by a newline. Lines that aren't events pass
+// through as is.
+type templateWriter struct {
+	lineWriter
+	w    io.Writer
+	tmpl *template.Template
+}
+
+func newTemplateWriter(w io.Writer, tmpl *template.Template) *templateWriter {
+	t := &templateWriter{w: w, tmpl: tmpl}
+	t.line = t.writeLine
+	return t
+}
+
+func (t *templateWriter) writeLine(line string) error {
+	e, ok := parseEvent(line)
+	if !ok {
+		_, err := fmt.Fprintln(t.w, line)
+		return err
+	}
+	e.Raw = line
+
+	var out bytes.Buffer
+	if err := t.tmpl.Execute(&out, e); err != nil {
+		// show the event rather than losing it
+		out.Reset()
+		out.WriteString(line)
+	}
+	out.WriteByte('\n')
+	_, err := t.w.Write(out.Bytes())
+	return err
+}
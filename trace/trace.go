@@ -1,34 +1,102 @@
 package trace
 
 import (
-	"debug/elf"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
+	"text/template"
+	"time"
 
+	"github.com/psanford/pptrace/inspect"
+	"github.com/psanford/pptrace/internal/tracefsutil"
+	"github.com/psanford/pptrace/internal/vlog"
 	"github.com/psanford/tracefs"
 	"github.com/spf13/cobra"
 )
 
 var (
-	dryRun  bool
-	verbose bool
+	dryRun       bool
+	instanceName string
+	cleanStale   bool
+	snapshot     bool
+	clearBuffer  bool
+	bufferSizeKB int
+	stackTrace   bool
+	filterExpr   string
+	autoLib      bool
+	duration     time.Duration
+	maxEvents    int
+	pretty       bool
+	traceOptions []string
+	watch        bool
+	firstMatch   bool
+	rawBinary    bool
+	pidBin       int
+	targetsFile  string
+	presets      []string
+	maxStrLen    int
+	validate     bool
+	atReturns    bool
+	histKeys     string
+	templateText string
+	dedup        bool
+	rateLimit    int
+	meter        bool
+	goid         bool
+	packageName  string
+	excludeExpr  string
+	maxProbes    int
 )
 
+const probeGroup = "pptrace"
+
 func Command() *cobra.Command {
 	cmd := cobra.Command{
 		Use:   "trace <binary> <function> [arg_expression...] [-- <binary> <function> [arg_expression...]]",
 		Short: "Function tracer",
 		RunE:  traceAction,
+
+		ValidArgsFunction: completeTraceArgs,
 	}
 
 	cmd.Flags().BoolVarP(&dryRun, "dry", "", false, "Show commands that would be run")
-	cmd.Flags().BoolVarP(&verbose, "verbose", "", false, "Show commands as they are run")
+	cmd.Flags().StringVarP(&instanceName, "instance", "", "", "Trace in a dedicated tracefs instance (created if it doesn't exist)")
+	cmd.Flags().BoolVarP(&cleanStale, "clean-stale", "", false, "Remove all leftover probes in the pptrace group before starting")
+	cmd.Flags().BoolVarP(&snapshot, "snapshot", "", false, "Instead of streaming, dump the trace buffer once when stopped")
+	cmd.Flags().BoolVarP(&clearBuffer, "clear", "", false, "Clear the trace buffer after a --snapshot dump")
+	cmd.Flags().IntVarP(&bufferSizeKB, "buffer-size-kb", "", 0, "Set the per-CPU ring buffer size while tracing")
+	cmd.Flags().BoolVarP(&stackTrace, "stack", "", false, "Record the user stack on each hit (requires frame pointers in the traced binary)")
+	cmd.Flags().StringVarP(&filterExpr, "filter", "", "", "Only record hits matching this expression over the fetch args, e.g. 'arg1 & 0x40'")
+	cmd.Flags().DurationVarP(&duration, "duration", "", 0, "Stop tracing after this long, e.g. 10s")
+	cmd.Flags().IntVarP(&maxEvents, "max-events", "", 0, "Stop tracing after this many lines of trace output")
+	cmd.Flags().BoolVarP(&pretty, "pretty", "", false, "Align trace output into columns, colorizing probe names on a terminal")
+	cmd.Flags().BoolVarP(&dedup, "dedup", "", false, "Collapse runs of identical events into one followed by an (xN) count")
+	cmd.Flags().IntVarP(&rateLimit, "rate", "", 0, "Show at most this many events per second, reporting how many were dropped")
+	cmd.Flags().BoolVarP(&meter, "meter", "", false, "Show a live count and events/sec rate on stderr, and the total and average rate on exit; events are still written if stdout isn't a terminal")
+	cmd.Flags().StringVarP(&templateText, "template", "", "", "Render each event with this Go text/template, e.g. '{{.Comm}}[{{.PID}}] {{.Probe}} {{.Args}}'")
+	cmd.Flags().StringArrayVarP(&traceOptions, "option", "", nil, "Set a tracefs trace option while tracing, e.g. sym-offset=on (repeatable)")
+	cmd.Flags().BoolVarP(&watch, "watch", "", false, "Reattach the probes when a traced binary is rebuilt")
+	cmd.Flags().BoolVarP(&firstMatch, "first", "", false, "If a Go function name matches several methods or instantiations, trace the first")
+	cmd.Flags().BoolVarP(&rawBinary, "raw-binary", "", false, "Read the binary per-CPU ring buffers instead of the text trace_pipe; cheaper for hot functions, but CPUs aren't interleaved in time order")
+	cmd.Flags().IntVarP(&pidBin, "pid-bin", "", 0, "Trace the executable of this running process; targets then omit the binary, e.g. --pid-bin 1234 main.handle")
+	cmd.Flags().StringVarP(&targetsFile, "targets", "", "", "Read more targets from this file, one \"binary function [arg_expression...]\" per line; # starts a comment")
+	cmd.Flags().StringVarP(&packageName, "package", "", "", "Trace every function of this Go package, e.g. --package net/http ./prog; args are then <binary> [arg_expression...]")
+	cmd.Flags().StringVarP(&excludeExpr, "exclude", "", "", "With --package, leave out functions whose name matches this regular expression")
+	cmd.Flags().IntVarP(&maxProbes, "max-probes", "", 500, "Refuse to install more than this many probes (0 for no limit)")
+	cmd.Flags().StringArrayVarP(&presets, "preset", "", nil, "Trace the targets of this preset from the config file (repeatable)")
+	cmd.Flags().IntVarP(&maxStrLen, "max-str-len", "", 0, "Truncate string args in the output to this many bytes (0 for the kernel's limit)")
+	cmd.Flags().BoolVarP(&validate, "validate", "", false, "Check the kernel accepts each probe by adding and removing it, without tracing")
+	cmd.Flags().BoolVarP(&goid, "goid", "", false, "Add a goid arg with the id of the goroutine making each call (Go binaries; needs Go 1.17+ on x86-64)")
+	cmd.Flags().BoolVarP(&atReturns, "at-returns", "", false, "Also probe each return instruction of the function, as events labeled _ret0, _ret1, ...")
+	cmd.Flags().StringVarP(&histKeys, "hist", "", "", "Instead of streaming, count hits in the kernel by these comma separated fetch args and print the histogram when stopped")
+	cmd.Flags().BoolVarP(&autoLib, "auto-lib", "", false, "Probe the function in whichever of the binary's shared libraries defines it")
 
 	return &cmd
 }
@@ -38,18 +106,89 @@ type traceTarget struct {
 	function       string
 	argExpressions []string
 
+	// symbol is the symbol function resolved to
+	symbol string
+	// pid is the process binary was resolved from, if any
+	pid int
+	// goid adds the running goroutine's id as a goid arg
+	goid bool
+	// warnf, if set, logs warnings about the probe
+	warnf func(format string, args ...interface{})
+
 	targetName   string
 	functionAddr uint64
-	compiledArgs []string
+	compiledArgs []fetchArg
+	filter       string
+	// hist is the hist trigger aggregating hits, if any
+	hist string
+}
+
+// completeTraceArgs completes the binary and function of the target
+// being typed, suggesting function symbols from the binary.
+func completeTraceArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	// pflag consumes the first "--", recording where it was
+	start := 0
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		start = dash
+	}
+	for i := start; i < len(args); i++ {
+		if args[i] == "--" {
+			start = i + 1
+		}
+	}
+
+	if pidBin != 0 {
+		exe, err := inspect.ProcessExecutable(pidBin)
+		if err != nil || len(args) > start {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return inspect.CompleteFunctions(exe, toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+
+	switch len(args) - start {
+	case 0:
+		return nil, cobra.ShellCompDirectiveDefault
+	case 1:
+		return inspect.CompleteFunctions(args[start], toComplete), cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveNoFileComp
 }
 
 func traceAction(cmd *cobra.Command, args []string) error {
-	if len(args) < 1 {
+	if len(args) < 1 && targetsFile == "" && len(presets) == 0 && packageName == "" {
 		log.Fatal("usage: trace <binary> <function> [arg_expression...] [-- <binary> <function> [arg_expression...]]")
 	}
+
+	var pidExe string
+	if pidBin != 0 {
+		var err error
+		pidExe, err = inspect.ProcessExecutable(pidBin)
+		if err != nil {
+			return fmt.Errorf("find executable of process %d err: %s", pidBin, err)
+		}
+	}
+
+	if excludeExpr != "" && packageName == "" {
+		return fmt.Errorf("--exclude only applies to --package")
+	}
+	if maxProbes < 0 {
+		return fmt.Errorf("invalid --max-probes %d, must not be negative", maxProbes)
+	}
+
+	var pkgTargets []TraceTarget
+	if packageName != "" {
+		var err error
+		pkgTargets, err = packageTargets(packageName, excludeExpr, args, pidExe, pidBin)
+		if err != nil {
+			return err
+		}
+		// the args were the package's binary and arg expressions
+		args = nil
+	}
+
 	var (
-		targets   []*traceTarget
-		curTarget *traceTarget
+		targets   []TraceTarget
+		curTarget *TraceTarget
 		seenName  bool
 		seenFunc  bool
 	)
@@ -58,168 +197,694 @@ func traceAction(cmd *cobra.Command, args []string) error {
 			if !seenName || !seenFunc {
 				log.Fatal("Arg parse error: saw '--' without full trace defintion <binary> <function>")
 			}
-			targets = append(targets, curTarget)
+			targets = append(targets, *curTarget)
 			curTarget = nil
 			seenName = false
 			seenFunc = false
 			continue
 		}
 		if curTarget == nil {
-			curTarget = &traceTarget{}
+			curTarget = &TraceTarget{}
+			if pidExe != "" {
+				// the binary comes from --pid-bin
+				curTarget.Binary = pidExe
+				curTarget.PID = pidBin
+				seenName = true
+			}
 		}
 
 		if !seenName {
 			seenName = true
-			curTarget.binary = arg
+			curTarget.Binary = arg
 			continue
 		}
 
 		if !seenFunc {
 			seenFunc = true
-			curTarget.function = arg
+			curTarget.Function = arg
 			continue
 		}
 
-		curTarget.argExpressions = append(curTarget.argExpressions, arg)
+		curTarget.Args = append(curTarget.Args, arg)
 	}
 
 	if curTarget != nil {
 		if !seenName || !seenFunc {
 			log.Fatal("Arg parse error: saw '--' without full trace defintion <binary> <function>")
 		}
-		targets = append(targets, curTarget)
+		targets = append(targets, *curTarget)
 		curTarget = nil
 	}
 
-	for i, t := range targets {
-		err := t.Compile(i)
+	// where each target came from, for errors in targets files
+	origins := make([]string, len(targets))
+	for _, t := range pkgTargets {
+		targets = append(targets, t)
+		origins = append(origins, "--package "+packageName)
+	}
+	if targetsFile != "" {
+		fileTargets, err := readTargetsFile(targetsFile, pidExe, pidBin)
 		if err != nil {
 			return err
 		}
+		for _, t := range fileTargets {
+			targets = append(targets, t.TraceTarget)
+			origins = append(origins, t.line)
+		}
 	}
-
-	inst := tracefs.DefaultInstance
-
-	instPath := filepath.Join("/sys/kernel/tracing/")
-
-	for _, t := range targets {
-		evt := t.Uprobe()
-		if dryRun || verbose {
-			log.Printf("echo %q >> %s", evt.Rule(), filepath.Join(instPath, "uprobe_events"))
+	if len(presets) > 0 {
+		fromPresets, err := presetTargets(presets, pidExe, pidBin)
+		if err != nil {
+			return err
 		}
-		if !dryRun {
-			err := inst.AddUprobeEvent(evt)
-			if err != nil {
-				return fmt.Errorf("add uprobe err: %s", err)
-			}
+		for _, t := range fromPresets {
+			targets = append(targets, t.TraceTarget)
+			origins = append(origins, t.line)
 		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no functions to trace")
+	}
 
-		defer inst.RemoveUprobeEvent(evt)
+	if duration < 0 {
+		return fmt.Errorf("invalid --duration %s, must not be negative", duration)
+	}
+	if maxEvents < 0 {
+		return fmt.Errorf("invalid --max-events %d, must not be negative", maxEvents)
+	}
+	if validate && dryRun {
+		return fmt.Errorf("--validate and --dry can't be combined")
+	}
+	if maxStrLen < 0 {
+		return fmt.Errorf("invalid --max-str-len %d, must not be negative", maxStrLen)
+	}
+	if histKeys != "" && (snapshot || rawBinary) {
+		return fmt.Errorf("--hist can't be combined with --snapshot or --raw-binary")
+	}
+	if rateLimit < 0 {
+		return fmt.Errorf("invalid --rate %d, must not be negative", rateLimit)
+	}
+	if meter && (histKeys != "" || snapshot) {
+		return fmt.Errorf("--meter can't be combined with --hist or --snapshot")
+	}
+	if templateText != "" && pretty {
+		return fmt.Errorf("--template and --pretty can't be combined")
 	}
 
-	for _, t := range targets {
-		evt := t.Uprobe()
-		if dryRun || verbose {
-			log.Printf("echo 1 > %s", inst.UprobeEnablePath(evt))
+	var tmpl *template.Template
+	if templateText != "" {
+		var err error
+		tmpl, err = parseEventTemplate(templateText)
+		if err != nil {
+			log.Printf("invalid --template, showing raw trace output instead: %s", err)
 		}
-		if !dryRun {
-			err := inst.EnableUprobe(evt)
-			if err != nil {
-				return fmt.Errorf("enable uprobe err: %s", err)
-			}
-			defer inst.DisableUprobe(evt)
+	}
+
+	var opts []TraceOption
+	for _, o := range traceOptions {
+		opt, err := parseTraceOption(o)
+		if err != nil {
+			return err
 		}
+		opts = append(opts, opt)
 	}
 
+	tracer := NewTracer(Options{
+		Instance:     instanceName,
+		BufferSizeKB: bufferSizeKB,
+		UserStack:    stackTrace,
+		TraceOptions: opts,
+		CleanStale:   cleanStale,
+		AutoLib:      autoLib,
+		First:        firstMatch,
+		AtReturns:    atReturns,
+		GoID:         goid,
+		DryRun:       dryRun,
+		Verbose:      vlog.Level >= 1,
+		Logf:         log.Printf,
+	})
+
+	// catch signals before any probes are installed, so an
+	// interrupt during setup still runs the cleanup below rather
+	// than exiting and leaving probes behind
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	for i, t := range targets {
+		t.Filter = filterExpr
+		t.Hist = histKeys
+		err := tracer.Add(t)
+		if err != nil && origins[i] != "" {
+			return fmt.Errorf("%s: %s", origins[i], err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if maxProbes > 0 && len(tracer.targets) > maxProbes {
+		return fmt.Errorf("%d probes would be installed, more than --max-probes %d", len(tracer.targets), maxProbes)
+	}
+
+	if validate {
+		if err := tracer.Validate(); err != nil {
+			return err
+		}
+		log.Printf("all %d probes accepted", len(tracer.targets))
+		return nil
+	}
+
+	err := tracer.Start()
+	if err != nil {
+		return err
+	}
+	defer tracer.Stop()
+
+	// a nil channel never fires, so without --duration only a
+	// signal stops the trace
+	var timeout <-chan time.Time
+	if duration > 0 {
+		timeout = time.After(duration)
+	}
+	limitReached := make(chan struct{})
 	stop := make(chan struct{})
 	go func() {
-		<-sigChan
+		select {
+		case <-sigChan:
+		case <-timeout:
+		case <-limitReached:
+		}
 		close(stop)
 	}()
 
-	if dryRun || verbose {
-		log.Printf("cat %s", filepath.Join(instPath, "trace_pipe"))
+	if watch {
+		go watchBinaries(tracer, stop)
 	}
+
+	// the ring buffer isn't read with --hist, so there's no point
+	// reporting what it lost
+	if histKeys != "" {
+		if !dryRun {
+			<-stop
+		}
+		return printHistograms(tracer)
+	}
+
 	if !dryRun {
-		p, err := inst.TracePipe()
+		defer reportStats(tracer)
+	}
+
+	if snapshot {
+		if !dryRun {
+			<-stop
+		}
+		data, err := tracer.Snapshot(clearBuffer)
 		if err != nil {
 			return err
 		}
-		defer p.Close()
-		go func() {
-			<-stop
-			p.Close()
+		os.Stdout.Write(data)
+		return nil
+	}
+
+	var p io.ReadCloser
+	if rawBinary {
+		p, err = rawEventReader(tracer)
+		if err != nil {
+			log.Printf("raw ring buffer unavailable, falling back to trace_pipe: %s", err)
+		}
+	}
+	if p == nil {
+		p, err = tracer.TracePipe()
+		if err != nil {
+			return err
+		}
+	}
+	go func() {
+		<-stop
+		p.Close()
+	}()
+
+	var r io.Reader = p
+	if maxEvents > 0 {
+		r = &lineLimitReader{
+			r:         p,
+			remaining: maxEvents,
+			done:      func() { close(limitReached) },
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if pretty {
+		pw := newPrettyWriter(os.Stdout)
+		defer pw.Flush()
+		out = pw
+	}
+	if tmpl != nil {
+		tw := newTemplateWriter(os.Stdout, tmpl)
+		defer tw.Flush()
+		out = tw
+	}
+	if maxStrLen > 0 {
+		sw := newStrLimitWriter(out, maxStrLen)
+		defer sw.Flush()
+		out = sw
+	}
+	if rateLimit > 0 {
+		rw := newRateWriter(out, rateLimit)
+		defer func() {
+			rw.Flush()
+			if rw.Total > 0 {
+				log.Printf("dropped %d events in total over --rate %d/s", rw.Total, rateLimit)
+			}
 		}()
-		io.Copy(os.Stdout, p)
+		out = rw
+	}
+	if dedup {
+		dw := newDedupWriter(out)
+		defer dw.Flush()
+		out = dw
+	}
+	if meter {
+		// on a terminal the events would scroll the meter away,
+		// so they're only written when stdout is redirected
+		var w io.Writer
+		if !isTerminal(os.Stdout) {
+			w = out
+		}
+		mw := newMeterWriter(w, os.Stderr)
+		defer mw.Stop()
+		out = mw
+	}
+
+	if stackTrace {
+		newStackSymbolizer(tracer.targets).Copy(out, r)
+	} else {
+		io.Copy(out, r)
+	}
+
+	return nil
+}
+
+// packageTargets returns a target for each function of the Go
+// package pkg in the binary, which is args[0] unless pidExe is set,
+// with the arg expressions in the rest of args. Functions matching
+// exclude are left out. The functions are listed before tracing.
+func packageTargets(pkg, exclude string, args []string, pidExe string, pid int) ([]TraceTarget, error) {
+	binary := pidExe
+	if binary == "" {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("--package needs a binary: trace --package %s <binary> [arg_expression...]", pkg)
+		}
+		binary, args = args[0], args[1:]
+	}
+	for _, arg := range args {
+		if arg == "--" {
+			return nil, fmt.Errorf("--package can't be combined with other targets on the command line")
+		}
+	}
+
+	var excludeRe *regexp.Regexp
+	if exclude != "" {
+		var err error
+		excludeRe, err = regexp.Compile(exclude)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --exclude: %s", err)
+		}
+	}
+
+	names, err := inspect.PackageFunctions(binary, pkg)
+	if err != nil {
+		return nil, err
+	}
+	var targets []TraceTarget
+	for _, name := range names {
+		if excludeRe != nil && excludeRe.MatchString(name) {
+			continue
+		}
+		targets = append(targets, TraceTarget{
+			Binary:   binary,
+			Function: name,
+			Args:     args,
+			PID:      pid,
+		})
+	}
+	if len(targets) == 0 {
+		if len(names) > 0 {
+			return nil, fmt.Errorf("all %d functions of package %s in %s match --exclude", len(names), pkg, binary)
+		}
+		return nil, fmt.Errorf("no functions of package %s in %s", pkg, binary)
+	}
+	// checked before the probes are compiled too, which is slow
+	// for thousands of functions
+	if maxProbes > 0 && len(targets) > maxProbes {
+		return nil, fmt.Errorf("%d functions of package %s match, more than --max-probes %d; narrow them with --exclude or raise --max-probes", len(targets), pkg, maxProbes)
+	}
+
+	log.Printf("tracing %d functions of package %s:", len(targets), pkg)
+	for _, t := range targets {
+		log.Printf("  %s", t.Function)
+	}
+	return targets, nil
+}
+
+// rawEventReader returns the tracer's raw events formatted as
+// trace_pipe lines, so they can be written out the same way.
+func rawEventReader(tracer *Tracer) (io.ReadCloser, error) {
+	events, err := tracer.RawEvents()
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		for e := range events {
+			if _, err := io.WriteString(pw, e.Raw+"\n"); err != nil {
+				break
+			}
+		}
+		pw.Close()
+	}()
+	return pr, nil
+}
+
+// parseTraceOption parses a --option value of the form name=on
+// or name=off.
+func parseTraceOption(s string) (TraceOption, error) {
+	name, val, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return TraceOption{}, fmt.Errorf("invalid --option %q, expected name=on or name=off", s)
+	}
+	switch val {
+	case "on", "1":
+		return TraceOption{Name: name, On: true}, nil
+	case "off", "0":
+		return TraceOption{Name: name, On: false}, nil
+	}
+	return TraceOption{}, fmt.Errorf("invalid --option %q, value must be on or off", s)
+}
+
+// lineLimitReader reads from r until remaining lines have been
+// read, then calls done and returns io.EOF.
+type lineLimitReader struct {
+	r         io.Reader
+	remaining int
+	done      func()
+}
+
+func (l *lineLimitReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, io.EOF
+	}
+	n, err := l.r.Read(p)
+	for i := 0; i < n; i++ {
+		if p[i] != '\n' {
+			continue
+		}
+		l.remaining--
+		if l.remaining == 0 {
+			l.done()
+			return i + 1, nil
+		}
+	}
+	return n, err
+}
+
+// setBufferSize sets inst's ring buffer size, logging the change
+// with logf, and returns a func that restores the previous size.
+func setBufferSize(inst *tracefs.Instance, kb int, logf func(string, ...interface{})) (func() error, error) {
+	oldKB, err := tracefsutil.BufferSizeKB(inst)
+	if err != nil {
+		return nil, fmt.Errorf("read buffer_size_kb err: %s", err)
 	}
 
+	newKB, err := tracefsutil.SetBufferSizeKB(inst, kb)
+	if err != nil {
+		return nil, fmt.Errorf("set buffer_size_kb err: %s", err)
+	}
+
+	logf("buffer_size_kb: %d -> %d (per cpu)", oldKB, newKB)
+	if newKB != kb {
+		logf("warning: kernel rounded buffer size from %d to %d KB", kb, newKB)
+	}
+
+	return func() error {
+		_, err := tracefsutil.SetBufferSizeKB(inst, oldKB)
+		if err != nil {
+			return fmt.Errorf("restore buffer_size_kb err: %s", err)
+		}
+		return nil
+	}, nil
+}
+
+// printHistograms writes the histogram of each probe to stdout.
+func printHistograms(tracer *Tracer) error {
+	hists, err := tracer.Histograms()
+	if err != nil {
+		return err
+	}
+	for i, h := range hists {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("==> %s (%s) <==\n", h.Function, h.Event)
+		os.Stdout.Write(h.Data)
+	}
 	return nil
+}
 
+// reportStats prints a summary of the ring buffer stats, warning
+// if any events were lost.
+func reportStats(tracer *Tracer) {
+	stats, err := tracer.Stats()
+	if err != nil {
+		log.Printf("read buffer stats err: %s", err)
+		return
+	}
+
+	var entries, overrun, dropped uint64
+	for _, st := range stats {
+		entries += st.Entries
+		overrun += st.Overrun
+		dropped += st.Dropped
+	}
+
+	log.Printf("buffer stats: entries=%d overrun=%d dropped=%d", entries, overrun, dropped)
+	if overrun > 0 || dropped > 0 {
+		log.Printf("warning: %d events were lost; consider a larger --buffer-size-kb", overrun+dropped)
+	}
+}
+
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	_, err := os.Stat(filepath.Join("/proc", strconv.Itoa(pid)))
+	return err == nil
+}
+
+// openInstance returns the named child instance, creating it
+// if it doesn't exist. created reports whether it was created.
+func openInstance(name string) (inst *tracefs.Instance, created bool, err error) {
+	insts, err := tracefs.ListInstances()
+	if err != nil {
+		return nil, false, fmt.Errorf("list instances err: %s", err)
+	}
+	for i := range insts {
+		if insts[i].Name() == name {
+			return &insts[i], false, nil
+		}
+	}
+
+	inst, err = tracefs.NewInstance(name)
+	if err != nil {
+		return nil, false, fmt.Errorf("create instance err: %s", err)
+	}
+	return inst, true, nil
+}
+
+func (t *traceTarget) warn(format string, args ...interface{}) {
+	if t.warnf != nil {
+		t.warnf(format, args...)
+	}
 }
 
 func (t *traceTarget) Uprobe() *tracefs.UprobeEvent {
 	e := tracefs.UprobeEvent{
-		Group:  "pptrace",
+		Group:  probeGroup,
 		Event:  t.targetName,
 		Path:   t.binary,
 		Offset: t.functionAddr,
 	}
+	for _, arg := range t.compiledArgs {
+		e.FetchArgs = append(e.FetchArgs, arg)
+	}
 	return &e
 }
 
-func (t *traceTarget) Compile(idx int) error {
-	exe, err := elf.Open(t.binary)
-	if err != nil {
-		return fmt.Errorf("Open elf %s err: %s", t.binary, err)
+// Compile resolves the target's function to a probe offset and
+// compiles its arg expressions, filter and hist keys. With autoLib
+// the function may be found in one of the binary's shared
+// libraries; with first an ambiguous Go name resolves to the first
+// match.
+func (t *traceTarget) Compile(idx int, filterExpr, histKeys string, autoLib, first bool) error {
+	if autoLib {
+		var (
+			candidates []string
+			err        error
+		)
+		if t.pid != 0 {
+			candidates, err = inspect.ProcessLibraries(t.pid)
+		} else {
+			candidates, err = inspect.NeededLibraries(t.binary)
+		}
+		if err != nil {
+			return fmt.Errorf("Read libraries of %s err: %s", t.binary, err)
+		}
+		lib, err := inspect.FindDefinition(candidates, t.function, true)
+		if err != nil {
+			return err
+		}
+		t.binary = lib
 	}
 
-	defer exe.Close()
-
-	symbols, errSym := exe.Symbols()
-	dsyms, errDyn := exe.DynamicSymbols()
-
-	if errSym != nil && errDyn != nil {
-		log.Fatalf("Get symbols err: %s %s", errSym, errDyn)
+	probe, err := inspect.ResolveProbe(t.binary, t.function, first)
+	if err != nil {
+		return err
 	}
+	if !probe.Executable {
+		t.warn("warning: 0x%x is in a non-executable segment; the probe may never be hit", probe.Value)
+	}
+	if hostMachine != 0 && probe.Machine != hostMachine {
+		t.warn("warning: %s is built for %s but this system is %s; uprobes only work on binaries of the running architecture", t.binary, machineName(probe.Machine), machineName(hostMachine))
+	}
+	if probe.FromDWARF {
+		t.warn("%s has no symbol for %s; using the address from its debug info", t.binary, t.function)
+	}
+	t.symbol = probe.Symbol
+	t.functionAddr = probe.Offset
+
+	t.targetName = eventName(t.function, "", idx, os.Getpid())
+
+	// parameters named in arg expressions are located using the
+	// debug info, read the first time one is
+	var params []inspect.EntryParam
+	for i, expr := range t.argExpressions {
+		if ref, ok := parseParamRef(expr); ok {
+			if params == nil {
+				params, err = inspect.EntryParams(t.binary, probe.Value)
+				if err != nil {
+					return fmt.Errorf("locate parameters of %s err: %s", t.function, err)
+				}
+			}
+			args, err := compileParamRef(ref, expr, params, probe.Class, t.warn)
+			if err != nil {
+				return err
+			}
+			t.compiledArgs = append(t.compiledArgs, args...)
+			continue
+		}
 
-	symbols = append(symbols, dsyms...)
-
-	var funcFound bool
+		arg, err := compileArg(expr, i+1, probe.Class)
+		if err != nil {
+			return err
+		}
+		if err := checkRegisters(arg.fetch, probe.Machine); err != nil {
+			return err
+		}
+		t.compiledArgs = append(t.compiledArgs, arg)
+	}
 
-	var addrOffset uint64
-	for _, prog := range exe.Progs {
-		if prog.Type == elf.PT_LOAD {
-			addrOffset = prog.Vaddr
-			break
+	// added last so argN names and filters over them are the same
+	// as without it
+	if t.goid {
+		fetch, err := inspect.GoIDFetch(t.binary)
+		if err != nil {
+			return fmt.Errorf("--goid: %s", err)
 		}
+		t.compiledArgs = append(t.compiledArgs, fetchArg{name: "goid", fetch: fetch, typ: "u64"})
 	}
 
-	for _, sym := range symbols {
-		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC {
-			continue
+	if filterExpr != "" {
+		filter, err := t.compileFilter(filterExpr)
+		if err != nil {
+			return err
 		}
+		t.filter = filter
+	}
 
-		if sym.Name == t.function {
-			t.functionAddr = sym.Value - addrOffset
-			funcFound = true
-			break
+	if histKeys != "" {
+		hist, err := t.compileHist(histKeys)
+		if err != nil {
+			return err
 		}
+		t.hist = hist
+	}
+
+	return nil
+}
+
+// returnSites returns copies of t probing each return instruction
+// of its function rather than its entry, with events named after
+// t's with a _ret<N> label. The same args, filter and hist are
+// used, but as they're read at the return they see the registers
+// as the function leaves them.
+func (t *traceTarget) returnSites(idx int) ([]*traceTarget, error) {
+	offsets, err := inspect.ReturnOffsets(t.binary, t.symbol)
+	if err != nil {
+		return nil, fmt.Errorf("find returns of %s err: %s", t.function, err)
+	}
+	if len(offsets) == 0 {
+		t.warn("warning: %s has no return instructions; it may only exit by tail calls or never return", t.function)
 	}
 
-	if !funcFound {
-		return fmt.Errorf("function %s not found in %s", t.function, t.binary)
+	var sites []*traceTarget
+	for i, off := range offsets {
+		site := *t
+		site.functionAddr = t.functionAddr + off
+		site.targetName = eventName(t.function, fmt.Sprintf("ret%d", i), idx, os.Getpid())
+		sites = append(sites, &site)
 	}
+	return sites, nil
+}
 
-	t.targetName = fmt.Sprintf("%s_%d", safeName(t.function), idx)
+// maxEventNameLen is the kernel's MAX_EVENT_NAME_LEN minus
+// the trailing NUL.
+const maxEventNameLen = 63
+
+// eventName returns the uprobe event name for the idx'th target,
+// with label distinguishing several probes on one function. The
+// pid is included so concurrent pptrace runs tracing the same
+// function don't collide.
+func eventName(function, label string, idx, pid int) string {
+	suffix := fmt.Sprintf("_%d_%d", idx, pid)
+	if label != "" {
+		suffix = "_" + label + suffix
+	}
+	name := safeName(function)
+	if len(name)+len(suffix) > maxEventNameLen {
+		name = name[:maxEventNameLen-len(suffix)]
+	}
+	return name + suffix
+}
 
-	return nil
+// eventPidRe matches the _<idx>_<pid> suffix of event names.
+var eventPidRe = regexp.MustCompile(`_\d+_(\d+)$`)
+
+// eventPid returns the pid of the pptrace process that created
+// the named event, or 0 if the name has no pid suffix.
+func eventPid(name string) int {
+	m := eventPidRe.FindStringSubmatch(name)
+	if m == nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
+	}
+	return pid
 }
 
 func safeName(n string) string {
 	return strings.Map(func(r rune) rune {
-		if r >= 'A' && r <= 'z' || r == '_' {
+		if r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r == '_' {
 			return r
 		}
 		return -1
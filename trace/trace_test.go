@@ -0,0 +1,53 @@
+package trace
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var eventNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func TestEventName(t *testing.T) {
+	long := "github.com/example/project/internal/pkg." + strings.Repeat("VeryLongName", 8)
+	tests := []struct {
+		function, label string
+		idx, pid        int
+		want            string
+	}{
+		{"main.mixed", "", 0, 100, "mainmixed_0_100"},
+		{"main.mixed", "", 0, 200, "mainmixed_0_200"},
+		{"main.mixed", "", 1, 100, "mainmixed_1_100"},
+		{"main.mixed", "ret0", 0, 100, "mainmixed_ret0_0_100"},
+		{"main.(*Point).Scale", "", 2, 4194304, "mainPointScale_2_4194304"},
+		{long, "ret12", 3, 4194304, ""},
+	}
+
+	seen := make(map[string]bool)
+	for _, tt := range tests {
+		got := eventName(tt.function, tt.label, tt.idx, tt.pid)
+		if tt.want != "" && got != tt.want {
+			t.Errorf("eventName(%q, %q, %d, %d) = %q, want %q", tt.function, tt.label, tt.idx, tt.pid, got, tt.want)
+		}
+		if len(got) > maxEventNameLen || !eventNameRe.MatchString(got) {
+			t.Errorf("eventName(%q, %q, %d, %d) = %q, not a valid event name", tt.function, tt.label, tt.idx, tt.pid, got)
+		}
+		if seen[got] {
+			t.Errorf("eventName(%q, %q, %d, %d) = %q, the same as another target's", tt.function, tt.label, tt.idx, tt.pid, got)
+		}
+		seen[got] = true
+		if pid := eventPid(got); pid != tt.pid {
+			t.Errorf("eventPid(%q) = %d, want %d", got, pid, tt.pid)
+		}
+	}
+}
+
+func TestEventPidWithoutSuffix(t *testing.T) {
+	// mainmixed_3 and do_open_12 are <func>_<idx> names, as older
+	// versions created
+	for _, name := range []string{"", "mainmixed", "mainmixed_x", "mainmixed_3", "do_open_12", "mainmixed_x_3"} {
+		if pid := eventPid(name); pid != 0 {
+			t.Errorf("eventPid(%q) = %d, want 0", name, pid)
+		}
+	}
+}
@@ -0,0 +1,770 @@
+package trace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/psanford/pptrace/internal/tracefsutil"
+	"github.com/psanford/tracefs"
+)
+
+// TraceTarget is a function to probe and the values to record on
+// each hit.
+type TraceTarget struct {
+	Binary   string
+	Function string
+	// Args are fetch arg expressions of the form
+	// [name=]fetcharg[:type].
+	Args []string
+	// Filter is an optional expression over Args in the kernel's
+	// event filter syntax.
+	Filter string
+	// Hist, if set, aggregates hits in the kernel by these comma
+	// separated Args names with a hist trigger.
+	Hist string
+	// PID is the running process Binary was resolved from, if
+	// any. Its mappings are searched for the function with
+	// AutoLib and used to symbolize stacks of PIE binaries.
+	PID int
+}
+
+// Options configure a Tracer.
+type Options struct {
+	// Instance is the tracefs instance to trace in. It's created
+	// if needed and removed on Stop if it was. Empty means the
+	// top-level instance.
+	Instance string
+	// BufferSizeKB sets the per-CPU ring buffer size while tracing.
+	BufferSizeKB int
+	// UserStack records the user stack on each hit.
+	UserStack bool
+	// TraceOptions are set in the instance while tracing and
+	// restored afterwards.
+	TraceOptions []TraceOption
+	// CleanStale removes every leftover probe in the pptrace group
+	// not owned by a running pptrace, not just colliding ones.
+	CleanStale bool
+	// AutoLib probes functions in whichever of the binary's shared
+	// libraries defines them.
+	AutoLib bool
+	// First resolves a Go function name that matches several
+	// methods or generic instantiations to the first rather than
+	// failing.
+	First bool
+	// AtReturns adds a probe on each return instruction of each
+	// function as well as its entry.
+	AtReturns bool
+	// GoID adds a goid arg with the id of the running goroutine to
+	// each probe, which must be in a Go function.
+	GoID bool
+	// DryRun logs the tracefs writes without making them.
+	DryRun bool
+	// Verbose logs the tracefs writes as they're made.
+	Verbose bool
+	// Logf, if set, is called with warnings, such as for a probe
+	// that may never be hit, and with the shell equivalent of each
+	// tracefs write if DryRun or Verbose is set.
+	Logf func(format string, args ...interface{})
+}
+
+// TraceOption is a tracefs trace option, one of the files under
+// options/ in the instance.
+type TraceOption struct {
+	Name string
+	On   bool
+}
+
+// Tracer manages the uprobes for a set of trace targets.
+type Tracer struct {
+	opts    Options
+	specs   []TraceTarget
+	targets []*traceTarget
+
+	// uprobe_events only exists in the top-level instance
+	root tracefs.Instance
+	inst *tracefs.Instance
+	// ops, if set, replaces the tracefs writes installing and
+	// removing probes
+	ops probeOps
+
+	// arrayArgs caches whether the kernel supports array fetch
+	// arg types
+	arrayArgs *bool
+
+	mu           sync.Mutex
+	cleanup      []func() error
+	probeCleanup []func() error
+	pipe         io.ReadCloser
+	events       chan Event
+	done         chan struct{}
+	err          error
+}
+
+// NewTracer returns a Tracer with no targets.
+func NewTracer(opts Options) *Tracer {
+	t := Tracer{
+		opts: opts,
+		root: tracefs.DefaultInstance,
+		done: make(chan struct{}),
+	}
+	t.inst = &t.root
+	return &t
+}
+
+// logf logs a tracefs write or what a target resolved to.
+func (t *Tracer) logf(format string, args ...interface{}) {
+	if t.opts.Logf != nil && (t.opts.DryRun || t.opts.Verbose) {
+		t.opts.Logf(format, args...)
+	}
+}
+
+// warnf logs a warning.
+func (t *Tracer) warnf(format string, args ...interface{}) {
+	if t.opts.Logf != nil {
+		t.opts.Logf(format, args...)
+	}
+}
+
+// onStop registers fn to be run by Stop, in reverse order of
+// registration.
+func (t *Tracer) onStop(fn func() error) {
+	t.cleanup = append(t.cleanup, fn)
+}
+
+// Add resolves target's function and compiles its args. It must
+// be called before Start.
+func (t *Tracer) Add(target TraceTarget) error {
+	tts, err := t.compile(target, len(t.specs))
+	if err != nil {
+		return err
+	}
+	t.specs = append(t.specs, target)
+	for _, tt := range tts {
+		t.targets = t.addTarget(t.targets, tt)
+	}
+	return nil
+}
+
+// addTarget appends tt to targets unless it would install the same
+// probe as one already there, as when two names are aliases for one
+// address. Two kernel probes at one place would report every hit
+// twice.
+func (t *Tracer) addTarget(targets []*traceTarget, tt *traceTarget) []*traceTarget {
+	for _, other := range targets {
+		if sameProbe(other, tt) {
+			t.warnf("warning: %s is the same probe as %s (%s:0x%x); tracing it once as %s", tt.function, other.function, tt.binary, tt.functionAddr, other.targetName)
+			return targets
+		}
+	}
+	return append(targets, tt)
+}
+
+// sameProbe reports whether a and b probe the same offset of the
+// same file with the same fetch args and filter.
+func sameProbe(a, b *traceTarget) bool {
+	if a.functionAddr != b.functionAddr || a.filter != b.filter || len(a.compiledArgs) != len(b.compiledArgs) {
+		return false
+	}
+	for i := range a.compiledArgs {
+		if a.compiledArgs[i] != b.compiledArgs[i] {
+			return false
+		}
+	}
+	if a.binary == b.binary {
+		return true
+	}
+	// uprobes are keyed by inode, so different paths to one file
+	// are the same probe too
+	sa, errA := os.Stat(a.binary)
+	sb, errB := os.Stat(b.binary)
+	return errA == nil && errB == nil && os.SameFile(sa, sb)
+}
+
+// compile compiles target into the probe on its function's entry
+// and, with AtReturns, those on its returns.
+func (t *Tracer) compile(target TraceTarget, idx int) ([]*traceTarget, error) {
+	tt := &traceTarget{
+		binary:         target.Binary,
+		function:       target.Function,
+		argExpressions: target.Args,
+		pid:            target.PID,
+		goid:           t.opts.GoID,
+		warnf:          t.warnf,
+	}
+	err := tt.Compile(idx, target.Filter, target.Hist, t.opts.AutoLib, t.opts.First)
+	if err != nil {
+		return nil, err
+	}
+	if tt.binary != target.Binary {
+		t.logf("%s resolved to %s", tt.function, tt.binary)
+	}
+	if tt.symbol != tt.function {
+		t.logf("%s resolved to symbol %s", tt.function, tt.symbol)
+	}
+	if !t.supportsArrayArgs() {
+		tt.compiledArgs = expandArrays(tt.compiledArgs)
+	}
+	if len(tt.compiledArgs) > maxProbeArgs {
+		return nil, fmt.Errorf("%s has %d fetch args, more than the kernel's limit of %d per probe", tt.function, len(tt.compiledArgs), maxProbeArgs)
+	}
+
+	targets := []*traceTarget{tt}
+	if t.opts.AtReturns {
+		sites, err := tt.returnSites(idx)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, sites...)
+	}
+	return targets, nil
+}
+
+// supportsArrayArgs reports whether the kernel accepts array fetch
+// arg types. If it can't be told, as in a dry run without tracefs,
+// it's assumed to.
+func (t *Tracer) supportsArrayArgs() bool {
+	if t.arrayArgs == nil {
+		ok, err := tracefsutil.SupportsArrayArgs(&t.root)
+		ok = ok || err != nil
+		if !ok {
+			t.logf("kernel has no array fetch args; expanding arrays into one arg per element")
+		}
+		t.arrayArgs = &ok
+	}
+	return *t.arrayArgs
+}
+
+// Binaries returns the files being probed. With AutoLib these may
+// be shared libraries rather than the binaries targets named.
+func (t *Tracer) Binaries() []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, target := range t.targets {
+		if !seen[target.binary] {
+			seen[target.binary] = true
+			out = append(out, target.binary)
+		}
+	}
+	return out
+}
+
+// Start installs and enables the probes. If it fails, anything
+// already set up is torn down again.
+func (t *Tracer) Start() (err error) {
+	defer func() {
+		if err != nil {
+			t.Stop()
+		}
+	}()
+
+	if !t.opts.DryRun {
+		if err := tracefsutil.Check(); err != nil {
+			return err
+		}
+	}
+
+	if t.opts.Instance != "" {
+		instPath := tracefsutil.Path(&t.root, filepath.Join("instances", t.opts.Instance))
+		t.logf("mkdir -p %s", instPath)
+		if t.opts.DryRun {
+			// nothing is created, so stand in for the instance
+			// with one at its path for the paths logged below
+			dry := tracefs.RootInstance(instPath)
+			t.inst = &dry
+		} else {
+			childInst, created, err := openInstance(t.opts.Instance)
+			if err != nil {
+				return err
+			}
+			if created {
+				t.onStop(childInst.Destroy)
+			}
+			t.inst = childInst
+		}
+	}
+
+	if t.opts.BufferSizeKB > 0 {
+		t.logf("echo %d > %s", t.opts.BufferSizeKB, tracefsutil.Path(t.inst, "buffer_size_kb"))
+		if !t.opts.DryRun {
+			restore, err := setBufferSize(t.inst, t.opts.BufferSizeKB, t.warnf)
+			if err != nil {
+				return err
+			}
+			t.onStop(restore)
+		}
+	}
+
+	if t.opts.UserStack {
+		t.logf("echo 1 > %s", tracefsutil.Path(t.inst, filepath.Join("options", "userstacktrace")))
+		if !t.opts.DryRun {
+			prev, err := tracefsutil.SetOption(t.inst, "userstacktrace", true)
+			if err != nil {
+				return fmt.Errorf("enable userstacktrace err: %s", err)
+			}
+			t.onStop(func() error {
+				_, err := tracefsutil.SetOption(t.inst, "userstacktrace", prev)
+				return err
+			})
+		}
+	}
+
+	if err := t.setTraceOptions(); err != nil {
+		return err
+	}
+
+	if err := t.removeStaleProbes(); err != nil {
+		return err
+	}
+
+	return t.installProbes()
+}
+
+// probeOps are the tracefs writes that install and remove probes.
+type probeOps interface {
+	AddUprobeEvent(evt *tracefs.UprobeEvent) error
+	RemoveUprobeEvent(evt *tracefs.UprobeEvent) error
+	SetFilter(evt *tracefs.UprobeEvent, filter string) error
+	SetTrigger(evt *tracefs.UprobeEvent, trigger string) error
+	RemoveTrigger(evt *tracefs.UprobeEvent, trigger string) error
+	EnableUprobe(evt *tracefs.UprobeEvent) error
+	DisableUprobe(evt *tracefs.UprobeEvent) error
+}
+
+// instanceOps are the probeOps of the tracefs instance inst, with
+// uprobe_events in root.
+type instanceOps struct {
+	root, inst *tracefs.Instance
+}
+
+func (o instanceOps) AddUprobeEvent(evt *tracefs.UprobeEvent) error {
+	return o.root.AddUprobeEvent(evt)
+}
+
+func (o instanceOps) RemoveUprobeEvent(evt *tracefs.UprobeEvent) error {
+	return o.root.RemoveUprobeEvent(evt)
+}
+
+func (o instanceOps) SetFilter(evt *tracefs.UprobeEvent, filter string) error {
+	return setFilter(o.inst, evt, filter)
+}
+
+func (o instanceOps) SetTrigger(evt *tracefs.UprobeEvent, trigger string) error {
+	return setTrigger(o.inst, evt, trigger)
+}
+
+func (o instanceOps) RemoveTrigger(evt *tracefs.UprobeEvent, trigger string) error {
+	return removeTrigger(o.inst, evt, trigger)
+}
+
+func (o instanceOps) EnableUprobe(evt *tracefs.UprobeEvent) error {
+	return o.inst.EnableUprobe(evt)
+}
+
+func (o instanceOps) DisableUprobe(evt *tracefs.UprobeEvent) error {
+	return o.inst.DisableUprobe(evt)
+}
+
+// probeOps returns the writes installing and removing probes in the
+// tracer's instance.
+func (t *Tracer) probeOps() probeOps {
+	if t.ops != nil {
+		return t.ops
+	}
+	return instanceOps{root: &t.root, inst: t.inst}
+}
+
+// installProbes adds, filters and enables a uprobe for each
+// target, with its hist trigger if any. They're tracked separately
+// from the rest of the setup so Reload can replace them. Each add,
+// trigger and enable is recorded as soon as it succeeds, so if one
+// fails part way removeProbes still undoes the rest; the disables
+// are recorded last so they run first, as the removals fail on an
+// enabled probe or one with triggers.
+func (t *Tracer) installProbes() error {
+	ops := t.probeOps()
+	for _, target := range t.targets {
+		evt := target.Uprobe()
+		t.logf("echo %q >> %s", evt.Rule(), tracefsutil.Path(&t.root, "uprobe_events"))
+		if !t.opts.DryRun {
+			err := ops.AddUprobeEvent(evt)
+			if err != nil {
+				return fmt.Errorf("add uprobe err: %s", err)
+			}
+			t.probeCleanup = append(t.probeCleanup, func() error {
+				return ops.RemoveUprobeEvent(evt)
+			})
+		}
+	}
+
+	for _, target := range t.targets {
+		if target.filter == "" {
+			continue
+		}
+		evt := target.Uprobe()
+		t.logf("echo %q > %s", target.filter, eventFile(t.inst, evt, "filter"))
+		if !t.opts.DryRun {
+			err := ops.SetFilter(evt, target.filter)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, target := range t.targets {
+		if target.hist == "" {
+			continue
+		}
+		evt := target.Uprobe()
+		trigger := target.hist
+		t.logf("echo %q >> %s", trigger, eventFile(t.inst, evt, "trigger"))
+		if !t.opts.DryRun {
+			err := ops.SetTrigger(evt, trigger)
+			if err != nil {
+				return err
+			}
+			t.probeCleanup = append(t.probeCleanup, func() error {
+				return ops.RemoveTrigger(evt, trigger)
+			})
+		}
+	}
+
+	for _, target := range t.targets {
+		evt := target.Uprobe()
+		t.logf("echo 1 > %s", t.inst.UprobeEnablePath(evt))
+		if !t.opts.DryRun {
+			err := ops.EnableUprobe(evt)
+			if err != nil {
+				return fmt.Errorf("enable uprobe err: %s", err)
+			}
+			t.probeCleanup = append(t.probeCleanup, func() error {
+				return ops.DisableUprobe(evt)
+			})
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the kernel accepts each target's probe and
+// filter by adding them and removing them again straight away,
+// without enabling them, so nothing is traced. It returns an error
+// describing every target that was rejected. It's an alternative
+// to Start, not a step before it.
+func (t *Tracer) Validate() error {
+	if err := tracefsutil.Check(); err != nil {
+		return err
+	}
+
+	var rejected []string
+	for _, target := range t.targets {
+		if err := t.validate(target); err != nil {
+			rejected = append(rejected, fmt.Sprintf("%s in %s: %s", target.function, target.binary, err))
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("%d of %d probes rejected:\n%s", len(rejected), len(t.targets), strings.Join(rejected, "\n"))
+	}
+	return nil
+}
+
+func (t *Tracer) validate(target *traceTarget) (err error) {
+	evt := target.Uprobe()
+	t.logf("echo %q >> %s", evt.Rule(), tracefsutil.Path(&t.root, "uprobe_events"))
+	if err := t.root.AddUprobeEvent(evt); err != nil {
+		if msg := tracefsutil.LastError(&t.root); msg != "" {
+			return fmt.Errorf("add uprobe err: %s\n%s", err, msg)
+		}
+		return fmt.Errorf("add uprobe err: %s", err)
+	}
+	defer func() {
+		t.logf("echo %q >> %s", evt.RemoveRule(), tracefsutil.Path(&t.root, "uprobe_events"))
+		rerr := t.root.RemoveUprobeEvent(evt)
+		if rerr != nil && err == nil {
+			err = fmt.Errorf("remove uprobe err: %s", rerr)
+		}
+	}()
+
+	if target.filter != "" {
+		t.logf("echo %q > %s", target.filter, eventFile(&t.root, evt, "filter"))
+		if err := setFilter(&t.root, evt, target.filter); err != nil {
+			return err
+		}
+	}
+	if target.hist != "" {
+		t.logf("echo %q >> %s", target.hist, eventFile(&t.root, evt, "trigger"))
+		if err := setTrigger(&t.root, evt, target.hist); err != nil {
+			return err
+		}
+		// the probe can't be removed while it has a trigger
+		return removeTrigger(&t.root, evt, target.hist)
+	}
+	return nil
+}
+
+// removeProbes disables and removes the probes installProbes
+// added, returning the first error.
+func (t *Tracer) removeProbes() error {
+	var firstErr error
+	for i := len(t.probeCleanup) - 1; i >= 0; i-- {
+		if err := t.probeCleanup[i](); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	t.probeCleanup = nil
+	return firstErr
+}
+
+// Reload replaces the probes with ones recompiled from the
+// original targets, for when a traced binary has been rebuilt and
+// its functions have moved. If recompiling fails the existing
+// probes are left in place.
+func (t *Tracer) Reload() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.done:
+		return errors.New("tracer stopped")
+	default:
+	}
+
+	targets := make([]*traceTarget, 0, len(t.specs))
+	for i, spec := range t.specs {
+		tts, err := t.compile(spec, i)
+		if err != nil {
+			return err
+		}
+		for _, tt := range tts {
+			targets = t.addTarget(targets, tt)
+		}
+	}
+
+	if err := t.removeProbes(); err != nil {
+		return fmt.Errorf("remove old probes err: %s", err)
+	}
+	t.targets = targets
+	if err := t.installProbes(); err != nil {
+		// don't leave a partial set of the new probes running
+		t.removeProbes()
+		return err
+	}
+	return nil
+}
+
+// TracePipe returns the raw trace_pipe output of the instance.
+// It's closed by Stop. In dry run mode it's empty.
+func (t *Tracer) TracePipe() (io.ReadCloser, error) {
+	if t.pipe != nil {
+		return t.pipe, nil
+	}
+
+	t.logf("cat %s", tracefsutil.Path(t.inst, "trace_pipe"))
+	if t.opts.DryRun {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	p, err := t.inst.TracePipe()
+	if err != nil {
+		return nil, err
+	}
+	t.pipe = p
+	return p, nil
+}
+
+// Events returns a channel of the events read from trace_pipe.
+// Lines that aren't events, such as user stack frames, are sent
+// with only Raw set. The channel is closed by Stop; if trace_pipe
+// couldn't be opened it's closed immediately and Err reports why.
+func (t *Tracer) Events() <-chan Event {
+	if t.events != nil {
+		return t.events
+	}
+	t.events = make(chan Event)
+
+	p, err := t.TracePipe()
+	if err != nil {
+		t.err = err
+		close(t.events)
+		return t.events
+	}
+
+	go func() {
+		defer close(t.events)
+		scanner := bufio.NewScanner(p)
+		for scanner.Scan() {
+			line := scanner.Text()
+			e, _ := parseEvent(line)
+			e.Raw = line
+			select {
+			case t.events <- e:
+			case <-t.done:
+				return
+			}
+		}
+	}()
+
+	return t.events
+}
+
+// Err returns the error that ended Events, if any.
+func (t *Tracer) Err() error {
+	return t.err
+}
+
+// Snapshot returns the current contents of the trace buffer,
+// clearing it afterwards if clear is set.
+func (t *Tracer) Snapshot(clear bool) ([]byte, error) {
+	t.logf("cat %s", tracefsutil.Path(t.inst, "trace"))
+	if clear {
+		t.logf("echo > %s", tracefsutil.Path(t.inst, "trace"))
+	}
+	if t.opts.DryRun {
+		return nil, nil
+	}
+
+	data, err := tracefsutil.Snapshot(t.inst)
+	if err != nil {
+		return nil, fmt.Errorf("read trace err: %s", err)
+	}
+
+	if clear {
+		err := tracefsutil.ClearTrace(t.inst)
+		if err != nil {
+			return nil, fmt.Errorf("clear trace err: %s", err)
+		}
+	}
+
+	return data, nil
+}
+
+// Stats returns the instance's per-CPU ring buffer statistics.
+func (t *Tracer) Stats() ([]tracefsutil.CPUStats, error) {
+	return tracefsutil.Stats(t.inst)
+}
+
+// Stop disables and removes the probes and restores any instance
+// settings Start changed. It returns the first error encountered
+// but always runs every cleanup step.
+func (t *Tracer) Stop() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.done:
+		return nil
+	default:
+		close(t.done)
+	}
+
+	if t.pipe != nil {
+		t.pipe.Close()
+	}
+
+	firstErr := t.removeProbes()
+	for i := len(t.cleanup) - 1; i >= 0; i-- {
+		if err := t.cleanup[i](); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	t.cleanup = nil
+
+	return firstErr
+}
+
+// removeStaleProbes removes probes left behind in the pptrace group
+// by a previous run that didn't exit cleanly. Probes whose names
+// collide with one of the targets are always removed; with
+// CleanStale every probe in the group not owned by a running
+// pptrace is.
+func (t *Tracer) removeStaleProbes() error {
+	probes, err := tracefsutil.ListProbes(&t.root)
+	if err != nil {
+		if t.opts.DryRun {
+			return nil
+		}
+		return fmt.Errorf("list probes err: %s", err)
+	}
+
+	ours := make(map[string]bool)
+	for _, target := range t.targets {
+		ours[target.targetName] = true
+	}
+
+	for _, p := range probes {
+		if p.Kind != "uprobe" || p.Group != probeGroup {
+			continue
+		}
+		if !t.opts.CleanStale && !ours[p.Event] {
+			continue
+		}
+		if !ours[p.Event] && processAlive(eventPid(p.Event)) {
+			// belongs to another running pptrace
+			continue
+		}
+
+		t.logf("remove stale probe: %s", p)
+		if !t.opts.DryRun {
+			err := tracefsutil.RemoveProbe(&t.root, p)
+			if err != nil {
+				return fmt.Errorf("remove stale probe %s/%s err: %s", p.Group, p.Event, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// setTraceOptions applies opts.TraceOptions to the instance,
+// registering their previous values to be restored on Stop.
+func (t *Tracer) setTraceOptions() error {
+	if len(t.opts.TraceOptions) == 0 {
+		return nil
+	}
+
+	supported, err := tracefsutil.Options(t.inst)
+	if err != nil && !t.opts.DryRun {
+		return fmt.Errorf("list trace options err: %s", err)
+	}
+	if err == nil {
+		known := make(map[string]bool)
+		for _, name := range supported {
+			known[name] = true
+		}
+		for _, opt := range t.opts.TraceOptions {
+			if !known[opt.Name] {
+				sort.Strings(supported)
+				return fmt.Errorf("unknown trace option %q, supported options: %s", opt.Name, strings.Join(supported, " "))
+			}
+		}
+	}
+
+	for _, opt := range t.opts.TraceOptions {
+		val := 0
+		if opt.On {
+			val = 1
+		}
+		t.logf("echo %d > %s", val, tracefsutil.Path(t.inst, filepath.Join("options", opt.Name)))
+		if t.opts.DryRun {
+			continue
+		}
+
+		name := opt.Name
+		prev, err := tracefsutil.SetOption(t.inst, name, opt.On)
+		if err != nil {
+			return fmt.Errorf("set option %s err: %s", name, err)
+		}
+		t.onStop(func() error {
+			_, err := tracefsutil.SetOption(t.inst, name, prev)
+			return err
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package trace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/psanford/tracefs"
+)
+
+func TestAddTargetDedup(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "prog")
+	other := filepath.Join(dir, "other")
+	for _, path := range []string{bin, other} {
+		if err := os.WriteFile(path, []byte(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	symlink := filepath.Join(dir, "symlink")
+	if err := os.Symlink(bin, symlink); err != nil {
+		t.Fatal(err)
+	}
+	hardlink := filepath.Join(dir, "hardlink")
+	if err := os.Link(bin, hardlink); err != nil {
+		t.Fatal(err)
+	}
+
+	arg := fetchArg{name: "n", fetch: "%di", typ: "s64"}
+	target := func(binary, function string, addr uint64, filter string, args ...fetchArg) *traceTarget {
+		return &traceTarget{
+			binary:       binary,
+			function:     function,
+			targetName:   strings.ReplaceAll(function, ".", ""),
+			functionAddr: addr,
+			filter:       filter,
+			compiledArgs: args,
+		}
+	}
+	first := target(bin, "add", 0x1000, "", arg)
+
+	tests := []struct {
+		name string
+		tt   *traceTarget
+		dup  bool
+	}{
+		{"alias at the same address", target(bin, "add_alias", 0x1000, "", arg), true},
+		{"another address", target(bin, "scale", 0x1100, "", arg), false},
+		{"other args", target(bin, "add", 0x1000, "", fetchArg{name: "n", fetch: "%si", typ: "s64"}), false},
+		{"fewer args", target(bin, "add", 0x1000, ""), false},
+		{"a filter", target(bin, "add", 0x1000, "n > 1", arg), false},
+		{"another file", target(other, "add", 0x1000, "", arg), false},
+		{"a symlink", target(symlink, "add", 0x1000, "", arg), true},
+		{"a hardlink", target(hardlink, "add", 0x1000, "", arg), true},
+		{"a missing file", target(filepath.Join(dir, "missing"), "add", 0x1000, "", arg), false},
+	}
+
+	for _, tt := range tests {
+		var warnings []string
+		tr := NewTracer(Options{Logf: func(format string, args ...interface{}) {
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		}})
+		targets := tr.addTarget(nil, first)
+		targets = tr.addTarget(targets, tt.tt)
+
+		if tt.dup {
+			if len(targets) != 1 || targets[0] != first {
+				t.Errorf("%s: got %d targets, want only the first", tt.name, len(targets))
+			}
+			if len(warnings) != 1 || !strings.Contains(warnings[0], "same probe as add") {
+				t.Errorf("%s: got warnings %q, want one about the duplicate", tt.name, warnings)
+			}
+			continue
+		}
+		if len(targets) != 2 {
+			t.Errorf("%s: got %d targets, want 2", tt.name, len(targets))
+		}
+		if len(warnings) != 0 {
+			t.Errorf("%s: got warnings %q, want none", tt.name, warnings)
+		}
+	}
+}
+
+// fakeOps records the probe writes made, failing the one named
+// fail.
+type fakeOps struct {
+	calls []string
+	fail  string
+}
+
+func (f *fakeOps) do(op string, evt *tracefs.UprobeEvent) error {
+	call := op + " " + evt.Event
+	f.calls = append(f.calls, call)
+	if call == f.fail {
+		return errors.New("injected failure")
+	}
+	return nil
+}
+
+func (f *fakeOps) AddUprobeEvent(evt *tracefs.UprobeEvent) error {
+	return f.do("add", evt)
+}
+
+func (f *fakeOps) RemoveUprobeEvent(evt *tracefs.UprobeEvent) error {
+	return f.do("remove", evt)
+}
+
+func (f *fakeOps) SetFilter(evt *tracefs.UprobeEvent, filter string) error {
+	return f.do("filter", evt)
+}
+
+func (f *fakeOps) SetTrigger(evt *tracefs.UprobeEvent, trigger string) error {
+	return f.do("trigger", evt)
+}
+
+func (f *fakeOps) RemoveTrigger(evt *tracefs.UprobeEvent, trigger string) error {
+	return f.do("untrigger", evt)
+}
+
+func (f *fakeOps) EnableUprobe(evt *tracefs.UprobeEvent) error {
+	return f.do("enable", evt)
+}
+
+func (f *fakeOps) DisableUprobe(evt *tracefs.UprobeEvent) error {
+	return f.do("disable", evt)
+}
+
+func TestInstallProbesFailure(t *testing.T) {
+	installed := "add a,add b,filter b,trigger a,enable a,enable b"
+	tests := []struct {
+		fail string
+		// undo are the writes undoing what was installed before
+		// fail, in order
+		undo string
+	}{
+		{"", "disable b,disable a,untrigger a,remove b,remove a"},
+		{"add a", ""},
+		{"add b", "remove a"},
+		{"filter b", "remove b,remove a"},
+		{"trigger a", "remove b,remove a"},
+		{"enable a", "untrigger a,remove b,remove a"},
+		{"enable b", "disable a,untrigger a,remove b,remove a"},
+	}
+
+	for _, tt := range tests {
+		ops := &fakeOps{fail: tt.fail}
+		tr := NewTracer(Options{})
+		tr.ops = ops
+		tr.targets = []*traceTarget{
+			{targetName: "a", binary: "/bin/a", functionAddr: 0x10, hist: "hist:keys=n"},
+			{targetName: "b", binary: "/bin/b", functionAddr: 0x20, filter: "n > 1"},
+		}
+
+		err := tr.installProbes()
+		if (err != nil) != (tt.fail != "") {
+			t.Errorf("fail %q: got err %v", tt.fail, err)
+		}
+		want := installed
+		if tt.fail != "" {
+			want = installed[:strings.Index(installed, tt.fail)+len(tt.fail)]
+		}
+		if got := strings.Join(ops.calls, ","); got != want {
+			t.Errorf("fail %q: installing made writes\n%s\nwant\n%s", tt.fail, got, want)
+		}
+
+		// as Start does when it fails
+		ops.calls = nil
+		if err := tr.Stop(); err != nil {
+			t.Errorf("fail %q: Stop: %s", tt.fail, err)
+		}
+		if got := strings.Join(ops.calls, ","); got != tt.undo {
+			t.Errorf("fail %q: Stop made writes\n%s\nwant\n%s", tt.fail, got, tt.undo)
+		}
+
+		ops.calls = nil
+		if err := tr.Stop(); err != nil {
+			t.Errorf("fail %q: second Stop: %s", tt.fail, err)
+		}
+		if len(ops.calls) > 0 {
+			t.Errorf("fail %q: second Stop made writes %q", tt.fail, ops.calls)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package trace
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// watchInterval is how often watched binaries are checked for
+// changes.
+var watchInterval = 500 * time.Millisecond
+
+// maxReloadAttempts bounds how many times a failed reload is
+// retried before giving up until the binary changes again.
+const maxReloadAttempts = 10
+
+// watchBinaries polls the mtime of each binary tracer probes and
+// reloads the probes once a changed binary has stopped changing,
+// until stop is closed. A reload that fails, e.g. because the
+// linker is still writing the file, is retried on later polls.
+func watchBinaries(tracer *Tracer, stop <-chan struct{}) {
+	mtimes := make(map[string]time.Time)
+	poll := func() (changed bool) {
+		for _, p := range tracer.Binaries() {
+			fi, err := os.Stat(p)
+			if err != nil {
+				// most likely mid rewrite; treat as changed so the
+				// reload waits for it to reappear
+				changed = true
+				continue
+			}
+			if prev, ok := mtimes[p]; ok && !prev.Equal(fi.ModTime()) {
+				changed = true
+			}
+			mtimes[p] = fi.ModTime()
+		}
+		return changed
+	}
+	poll()
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+
+	var (
+		pending  bool
+		attempts int
+	)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if poll() {
+			pending = true
+			attempts = 0
+			continue
+		}
+		if !pending {
+			continue
+		}
+
+		err := tracer.Reload()
+		if err != nil {
+			attempts++
+			if attempts >= maxReloadAttempts {
+				log.Printf("watch: reattach failed, waiting for the next change: %s", err)
+				pending = false
+			}
+			continue
+		}
+		pending = false
+		log.Printf("watch: binary changed, probes reattached")
+
+		// pick up any binaries that are new after resolution
+		poll()
+	}
+}
@@ -3,11 +3,23 @@ package tracerstate
 import (
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/psanford/pptrace/internal/render"
+	"github.com/psanford/pptrace/internal/tracefsutil"
 	"github.com/psanford/tracefs"
 	"github.com/spf13/cobra"
 )
 
+var (
+	probeGroup  string
+	clearBuffer bool
+	jsonOutput  bool
+)
+
 func Command() *cobra.Command {
 	cmd := cobra.Command{
 		Use:   "tracer_state",
@@ -15,6 +27,18 @@ func Command() *cobra.Command {
 	}
 
 	cmd.AddCommand(listTracersCommand())
+	cmd.AddCommand(enableCommand())
+	cmd.AddCommand(disableCommand())
+	cmd.AddCommand(createCommand())
+	cmd.AddCommand(removeCommand())
+	cmd.AddCommand(setTracerCommand())
+	cmd.AddCommand(availableTracersCommand())
+	cmd.AddCommand(listProbesCommand())
+	cmd.AddCommand(clearProbesCommand())
+	cmd.AddCommand(dumpCommand())
+	cmd.AddCommand(setBufferSizeCommand())
+	cmd.AddCommand(checkCommand())
+	cmd.AddCommand(eventFormatCommand())
 
 	return &cmd
 }
@@ -26,14 +50,26 @@ func listTracersCommand() *cobra.Command {
 		Run:   listTracersAction,
 	}
 
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+
 	return &cmd
 }
 
+// instanceState is the state of a tracer instance, as listed by
+// list_tracers.
+type instanceState struct {
+	Name   string `json:"name"`
+	On     bool   `json:"on"`
+	Tracer string `json:"tracer"`
+}
+
 func listTracersAction(cmd *cobra.Command, args []string) {
 	insts, err := tracefs.ListInstances()
 	if err != nil {
 		log.Fatalf("list instances err: %s", err)
 	}
+
+	states := make([]instanceState, 0, len(insts))
 	for _, inst := range insts {
 		on, err := inst.On()
 		if err != nil {
@@ -45,6 +81,480 @@ func listTracersAction(cmd *cobra.Command, args []string) {
 			log.Fatalf("get on CurrentTracer err for %s: %s", inst.Name(), err)
 		}
 
-		fmt.Printf("Instance: %s on=%t tracer=%s\n", inst.Name(), on, tracer)
+		states = append(states, instanceState{
+			Name:   inst.Name(),
+			On:     on,
+			Tracer: string(tracer),
+		})
+	}
+
+	if structuredOutput() {
+		printStructured(states)
+		return
+	}
+
+	for _, st := range states {
+		fmt.Printf("Instance: %s on=%t tracer=%s\n", st.Name, st.On, st.Tracer)
+	}
+}
+
+func enableCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "enable [instance]",
+		Short: "Turn tracing on for an instance (default top-level)",
+		Run:   enableAction,
+	}
+
+	return &cmd
+}
+
+func enableAction(cmd *cobra.Command, args []string) {
+	setOn(args, true)
+}
+
+func disableCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "disable [instance]",
+		Short: "Turn tracing off for an instance (default top-level)",
+		Run:   disableAction,
+	}
+
+	return &cmd
+}
+
+func disableAction(cmd *cobra.Command, args []string) {
+	setOn(args, false)
+}
+
+func setOn(args []string, on bool) {
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	inst, err := findInstance(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if on {
+		err = inst.Enable()
+	} else {
+		err = inst.Disable()
+	}
+	if err != nil {
+		log.Fatalf("set tracing_on err for %s: %s", inst.Name(), err)
+	}
+
+	newOn, err := inst.On()
+	if err != nil {
+		log.Fatalf("get on state err for %s: %s", inst.Name(), err)
+	}
+
+	fmt.Printf("Instance: %s on=%t\n", inst.Name(), newOn)
+}
+
+// findInstance returns the named child instance, or the top-level
+// instance if name is empty or the top-level instance's name.
+func findInstance(name string) (*tracefs.Instance, error) {
+	if name == "" || name == tracefs.DefaultInstance.Name() {
+		inst := tracefs.DefaultInstance
+		return &inst, nil
+	}
+
+	insts, err := tracefs.ListInstances()
+	if err != nil {
+		return nil, fmt.Errorf("list instances err: %s", err)
+	}
+	for i := range insts {
+		if insts[i].Name() == name {
+			return &insts[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("instance %s not found", name)
+}
+
+func createCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "create <name>",
+		Short: "Create a new tracer instance",
+		Run:   createAction,
+	}
+
+	return &cmd
+}
+
+func createAction(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		log.Fatalf("Usage: create <name>")
+	}
+
+	inst, err := tracefs.NewInstance(args[0])
+	if err != nil {
+		log.Fatalf("create instance err: %s", err)
+	}
+
+	fmt.Printf("Created instance: %s\n", inst.Name())
+}
+
+func removeCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "remove <name>",
+		Short: "Remove a tracer instance",
+		Run:   removeAction,
+	}
+
+	return &cmd
+}
+
+func removeAction(cmd *cobra.Command, args []string) {
+	if len(args) < 1 || args[0] == "" {
+		log.Fatalf("Usage: remove <name>")
+	}
+
+	if args[0] == tracefs.DefaultInstance.Name() {
+		log.Fatalf("Cannot remove the top-level instance")
+	}
+
+	inst, err := findInstance(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = inst.Destroy()
+	if err != nil {
+		log.Fatalf("remove instance err: %s", err)
+	}
+
+	fmt.Printf("Removed instance: %s\n", inst.Name())
+}
+
+func setTracerCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "set_tracer <instance> <tracer>",
+		Short: "Set the current tracer for an instance",
+		Run:   setTracerAction,
+	}
+
+	return &cmd
+}
+
+func setTracerAction(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		log.Fatalf("Usage: set_tracer <instance> <tracer>")
+	}
+
+	inst, err := findInstance(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	available, err := tracefsutil.AvailableTracers(inst)
+	if err != nil {
+		log.Fatalf("get available tracers err for %s: %s", inst.Name(), err)
+	}
+
+	tracer := tracefs.Tracer(args[1])
+
+	var valid bool
+	names := make([]string, 0, len(available))
+	for _, t := range available {
+		if t == tracer {
+			valid = true
+		}
+		names = append(names, string(t))
+	}
+	if !valid {
+		log.Fatalf("Invalid tracer %q, available tracers: %s", tracer, strings.Join(names, " "))
+	}
+
+	err = inst.SetTracer(tracer)
+	if err != nil {
+		log.Fatalf("set tracer err for %s: %s", inst.Name(), err)
+	}
+
+	cur, err := inst.CurrentTracer()
+	if err != nil {
+		log.Fatalf("get CurrentTracer err for %s: %s", inst.Name(), err)
+	}
+
+	fmt.Printf("Instance: %s tracer=%s\n", inst.Name(), cur)
+}
+
+func availableTracersCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "available_tracers [instance]",
+		Short: "List the tracers available to an instance",
+		Run:   availableTracersAction,
+	}
+
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+
+	return &cmd
+}
+
+func availableTracersAction(cmd *cobra.Command, args []string) {
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	inst, err := findInstance(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	available, err := tracefsutil.AvailableTracers(inst)
+	if err != nil {
+		log.Fatalf("get available tracers err for %s: %s", inst.Name(), err)
+	}
+
+	if structuredOutput() {
+		printStructured(available)
+		return
+	}
+
+	for _, t := range available {
+		fmt.Println(t)
+	}
+}
+
+func listProbesCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "list_probes",
+		Short: "List installed uprobes and kprobes",
+		Run:   listProbesAction,
+	}
+
+	cmd.Flags().StringVarP(&probeGroup, "group", "", "", "Only show probes in this group")
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+
+	return &cmd
+}
+
+func listProbesAction(cmd *cobra.Command, args []string) {
+	inst := tracefs.DefaultInstance
+	probes, err := tracefsutil.ListProbes(&inst)
+	if err != nil {
+		log.Fatalf("list probes err: %s", err)
+	}
+
+	shown := make([]tracefsutil.Probe, 0, len(probes))
+	for _, p := range probes {
+		if probeGroup == "" || p.Group == probeGroup {
+			shown = append(shown, p)
+		}
+	}
+
+	if structuredOutput() {
+		printStructured(shown)
+		return
+	}
+
+	for _, p := range shown {
+		fmt.Printf("%s %s\n", p.Kind, p)
+	}
+}
+
+func clearProbesCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "clear_probes --group <group>",
+		Short: "Remove all uprobes and kprobes in a group",
+		Run:   clearProbesAction,
+	}
+
+	cmd.Flags().StringVarP(&probeGroup, "group", "", "", "Group to remove probes from (required)")
+
+	return &cmd
+}
+
+func clearProbesAction(cmd *cobra.Command, args []string) {
+	if probeGroup == "" {
+		log.Fatalf("Usage: clear_probes --group <group>")
+	}
+
+	inst := tracefs.DefaultInstance
+	probes, err := tracefsutil.ListProbes(&inst)
+	if err != nil {
+		log.Fatalf("list probes err: %s", err)
+	}
+
+	for _, p := range probes {
+		if p.Group != probeGroup {
+			continue
+		}
+		err := tracefsutil.RemoveProbe(&inst, p)
+		if err != nil {
+			log.Printf("remove %s/%s err: %s", p.Group, p.Event, err)
+			continue
+		}
+		fmt.Printf("Removed %s %s/%s\n", p.Kind, p.Group, p.Event)
+	}
+}
+
+func dumpCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "dump [instance]",
+		Short: "Print the contents of an instance's trace buffer",
+		Run:   dumpAction,
+	}
+
+	cmd.Flags().BoolVarP(&clearBuffer, "clear", "", false, "Clear the trace buffer after dumping")
+
+	return &cmd
+}
+
+func dumpAction(cmd *cobra.Command, args []string) {
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	inst, err := findInstance(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := tracefsutil.Snapshot(inst)
+	if err != nil {
+		log.Fatalf("read trace err for %s: %s", inst.Name(), err)
+	}
+	os.Stdout.Write(data)
+
+	if clearBuffer {
+		err := tracefsutil.ClearTrace(inst)
+		if err != nil {
+			log.Fatalf("clear trace err for %s: %s", inst.Name(), err)
+		}
+	}
+}
+
+func checkCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "check",
+		Short: "Check that tracefs is mounted and writable",
+		Run:   checkAction,
+	}
+
+	return &cmd
+}
+
+func checkAction(cmd *cobra.Command, args []string) {
+	if err := tracefsutil.Check(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s is mounted and writable\n", filepath.Clean(tracefsutil.Dir(&tracefs.DefaultInstance)))
+}
+
+func setBufferSizeCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "set_buffer_size <instance> <kb>",
+		Short: "Set the per-CPU ring buffer size of an instance",
+		Run:   setBufferSizeAction,
+	}
+
+	return &cmd
+}
+
+func setBufferSizeAction(cmd *cobra.Command, args []string) {
+	if len(args) < 2 {
+		log.Fatalf("Usage: set_buffer_size <instance> <kb>")
+	}
+
+	kb, err := strconv.Atoi(args[1])
+	if err != nil || kb <= 0 {
+		log.Fatalf("Invalid size %q", args[1])
+	}
+
+	inst, err := findInstance(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	oldKB, err := tracefsutil.BufferSizeKB(inst)
+	if err != nil {
+		log.Fatalf("read buffer_size_kb err for %s: %s", inst.Name(), err)
+	}
+
+	newKB, err := tracefsutil.SetBufferSizeKB(inst, kb)
+	if err != nil {
+		log.Fatalf("set buffer_size_kb err for %s: %s", inst.Name(), err)
+	}
+
+	fmt.Printf("Instance: %s buffer_size_kb=%d (was %d)\n", inst.Name(), newKB, oldKB)
+	if newKB != kb {
+		log.Printf("warning: kernel rounded buffer size from %d to %d KB", kb, newKB)
+	}
+}
+
+func eventFormatCommand() *cobra.Command {
+	cmd := cobra.Command{
+		Use:   "event_format <group>/<event> [instance]",
+		Short: "Show the field layout the kernel records for an event",
+		Long:  "Show the fields of an event's format file, events/<group>/<event>/format. For pptrace probes these are the fetch args as the kernel registered them.",
+		Run:   eventFormatAction,
+	}
+
+	cmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "Show json output")
+
+	return &cmd
+}
+
+func eventFormatAction(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		log.Fatalf("Usage: event_format <group>/<event> [instance]")
+	}
+	group, event, ok := strings.Cut(args[0], "/")
+	if !ok || group == "" || event == "" {
+		log.Fatalf("Usage: event_format <group>/<event> [instance]")
+	}
+
+	var name string
+	if len(args) > 1 {
+		name = args[1]
+	}
+	inst, err := findInstance(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := tracefsutil.ReadEventFormat(inst, group, event)
+	if os.IsNotExist(err) {
+		log.Fatalf("no event %s/%s in %s", group, event, inst.Name())
+	} else if err != nil {
+		log.Fatalf("read event format err: %s", err)
+	}
+
+	if structuredOutput() {
+		printStructured(f)
+		return
+	}
+
+	fmt.Printf("Event: %s/%s ID=%d\n", group, f.Name, f.ID)
+	fmt.Printf("%-6s  %-4s  %-6s  %-24s  %s\n", "OFFSET", "SIZE", "SIGNED", "TYPE", "NAME")
+	for _, field := range f.Fields {
+		fmt.Printf("%-6d  %-4d  %-6t  %-24s  %s\n", field.Offset, field.Size, field.Signed, field.Type, field.Name)
+	}
+	if f.Print != "" {
+		fmt.Printf("print fmt: %s\n", f.Print)
+	}
+}
+
+// structuredOutput reports whether a command should print its
+// result with printStructured rather than as text.
+func structuredOutput() bool {
+	return jsonOutput || render.Output != render.Text
+}
+
+// printStructured writes v to stdout in the format chosen with
+// --json or --format.
+func printStructured(v interface{}) {
+	format := render.Output
+	if jsonOutput {
+		format = render.JSON
+	}
+	if err := render.Render(os.Stdout, format, v); err != nil {
+		log.Fatalf("Write output err: %s", err)
 	}
 }